@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/pkg/control"
+	"github.com/NHAS/wag/pkg/control/wagctl"
+)
+
+type access struct {
+	fs *flag.FlagSet
+
+	id, username, reason, socket string
+	action                       string
+}
+
+func Access() *access {
+	gc := &access{
+		fs: flag.NewFlagSet("access", flag.ContinueOnError),
+	}
+
+	gc.fs.StringVar(&gc.id, "id", "", "Access request to act upon")
+	gc.fs.StringVar(&gc.username, "username", "", "Only list the access requests of this user (optional)")
+	gc.fs.StringVar(&gc.reason, "reason", "", "Reason for the decision, recorded in the audit log (required for -deny and -revoke)")
+	gc.fs.StringVar(&gc.socket, "socket", control.DefaultWagSocket, "Wag instance control socket")
+
+	gc.fs.Bool("list", false, "List just in time access requests")
+	gc.fs.Bool("approve", false, "Approve a pending access request, granting its routes until it expires (requires -id)")
+	gc.fs.Bool("deny", false, "Deny a pending access request (requires -id and -reason)")
+	gc.fs.Bool("revoke", false, "Remove an approved access request before it expires (requires -id and -reason)")
+
+	return gc
+}
+
+func (g *access) FlagSet() *flag.FlagSet {
+	return g.fs
+}
+
+func (g *access) Name() string {
+
+	return g.fs.Name()
+}
+
+func (g *access) PrintUsage() {
+	g.fs.Usage()
+}
+
+func (g *access) Check() error {
+	g.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "list", "approve", "deny", "revoke":
+			g.action = strings.ToLower(f.Name)
+		}
+	})
+
+	switch g.action {
+	case "list":
+	case "approve":
+		if g.id == "" {
+			return errors.New("id must be supplied")
+		}
+	case "deny", "revoke":
+		if g.id == "" || g.reason == "" {
+			return errors.New("both id and reason must be supplied")
+		}
+	default:
+		return errors.New("Unknown flag: " + g.action)
+	}
+
+	return nil
+}
+
+func (g *access) Run() error {
+	ctl := wagctl.NewControlClient(g.socket)
+
+	var err error
+	switch g.action {
+	case "list":
+		requests, err := ctl.AccessRequests(g.username)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("id,username,status,routes,duration,reason,requested,decided_by,expires")
+		for _, request := range requests {
+			expires := ""
+			if !request.Expires.IsZero() {
+				expires = request.Expires.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%s,%s,%s,%s,%s,%q,%s,%s,%s\n", request.ID, request.Username, request.Status, strings.Join(request.Routes, " "), request.Duration,
+				request.Reason, request.Requested.Format(time.RFC3339), request.DecidedBy, expires)
+		}
+		return nil
+
+	case "approve":
+		err = ctl.ApproveAccessRequest(g.id, g.reason)
+	case "deny":
+		err = ctl.DenyAccessRequest(g.id, g.reason)
+	case "revoke":
+		err = ctl.RevokeAccessRequest(g.id, g.reason)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("OK")
+
+	return nil
+}
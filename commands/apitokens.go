@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control"
+	"github.com/NHAS/wag/pkg/control/wagctl"
+)
+
+type apiTokensCmd struct {
+	fs *flag.FlagSet
+
+	socket   string
+	action   string
+	name     string
+	id       string
+	scopes   string
+	lifetime time.Duration
+}
+
+func APITokens() *apiTokensCmd {
+	gc := &apiTokensCmd{
+		fs: flag.NewFlagSet("apitokens", flag.ContinueOnError),
+	}
+
+	gc.fs.StringVar(&gc.socket, "socket", control.DefaultWagSocket, "Wag control socket to act on")
+
+	gc.fs.StringVar(&gc.name, "name", "", "Name of the new token, shown as the actor in the audit log")
+	gc.fs.StringVar(&gc.id, "id", "", "Id of the token to delete")
+	gc.fs.StringVar(&gc.scopes, "scopes", "", "',' delimited scopes of the new token, <scope>:read or <scope>:write, or * for everything. Scopes: "+strings.Join(data.APITokenScopes, ", "))
+	gc.fs.DurationVar(&gc.lifetime, "lifetime", 30*24*time.Hour, "How long the new token is valid for")
+
+	gc.fs.Bool("add", false, "Create a new management api token")
+	gc.fs.Bool("del", false, "Delete a management api token")
+	gc.fs.Bool("list", false, "List management api tokens")
+
+	return gc
+}
+
+func (g *apiTokensCmd) FlagSet() *flag.FlagSet {
+	return g.fs
+}
+
+func (g *apiTokensCmd) Name() string {
+
+	return g.fs.Name()
+}
+
+func (g *apiTokensCmd) PrintUsage() {
+	fmt.Println("Usage of apitokens:")
+	fmt.Println("  Manage tokens for the remote management api")
+	g.fs.PrintDefaults()
+}
+
+func (g *apiTokensCmd) Check() error {
+	g.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "add", "del", "list":
+			g.action = strings.ToLower(f.Name)
+		}
+	})
+
+	switch g.action {
+	case "add":
+		if g.name == "" {
+			return errors.New("name must be supplied")
+		}
+
+		if g.scopes == "" {
+			return errors.New("scopes must be supplied")
+		}
+	case "del":
+		if g.id == "" {
+			return errors.New("id must be supplied")
+		}
+	case "list":
+	default:
+		return errors.New("one of -add, -del or -list must be specified")
+	}
+
+	return nil
+}
+
+func (g *apiTokensCmd) Run() error {
+	ctl := wagctl.NewControlClient(g.socket)
+
+	switch g.action {
+	case "add":
+		created, err := ctl.CreateAPIToken(g.name, strings.Split(g.scopes, ","), g.lifetime)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("id,name,expires,token")
+		fmt.Printf("%s,%s,%s,%s\n", created.Token.ID, created.Token.Name, created.Token.Expires.Format(time.RFC3339), created.Secret)
+
+	case "del":
+		if err := ctl.DeleteAPIToken(g.id); err != nil {
+			return err
+		}
+
+		fmt.Println("OK")
+
+	case "list":
+		tokens, err := ctl.APITokens()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("id,name,scopes,created_by,expires,expired")
+		for _, token := range tokens {
+			fmt.Printf("%s,%s,%s,%s,%s,%t\n", token.ID, token.Name, strings.Join(token.Scopes, " "), token.CreatedBy, token.Expires.Format(time.RFC3339), token.Expired())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control"
+	"github.com/NHAS/wag/pkg/control/wagctl"
+)
+
+type applyCmd struct {
+	fs *flag.FlagSet
+
+	socket string
+	file   string
+	dryRun bool
+	yes    bool
+}
+
+func Apply() *applyCmd {
+	gc := &applyCmd{
+		fs: flag.NewFlagSet("apply", flag.ContinueOnError),
+	}
+
+	gc.fs.StringVar(&gc.socket, "socket", control.DefaultWagSocket, "Wag control socket to act on")
+	gc.fs.StringVar(&gc.file, "f", "", "File containing the desired policies and groups, either {\"Groups\":..., \"Policies\":...} or a wag config file with an Acls section")
+	gc.fs.BoolVar(&gc.dryRun, "dry-run", false, "Show the plan without applying it")
+	gc.fs.BoolVar(&gc.yes, "yes", false, "Apply the plan without asking for confirmation")
+
+	return gc
+}
+
+func (g *applyCmd) FlagSet() *flag.FlagSet {
+	return g.fs
+}
+
+func (g *applyCmd) Name() string {
+
+	return g.fs.Name()
+}
+
+func (g *applyCmd) PrintUsage() {
+	fmt.Println("Usage of apply:")
+	fmt.Println("  Make the policies and groups of a running wag instance match a file. Anything not in the file is removed, except for the default group (*)")
+	g.fs.PrintDefaults()
+}
+
+func (g *applyCmd) Check() error {
+	if g.file == "" {
+		return errors.New("no policy file specified (-f)")
+	}
+
+	return nil
+}
+
+func readPolicySet(path string) (data.PolicySet, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return data.PolicySet{}, err
+	}
+
+	var file struct {
+		data.PolicySet
+		Acls *data.PolicySet
+	}
+
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return data.PolicySet{}, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	if file.Acls != nil {
+		return *file.Acls, nil
+	}
+
+	return file.PolicySet, nil
+}
+
+func printPlan(plan data.PolicyPlan) {
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case data.PolicyCreate:
+			fmt.Printf("+ %s %s\n", change.Kind, change.Name)
+			fmt.Printf("\t%s\n", change.After)
+		case data.PolicyUpdate:
+			fmt.Printf("~ %s %s\n", change.Kind, change.Name)
+			fmt.Printf("\t- %s\n", change.Before)
+			fmt.Printf("\t+ %s\n", change.After)
+		case data.PolicyDelete:
+			fmt.Printf("- %s %s\n", change.Kind, change.Name)
+			fmt.Printf("\t%s\n", change.Before)
+		}
+	}
+
+	counts := map[data.PolicyChangeAction]int{}
+	for _, change := range plan.Changes {
+		counts[change.Action]++
+	}
+
+	fmt.Printf("\nPlan: %d to create, %d to update, %d to delete\n", counts[data.PolicyCreate], counts[data.PolicyUpdate], counts[data.PolicyDelete])
+}
+
+func (g *applyCmd) Run() error {
+	desired, err := readPolicySet(g.file)
+	if err != nil {
+		return err
+	}
+
+	ctl := wagctl.NewControlClient(g.socket)
+
+	plan, err := ctl.PlanPolicies(desired)
+	if err != nil {
+		return err
+	}
+
+	if len(plan.Changes) == 0 {
+		fmt.Println("No changes, policies and groups match", g.file)
+		return nil
+	}
+
+	printPlan(plan)
+
+	if g.dryRun {
+		return nil
+	}
+
+	if !g.yes {
+		fmt.Print("Apply these changes? [y/N]: ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			return errors.New("apply cancelled")
+		}
+	}
+
+	applied, err := ctl.ApplyPolicies(desired, plan)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("OK applied %d changes (revision %d)\n", len(applied.Changes), applied.Revision)
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control"
+	"github.com/NHAS/wag/pkg/control/wagctl"
+)
+
+type auditCmd struct {
+	fs *flag.FlagSet
+
+	socket                   string
+	eventType, actor, search string
+	since, until             string
+	limit                    int
+	asJson                   bool
+}
+
+func Audit() *auditCmd {
+	gc := &auditCmd{
+		fs: flag.NewFlagSet("audit", flag.ContinueOnError),
+	}
+
+	gc.fs.StringVar(&gc.socket, "socket", control.DefaultWagSocket, "Wag control socket to act on")
+
+	gc.fs.StringVar(&gc.eventType, "type", "", "Only show events of this type, e.g login, login_failed, lockout, device_registered, admin_action, policy_change")
+	gc.fs.StringVar(&gc.actor, "actor", "", "Only show events by this user or administrator")
+	gc.fs.StringVar(&gc.search, "search", "", "Only show events where the actor, source, target or details contain this text")
+	gc.fs.StringVar(&gc.since, "since", "", "Only show events after this time, either RFC3339 or a duration before now (e.g 24h)")
+	gc.fs.StringVar(&gc.until, "until", "", "Only show events before this time, either RFC3339 or a duration before now")
+	gc.fs.IntVar(&gc.limit, "limit", 100, "Maximum number of events to show (0 for all)")
+	gc.fs.BoolVar(&gc.asJson, "json", false, "Output events as json lines")
+
+	return gc
+}
+
+func (g *auditCmd) FlagSet() *flag.FlagSet {
+	return g.fs
+}
+
+func (g *auditCmd) Name() string {
+
+	return g.fs.Name()
+}
+
+func (g *auditCmd) PrintUsage() {
+	g.fs.Usage()
+}
+
+func (g *auditCmd) Check() error {
+	if g.limit < 0 {
+		return errors.New("limit cannot be negative")
+	}
+
+	if _, err := parseAuditTime(g.since); err != nil {
+		return fmt.Errorf("invalid -since: %w", err)
+	}
+
+	if _, err := parseAuditTime(g.until); err != nil {
+		return fmt.Errorf("invalid -until: %w", err)
+	}
+
+	return nil
+}
+
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+func (g *auditCmd) Run() error {
+	ctl := wagctl.NewControlClient(g.socket)
+
+	since, _ := parseAuditTime(g.since)
+	until, _ := parseAuditTime(g.until)
+
+	events, err := ctl.SearchAudit(data.AuditFilter{
+		Since: since,
+		Until: until,
+		Type:  data.AuditEventType(g.eventType),
+		Actor: g.actor,
+		Text:  g.search,
+		Limit: g.limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	if g.asJson {
+		for _, event := range events {
+			b, _ := json.Marshal(event)
+			fmt.Println(string(b))
+		}
+		return nil
+	}
+
+	fmt.Println("time,type,actor,source,target,details")
+	for _, event := range events {
+		fmt.Printf("%s,%s,%s,%s,%s,%s\n", event.Time.Format(time.RFC3339), event.Type, event.Actor, event.SourceIP, event.Target, strings.ReplaceAll(event.Details, "\n", " "))
+	}
+
+	return nil
+}
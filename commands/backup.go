@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control"
+	"github.com/NHAS/wag/pkg/control/wagctl"
+)
+
+const backupPassphraseEnv = "WAG_BACKUP_PASSPHRASE"
+
+type backupCmd struct {
+	fs *flag.FlagSet
+
+	socket         string
+	file           string
+	passphraseFile string
+}
+
+func Backup() *backupCmd {
+	gc := &backupCmd{
+		fs: flag.NewFlagSet("backup", flag.ContinueOnError),
+	}
+
+	gc.fs.StringVar(&gc.socket, "socket", control.DefaultWagSocket, "Wag control socket to act on")
+	gc.fs.StringVar(&gc.file, "file", "", "Path to write the backup archive to")
+	gc.fs.StringVar(&gc.passphraseFile, "passphrase-file", "", "Encrypt the backup with the passphrase in this file (or set "+backupPassphraseEnv+")")
+
+	return gc
+}
+
+func (g *backupCmd) FlagSet() *flag.FlagSet {
+	return g.fs
+}
+
+func (g *backupCmd) Name() string {
+
+	return g.fs.Name()
+}
+
+func (g *backupCmd) PrintUsage() {
+	fmt.Println("Usage of backup:")
+	fmt.Println("  Write a consistent backup of users, devices, policies, groups, registration tokens, settings and admin users from a running wag instance")
+	g.fs.PrintDefaults()
+}
+
+func (g *backupCmd) Check() error {
+	if g.file == "" {
+		return errors.New("no backup file specified (-file)")
+	}
+
+	return nil
+}
+
+// readBackupPassphrase returns the passphrase from the file if set, otherwise from the environment. An empty passphrase means the backup is not encrypted
+func readBackupPassphrase(path string) (string, error) {
+	if path == "" {
+		return os.Getenv(backupPassphraseEnv), nil
+	}
+
+	passphrase, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read passphrase file: %w", err)
+	}
+
+	return strings.TrimRight(string(passphrase), "\r\n"), nil
+}
+
+func (g *backupCmd) Run() error {
+	passphrase, err := readBackupPassphrase(g.passphraseFile)
+	if err != nil {
+		return err
+	}
+
+	ctl := wagctl.NewControlClient(g.socket)
+
+	backup, err := ctl.Backup()
+	if err != nil {
+		return err
+	}
+
+	archive, err := data.EncodeBackup(backup, passphrase)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(g.file, archive, 0600)
+	if err != nil {
+		return err
+	}
+
+	encrypted := "unencrypted"
+	if passphrase != "" {
+		encrypted = "encrypted"
+	}
+
+	fmt.Printf("OK wrote %s backup of %d entries (revision %d) to %s\n", encrypted, len(backup.Entries), backup.Revision, g.file)
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/pkg/control"
+	"github.com/NHAS/wag/pkg/control/wagctl"
+)
+
+type clusterCmd struct {
+	fs *flag.FlagSet
+
+	socket  string
+	action  string
+	id      string
+	name    string
+	peer    string
+	manager string
+}
+
+func Cluster() *clusterCmd {
+	gc := &clusterCmd{
+		fs: flag.NewFlagSet("cluster", flag.ContinueOnError),
+	}
+
+	gc.fs.StringVar(&gc.socket, "socket", control.DefaultWagSocket, "Wag control socket to act on")
+
+	gc.fs.StringVar(&gc.id, "id", "", "Hex id of the member to act on")
+	gc.fs.StringVar(&gc.name, "name", "", "Name of the new member")
+	gc.fs.StringVar(&gc.peer, "peer", "", "Etcd peer url of the new member, e.g https://10.0.0.2:2380")
+	gc.fs.StringVar(&gc.manager, "manager", "", "Url the new member will serve its tls manager on, defaults to https://<peer host>:4545")
+
+	gc.fs.Bool("list", false, "List cluster members and their health")
+	gc.fs.Bool("add", false, "Add a new member, prints the token to start it with (wag start -join <token>)")
+	gc.fs.Bool("promote", false, "Promote a learner to a full member")
+	gc.fs.Bool("drain", false, "Mark a member as drained, so load balancers stop sending clients to it")
+	gc.fs.Bool("restore", false, "Undo -drain")
+	gc.fs.Bool("stepdown", false, "Make this node step down as leader")
+	gc.fs.Bool("remove", false, "Remove a member from the cluster")
+
+	return gc
+}
+
+func (g *clusterCmd) FlagSet() *flag.FlagSet {
+	return g.fs
+}
+
+func (g *clusterCmd) Name() string {
+
+	return g.fs.Name()
+}
+
+func (g *clusterCmd) PrintUsage() {
+	fmt.Println("Usage of cluster:")
+	fmt.Println("  Manage cluster membership")
+	g.fs.PrintDefaults()
+}
+
+func (g *clusterCmd) Check() error {
+	g.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "list", "add", "promote", "drain", "restore", "stepdown", "remove":
+			g.action = strings.ToLower(f.Name)
+		}
+	})
+
+	switch g.action {
+	case "add":
+		if g.name == "" || g.peer == "" {
+			return errors.New("name and peer must be supplied")
+		}
+	case "promote", "drain", "restore", "remove":
+		if g.id == "" {
+			return errors.New("id must be supplied")
+		}
+	case "list", "stepdown":
+	default:
+		return errors.New("one of -list, -add, -promote, -drain, -restore, -stepdown or -remove must be specified")
+	}
+
+	return nil
+}
+
+func (g *clusterCmd) Run() error {
+	ctl := wagctl.NewControlClient(g.socket)
+
+	switch g.action {
+	case "list":
+		members, err := ctl.ClusterMembers()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("id,name,peer_urls,role,status,last_ping,current")
+		for _, member := range members {
+			role := "member"
+			if member.Leader {
+				role = "leader"
+			} else if member.Learner {
+				role = "learner"
+			}
+
+			lastPing := ""
+			if !member.LastPing.IsZero() {
+				lastPing = member.LastPing.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%s,%s,%s,%s,%s,%s,%t\n", member.ID, member.Name, strings.Join(member.PeerURLs, " "), role, member.Status, lastPing, member.Current)
+		}
+
+		return nil
+
+	case "add":
+		token, err := ctl.AddClusterMember(g.name, g.peer, g.manager)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(token)
+		return nil
+
+	default:
+		if err := ctl.ClusterControl(g.id, g.action); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("OK")
+
+	return nil
+}
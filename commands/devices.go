@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/NHAS/wag/internal/data"
 	"github.com/NHAS/wag/pkg/control"
 	"github.com/NHAS/wag/pkg/control/wagctl"
 )
@@ -16,6 +17,9 @@ type devices struct {
 
 	address, username, socket string
 	action                    string
+
+	group                    string
+	intervalDays, graceHours int
 }
 
 func Devices() *devices {
@@ -36,6 +40,14 @@ func Devices() *devices {
 	gc.fs.Bool("unlock", false, "Unlock device")
 	gc.fs.Bool("lock", false, "Lock device access to mfa routes")
 
+	gc.fs.Bool("rotation", false, "List when devices subject to a key rotation policy must next rotate their keys")
+	gc.fs.Bool("rotation_policies", false, "List the key rotation policy of each group")
+	gc.fs.Bool("set_rotation", false, "Set the key rotation policy of -group, an -interval of 0 removes it")
+
+	gc.fs.StringVar(&gc.group, "group", "", "Group the key rotation policy applies to, e.g group:contractors, or * for every user")
+	gc.fs.IntVar(&gc.intervalDays, "interval", 0, "Days between key rotations")
+	gc.fs.IntVar(&gc.graceHours, "grace", data.DefaultKeyRotationGraceHours, "Hours old keys stay valid after new keys are issued")
+
 	return gc
 }
 
@@ -55,7 +67,7 @@ func (g *devices) PrintUsage() {
 func (g *devices) Check() error {
 	g.fs.Visit(func(f *flag.Flag) {
 		switch f.Name {
-		case "unlock", "del", "list", "lock", "mfa_sessions":
+		case "unlock", "del", "list", "lock", "mfa_sessions", "rotation", "rotation_policies", "set_rotation":
 			g.action = strings.ToLower(f.Name)
 		}
 	})
@@ -65,7 +77,11 @@ func (g *devices) Check() error {
 		if g.address == "" && g.username == "" {
 			return errors.New("address or username must be supplied")
 		}
-	case "list", "mfa_sessions":
+	case "set_rotation":
+		if g.group == "" {
+			return errors.New("group must be supplied")
+		}
+	case "list", "mfa_sessions", "rotation", "rotation_policies":
 	default:
 		return errors.New("Unknown flag: " + g.action)
 	}
@@ -123,6 +139,33 @@ func (g *devices) Run() error {
 			return err
 		}
 		fmt.Println(sessions)
+	case "rotation":
+		rotations, err := ctl.KeyRotations()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("username,address,due,overdue,pending")
+		for _, rotation := range rotations {
+			fmt.Printf("%s,%s,%s,%t,%t\n", rotation.Username, rotation.Address, rotation.Due.Format(time.DateTime), rotation.Overdue, rotation.Pending)
+		}
+	case "rotation_policies":
+		policies, err := ctl.KeyRotationPolicies()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("group,interval_days,grace_hours")
+		for group, policy := range policies {
+			fmt.Printf("%s,%d,%d\n", group, policy.IntervalDays, policy.GraceHours)
+		}
+	case "set_rotation":
+		err := ctl.SetKeyRotationPolicy(g.group, data.KeyRotationPolicy{IntervalDays: g.intervalDays, GraceHours: g.graceHours})
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("OK")
 	case "lock":
 
 		if g.username != "" {
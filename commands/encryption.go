@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/encryption"
+	"github.com/NHAS/wag/pkg/control"
+	"github.com/NHAS/wag/pkg/control/wagctl"
+)
+
+type encryptionCmd struct {
+	fs *flag.FlagSet
+
+	socket string
+	action string
+}
+
+func Encryption() *encryptionCmd {
+	gc := &encryptionCmd{
+		fs: flag.NewFlagSet("encryption", flag.ContinueOnError),
+	}
+
+	gc.fs.StringVar(&gc.socket, "socket", control.DefaultWagSocket, "Wag control socket to act on")
+
+	gc.fs.Bool("status", false, "Show the active data key and how many users, devices and administrators are not yet encrypted with it")
+	gc.fs.Bool("rotate", false, "Create a new data key, re-encrypt all secrets with it and remove unused data keys")
+	gc.fs.Bool("generate-key", false, "Print a new random key suitable for Encryption.KeyFile or Encryption.KeyEnv")
+
+	return gc
+}
+
+func (g *encryptionCmd) FlagSet() *flag.FlagSet {
+	return g.fs
+}
+
+func (g *encryptionCmd) Name() string {
+
+	return g.fs.Name()
+}
+
+func (g *encryptionCmd) PrintUsage() {
+	fmt.Println("Usage of encryption:")
+	fmt.Println("  Manage encryption at rest of mfa secrets and wireguard preshared keys")
+	g.fs.PrintDefaults()
+}
+
+func (g *encryptionCmd) Check() error {
+	g.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "status", "rotate", "generate-key":
+			g.action = strings.ToLower(f.Name)
+		}
+	})
+
+	if g.action == "" {
+		return errors.New("one of -status, -rotate or -generate-key must be specified")
+	}
+
+	return nil
+}
+
+func printEncryptionStatus(status data.EncryptionStatus) {
+	if !status.Enabled {
+		fmt.Println("encryption is not configured, secrets are stored in plaintext")
+		return
+	}
+
+	fmt.Println("key encryption key:", status.KEK)
+	fmt.Println("active data key:", status.Active)
+	fmt.Println("users, devices and administrators not encrypted with the active key:", status.Outdated)
+
+	fmt.Println("\nid,kek,created")
+	for _, key := range status.Keys {
+		fmt.Printf("%s,%s,%s\n", key.ID, key.KEK, key.Created.Format(time.RFC3339))
+	}
+}
+
+func (g *encryptionCmd) Run() error {
+	if g.action == "generate-key" {
+		key, err := encryption.NewDataKey()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+		return nil
+	}
+
+	ctl := wagctl.NewControlClient(g.socket)
+
+	switch g.action {
+	case "status":
+		status, err := ctl.EncryptionStatus()
+		if err != nil {
+			return err
+		}
+
+		printEncryptionStatus(status)
+
+	case "rotate":
+		status, err := ctl.RotateEncryptionKey()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("OK")
+		printEncryptionStatus(status)
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/NHAS/wag/pkg/control"
 	"github.com/NHAS/wag/pkg/control/wagctl"
@@ -21,6 +22,20 @@ func (i *arrayFlags) Set(value string) error {
 	return nil
 }
 
+// parseExpiry accepts a date (2006-01-02, the start of that day in local time) or an RFC3339 time
+func parseExpiry(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("expiry must be a date (YYYY-MM-DD) or RFC3339 time")
+	}
+
+	return t, nil
+}
+
 type registration struct {
 	fs *flag.FlagSet
 
@@ -34,6 +49,9 @@ type registration struct {
 	overwrite    string
 
 	uses int
+
+	expires        string
+	accountExpires time.Time
 }
 
 func Registration() *registration {
@@ -52,6 +70,7 @@ func Registration() *registration {
 	gc.fs.StringVar(&gc.overwrite, "overwrite", "", "Add registration token for an existing user device, will overwrite wireguard public key (but not 2FA)")
 
 	gc.fs.IntVar(&gc.uses, "uses", 1, "Number of times a registration token can be used")
+	gc.fs.StringVar(&gc.expires, "expires", "", "Date the users account expires and is locked, YYYY-MM-DD or RFC3339 (Optional)")
 
 	gc.fs.Bool("add", false, "Create a new enrolment token")
 	gc.fs.Bool("del", false, "Delete existing enrolment token")
@@ -97,6 +116,14 @@ func (g *registration) Check() error {
 			return errors.New("username must be supplied")
 		}
 
+		if g.expires != "" {
+			var err error
+			g.accountExpires, err = parseExpiry(g.expires)
+			if err != nil {
+				return err
+			}
+		}
+
 	case "del":
 		if g.token == "" && g.username == "" {
 			return errors.New("token or username must be supplied")
@@ -117,7 +144,7 @@ func (g *registration) Run() error {
 	switch g.action {
 	case "add":
 
-		result, err := ctl.NewRegistration(g.token, g.username, g.overwrite, g.uses, g.groups...)
+		result, err := ctl.NewExpiringRegistration(g.token, g.username, g.overwrite, g.uses, g.accountExpires, g.groups...)
 		if err != nil {
 			return err
 		}
@@ -144,9 +171,14 @@ func (g *registration) Run() error {
 			return err
 		}
 
-		fmt.Println("token,username,overwrites,groups")
+		fmt.Println("token,username,overwrites,groups,account_expires")
 		for _, token := range tokens {
-			fmt.Printf("%s,%s,%s,%s\n", token.Token, token.Username, token.Overwrites, token.Groups)
+			expires := ""
+			if !token.AccountExpires.IsZero() {
+				expires = token.AccountExpires.Format(time.DateTime)
+			}
+
+			fmt.Printf("%s,%s,%s,%s,%s\n", token.Token, token.Username, token.Overwrites, token.Groups, expires)
 		}
 	}
 
@@ -0,0 +1,112 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control/wagctl"
+)
+
+type restoreCmd struct {
+	fs *flag.FlagSet
+
+	config         string
+	file           string
+	passphraseFile string
+}
+
+func Restore() *restoreCmd {
+	gc := &restoreCmd{
+		fs: flag.NewFlagSet("restore", flag.ContinueOnError),
+	}
+
+	gc.fs.StringVar(&gc.config, "config", "./config.json", "Configuration file location")
+	gc.fs.StringVar(&gc.file, "file", "", "Backup archive to restore")
+	gc.fs.StringVar(&gc.passphraseFile, "passphrase-file", "", "Passphrase for an encrypted backup (or set "+backupPassphraseEnv+")")
+
+	return gc
+}
+
+func (g *restoreCmd) FlagSet() *flag.FlagSet {
+	return g.fs
+}
+
+func (g *restoreCmd) Name() string {
+
+	return g.fs.Name()
+}
+
+func (g *restoreCmd) PrintUsage() {
+	fmt.Println("Usage of restore:")
+	fmt.Println("  Rebuild this node as a new single node cluster from a backup, wag must not be running. The existing cluster data is moved aside, not deleted")
+	g.fs.PrintDefaults()
+}
+
+func (g *restoreCmd) Check() error {
+	if g.file == "" {
+		return errors.New("no backup file specified (-file)")
+	}
+
+	return config.Load(g.config)
+}
+
+func (g *restoreCmd) Run() error {
+	if _, err := wagctl.NewControlClient(config.Values.Socket).GetVersion(); err == nil {
+		return errors.New("wag is running (control socket " + config.Values.Socket + " responded), stop it before restoring")
+	}
+
+	passphrase, err := readBackupPassphrase(g.passphraseFile)
+	if err != nil {
+		return err
+	}
+
+	raw, err := os.ReadFile(g.file)
+	if err != nil {
+		return err
+	}
+
+	backup, err := data.DecodeBackup(raw, passphrase)
+	if err != nil {
+		return err
+	}
+
+	// An external etcd cluster has no local state to move aside, the restore replaces everything under the configured prefix.
+	// Every wag node using it must be stopped first
+	if !config.Values.Clustering.IsExternal() {
+		etcdDir := filepath.Join(config.Values.Clustering.DatabaseLocation, config.Values.Clustering.Name+".wag-node.etcd")
+		if _, err := os.Stat(etcdDir); err == nil {
+			moved := etcdDir + "." + time.Now().Format("20060102150405") + ".bak"
+			err = os.Rename(etcdDir, moved)
+			if err != nil {
+				return fmt.Errorf("unable to move existing cluster data: %w", err)
+			}
+
+			fmt.Println("moved existing cluster data to", moved)
+		}
+
+		// Always start a fresh cluster with only this node, other members must rejoin
+		config.Values.Clustering.ClusterState = "new"
+		config.Values.Clustering.Peers = map[string][]string{}
+	}
+
+	err = data.Load(config.Values.DatabaseLocation, "", false)
+	if err != nil {
+		return fmt.Errorf("cannot load database: %v", err)
+	}
+	defer data.TearDown()
+
+	err = data.RestoreBackup(backup)
+	if err != nil {
+		return fmt.Errorf("unable to restore backup: %w", err)
+	}
+
+	fmt.Printf("OK restored %d entries from backup taken %s (wag %s)\n", len(backup.Entries), backup.Created.Format(time.RFC3339), backup.WagVersion)
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control"
+	"github.com/NHAS/wag/pkg/control/wagctl"
+)
+
+type settingsCmd struct {
+	fs *flag.FlagSet
+
+	socket string
+	action string
+	name   string
+	value  string
+	file   string
+}
+
+func Settings() *settingsCmd {
+	gc := &settingsCmd{
+		fs: flag.NewFlagSet("settings", flag.ContinueOnError),
+	}
+
+	gc.fs.StringVar(&gc.socket, "socket", control.DefaultWagSocket, "Wag control socket to act on")
+
+	gc.fs.StringVar(&gc.name, "name", "", "Setting to change with -set, one of: "+strings.Join(data.SettingNames, ", "))
+	gc.fs.StringVar(&gc.value, "value", "", "New value of the setting, lists are ',' delimited")
+	gc.fs.StringVar(&gc.file, "file", "", "Json file of settings for -general or -login, in the format output by -get")
+
+	gc.fs.Bool("get", false, "Print all settings as json")
+	gc.fs.Bool("set", false, "Change a single setting")
+	gc.fs.Bool("general", false, "Replace the general settings with those in -file")
+	gc.fs.Bool("login", false, "Replace the login settings with those in -file")
+
+	return gc
+}
+
+func (g *settingsCmd) FlagSet() *flag.FlagSet {
+	return g.fs
+}
+
+func (g *settingsCmd) Name() string {
+
+	return g.fs.Name()
+}
+
+func (g *settingsCmd) PrintUsage() {
+	fmt.Println("Usage of settings:")
+	fmt.Println("  View and change general and login settings")
+	g.fs.PrintDefaults()
+}
+
+func (g *settingsCmd) Check() error {
+	g.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "get", "set", "general", "login":
+			g.action = strings.ToLower(f.Name)
+		}
+	})
+
+	switch g.action {
+	case "set":
+		if g.name == "" {
+			return errors.New("name must be supplied")
+		}
+	case "general", "login":
+		if g.file == "" {
+			return errors.New("file must be supplied")
+		}
+	case "get":
+	default:
+		return errors.New("one of -get, -set, -general or -login must be specified")
+	}
+
+	return nil
+}
+
+func (g *settingsCmd) Run() error {
+	ctl := wagctl.NewControlClient(g.socket)
+
+	switch g.action {
+	case "get":
+		settings, err := ctl.GetSettings()
+		if err != nil {
+			return err
+		}
+
+		b, err := json.MarshalIndent(settings, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(b))
+		return nil
+
+	case "set":
+		if err := ctl.SetSetting(g.name, g.value); err != nil {
+			return err
+		}
+
+	case "general", "login":
+		content, err := os.ReadFile(g.file)
+		if err != nil {
+			return err
+		}
+
+		// Accept both a bare settings object and the full output of -get, as they share field names
+		if g.action == "general" {
+			var general data.GeneralSettings
+			if err := json.Unmarshal(content, &general); err != nil {
+				return err
+			}
+
+			err = ctl.SetGeneralSettings(general)
+		} else {
+			var login data.LoginSettings
+			if err := json.Unmarshal(content, &login); err != nil {
+				return err
+			}
+
+			err = ctl.SetLoginSettings(login)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("OK")
+
+	return nil
+}
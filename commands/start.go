@@ -11,9 +11,12 @@ import (
 	"sync"
 	"syscall"
 
+	"github.com/NHAS/wag/internal/certificates"
 	"github.com/NHAS/wag/internal/config"
 	"github.com/NHAS/wag/internal/data"
+	metrics "github.com/NHAS/wag/internal/metrics/server"
 	"github.com/NHAS/wag/internal/router"
+	"github.com/NHAS/wag/internal/sinks"
 	"github.com/NHAS/wag/internal/webserver"
 	"github.com/NHAS/wag/pkg/control/server"
 	"github.com/NHAS/wag/ui"
@@ -105,6 +108,10 @@ func teardown(force bool) {
 
 	ui.Teardown()
 	webserver.Teardown()
+	metrics.Teardown()
+
+	sinks.Teardown()
+	certificates.Teardown()
 
 }
 
@@ -151,6 +158,18 @@ func clusterState(noIptables bool, errorChan chan<- error) func(string) {
 						return
 					}
 
+					err = certificates.Start(errorChan)
+					if err != nil {
+						errorChan <- fmt.Errorf("unable to start certificate management: %v", err)
+						return
+					}
+
+					err = server.StartManagementAPI(errorChan)
+					if err != nil {
+						errorChan <- fmt.Errorf("unable to start management api: %v", err)
+						return
+					}
+
 					err = webserver.Start(errorChan)
 					if err != nil {
 						errorChan <- fmt.Errorf("unable to start webserver: %v", err)
@@ -162,6 +181,18 @@ func clusterState(noIptables bool, errorChan chan<- error) func(string) {
 						errorChan <- fmt.Errorf("unable to start management web server: %v", err)
 						return
 					}
+
+					err = metrics.Start(errorChan)
+					if err != nil {
+						errorChan <- fmt.Errorf("unable to start metrics listener: %v", err)
+						return
+					}
+
+					err = sinks.Start()
+					if err != nil {
+						errorChan <- fmt.Errorf("unable to start event sinks: %v", err)
+						return
+					}
 				}
 
 				wasDead = false
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/NHAS/wag/pkg/control"
 	"github.com/NHAS/wag/pkg/control/wagctl"
@@ -15,6 +16,8 @@ type users struct {
 
 	username, socket string
 	action           string
+	keyId            string
+	expires          string
 }
 
 func Users() *users {
@@ -31,8 +34,14 @@ func Users() *users {
 	gc.fs.Bool("lockaccount", false, "Lock account disable authention from any device, deauthenticates user active sessions")
 	gc.fs.Bool("unlockaccount", false, "Unlock a locked account, does not unlock specific device locks (use device -unlock -username <> for that)")
 
+	gc.fs.StringVar(&gc.expires, "expires", "", "Lock the account once this date passes, YYYY-MM-DD or RFC3339, 'never' removes the expiry")
+
 	gc.fs.Bool("reset-mfa", false, "Reset MFA details, invalids all session and set MFA to be shown")
 
+	gc.fs.Bool("list-keys", false, "List the security keys registered by a webauthn user")
+	gc.fs.StringVar(&gc.keyId, "delete-key", "", "Remove a security key by id (from -list-keys), the users last key cannot be removed")
+	gc.fs.Bool("recovery-codes", false, "Generate new one time recovery codes for a user, replacing any existing codes")
+
 	return gc
 }
 
@@ -52,13 +61,13 @@ func (g *users) PrintUsage() {
 func (g *users) Check() error {
 	g.fs.Visit(func(f *flag.Flag) {
 		switch f.Name {
-		case "lockaccount", "unlockaccount", "del", "list", "reset-mfa":
+		case "lockaccount", "unlockaccount", "del", "list", "reset-mfa", "list-keys", "delete-key", "recovery-codes", "expires":
 			g.action = strings.ToLower(f.Name)
 		}
 	})
 
 	switch g.action {
-	case "del", "unlockaccount", "lockaccount", "reset-mfa":
+	case "del", "unlockaccount", "lockaccount", "reset-mfa", "list-keys", "delete-key", "recovery-codes", "expires":
 		if g.username == "" {
 			return errors.New("username must be supplied")
 		}
@@ -90,9 +99,16 @@ func (g *users) Run() error {
 			return err
 		}
 
-		fmt.Println("username,locked,enforcingmfa")
+		format := func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Format(time.DateTime)
+		}
+
+		fmt.Println("username,locked,enforcingmfa,expires,last_seen")
 		for _, user := range users {
-			fmt.Printf("%s,%t,%t\n", user.Username, user.Locked, user.Enforcing)
+			fmt.Printf("%s,%t,%t,%s,%s\n", user.Username, user.Locked, user.Enforcing, format(user.Expires), format(user.LastActive()))
 		}
 	case "lockaccount":
 
@@ -117,6 +133,50 @@ func (g *users) Run() error {
 			return err
 		}
 		fmt.Println("OK")
+
+	case "list-keys":
+		keys, err := ctl.ListUserKeys(g.username)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("id,name,added")
+		for _, key := range keys {
+			fmt.Printf("%s,%s,%s\n", key.ID, key.Name, key.Added.Format(time.DateTime))
+		}
+
+	case "delete-key":
+		err := ctl.DeleteUserKey(g.username, g.keyId)
+		if err != nil {
+			return err
+		}
+		fmt.Println("OK")
+
+	case "expires":
+		var expires time.Time
+		if g.expires != "never" {
+			var err error
+			expires, err = parseExpiry(g.expires)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := ctl.SetUserExpiry(g.username, expires)
+		if err != nil {
+			return err
+		}
+		fmt.Println("OK")
+
+	case "recovery-codes":
+		codes, err := ctl.GenerateRecoveryCodes(g.username)
+		if err != nil {
+			return err
+		}
+
+		for _, code := range codes {
+			fmt.Println(code)
+		}
 	}
 
 	return nil
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/NHAS/wag/internal/data"
 	"github.com/NHAS/wag/pkg/control"
 	"github.com/NHAS/wag/pkg/control/wagctl"
 )
@@ -16,6 +17,7 @@ type webadmin struct {
 	username, password, socket string
 	action                     string
 	isTempPass                 bool
+	role, groups               string
 }
 
 func Webadmin() *webadmin {
@@ -26,6 +28,8 @@ func Webadmin() *webadmin {
 	gc.fs.StringVar(&gc.username, "username", "", "Admin Username to act upon")
 	gc.fs.StringVar(&gc.password, "password", "", "Password to set")
 	gc.fs.StringVar(&gc.socket, "socket", control.DefaultWagSocket, "Wag instance control socket")
+	gc.fs.StringVar(&gc.role, "role", string(data.RoleSuperAdmin), "Role of the administrator for -add or -setrole, one of: "+strings.Join(roles(), ", "))
+	gc.fs.StringVar(&gc.groups, "groups", "", "',' delimited groups the administrator is limited to acting on, e.g group:engineering (optional, not for superadmins)")
 
 	gc.fs.Bool("add", false, "Add web administrator user (requires -password)")
 	gc.fs.Bool("temp", false, "If the user should be forced to change their password on first use (requires -add)")
@@ -33,6 +37,8 @@ func Webadmin() *webadmin {
 	gc.fs.Bool("del", false, "Delete admin user")
 	gc.fs.Bool("list", false, "List web administration users, if '-username' supply will filter by user")
 	gc.fs.Bool("reset", false, "Reset admin user account password (requires -password and -username)")
+	gc.fs.Bool("setrole", false, "Change the role and groups of an admin user (requires -username and -role)")
+	gc.fs.Bool("resetmfa", false, "Remove the management ui mfa of an admin user, they enrol again on next login (requires -username)")
 
 	gc.fs.Bool("lockaccount", false, "Lock admin account disable login for this web administrator user")
 	gc.fs.Bool("unlockaccount", false, "Unlock a web administrator account")
@@ -40,6 +46,22 @@ func Webadmin() *webadmin {
 	return gc
 }
 
+func roles() (result []string) {
+	for _, role := range data.AdminRoles {
+		result = append(result, string(role))
+	}
+	return result
+}
+
+func splitGroups(groups string) (result []string) {
+	for _, group := range strings.Split(groups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
 func (g *webadmin) FlagSet() *flag.FlagSet {
 	return g.fs
 }
@@ -56,7 +78,7 @@ func (g *webadmin) PrintUsage() {
 func (g *webadmin) Check() error {
 	g.fs.Visit(func(f *flag.Flag) {
 		switch f.Name {
-		case "lockaccount", "unlockaccount", "del", "list", "add", "reset":
+		case "lockaccount", "unlockaccount", "del", "list", "add", "reset", "setrole", "resetmfa":
 			g.action = strings.ToLower(f.Name)
 		case "temp":
 			g.isTempPass = true
@@ -64,7 +86,7 @@ func (g *webadmin) Check() error {
 	})
 
 	switch g.action {
-	case "del", "unlockaccount", "lockaccount":
+	case "del", "unlockaccount", "lockaccount", "setrole", "resetmfa":
 		if g.username == "" {
 			return errors.New("address must be supplied")
 		}
@@ -89,7 +111,15 @@ func (g *webadmin) Run() error {
 
 	case "add":
 
-		err := ctl.AddAdminUser(g.username, g.password, g.isTempPass)
+		err := ctl.AddAdminUserWithRole(g.username, g.password, g.isTempPass, data.AdminRole(g.role), splitGroups(g.groups))
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("OK")
+
+	case "setrole":
+		err := ctl.SetAdminRole(g.username, data.AdminRole(g.role), splitGroups(g.groups))
 		if err != nil {
 			return err
 		}
@@ -104,6 +134,14 @@ func (g *webadmin) Run() error {
 
 		fmt.Println("OK")
 
+	case "resetmfa":
+		err := ctl.ResetAdminUserMFA(g.username)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("OK")
+
 	case "del":
 
 		err := ctl.DeleteAdminUser(g.username)
@@ -120,9 +158,9 @@ func (g *webadmin) Run() error {
 			return err
 		}
 
-		fmt.Println("username,attempts,date_added,last_login,ip")
+		fmt.Println("username,role,groups,mfa,sso,attempts,date_added,last_login,ip")
 		for _, user := range users {
-			fmt.Printf("%s,%d,%s,%s,%s\n", user.Username, user.Attempts, user.DateAdded, user.LastLogin, user.IP)
+			fmt.Printf("%s,%s,%s,%s,%t,%d,%s,%s,%s\n", user.Username, user.EffectiveRole(), strings.Join(user.Groups, " "), user.MfaType, user.SSO, user.Attempts, user.DateAdded, user.LastLogin, user.IP)
 		}
 	case "lockaccount":
 
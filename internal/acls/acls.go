@@ -4,4 +4,7 @@ type Acl struct {
 	Mfa   []string `json:",omitempty"`
 	Allow []string `json:",omitempty"`
 	Deny  []string `json:",omitempty"`
+
+	// StepUp routes are only accessible once the user has completed all of the configured step up factors
+	StepUp []string `json:",omitempty"`
 }
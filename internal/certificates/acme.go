@@ -0,0 +1,398 @@
+package certificates
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	renewalCheckInterval = time.Hour
+
+	// Failed orders are retried with a growing delay so a misconfigured domain does not hit the ACME server rate limits
+	initialRetryDelay = 10 * time.Minute
+	maxRetryDelay     = 6 * time.Hour
+
+	orderTimeout = 10 * time.Minute
+
+	challengePath = "/.well-known/acme-challenge/"
+)
+
+// HTTPChallengeHandler answers http-01 challenges for any order in the cluster and passes every other request to next
+func HTTPChallengeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, challengePath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.URL.Path, challengePath)
+		if token == "" || strings.Contains(token, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		keyAuthorization, err := data.GetACMEChallenge(token)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuthorization))
+	})
+}
+
+// renewals obtains missing certificates and renews those close to expiring, only the leader does this and the others load the result from etcd
+func renewals(cancel <-chan bool, domains []string) {
+	ticker := time.NewTicker(renewalCheckInterval)
+	defer ticker.Stop()
+
+	retries := newRetrySchedule()
+
+	check := func() {
+		if !data.IsLeader() {
+			return
+		}
+
+		for _, domain := range domains {
+			if !retries.due(domain, time.Now()) {
+				continue
+			}
+
+			due, err := renewalDue(domain)
+			if err != nil {
+				log.Println("unable to check ACME certificate for", domain, ": ", err)
+				continue
+			}
+
+			if !due {
+				continue
+			}
+
+			log.Println("obtaining ACME certificate for", domain)
+
+			ctx, cancelOrder := context.WithTimeout(context.Background(), orderTimeout)
+			err = obtain(ctx, domain)
+			cancelOrder()
+
+			if err != nil {
+				delay := retries.failed(domain, time.Now())
+
+				err = fmt.Errorf("unable to obtain ACME certificate for %s, retrying in %s: %w", domain, delay, err)
+				log.Println(err)
+				data.RaiseError(err, []byte(domain))
+				continue
+			}
+
+			retries.succeeded(domain)
+		}
+	}
+
+	// Dont wait an hour for the first certificate
+	check()
+
+	for {
+		select {
+		case <-ticker.C:
+			check()
+		case <-cancel:
+			return
+		}
+	}
+}
+
+// retrySchedule tracks failed orders per domain, each failure doubles the wait before the next attempt
+type retrySchedule struct {
+	at    map[string]time.Time
+	delay map[string]time.Duration
+}
+
+func newRetrySchedule() *retrySchedule {
+	return &retrySchedule{at: map[string]time.Time{}, delay: map[string]time.Duration{}}
+}
+
+func (r *retrySchedule) due(domain string, now time.Time) bool {
+	return !now.Before(r.at[domain])
+}
+
+// failed schedules the next attempt for domain and returns how long until it
+func (r *retrySchedule) failed(domain string, now time.Time) time.Duration {
+	delay := r.delay[domain]
+	if delay == 0 {
+		delay = initialRetryDelay
+	}
+
+	r.at[domain] = now.Add(delay)
+	r.delay[domain] = min(delay*2, maxRetryDelay)
+
+	return delay
+}
+
+func (r *retrySchedule) succeeded(domain string) {
+	delete(r.at, domain)
+	delete(r.delay, domain)
+}
+
+func renewalDue(domain string) (bool, error) {
+	certificate, ok, err := data.GetACMECertificate(domain)
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return true, nil
+	}
+
+	renewBefore := time.Duration(config.Values.ACME.RenewBeforeDays) * 24 * time.Hour
+	return time.Until(certificate.NotAfter) < renewBefore, nil
+}
+
+// client returns an ACME client for the shared account, registering one if there is no account for the configured directory
+func client(ctx context.Context) (*acme.Client, error) {
+	settings := config.Values.ACME
+
+	account, ok, err := data.GetACMEAccount(settings.DirectoryURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get ACME account: %w", err)
+	}
+
+	if ok {
+		key, err := parsePrivateKey(account.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse ACME account key: %w", err)
+		}
+
+		return &acme.Client{
+			Key:          key,
+			KID:          acme.KeyID(account.URI),
+			DirectoryURL: settings.DirectoryURL,
+		}, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &acme.Client{
+		Key:          key,
+		DirectoryURL: settings.DirectoryURL,
+	}
+
+	registration := &acme.Account{}
+	if settings.Email != "" {
+		registration.Contact = []string{"mailto:" + settings.Email}
+	}
+
+	registered, err := c.Register(ctx, registration, acme.AcceptTOS)
+	if err != nil {
+		return nil, fmt.Errorf("unable to register ACME account: %w", err)
+	}
+
+	encodedKey, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = data.SetACMEAccount(data.ACMEAccount{
+		DirectoryURL: settings.DirectoryURL,
+		Email:        settings.Email,
+		URI:          registered.URI,
+		PrivateKey:   encodedKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to store ACME account: %w", err)
+	}
+
+	log.Println("registered ACME account with", settings.DirectoryURL)
+
+	return c, nil
+}
+
+// obtain orders a certificate for domain and stores it in etcd, where every node picks it up
+func obtain(ctx context.Context, domain string) error {
+	c, err := client(ctx)
+	if err != nil {
+		return err
+	}
+
+	order, err := c.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return fmt.Errorf("unable to create order: %w", err)
+	}
+
+	for _, authorizationURL := range order.AuthzURLs {
+		if err := authorize(ctx, c, authorizationURL); err != nil {
+			return err
+		}
+	}
+
+	order, err = c.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("order was not ready: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	chain, _, err := c.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("unable to finalise order: %w", err)
+	}
+
+	if len(chain) == 0 {
+		return errors.New("ACME server returned no certificates")
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return fmt.Errorf("ACME server returned an invalid certificate: %w", err)
+	}
+
+	var encodedChain strings.Builder
+	for _, der := range chain {
+		if err := pem.Encode(&encodedChain, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return err
+		}
+	}
+
+	encodedKey, err := encodePrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = data.SetACMECertificate(data.ACMECertificate{
+		Domain:      domain,
+		Certificate: encodedChain.String(),
+		PrivateKey:  encodedKey,
+		NotAfter:    leaf.NotAfter,
+		Issued:      time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to store certificate: %w", err)
+	}
+
+	log.Println("obtained ACME certificate for", domain, "valid until", leaf.NotAfter.Format(time.RFC3339))
+
+	return nil
+}
+
+// authorize completes the configured challenge type for a single authorization of an order
+func authorize(ctx context.Context, c *acme.Client, authorizationURL string) error {
+	authorization, err := c.GetAuthorization(ctx, authorizationURL)
+	if err != nil {
+		return fmt.Errorf("unable to get authorization: %w", err)
+	}
+
+	if authorization.Status == acme.StatusValid {
+		return nil
+	}
+
+	settings := config.Values.ACME
+
+	var challenge *acme.Challenge
+	for _, offered := range authorization.Challenges {
+		if offered.Type == settings.Challenge {
+			challenge = offered
+			break
+		}
+	}
+
+	if challenge == nil {
+		return fmt.Errorf("ACME server did not offer a %s challenge for %s", settings.Challenge, authorization.Identifier.Value)
+	}
+
+	switch challenge.Type {
+	case "http-01":
+		keyAuthorization, err := c.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return err
+		}
+
+		if err := data.SetACMEChallenge(challenge.Token, keyAuthorization); err != nil {
+			return fmt.Errorf("unable to store http-01 challenge: %w", err)
+		}
+		defer data.DeleteACMEChallenge(challenge.Token)
+
+	case "dns-01":
+		value, err := c.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return err
+		}
+
+		provider, err := newDNSProvider(settings.DNS.Provider, settings.DNS.Options)
+		if err != nil {
+			return err
+		}
+
+		fqdn := "_acme-challenge." + strings.TrimSuffix(authorization.Identifier.Value, ".") + "."
+		if err := provider.Present(ctx, fqdn, value); err != nil {
+			return fmt.Errorf("unable to create dns-01 record %s: %w", fqdn, err)
+		}
+		defer func() {
+			if err := provider.CleanUp(context.Background(), fqdn, value); err != nil {
+				log.Println("unable to remove dns-01 record", fqdn, ": ", err)
+			}
+		}()
+
+		select {
+		case <-time.After(time.Duration(settings.DNS.PropagationSeconds) * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if _, err := c.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("unable to accept %s challenge: %w", challenge.Type, err)
+	}
+
+	if _, err := c.WaitAuthorization(ctx, authorization.URI); err != nil {
+		return fmt.Errorf("%s challenge for %s failed: %w", challenge.Type, authorization.Identifier.Value, err)
+	}
+
+	return nil
+}
+
+func encodePrivateKey(key *ecdsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+}
+
+func parsePrivateKey(encoded string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	return x509.ParseECPrivateKey(block.Bytes)
+}
@@ -0,0 +1,112 @@
+package certificates
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+)
+
+func TestHTTPChallengeHandler(t *testing.T) {
+	if err := data.SetACMEChallenge("known-token", "known-token.thumbprint"); err != nil {
+		t.Fatal(err)
+	}
+	defer data.DeleteACMEChallenge("known-token")
+
+	handler := HTTPChallengeHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	for _, test := range []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{http.MethodGet, "/.well-known/acme-challenge/known-token", http.StatusOK, "known-token.thumbprint"},
+		{http.MethodGet, "/.well-known/acme-challenge/unknown-token", http.StatusNotFound, ""},
+		{http.MethodGet, "/.well-known/acme-challenge/", http.StatusNotFound, ""},
+		{http.MethodGet, "/.well-known/acme-challenge/known-token/extra", http.StatusNotFound, ""},
+		{http.MethodPost, "/.well-known/acme-challenge/known-token", http.StatusTeapot, ""},
+		{http.MethodGet, "/register_device", http.StatusTeapot, ""},
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, nil))
+
+		if recorder.Code != test.status {
+			t.Fatalf("%s %s: expected status %d got %d", test.method, test.path, test.status, recorder.Code)
+		}
+
+		if test.body != "" && recorder.Body.String() != test.body {
+			t.Fatalf("%s %s: expected %q got %q", test.method, test.path, test.body, recorder.Body.String())
+		}
+	}
+}
+
+func TestRenewalDue(t *testing.T) {
+	previous := config.Values.ACME.RenewBeforeDays
+	config.Values.ACME.RenewBeforeDays = 30
+	defer func() { config.Values.ACME.RenewBeforeDays = previous }()
+
+	if due, err := renewalDue("missing.example.com"); err != nil || !due {
+		t.Fatal("a domain without a certificate should be due: ", due, err)
+	}
+
+	for _, test := range []struct {
+		domain   string
+		notAfter time.Time
+		due      bool
+	}{
+		{"fresh.example.com", time.Now().Add(60 * 24 * time.Hour), false},
+		{"expiring.example.com", time.Now().Add(29 * 24 * time.Hour), true},
+		{"expired.example.com", time.Now().Add(-time.Hour), true},
+	} {
+		certificate, key := selfSigned(t, test.domain, test.notAfter)
+		err := data.SetACMECertificate(data.ACMECertificate{Domain: test.domain, Certificate: certificate, PrivateKey: key, NotAfter: test.notAfter})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if due, err := renewalDue(test.domain); err != nil || due != test.due {
+			t.Fatalf("%s expiring %s: expected due %t got %t (%v)", test.domain, test.notAfter, test.due, due, err)
+		}
+	}
+}
+
+func TestRetrySchedule(t *testing.T) {
+	retries := newRetrySchedule()
+	now := time.Now()
+
+	if !retries.due("example.com", now) {
+		t.Fatal("domains that have not failed should be due")
+	}
+
+	expected := initialRetryDelay
+	for i := 0; i < 10; i++ {
+		delay := retries.failed("example.com", now)
+		if delay != expected {
+			t.Fatalf("failure %d: expected a delay of %s got %s", i+1, expected, delay)
+		}
+
+		if retries.due("example.com", now.Add(delay-time.Second)) || !retries.due("example.com", now.Add(delay)) {
+			t.Fatal("domain should only be due once the delay has passed")
+		}
+
+		expected = min(expected*2, maxRetryDelay)
+	}
+
+	if expected != maxRetryDelay {
+		t.Fatal("delay should be capped: ", expected)
+	}
+
+	if !retries.due("other.example.com", now) {
+		t.Fatal("failures should only delay their own domain")
+	}
+
+	retries.succeeded("example.com")
+	if !retries.due("example.com", now) || retries.failed("example.com", now) != initialRetryDelay {
+		t.Fatal("success should reset the delay")
+	}
+}
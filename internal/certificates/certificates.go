@@ -0,0 +1,231 @@
+package certificates
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+)
+
+// Certificate files are checked for changes at most this often, so they can be replaced (e.g by certbot) without restarting wag
+const fileCheckInterval = 30 * time.Second
+
+var (
+	lck sync.RWMutex
+	// Certificates obtained with ACME by any node in the cluster, by domain
+	acmeCertificates = map[string]*tls.Certificate{}
+
+	listenerKeys []string
+	cancel       chan bool
+
+	challengeServer *http.Server
+)
+
+// Start loads certificates obtained with ACME and watches for renewals, the leader obtains and renews them
+func Start(errs chan<- error) error {
+	domains := config.Values.ACMEDomains()
+	if len(domains) == 0 {
+		return nil
+	}
+
+	current, err := data.GetACMECertificates()
+	if err != nil {
+		return fmt.Errorf("unable to get ACME certificates: %w", err)
+	}
+
+	for _, certificate := range current {
+		if err := loadACMECertificate(certificate); err != nil {
+			log.Println("unable to load ACME certificate for", certificate.Domain, ": ", err)
+		}
+	}
+
+	key, err := data.RegisterEventListener(data.ACMECertificatesPrefix, true, certificatesChanged)
+	if err != nil {
+		return err
+	}
+	listenerKeys = append(listenerKeys, key)
+
+	if config.Values.ACME.HTTPListenAddress != "" {
+		challengeServer = &http.Server{
+			Addr:         config.Values.ACME.HTTPListenAddress,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			IdleTimeout:  120 * time.Second,
+			Handler:      HTTPChallengeHandler(http.NotFoundHandler()),
+		}
+
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errs <- fmt.Errorf("ACME http-01 challenge listener failed: %v", err)
+			}
+		}(challengeServer)
+	}
+
+	cancel = make(chan bool)
+	go renewals(cancel, domains)
+
+	log.Println("Started ACME certificate management for:", strings.Join(domains, ", "))
+
+	return nil
+}
+
+func Teardown() {
+	for _, key := range listenerKeys {
+		data.DeregisterEventListener(key)
+	}
+	listenerKeys = nil
+
+	if cancel != nil {
+		close(cancel)
+		cancel = nil
+	}
+
+	if challengeServer != nil {
+		challengeServer.Close()
+		challengeServer = nil
+	}
+}
+
+func loadACMECertificate(certificate data.ACMECertificate) error {
+	pair, err := tls.X509KeyPair([]byte(certificate.Certificate), []byte(certificate.PrivateKey))
+	if err != nil {
+		return err
+	}
+
+	lck.Lock()
+	acmeCertificates[certificate.Domain] = &pair
+	lck.Unlock()
+
+	return nil
+}
+
+func certificatesChanged(key string, current, previous data.ACMECertificate, et data.EventType) error {
+	switch et {
+	case data.CREATED, data.MODIFIED:
+		if err := loadACMECertificate(current); err != nil {
+			return fmt.Errorf("unable to load renewed certificate for %s: %w", current.Domain, err)
+		}
+
+		log.Println("loaded ACME certificate for", current.Domain, "valid until", current.NotAfter.Format(time.RFC3339))
+	case data.DELETED:
+		lck.Lock()
+		delete(acmeCertificates, strings.TrimPrefix(key, data.ACMECertificatesPrefix))
+		lck.Unlock()
+	}
+
+	return nil
+}
+
+// TLSConfig returns a copy of base that serves the certificate of a listener, either obtained with ACME for acmeDomain or read from certPath and keyPath.
+// The certificate is looked up on each handshake, so renewed or replaced certificates are used without restarting the listener
+func TLSConfig(base *tls.Config, acmeDomain, certPath, keyPath string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if base != nil {
+		tlsConfig = base.Clone()
+	}
+
+	if acmeDomain != "" {
+		tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			lck.RLock()
+			defer lck.RUnlock()
+
+			certificate, ok := acmeCertificates[acmeDomain]
+			if !ok {
+				return nil, fmt.Errorf("no certificate has been obtained for %s yet", acmeDomain)
+			}
+
+			return certificate, nil
+		}
+
+		return tlsConfig, nil
+	}
+
+	file := &fileCertificate{certPath: certPath, keyPath: keyPath}
+
+	// Fail straight away on bad files, as ListenAndServeTLS would
+	if _, err := file.get(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return file.get()
+	}
+
+	return tlsConfig, nil
+}
+
+// fileCertificate is a certificate and key on disk, reloaded when either file changes
+type fileCertificate struct {
+	sync.Mutex
+
+	certPath, keyPath string
+
+	certificate *tls.Certificate
+	modified    time.Time
+	checked     time.Time
+}
+
+func (f *fileCertificate) get() (*tls.Certificate, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.certificate != nil && time.Since(f.checked) < fileCheckInterval {
+		return f.certificate, nil
+	}
+	f.checked = time.Now()
+
+	modified, err := lastModified(f.certPath, f.keyPath)
+	if err == nil && f.certificate != nil && !modified.After(f.modified) {
+		return f.certificate, nil
+	}
+
+	var pair tls.Certificate
+	if err == nil {
+		pair, err = tls.LoadX509KeyPair(f.certPath, f.keyPath)
+	}
+
+	if err != nil {
+		// Files are often replaced one at a time, keep serving the previous certificate until both are readable and match
+		if f.certificate != nil {
+			log.Println("unable to reload certificate", f.certPath, "keeping the current one: ", err)
+			return f.certificate, nil
+		}
+
+		return nil, err
+	}
+
+	if f.certificate != nil {
+		log.Println("reloaded certificate", f.certPath)
+	}
+
+	f.certificate, f.modified = &pair, modified
+
+	return f.certificate, nil
+}
+
+func lastModified(paths ...string) (latest time.Time, err error) {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	if latest.IsZero() {
+		return latest, errors.New("no certificate files")
+	}
+
+	return latest, nil
+}
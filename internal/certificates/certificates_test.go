@@ -0,0 +1,205 @@
+package certificates
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestMain(m *testing.M) {
+	if err := config.Load("../config/testing_config2.json"); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	k, err := wgtypes.GenerateKey()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	err = data.Load(fmt.Sprintf("file:%s?mode=memory&cache=shared", k.String()), "", true)
+	if err != nil {
+		log.Println("cannot load database: ", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	data.TearDown()
+
+	os.Exit(code)
+}
+
+// selfSigned returns a PEM encoded certificate and key for domain
+func selfSigned(t *testing.T, domain string, notAfter time.Time) (certificate, key string) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodedKey, err := encodePrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), encodedKey
+}
+
+func commonName(t *testing.T, certificate *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.Subject.CommonName
+}
+
+func writeFiles(t *testing.T, certPath, keyPath, certificate, key string, modified time.Time) {
+	if err := os.WriteFile(certPath, []byte(certificate), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyPath, []byte(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{certPath, keyPath} {
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	first, firstKey := selfSigned(t, "first.example.com", time.Now().Add(time.Hour))
+	writeFiles(t, certPath, keyPath, first, firstKey, time.Now().Add(-time.Hour))
+
+	if _, err := TLSConfig(nil, "", filepath.Join(dir, "missing.pem"), keyPath); err == nil {
+		t.Fatal("missing certificate files should fail straight away")
+	}
+
+	tlsConfig, err := TLSConfig(nil, "", certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := tlsConfig.GetCertificate(nil)
+	if err != nil || commonName(t, current) != "first.example.com" {
+		t.Fatal("expected the first certificate: ", err)
+	}
+
+	file := &fileCertificate{certPath: certPath, keyPath: keyPath}
+	if _, err := file.get(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, secondKey := selfSigned(t, "second.example.com", time.Now().Add(time.Hour))
+	writeFiles(t, certPath, keyPath, second, secondKey, time.Now())
+
+	if current, _ := file.get(); commonName(t, current) != "first.example.com" {
+		t.Fatal("files should not be checked again until the check interval has passed")
+	}
+
+	file.checked = time.Time{}
+	if current, err := file.get(); err != nil || commonName(t, current) != "second.example.com" {
+		t.Fatal("changed files should be reloaded: ", err)
+	}
+
+	// A certificate that does not match the key, as when files are replaced one at a time
+	third, _ := selfSigned(t, "third.example.com", time.Now().Add(time.Hour))
+	writeFiles(t, certPath, keyPath, third, secondKey, time.Now().Add(time.Minute))
+
+	file.checked = time.Time{}
+	if current, err := file.get(); err != nil || commonName(t, current) != "second.example.com" {
+		t.Fatal("the previous certificate should be kept when the new files are invalid: ", err)
+	}
+
+	if err := os.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	file.checked = time.Time{}
+	if current, err := file.get(); err != nil || commonName(t, current) != "second.example.com" {
+		t.Fatal("the previous certificate should be kept when the key is unreadable: ", err)
+	}
+}
+
+func TestACMECertificateReload(t *testing.T) {
+	key, err := data.RegisterEventListener(data.ACMECertificatesPrefix, true, certificatesChanged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer data.DeregisterEventListener(key)
+
+	tlsConfig, err := TLSConfig(nil, "reload.example.com", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tlsConfig.GetCertificate(nil); err == nil {
+		t.Fatal("there should be no certificate before one is obtained")
+	}
+
+	for _, name := range []string{"reload.example.com", "renewed.example.com"} {
+		certificate, privateKey := selfSigned(t, name, time.Now().Add(90*24*time.Hour))
+
+		err := data.SetACMECertificate(data.ACMECertificate{
+			Domain:      "reload.example.com",
+			Certificate: certificate,
+			PrivateKey:  privateKey,
+			NotAfter:    time.Now().Add(90 * 24 * time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			current, err := tlsConfig.GetCertificate(nil)
+			if err == nil && commonName(t, current) == name {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatal("listener did not pick up the certificate stored in etcd: ", name)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
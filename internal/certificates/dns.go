@@ -0,0 +1,215 @@
+package certificates
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsProvider creates and removes the TXT records for dns-01 challenges
+type dnsProvider interface {
+	Present(ctx context.Context, fqdn, value string) error
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+func newDNSProvider(name string, options map[string]string) (dnsProvider, error) {
+	switch name {
+	case "exec":
+		return newExecProvider(options)
+	case "cloudflare":
+		return newCloudflareProvider(options)
+	}
+
+	return nil, fmt.Errorf("unknown dns provider %q", name)
+}
+
+// execProvider runs a command as "<command> present|cleanup <fqdn> <value>" so any DNS server can be supported with a script
+type execProvider struct {
+	command string
+}
+
+func newExecProvider(options map[string]string) (*execProvider, error) {
+	if options["command"] == "" {
+		return nil, errors.New("exec dns provider requires the command option")
+	}
+
+	return &execProvider{command: options["command"]}, nil
+}
+
+func (e *execProvider) run(ctx context.Context, action, fqdn, value string) error {
+	output, err := exec.CommandContext(ctx, e.command, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w: %s", e.command, action, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func (e *execProvider) Present(ctx context.Context, fqdn, value string) error {
+	return e.run(ctx, "present", fqdn, value)
+}
+
+func (e *execProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return e.run(ctx, "cleanup", fqdn, value)
+}
+
+const defaultCloudflareURL = "https://api.cloudflare.com/client/v4"
+
+type cloudflareProvider struct {
+	apiURL string
+	token  string
+	zoneID string
+
+	client *http.Client
+
+	lck sync.Mutex
+	// Record IDs by fqdn and value, so only the records this provider made are removed
+	records map[string]string
+}
+
+func newCloudflareProvider(options map[string]string) (*cloudflareProvider, error) {
+	if options["api_token"] == "" {
+		return nil, errors.New("cloudflare dns provider requires the api_token option")
+	}
+
+	apiURL := options["api_url"]
+	if apiURL == "" {
+		apiURL = defaultCloudflareURL
+	}
+
+	return &cloudflareProvider{
+		apiURL:  strings.TrimSuffix(apiURL, "/"),
+		token:   options["api_token"],
+		zoneID:  options["zone_id"],
+		records: map[string]string{},
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}, nil
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+func (cf *cloudflareProvider) do(ctx context.Context, method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, cf.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+cf.token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := cf.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var parsed cloudflareResponse
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&parsed); err != nil {
+		return fmt.Errorf("cloudflare returned %s: %w", response.Status, err)
+	}
+
+	if !parsed.Success {
+		var messages []string
+		for _, e := range parsed.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("cloudflare returned %s: %s", response.Status, strings.Join(messages, ", "))
+	}
+
+	if result != nil {
+		return json.Unmarshal(parsed.Result, result)
+	}
+
+	return nil
+}
+
+// zone returns the configured zone, or finds the closest zone enclosing fqdn
+func (cf *cloudflareProvider) zone(ctx context.Context, fqdn string) (string, error) {
+	if cf.zoneID != "" {
+		return cf.zoneID, nil
+	}
+
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	for i := 0; i < len(labels)-1; i++ {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+
+		err := cf.do(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(strings.Join(labels[i:], ".")), nil, &zones)
+		if err != nil {
+			return "", err
+		}
+
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("no cloudflare zone found for %s", fqdn)
+}
+
+func (cf *cloudflareProvider) Present(ctx context.Context, fqdn, value string) error {
+	zone, err := cf.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+
+	var record struct {
+		ID string `json:"id"`
+	}
+
+	err = cf.do(ctx, http.MethodPost, "/zones/"+url.PathEscape(zone)+"/dns_records", map[string]any{
+		"type":    "TXT",
+		"name":    strings.TrimSuffix(fqdn, "."),
+		"content": value,
+		"ttl":     120,
+	}, &record)
+	if err != nil {
+		return err
+	}
+
+	cf.lck.Lock()
+	cf.records[fqdn+" "+value] = zone + "/dns_records/" + url.PathEscape(record.ID)
+	cf.lck.Unlock()
+
+	return nil
+}
+
+func (cf *cloudflareProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	cf.lck.Lock()
+	record, ok := cf.records[fqdn+" "+value]
+	delete(cf.records, fqdn+" "+value)
+	cf.lck.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return cf.do(ctx, http.MethodDelete, "/zones/"+record, nil, nil)
+}
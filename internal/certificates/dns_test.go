@@ -0,0 +1,163 @@
+package certificates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeCloudflare serves the parts of the cloudflare api the provider uses, for a single zone
+type fakeCloudflare struct {
+	sync.Mutex
+	zone    string
+	zoneID  string
+	records map[string]map[string]any
+	nextID  int
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	respond := func(result any) {
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "errors": []any{}, "result": result})
+	}
+
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "errors": []map[string]string{{"message": "Invalid API Token"}}})
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/zones":
+		zones := []map[string]string{}
+		if r.URL.Query().Get("name") == f.zone {
+			zones = append(zones, map[string]string{"id": f.zoneID})
+		}
+		respond(zones)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/zones/"+f.zoneID+"/dns_records":
+		var record map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f.nextID++
+		id := fmt.Sprintf("record%d", f.nextID)
+		f.records[id] = record
+		respond(map[string]string{"id": id})
+
+	case r.Method == http.MethodDelete && len(r.URL.Path) > len("/zones/"+f.zoneID+"/dns_records/"):
+		id := r.URL.Path[len("/zones/"+f.zoneID+"/dns_records/"):]
+		if _, ok := f.records[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "errors": []map[string]string{{"message": "Record not found"}}})
+			return
+		}
+
+		delete(f.records, id)
+		respond(map[string]string{"id": id})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{"success": false, "errors": []map[string]string{{"message": "not found"}}})
+	}
+}
+
+func TestCloudflareProvider(t *testing.T) {
+	fake := &fakeCloudflare{zone: "example.com", zoneID: "zone123", records: map[string]map[string]any{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	if _, err := newDNSProvider("cloudflare", map[string]string{}); err == nil {
+		t.Fatal("cloudflare provider should require an api token")
+	}
+
+	provider, err := newDNSProvider("cloudflare", map[string]string{"api_token": "test-token", "api_url": server.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	// The zone is found by walking up the labels of the record name
+	if err := provider.Present(ctx, "_acme-challenge.vpn.example.com.", "first-value"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.Present(ctx, "_acme-challenge.vpn.example.com.", "second-value"); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Lock()
+	if len(fake.records) != 2 {
+		t.Fatal("expected two records: ", fake.records)
+	}
+
+	for _, record := range fake.records {
+		if record["type"] != "TXT" || record["name"] != "_acme-challenge.vpn.example.com" {
+			t.Fatal("unexpected record: ", record)
+		}
+	}
+	fake.Unlock()
+
+	if err := provider.CleanUp(ctx, "_acme-challenge.vpn.example.com.", "first-value"); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Lock()
+	if len(fake.records) != 1 {
+		t.Fatal("clean up should only remove the record with the matching value: ", fake.records)
+	}
+
+	for _, record := range fake.records {
+		if record["content"] != "second-value" {
+			t.Fatal("wrong record was removed: ", record)
+		}
+	}
+	fake.Unlock()
+
+	// Records the provider did not create are left alone
+	if err := provider.CleanUp(ctx, "_acme-challenge.vpn.example.com.", "unknown-value"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.Present(ctx, "_acme-challenge.vpn.example.org.", "value"); err == nil {
+		t.Fatal("records outside of any zone should fail")
+	}
+
+	unauthorised, _ := newDNSProvider("cloudflare", map[string]string{"api_token": "wrong", "api_url": server.URL, "zone_id": "zone123"})
+	if err := unauthorised.Present(ctx, "_acme-challenge.vpn.example.com.", "value"); err == nil {
+		t.Fatal("api errors should be returned")
+	}
+}
+
+func TestExecProvider(t *testing.T) {
+	if _, err := newDNSProvider("exec", map[string]string{}); err == nil {
+		t.Fatal("exec provider should require a command")
+	}
+
+	if _, err := newDNSProvider("unknown", nil); err == nil {
+		t.Fatal("unknown providers should be refused")
+	}
+
+	provider, err := newDNSProvider("exec", map[string]string{"command": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provider.Present(context.Background(), "_acme-challenge.example.com.", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	failing, _ := newDNSProvider("exec", map[string]string{"command": "false"})
+	if err := failing.CleanUp(context.Background(), "_acme-challenge.example.com.", "value"); err == nil {
+		t.Fatal("a failing command should return an error")
+	}
+}
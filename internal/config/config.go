@@ -7,6 +7,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -22,6 +23,77 @@ var Version string
 type webserverDetails struct {
 	CertPath string `json:",omitempty"`
 	KeyPath  string `json:",omitempty"`
+
+	// Obtain and renew a certificate for this domain with ACME instead of using CertPath and KeyPath
+	ACMEDomain string `json:",omitempty"`
+
+	ProxyProtocol ProxyProtocolDetails `json:",omitempty"`
+}
+
+// ProxyProtocolDetails configures a listener to take client addresses from PROXY protocol headers sent by a load balancer
+type ProxyProtocolDetails struct {
+	Enabled bool
+	// Addresses or CIDRs of the load balancers, connections from them must start with a PROXY protocol header.
+	// Connections from anywhere else are used as is
+	TrustedSources []string `json:",omitempty"`
+}
+
+// validate refuses trusted sources that vpn clients could connect from, as devices are identified by their tunnel address
+// and any client could then claim to be another device by sending a header
+func (p ProxyProtocolDetails) validate(vpnRange *net.IPNet, serverAddress net.IP) error {
+	if !p.Enabled {
+		return nil
+	}
+
+	if len(p.TrustedSources) == 0 {
+		return errors.New("TrustedSources is required, the load balancers allowed to set client addresses")
+	}
+
+	trusted, err := p.Trusted()
+	if err != nil {
+		return fmt.Errorf("TrustedSources: %w", err)
+	}
+
+	for _, network := range trusted {
+		if vpnRange != nil && (network.Contains(vpnRange.IP) || vpnRange.Contains(network.IP)) {
+			return fmt.Errorf("TrustedSources %s overlaps the wireguard range %s, vpn clients could spoof the address of other devices", network, vpnRange)
+		}
+
+		if serverAddress != nil && network.Contains(serverAddress) {
+			return fmt.Errorf("TrustedSources %s contains the wireguard server address %s, vpn clients could spoof the address of other devices", network, serverAddress)
+		}
+	}
+
+	return nil
+}
+
+// Trusted parses TrustedSources, single addresses are treated as a /32 or /128
+func (p ProxyProtocolDetails) Trusted() (trusted []*net.IPNet, err error) {
+	for _, source := range p.TrustedSources {
+		if !strings.Contains(source, "/") {
+			ip := net.ParseIP(source)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not a valid address or CIDR", source)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(source)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid address or CIDR: %w", source, err)
+		}
+
+		trusted = append(trusted, network)
+	}
+
+	return trusted, nil
 }
 
 type usualWeb struct {
@@ -35,7 +107,69 @@ type tunnelWeb struct {
 }
 
 func (wb webserverDetails) SupportsTLS() bool {
-	return len(wb.CertPath) > 0 && len(wb.KeyPath) > 0
+	return (len(wb.CertPath) > 0 && len(wb.KeyPath) > 0) || wb.UsesACME()
+}
+
+func (wb webserverDetails) UsesACME() bool {
+	return len(wb.ACMEDomain) > 0
+}
+
+// ACMEDetails configures how certificates are obtained for listeners with an ACMEDomain
+type ACMEDetails struct {
+	// Defaults to Let's Encrypt
+	DirectoryURL string `json:",omitempty"`
+	Email        string `json:",omitempty"`
+
+	// http-01 (default) or dns-01
+	Challenge string `json:",omitempty"`
+
+	// Address to answer http-01 challenges on, needed if no wag listener is reachable on port 80 of the domains (e.g behind a proxy)
+	HTTPListenAddress string `json:",omitempty"`
+
+	DNS struct {
+		// exec or cloudflare
+		Provider string
+		Options  map[string]string `json:",omitempty"`
+		// How long to wait for the challenge record to be visible to the ACME server, defaults to 60 seconds
+		PropagationSeconds int `json:",omitempty"`
+	} `json:",omitempty"`
+
+	// Certificates are renewed this many days before they expire, defaults to 30
+	RenewBeforeDays int `json:",omitempty"`
+}
+
+func (a *ACMEDetails) validate() error {
+	if a.DirectoryURL == "" {
+		a.DirectoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+	}
+
+	if a.Challenge == "" {
+		a.Challenge = "http-01"
+	}
+
+	switch a.Challenge {
+	case "http-01":
+	case "dns-01":
+		if a.DNS.Provider == "" {
+			return errors.New("ACME dns-01 challenges require a DNS.Provider")
+		}
+	default:
+		return fmt.Errorf("ACME challenge %q is not supported, must be http-01 or dns-01", a.Challenge)
+	}
+
+	if a.DNS.PropagationSeconds < 0 || a.RenewBeforeDays < 0 {
+		return errors.New("ACME DNS.PropagationSeconds and RenewBeforeDays cannot be negative")
+	}
+
+	if a.DNS.PropagationSeconds == 0 {
+		a.DNS.PropagationSeconds = 60
+	}
+
+	if a.RenewBeforeDays == 0 {
+		a.RenewBeforeDays = 30
+	}
+
+	return nil
 }
 
 type Acls struct {
@@ -56,6 +190,69 @@ type ClusteringDetails struct {
 
 	TLSManagerStorage   string
 	TLSManagerListenURL string
+
+	// Use an existing etcd cluster rather than running etcd inside wag. Nodes are then stateless clients, and the
+	// listen addresses, peers, database location, witness and tls manager settings above are not used
+	External struct {
+		Endpoints     []string
+		CACertificate string `json:",omitempty"`
+		Certificate   string `json:",omitempty"`
+		Key           string `json:",omitempty"`
+		Username      string `json:",omitempty"`
+		PasswordFile  string `json:",omitempty"`
+		// Every wag key is stored under this prefix so the cluster can be shared, defaults to "/wag/"
+		Prefix string `json:",omitempty"`
+	} `json:",omitempty"`
+}
+
+func (c ClusteringDetails) IsExternal() bool {
+	return len(c.External.Endpoints) > 0
+}
+
+// EncryptionKey is where the key that protects secrets in etcd comes from, only one source may be set
+type EncryptionKey struct {
+	// Path to a file containing a base64 encoded 32 byte key
+	KeyFile string `json:",omitempty"`
+	// Name of an environment variable containing a base64 encoded 32 byte key
+	KeyEnv string `json:",omitempty"`
+
+	// A transit encrypt/decrypt API (Vault, OpenBao or a local stand in), the token is read from TokenFile or WAG_KMS_TOKEN
+	KMS struct {
+		Address       string
+		Mount         string `json:",omitempty"`
+		KeyName       string
+		TokenFile     string `json:",omitempty"`
+		CACertificate string `json:",omitempty"`
+	} `json:",omitempty"`
+}
+
+func (k EncryptionKey) Configured() bool {
+	return k.KeyFile != "" || k.KeyEnv != "" || k.KMS.Address != ""
+}
+
+func (k EncryptionKey) validate() error {
+	sources := 0
+	for _, set := range []bool{k.KeyFile != "", k.KeyEnv != "", k.KMS.Address != ""} {
+		if set {
+			sources++
+		}
+	}
+
+	if sources > 1 {
+		return errors.New("only one of KeyFile, KeyEnv or KMS may be set")
+	}
+
+	if k.KMS.Address != "" && k.KMS.KeyName == "" {
+		return errors.New("KMS requires a KeyName")
+	}
+
+	return nil
+}
+
+// KeyRotation is how often the devices of a group must rotate their wireguard keys
+type KeyRotation struct {
+	IntervalDays int
+	GraceHours   int `json:",omitempty"`
 }
 
 type Config struct {
@@ -76,12 +273,45 @@ type Config struct {
 	MaxSessionLifetimeMinutes       int    // Done
 	SessionInactivityTimeoutMinutes int    // Done
 
+	// Locked devices are unlocked this long after their last attempt, 0 keeps them locked until an administrator unlocks them
+	LockoutCooldownMinutes int `json:",omitempty"`
+	// Delay after the first failed mfa attempt, doubling with each further failure. 0 disables it
+	AttemptDelaySeconds int `json:",omitempty"`
+
+	// Devices may re-authenticate to extend their session this long before it reaches MaxSessionLifetimeMinutes, 0 disables extension
+	SessionExtensionMinutes int `json:",omitempty"`
+
 	DownloadConfigFileName string `json:",omitempty"`
 
 	ManagementUI struct {
 		usualWeb
 		Enabled bool
 		Debug   bool
+
+		// Url the management ui is reached at, e.g https://admin.example.com:4433, needed for security keys and single sign on
+		Domain string `json:",omitempty"`
+
+		// Administrators must enrol a totp code or security key for the management ui, unless they login with single sign on
+		DisableMFA bool `json:",omitempty"`
+
+		// Login to the management ui with the oidc provider set in the login settings
+		OIDC struct {
+			Enabled bool
+			// Maps values of the oidc groups claim to administrator roles, e.g {"wag-admins": "superadmin"}. Users in none of these groups cannot login
+			AdminRoles map[string]string
+		} `json:",omitempty"`
+	} `json:",omitempty"`
+
+	// Prometheus metrics listener, serves /metrics
+	Metrics struct {
+		usualWeb
+		Enabled bool
+	} `json:",omitempty"`
+
+	// HTTPS listener for the control api, authenticated with api tokens
+	ManagementAPI struct {
+		usualWeb
+		Enabled bool
 	} `json:",omitempty"`
 
 	Webserver struct {
@@ -97,6 +327,9 @@ type Config struct {
 		Methods       []string `json:",omitempty"`
 		DomainURL     string   // Done
 
+		// Authenticators that must all be completed before step up routes are accessible, each must also be in Methods
+		StepUpMethods []string `json:",omitempty"`
+
 		OIDC struct {
 			IssuerURL       string
 			ClientSecret    string
@@ -104,6 +337,39 @@ type Config struct {
 			GroupsClaimName string `json:",omitempty"`
 		} `json:",omitempty"`
 
+		// Additional identity providers, each is offered as its own "oidc-<Name>" mfa method
+		OIDCProviders []struct {
+			Name                string
+			DisplayName         string `json:",omitempty"`
+			IssuerURL           string
+			ClientSecret        string
+			ClientID            string
+			GroupsClaimName     string `json:",omitempty"`
+			DeviceUsernameClaim string `json:",omitempty"`
+		} `json:",omitempty"`
+
+		SAML struct {
+			IdPMetadataURL    string
+			IdPMetadataXML    string `json:",omitempty"`
+			EntityID          string `json:",omitempty"`
+			UsernameAttribute string `json:",omitempty"`
+			GroupsAttribute   string `json:",omitempty"`
+		} `json:",omitempty"`
+
+		LDAP struct {
+			URL                string
+			StartTLS           bool   `json:",omitempty"`
+			InsecureSkipVerify bool   `json:",omitempty"`
+			CACertificate      string `json:",omitempty"`
+			UserDNTemplate     string `json:",omitempty"`
+			BindDN             string `json:",omitempty"`
+			BindPassword       string `json:",omitempty"`
+			BaseDN             string `json:",omitempty"`
+			UserFilter         string `json:",omitempty"`
+			GroupAttribute     string `json:",omitempty"`
+			NestedGroups       bool   `json:",omitempty"`
+		} `json:",omitempty"`
+
 		PAM struct {
 			ServiceName string
 		} `json:",omitempty"`
@@ -126,6 +392,56 @@ type Config struct {
 
 	DatabaseLocation string
 
+	// Retention of the audit log, defaults to 90 days and 100000 events. Setting either to -1 disables that limit
+	Audit struct {
+		RetentionDays int `json:",omitempty"`
+		MaxEntries    int `json:",omitempty"`
+	} `json:",omitempty"`
+
+	// Accounts whose devices have not authorised for Days are locked or deleted, defaults to disabled. Administrators are warned in the management ui WarningDays beforehand
+	Inactivity struct {
+		Days int `json:",omitempty"`
+		// "lock" (default) or "delete"
+		Action      string `json:",omitempty"`
+		WarningDays int    `json:",omitempty"`
+	} `json:",omitempty"`
+
+	// Devices of members of each group ("*" for every user) must rotate their wireguard keys every IntervalDays, after new keys are issued the old ones stay valid for GraceHours (default 24)
+	KeyRotation map[string]KeyRotation `json:",omitempty"`
+
+	// Encryption at rest for mfa secrets and wireguard preshared keys stored in etcd
+	Encryption struct {
+		EncryptionKey
+		// Keys that used to be current, data keys they protect are re-wrapped with the current key on start
+		PreviousKeys []EncryptionKey `json:",omitempty"`
+	} `json:",omitempty"`
+
+	// Automatic certificates for listeners with an ACMEDomain, shared by every node in the cluster
+	ACME ACMEDetails `json:",omitempty"`
+
+	// Destinations that wag events (device authorised, lockouts, cluster errors, audit events) are sent to, each event is delivered by only one node in the cluster
+	Sinks []struct {
+		Name string
+		// webhook, syslog or file
+		Type string
+		// Event types to send, all events are sent if empty
+		Events []string `json:",omitempty"`
+
+		// Webhook, bodies are signed with HMAC-SHA256 using Secret
+		URL        string `json:",omitempty"`
+		Secret     string `json:",omitempty"`
+		MaxRetries int    `json:",omitempty"`
+
+		// Syslog, host:port of a TCP listener, optionally using TLS
+		Address            string `json:",omitempty"`
+		TLS                bool   `json:",omitempty"`
+		InsecureSkipVerify bool   `json:",omitempty"`
+		CACertificate      string `json:",omitempty"`
+
+		// File, JSON lines are appended to this path on the node that delivers the event
+		Path string `json:",omitempty"`
+	} `json:",omitempty"`
+
 	Acls Acls
 }
 
@@ -133,6 +449,30 @@ var (
 	Values Config
 )
 
+// ACMEDomains are the domains of every listener that gets its certificate with ACME
+func (c Config) ACMEDomains() (domains []string) {
+	listeners := []webserverDetails{c.Webserver.Public.webserverDetails, c.Webserver.Tunnel.webserverDetails}
+	if c.ManagementUI.Enabled {
+		listeners = append(listeners, c.ManagementUI.webserverDetails)
+	}
+
+	if c.ManagementAPI.Enabled {
+		listeners = append(listeners, c.ManagementAPI.webserverDetails)
+	}
+
+	if c.Metrics.Enabled {
+		listeners = append(listeners, c.Metrics.webserverDetails)
+	}
+
+	for _, listener := range listeners {
+		if listener.UsesACME() && !slices.Contains(domains, listener.ACMEDomain) {
+			domains = append(domains, listener.ACMEDomain)
+		}
+	}
+
+	return domains
+}
+
 func load(path string) (c Config, err error) {
 	configFile, err := os.Open(path)
 	if err != nil {
@@ -161,16 +501,38 @@ func load(path string) (c Config, err error) {
 		c.NumberProxies = 1
 	}
 
-	if c.Clustering.TLSManagerStorage == "" {
-		c.Clustering.TLSManagerStorage = "certificates"
-	}
+	if c.Clustering.IsExternal() {
+		if c.Clustering.External.Prefix == "" {
+			c.Clustering.External.Prefix = "/wag/"
+		}
 
-	if c.Clustering.TLSManagerListenURL == "" {
-		return c, fmt.Errorf("no listen url was specified for the etcd cluster TLS manager, new wag servers will not be able to join")
-	}
+		if (c.Clustering.External.Certificate == "") != (c.Clustering.External.Key == "") {
+			return c, errors.New("external etcd client certificate and key must both be set")
+		}
 
-	if !strings.HasPrefix(c.Clustering.TLSManagerListenURL, "https://") {
-		return c, fmt.Errorf("tls manager listen url must be https://")
+		if c.Clustering.Witness {
+			return c, errors.New("witness nodes are not supported with an external etcd cluster")
+		}
+
+		// Every node is a separate client, so they cannot share the default name
+		if c.Clustering.Name == "" {
+			c.Clustering.Name, err = os.Hostname()
+			if err != nil {
+				return c, fmt.Errorf("no clustering name was set and unable to use hostname: %w", err)
+			}
+		}
+	} else {
+		if c.Clustering.TLSManagerStorage == "" {
+			c.Clustering.TLSManagerStorage = "certificates"
+		}
+
+		if c.Clustering.TLSManagerListenURL == "" {
+			return c, fmt.Errorf("no listen url was specified for the etcd cluster TLS manager, new wag servers will not be able to join")
+		}
+
+		if !strings.HasPrefix(c.Clustering.TLSManagerListenURL, "https://") {
+			return c, fmt.Errorf("tls manager listen url must be https://")
+		}
 	}
 
 	i, err := net.InterfaceByName(c.Wireguard.DevName)
@@ -252,6 +614,83 @@ func load(path string) (c Config, err error) {
 		return c, errors.New("lockout policy unconfigured")
 	}
 
+	if c.ManagementUI.OIDC.Enabled && (c.ManagementUI.Domain == "" || len(c.ManagementUI.OIDC.AdminRoles) == 0) {
+		return c, errors.New("management ui single sign on requires ManagementUI.Domain and at least one entry in ManagementUI.OIDC.AdminRoles")
+	}
+
+	if c.ManagementAPI.Enabled && !c.ManagementAPI.SupportsTLS() {
+		return c, errors.New("management api requires CertPath and KeyPath, or ACMEDomain, to be set, it is only served over https")
+	}
+
+	for name, listener := range map[string]webserverDetails{
+		"Webserver.Public": c.Webserver.Public.webserverDetails,
+		"Webserver.Tunnel": c.Webserver.Tunnel.webserverDetails,
+		"ManagementUI":     c.ManagementUI.webserverDetails,
+		"ManagementAPI":    c.ManagementAPI.webserverDetails,
+		"Metrics":          c.Metrics.webserverDetails,
+	} {
+		if listener.UsesACME() && (listener.CertPath != "" || listener.KeyPath != "") {
+			return c, fmt.Errorf("%s cannot set both ACMEDomain and CertPath/KeyPath", name)
+		}
+
+		if err := listener.ProxyProtocol.validate(c.Wireguard.Range, c.Wireguard.ServerAddress); err != nil {
+			return c, fmt.Errorf("%s.ProxyProtocol: %w", name, err)
+		}
+	}
+
+	if len(c.ACMEDomains()) > 0 {
+		if err := c.ACME.validate(); err != nil {
+			return c, err
+		}
+	}
+
+	if c.Audit.RetentionDays == 0 {
+		c.Audit.RetentionDays = 90
+	}
+
+	if c.Audit.MaxEntries == 0 {
+		c.Audit.MaxEntries = 100000
+	}
+
+	if c.LockoutCooldownMinutes < 0 || c.AttemptDelaySeconds < 0 {
+		return c, errors.New("LockoutCooldownMinutes and AttemptDelaySeconds cannot be negative")
+	}
+
+	if c.SessionExtensionMinutes < 0 {
+		return c, errors.New("SessionExtensionMinutes cannot be negative")
+	}
+
+	if c.Inactivity.Action == "" {
+		c.Inactivity.Action = "lock"
+	}
+
+	if c.Inactivity.Action != "lock" && c.Inactivity.Action != "delete" {
+		return c, errors.New("Inactivity.Action must be lock or delete")
+	}
+
+	if c.Inactivity.Days < 0 || c.Inactivity.WarningDays < 0 {
+		return c, errors.New("Inactivity.Days and Inactivity.WarningDays cannot be negative")
+	}
+
+	if c.Inactivity.Days > 0 && c.Inactivity.WarningDays == 0 {
+		c.Inactivity.WarningDays = 7
+	}
+
+	for group, policy := range c.KeyRotation {
+		if group != "*" && !strings.HasPrefix(group, "group:") {
+			return c, fmt.Errorf("KeyRotation policy %q must be for a group (group:name) or every user (*)", group)
+		}
+
+		if policy.IntervalDays <= 0 || policy.GraceHours < 0 {
+			return c, fmt.Errorf("KeyRotation policy %q must have a positive IntervalDays and GraceHours cannot be negative", group)
+		}
+
+		if policy.GraceHours == 0 {
+			policy.GraceHours = 24
+			c.KeyRotation[group] = policy
+		}
+	}
+
 	if c.MaxSessionLifetimeMinutes == 0 {
 		return c, errors.New("session max lifetime policy is not set (may be disabled by setting it to -1)")
 	}
@@ -330,7 +769,7 @@ func load(path string) (c Config, err error) {
 	}
 
 	for _, acl := range c.Acls.Policies {
-		err = routetypes.ValidateRules(acl.Mfa, acl.Allow, acl.Deny)
+		err = routetypes.ValidateRulesWithStepUp(acl.Mfa, acl.Allow, acl.Deny, acl.StepUp)
 		if err != nil {
 			return c, fmt.Errorf("policy was invalid: %s", err)
 		}
@@ -347,6 +786,70 @@ func load(path string) (c Config, err error) {
 		}
 	}
 
+	oidcProviderNames := map[string]bool{}
+	for i, provider := range c.Authenticators.OIDCProviders {
+		if provider.Name == "" {
+			return c, fmt.Errorf("oidc provider %d has no name", i)
+		}
+
+		for _, r := range provider.Name {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+				return c, fmt.Errorf("oidc provider name %q must be alphanumeric", provider.Name)
+			}
+		}
+
+		if oidcProviderNames[provider.Name] {
+			return c, fmt.Errorf("oidc provider name %q is not unique", provider.Name)
+		}
+		oidcProviderNames[provider.Name] = true
+
+		if provider.GroupsClaimName == "" {
+			c.Authenticators.OIDCProviders[i].GroupsClaimName = "groups"
+		}
+	}
+
+	sinkNames := map[string]bool{}
+	for i, sink := range c.Sinks {
+		if sink.Name == "" {
+			return c, fmt.Errorf("sink %d has no name", i)
+		}
+
+		if sinkNames[sink.Name] {
+			return c, fmt.Errorf("sink name %q is not unique", sink.Name)
+		}
+		sinkNames[sink.Name] = true
+
+		switch sink.Type {
+		case "webhook", "syslog", "file":
+		default:
+			return c, fmt.Errorf("sink %q has unknown type %q (valid: webhook, syslog, file)", sink.Name, sink.Type)
+		}
+	}
+
+	if err := c.Encryption.validate(); err != nil {
+		return c, fmt.Errorf("encryption: %w", err)
+	}
+
+	for i, key := range c.Encryption.PreviousKeys {
+		if !c.Encryption.Configured() {
+			return c, errors.New("encryption: previous keys are set but there is no current key")
+		}
+
+		if !key.Configured() {
+			return c, fmt.Errorf("encryption: previous key %d has no source", i)
+		}
+
+		if err := key.validate(); err != nil {
+			return c, fmt.Errorf("encryption: previous key %d: %w", i, err)
+		}
+	}
+
+	for _, method := range c.Authenticators.StepUpMethods {
+		if !slices.Contains(c.Authenticators.Methods, method) {
+			return c, fmt.Errorf("step up method %q is not an enabled authentication method", method)
+		}
+	}
+
 	if len(c.Authenticators.Methods) == 1 {
 		c.Authenticators.DefaultMethod = c.Authenticators.Methods[len(c.Authenticators.Methods)-1]
 	}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withTunnelProxyProtocol writes testing_config.json with PROXY protocol enabled on the tunnel listener
+func withTunnelProxyProtocol(t *testing.T, trustedSources ...string) string {
+	b, err := os.ReadFile("testing_config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	tunnel := raw["Webserver"].(map[string]any)["Tunnel"].(map[string]any)
+	tunnel["ProxyProtocol"] = map[string]any{
+		"Enabled":        true,
+		"TrustedSources": trustedSources,
+	}
+
+	b, err = json.Marshal(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestProxyProtocolTrustedSources(t *testing.T) {
+	// testing_config.json uses 192.168.1.1/24 for wireguard
+	for _, sources := range [][]string{
+		{"192.168.1.0/24"},
+		{"192.168.1.77"},
+		{"192.168.0.0/16"},
+		{"10.0.0.0/8", "192.168.1.128/25"},
+		{"0.0.0.0/0"},
+	} {
+		_, err := load(withTunnelProxyProtocol(t, sources...))
+		if err == nil || !strings.Contains(err.Error(), "ProxyProtocol") {
+			t.Fatalf("trusted sources %v reachable from the vpn should be refused, got: %v", sources, err)
+		}
+	}
+
+	if _, err := load(withTunnelProxyProtocol(t)); err == nil {
+		t.Fatal("proxy protocol without trusted sources should be refused")
+	}
+
+	if _, err := load(withTunnelProxyProtocol(t, "10.0.0.0/24", "172.16.0.5")); err != nil {
+		t.Fatal("trusted sources outside of the vpn should be allowed: ", err)
+	}
+}
@@ -0,0 +1,367 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/routetypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	AccessRequestsPrefix = "wag-access-requests-"
+	// Approved requests are copied here with an etcd lease, so the grant is removed when it expires even if no wag node is running
+	AccessGrantsPrefix = "wag-access-grants-"
+
+	MaxAccessDuration = 24 * time.Hour
+
+	// Decided requests are kept for this long, the audit log keeps the full history
+	accessRequestRetention = 30 * 24 * time.Hour
+)
+
+type AccessRequestStatus string
+
+const (
+	AccessPending   AccessRequestStatus = "pending"
+	AccessApproved  AccessRequestStatus = "approved"
+	AccessDenied    AccessRequestStatus = "denied"
+	AccessCancelled AccessRequestStatus = "cancelled"
+	AccessRevoked   AccessRequestStatus = "revoked"
+	AccessExpired   AccessRequestStatus = "expired"
+)
+
+var ErrAccessRequestNotFound = errors.New("access request not found")
+
+// AccessRequest is a users request for temporary access to routes, e.g "10.0.0.5 5432/tcp" for 2 hours. Once approved the routes are added to the users mfa routes until it expires
+type AccessRequest struct {
+	ID       string
+	Username string
+	Routes   []string
+	Duration time.Duration
+	// Why the user needs access, e.g a ticket number
+	Reason string
+
+	Status    AccessRequestStatus
+	Requested time.Time
+
+	DecidedBy      string    `json:",omitempty"`
+	Decided        time.Time `json:",omitempty"`
+	DecisionReason string    `json:",omitempty"`
+
+	// Set when the request is approved
+	Expires time.Time `json:",omitempty"`
+}
+
+func (a AccessRequest) Active() bool {
+	return a.Status == AccessApproved && time.Now().Before(a.Expires)
+}
+
+func accessRequestKey(id string) string {
+	return AccessRequestsPrefix + id
+}
+
+func accessGrantKey(id string) string {
+	return AccessGrantsPrefix + id
+}
+
+func CreateAccessRequest(username string, routes []string, duration time.Duration, reason string) (AccessRequest, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return AccessRequest{}, errors.New("a reason is required")
+	}
+
+	if len(routes) == 0 {
+		return AccessRequest{}, errors.New("at least one route is required")
+	}
+
+	if duration < time.Minute || duration > MaxAccessDuration {
+		return AccessRequest{}, fmt.Errorf("access can be requested for between 1 minute and %s", MaxAccessDuration)
+	}
+
+	if err := routetypes.ValidateRules(routes, nil, nil); err != nil {
+		return AccessRequest{}, err
+	}
+
+	if _, err := GetUserData(username); err != nil {
+		return AccessRequest{}, err
+	}
+
+	id, err := generateRandomBytes(8)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+
+	request := AccessRequest{
+		ID:        id,
+		Username:  username,
+		Routes:    routes,
+		Duration:  duration,
+		Reason:    reason,
+		Status:    AccessPending,
+		Requested: time.Now(),
+	}
+
+	b, err := json.Marshal(request)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+
+	txn := etcd.Txn(context.Background())
+	txn.If(clientv3.Compare(clientv3.CreateRevision(accessRequestKey(id)), "=", 0))
+	txn.Then(clientv3.OpPut(accessRequestKey(id), string(b)))
+
+	resp, err := txn.Commit()
+	if err != nil {
+		return AccessRequest{}, err
+	}
+
+	if !resp.Succeeded {
+		return AccessRequest{}, errors.New("access request id collided, try again")
+	}
+
+	return request, nil
+}
+
+// GetAccessRequests returns every access request, or only those of username if it is set, newest first
+func GetAccessRequests(username string) (requests []AccessRequest, err error) {
+	response, err := etcd.Get(context.Background(), AccessRequestsPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kv := range response.Kvs {
+		var request AccessRequest
+		if err := json.Unmarshal(kv.Value, &request); err != nil {
+			return nil, err
+		}
+
+		if username != "" && request.Username != username {
+			continue
+		}
+
+		requests = append(requests, request)
+	}
+
+	slices.SortFunc(requests, func(a, b AccessRequest) int {
+		return b.Requested.Compare(a.Requested)
+	})
+
+	return requests, nil
+}
+
+func GetAccessRequest(id string) (request AccessRequest, err error) {
+	response, err := etcd.Get(context.Background(), accessRequestKey(id))
+	if err != nil {
+		return request, err
+	}
+
+	if len(response.Kvs) != 1 {
+		return request, ErrAccessRequestNotFound
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &request)
+	return request, err
+}
+
+// updateAccessRequest changes a request only if it is still in the from state, extra operations are applied in the same transaction
+func updateAccessRequest(id string, from AccessRequestStatus, f func(request *AccessRequest) ([]clientv3.Op, error)) (AccessRequest, error) {
+	response, err := etcd.Get(context.Background(), accessRequestKey(id))
+	if err != nil {
+		return AccessRequest{}, err
+	}
+
+	if len(response.Kvs) != 1 {
+		return AccessRequest{}, ErrAccessRequestNotFound
+	}
+
+	var request AccessRequest
+	if err := json.Unmarshal(response.Kvs[0].Value, &request); err != nil {
+		return AccessRequest{}, err
+	}
+
+	if request.Status != from {
+		return AccessRequest{}, fmt.Errorf("access request is %s, not %s", request.Status, from)
+	}
+
+	ops, err := f(&request)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+
+	b, err := json.Marshal(request)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+
+	txn := etcd.Txn(context.Background())
+	txn.If(clientv3.Compare(clientv3.ModRevision(accessRequestKey(id)), "=", response.Kvs[0].ModRevision))
+	txn.Then(append([]clientv3.Op{clientv3.OpPut(accessRequestKey(id), string(b))}, ops...)...)
+
+	resp, err := txn.Commit()
+	if err != nil {
+		return AccessRequest{}, err
+	}
+
+	if !resp.Succeeded {
+		return AccessRequest{}, errors.New("access request was changed by someone else, try again")
+	}
+
+	return request, nil
+}
+
+// ApproveAccessRequest installs the requested routes for the user until the requested duration has passed.
+// Administrator accounts are not linked to vpn users, so self approval is only refused when the approver has the same name as the user that made the request
+func ApproveAccessRequest(id, approver, reason string) (AccessRequest, error) {
+	var lease *clientv3.LeaseGrantResponse
+
+	approved, err := updateAccessRequest(id, AccessPending, func(request *AccessRequest) ([]clientv3.Op, error) {
+		if approver == request.Username {
+			return nil, errors.New("access requests cannot be approved by the user that made them")
+		}
+
+		var err error
+		lease, err = clientv3.NewLease(etcd).Grant(context.Background(), int64(request.Duration.Seconds()))
+		if err != nil {
+			return nil, err
+		}
+
+		request.Status = AccessApproved
+		request.DecidedBy = approver
+		request.Decided = time.Now()
+		request.DecisionReason = reason
+		request.Expires = request.Decided.Add(request.Duration)
+
+		grant, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+
+		return []clientv3.Op{clientv3.OpPut(accessGrantKey(id), string(grant), clientv3.WithLease(lease.ID))}, nil
+	})
+
+	// The grant was never written, so nothing else will use the lease before it expires
+	if err != nil && lease != nil {
+		if _, revokeErr := clientv3.NewLease(etcd).Revoke(context.Background(), lease.ID); revokeErr != nil {
+			log.Println("unable to revoke lease of access request", id, "that was not approved, err:", revokeErr)
+		}
+	}
+
+	return approved, err
+}
+
+func DenyAccessRequest(id, approver, reason string) (AccessRequest, error) {
+	return updateAccessRequest(id, AccessPending, func(request *AccessRequest) ([]clientv3.Op, error) {
+		request.Status = AccessDenied
+		request.DecidedBy = approver
+		request.Decided = time.Now()
+		request.DecisionReason = reason
+
+		return nil, nil
+	})
+}
+
+// CancelAccessRequest withdraws a pending request, only the user that made it can cancel it
+func CancelAccessRequest(id, username string) (AccessRequest, error) {
+	return updateAccessRequest(id, AccessPending, func(request *AccessRequest) ([]clientv3.Op, error) {
+		if request.Username != username {
+			return nil, ErrAccessRequestNotFound
+		}
+
+		request.Status = AccessCancelled
+		request.Decided = time.Now()
+
+		return nil, nil
+	})
+}
+
+// RevokeAccessRequest removes an approved grant before it expires
+func RevokeAccessRequest(id, by, reason string) (AccessRequest, error) {
+	return updateAccessRequest(id, AccessApproved, func(request *AccessRequest) ([]clientv3.Op, error) {
+		request.Status = AccessRevoked
+		request.DecidedBy = by
+		request.Decided = time.Now()
+		request.DecisionReason = reason
+
+		return []clientv3.Op{clientv3.OpDelete(accessGrantKey(id))}, nil
+	})
+}
+
+// getAccessGrants returns the routes of every unexpired grant for username
+func getAccessGrants(username string) (routes []string, err error) {
+	response, err := etcd.Get(context.Background(), AccessGrantsPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kv := range response.Kvs {
+		var grant AccessRequest
+		if err := json.Unmarshal(kv.Value, &grant); err != nil {
+			return nil, err
+		}
+
+		if grant.Username == username && grant.Active() {
+			routes = append(routes, grant.Routes...)
+		}
+	}
+
+	return routes, nil
+}
+
+// expireAccessRequests marks approved requests that have passed their expiry as expired, and removes old decided requests
+func expireAccessRequests() error {
+	requests, err := GetAccessRequests("")
+	if err != nil {
+		return err
+	}
+
+	for _, request := range requests {
+		switch {
+		case request.Status == AccessApproved && !request.Active():
+			expired, err := updateAccessRequest(request.ID, AccessApproved, func(request *AccessRequest) ([]clientv3.Op, error) {
+				request.Status = AccessExpired
+
+				// The lease normally removes the grant first, this is in case the request was restored from a backup
+				return []clientv3.Op{clientv3.OpDelete(accessGrantKey(request.ID))}, nil
+			})
+			if err != nil {
+				log.Println("unable to expire access request", request.ID, "err:", err)
+				continue
+			}
+
+			Audit(AuditAccessExpired, "wag", "", expired.Username, fmt.Sprintf("%s: %s", expired.ID, strings.Join(expired.Routes, ", ")))
+
+		case request.Status != AccessPending && request.Status != AccessApproved && time.Since(request.Decided) > accessRequestRetention:
+			if _, err := etcd.Delete(context.Background(), accessRequestKey(request.ID)); err != nil {
+				log.Println("unable to remove old access request", request.ID, "err:", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func accessRequestExpiry() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !IsLeader() {
+				continue
+			}
+
+			if err := expireAccessRequests(); err != nil {
+				log.Println("unable to expire access requests: ", err)
+			}
+		case <-exit:
+			return
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package data
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestAccessRequestApproval(t *testing.T) {
+	if _, err := CreateUserDataAccount("access_user"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("access_user")
+
+	request, err := CreateAccessRequest("access_user", []string{"10.0.0.5 5432/tcp"}, 2*time.Hour, "INC-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer etcd.Delete(context.Background(), accessRequestKey(request.ID))
+
+	if _, err := ApproveAccessRequest(request.ID, "access_user", ""); err == nil {
+		t.Fatal("users should not be able to approve their own requests")
+	}
+
+	approved, err := ApproveAccessRequest(request.ID, "approver", "looks fine")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if approved.Status != AccessApproved || approved.DecidedBy != "approver" || !approved.Active() {
+		t.Fatal("request was not approved: ", approved)
+	}
+
+	if !slices.Contains(GetEffectiveAcl("access_user").Mfa, "10.0.0.5 5432/tcp") {
+		t.Fatal("approved routes were not added to the users mfa routes: ", GetEffectiveAcl("access_user"))
+	}
+
+	if _, err := DenyAccessRequest(request.ID, "approver", "too late"); err == nil {
+		t.Fatal("approved requests should not be deniable")
+	}
+
+	if _, err := RevokeAccessRequest(request.ID, "approver", "incident closed"); err != nil {
+		t.Fatal(err)
+	}
+
+	if slices.Contains(GetEffectiveAcl("access_user").Mfa, "10.0.0.5 5432/tcp") {
+		t.Fatal("revoked routes were not removed: ", GetEffectiveAcl("access_user"))
+	}
+
+	revoked, err := GetAccessRequest(request.ID)
+	if err != nil || revoked.Status != AccessRevoked {
+		t.Fatal("request was not marked revoked: ", revoked, err)
+	}
+}
+
+func TestAccessRequestDenyAndCancel(t *testing.T) {
+	if _, err := CreateUserDataAccount("access_user2"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("access_user2")
+
+	denied, err := CreateAccessRequest("access_user2", []string{"10.0.0.6"}, time.Hour, "INC-124")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer etcd.Delete(context.Background(), accessRequestKey(denied.ID))
+
+	if _, err := DenyAccessRequest(denied.ID, "approver", "not needed"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ApproveAccessRequest(denied.ID, "approver", ""); err == nil {
+		t.Fatal("denied requests should not be approvable")
+	}
+
+	if slices.Contains(GetEffectiveAcl("access_user2").Mfa, "10.0.0.6") {
+		t.Fatal("denied request should not grant routes: ", GetEffectiveAcl("access_user2"))
+	}
+
+	cancelled, err := CreateAccessRequest("access_user2", []string{"10.0.0.6"}, time.Hour, "INC-125")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer etcd.Delete(context.Background(), accessRequestKey(cancelled.ID))
+
+	if _, err := CancelAccessRequest(cancelled.ID, "someone_else"); err == nil {
+		t.Fatal("only the requesting user should be able to cancel a request")
+	}
+
+	if _, err := CancelAccessRequest(cancelled.ID, "access_user2"); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, err := GetAccessRequests("access_user2")
+	if err != nil || len(requests) != 2 || requests[0].ID != cancelled.ID || requests[0].Status != AccessCancelled {
+		t.Fatal("expected both requests newest first: ", requests, err)
+	}
+}
+
+func TestAccessRequestValidation(t *testing.T) {
+	if _, err := CreateUserDataAccount("access_user3"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("access_user3")
+
+	if _, err := CreateAccessRequest("access_user3", []string{"not a route"}, time.Hour, "INC-126"); err == nil {
+		t.Fatal("invalid routes should be rejected")
+	}
+
+	if _, err := CreateAccessRequest("access_user3", []string{"10.0.0.7"}, 48*time.Hour, "INC-126"); err == nil {
+		t.Fatal("durations over the maximum should be rejected")
+	}
+
+	if _, err := CreateAccessRequest("access_user3", []string{"10.0.0.7"}, time.Hour, " "); err == nil {
+		t.Fatal("a reason should be required")
+	}
+
+	if _, err := CreateAccessRequest("no_such_user", []string{"10.0.0.7"}, time.Hour, "INC-126"); err == nil {
+		t.Fatal("requests for unknown users should be rejected")
+	}
+}
+
+func TestAccessRequestConcurrentApproval(t *testing.T) {
+	if _, err := CreateUserDataAccount("access_user4"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("access_user4")
+
+	// An unusual duration so the leases of this test can be told apart
+	duration := 97 * time.Minute
+
+	request, err := CreateAccessRequest("access_user4", []string{"10.0.0.8"}, duration, "INC-127")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer etcd.Delete(context.Background(), accessRequestKey(request.ID))
+
+	// Approvals that lose the race have already been granted a lease when their transaction fails
+	var (
+		wg       sync.WaitGroup
+		start    = make(chan struct{})
+		approved atomic.Int32
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			if _, err := ApproveAccessRequest(request.ID, "approver", ""); err == nil {
+				approved.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if approved.Load() != 1 {
+		t.Fatal("request should be approved exactly once, got: ", approved.Load())
+	}
+
+	leases, err := etcd.Leases(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for _, lease := range leases.Leases {
+		ttl, err := etcd.TimeToLive(context.Background(), lease.ID, clientv3.WithAttachedKeys())
+		if err != nil || ttl.GrantedTTL != int64(duration.Seconds()) {
+			continue
+		}
+
+		defer etcd.Revoke(context.Background(), lease.ID)
+
+		count++
+		if len(ttl.Keys) != 1 || string(ttl.Keys[0]) != accessGrantKey(request.ID) {
+			t.Fatal("lease of an approval that failed was left behind: ", ttl.Keys)
+		}
+	}
+
+	if count != 1 {
+		t.Fatal("expected only the lease of the grant, got: ", count)
+	}
+}
@@ -18,7 +18,7 @@ import (
 
 func SetAcl(effects string, policy acls.Acl, overwrite bool) error {
 
-	if err := routetypes.ValidateRules(policy.Mfa, policy.Allow, policy.Deny); err != nil {
+	if err := routetypes.ValidateRulesWithStepUp(policy.Mfa, policy.Allow, policy.Deny, policy.StepUp); err != nil {
 		return err
 	}
 
@@ -65,6 +65,7 @@ func GetPolicies() (result []control.PolicyData, err error) {
 			PublicRoutes: policy.Allow,
 			MfaRoutes:    policy.Mfa,
 			DenyRoutes:   policy.Deny,
+			StepUpRoutes: policy.StepUp,
 		})
 	}
 
@@ -76,8 +77,32 @@ func RemoveAcl(effects string) error {
 	return err
 }
 
+// GetFirewallAcl is the effective acl for a user as it should be installed in the firewall, step up routes are only kept once the user has completed all step up factors,
+// until then they are explicitly denied
+func GetFirewallAcl(username string) acls.Acl {
+	acl := GetEffectiveAcl(username)
+	if len(acl.StepUp) == 0 {
+		return acl
+	}
+
+	granted, err := IsStepUpGranted(username)
+	if err != nil {
+		log.Println("failed to determine step up state for user", username, "err:", err)
+	}
+
+	if !granted {
+		acl.Deny = append(acl.Deny, acl.StepUp...)
+		acl.StepUp = nil
+	}
+
+	return acl
+}
+
 func GetEffectiveAcl(username string) acls.Acl {
-	var resultingACLs acls.Acl
+	var (
+		resultingACLs acls.Acl
+		stepUp        []string
+	)
 	//Add the server address by default
 	resultingACLs.Allow = []string{config.Values.Wireguard.ServerAddress.String() + "/32"}
 
@@ -97,6 +122,7 @@ func GetEffectiveAcl(username string) acls.Acl {
 		if err == nil {
 			resultingACLs.Allow = append(resultingACLs.Allow, acl.Allow...)
 			resultingACLs.Mfa = append(resultingACLs.Mfa, acl.Mfa...)
+			stepUp = append(stepUp, acl.StepUp...)
 		} else {
 			RaiseError(err, []byte("failed to unmarshal default acls policy"))
 			log.Println("failed to unmarshal default acls policy: ", err)
@@ -111,6 +137,7 @@ func GetEffectiveAcl(username string) acls.Acl {
 		if err == nil {
 			resultingACLs.Allow = append(resultingACLs.Allow, acl.Allow...)
 			resultingACLs.Mfa = append(resultingACLs.Mfa, acl.Mfa...)
+			stepUp = append(stepUp, acl.StepUp...)
 		} else {
 			log.Println("failed to unmarshal user specific acls: ", err)
 		}
@@ -151,6 +178,7 @@ func GetEffectiveAcl(username string) acls.Acl {
 
 					resultingACLs.Allow = append(resultingACLs.Allow, acl.Allow...)
 					resultingACLs.Mfa = append(resultingACLs.Mfa, acl.Mfa...)
+					stepUp = append(stepUp, acl.StepUp...)
 				}
 			}
 
@@ -174,5 +202,15 @@ func GetEffectiveAcl(username string) acls.Acl {
 		}
 	}
 
+	// Temporary access granted through approved access requests, only available once the device has authorised
+	grants, err := getAccessGrants(username)
+	if err == nil {
+		resultingACLs.Mfa = append(resultingACLs.Mfa, grants...)
+	} else {
+		log.Println("failed to get access grants for user", username, "err:", err)
+	}
+
+	resultingACLs.StepUp = stepUp
+
 	return resultingACLs
 }
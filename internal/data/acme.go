@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	ACMEPrefix             = "wag-acme-"
+	ACMEAccountKey         = ACMEPrefix + "account"
+	ACMECertificatesPrefix = ACMEPrefix + "certificates-"
+	ACMEChallengesPrefix   = ACMEPrefix + "challenges-"
+
+	// http-01 challenges are only needed while the ACME server validates an order
+	acmeChallengeLifetime = 10 * time.Minute
+)
+
+// ACMEAccount is the account certificates are ordered with, it is shared so every node renews as the same account
+type ACMEAccount struct {
+	DirectoryURL string
+	Email        string
+	URI          string
+	// PEM encoded
+	PrivateKey string
+}
+
+// ACMECertificate is the current certificate of a domain, nodes watch these to load renewed certificates without restarting their listeners
+type ACMECertificate struct {
+	Domain string
+	// PEM encoded, leaf first
+	Certificate string
+	PrivateKey  string
+	NotAfter    time.Time
+	Issued      time.Time
+}
+
+func GetACMEAccount(directoryURL string) (account ACMEAccount, ok bool, err error) {
+	response, err := etcd.Get(context.Background(), ACMEAccountKey)
+	if err != nil {
+		return account, false, err
+	}
+
+	if len(response.Kvs) == 0 {
+		return account, false, nil
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &account)
+	if err != nil {
+		return account, false, err
+	}
+
+	// Accounts belong to a single ACME server, a new one is registered if the directory changes
+	return account, account.DirectoryURL == directoryURL, nil
+}
+
+func SetACMEAccount(account ACMEAccount) error {
+	b, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+
+	_, err = etcd.Put(context.Background(), ACMEAccountKey, string(b))
+	return err
+}
+
+func GetACMECertificate(domain string) (certificate ACMECertificate, ok bool, err error) {
+	response, err := etcd.Get(context.Background(), ACMECertificatesPrefix+domain)
+	if err != nil {
+		return certificate, false, err
+	}
+
+	if len(response.Kvs) == 0 {
+		return certificate, false, nil
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &certificate)
+	return certificate, err == nil, err
+}
+
+func GetACMECertificates() (certificates []ACMECertificate, err error) {
+	response, err := etcd.Get(context.Background(), ACMECertificatesPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kv := range response.Kvs {
+		var certificate ACMECertificate
+		if err := json.Unmarshal(kv.Value, &certificate); err != nil {
+			return nil, err
+		}
+
+		certificates = append(certificates, certificate)
+	}
+
+	return certificates, nil
+}
+
+func SetACMECertificate(certificate ACMECertificate) error {
+	if certificate.Domain == "" {
+		return errors.New("certificate has no domain")
+	}
+
+	b, err := json.Marshal(certificate)
+	if err != nil {
+		return err
+	}
+
+	_, err = etcd.Put(context.Background(), ACMECertificatesPrefix+certificate.Domain, string(b))
+	return err
+}
+
+// SetACMEChallenge stores the response to a http-01 challenge so whichever node the ACME server reaches can answer it, it expires on its own
+func SetACMEChallenge(token, keyAuthorization string) error {
+	lease, err := clientv3.NewLease(etcd).Grant(context.Background(), int64(acmeChallengeLifetime.Seconds()))
+	if err != nil {
+		return err
+	}
+
+	_, err = etcd.Put(context.Background(), ACMEChallengesPrefix+token, keyAuthorization, clientv3.WithLease(lease.ID))
+	return err
+}
+
+func GetACMEChallenge(token string) (string, error) {
+	response, err := etcd.Get(context.Background(), ACMEChallengesPrefix+token)
+	if err != nil {
+		return "", err
+	}
+
+	if len(response.Kvs) == 0 {
+		return "", errors.New("challenge not found")
+	}
+
+	return string(response.Kvs[0].Value), nil
+}
+
+func DeleteACMEChallenge(token string) error {
+	_, err := etcd.Delete(context.Background(), ACMEChallengesPrefix+token)
+	return err
+}
@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const adminUsersPrefix = "admin-users-"
+
+func updateAdmin(username string, f func(a *admin) error) error {
+	return doSafeUpdate(context.Background(), adminUsersPrefix+username, false, func(gr *clientv3.GetResponse) (value string, err error) {
+
+		if len(gr.Kvs) != 1 {
+			return "", errors.New("invalid number of admin users")
+		}
+
+		var admin admin
+		err = json.Unmarshal(gr.Kvs[0].Value, &admin)
+		if err != nil {
+			return "", err
+		}
+
+		if err := f(&admin); err != nil {
+			return "", err
+		}
+
+		b, err := json.Marshal(admin)
+
+		return string(b), err
+	})
+}
+
+// SetAdminMfa stores the management ui mfa secret of an administrator, it is encrypted at rest like user mfa secrets
+func SetAdminMfa(username, mfaType, secret string) error {
+	return updateAdmin(username, func(a *admin) error {
+		a.MfaType = mfaType
+		a.Mfa = secret
+		return nil
+	})
+}
+
+// GetAdminMfa returns the mfa method and secret an administrator enrolled, mfaType is empty if they have not enrolled
+func GetAdminMfa(username string) (mfaType, secret string, err error) {
+	response, err := etcd.Get(context.Background(), adminUsersPrefix+username)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(response.Kvs) != 1 {
+		return "", "", errors.New("invalid number of admin users")
+	}
+
+	var a admin
+	err = json.Unmarshal(response.Kvs[0].Value, &a)
+	if err != nil {
+		return "", "", err
+	}
+
+	return a.MfaType, a.Mfa, nil
+}
+
+// ResetAdminMfa removes an administrators mfa, they enrol again on their next login
+func ResetAdminMfa(username string) error {
+	return updateAdmin(username, func(a *admin) error {
+		a.MfaType = ""
+		a.Mfa = ""
+		return nil
+	})
+}
+
+// SetSSOAdmin creates or updates an administrator that logs in with single sign on, their role is set by the identity provider on every login
+func SetSSOAdmin(username string, role AdminRole) error {
+	if err := ValidateAdminRole(role, nil); err != nil {
+		return err
+	}
+
+	newAdmin := admin{
+		AdminModel: AdminModel{
+			Username:  username,
+			DateAdded: time.Now().Format(time.RFC3339),
+			Role:      role,
+			SSO:       true,
+		},
+	}
+
+	b, err := json.Marshal(newAdmin)
+	if err != nil {
+		return err
+	}
+
+	txn := etcd.Txn(context.Background())
+	txn.If(clientv3.Compare(clientv3.CreateRevision(adminUsersPrefix+username), "=", 0))
+	txn.Then(clientv3.OpPut(adminUsersPrefix+username, string(b)))
+
+	resp, err := txn.Commit()
+	if err != nil {
+		return err
+	}
+
+	if resp.Succeeded {
+		return nil
+	}
+
+	return updateAdmin(username, func(a *admin) error {
+		if !a.SSO {
+			return errors.New("an administrator with a password already has this username")
+		}
+
+		a.Role = role
+		a.Groups = nil
+		return nil
+	})
+}
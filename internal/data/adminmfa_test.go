@@ -0,0 +1,70 @@
+package data
+
+import "testing"
+
+func TestAdminMfa(t *testing.T) {
+	if err := CreateAdminUser("mfa_admin", "a very long password that is fine", false); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteAdminUser("mfa_admin")
+
+	if mfaType, _, err := GetAdminMfa("mfa_admin"); err != nil || mfaType != "" {
+		t.Fatal("new admins should not have mfa: ", mfaType, err)
+	}
+
+	if err := SetAdminMfa("mfa_admin", "totp", "otpauth://totp/test?secret=AAAA"); err != nil {
+		t.Fatal(err)
+	}
+
+	mfaType, secret, err := GetAdminMfa("mfa_admin")
+	if err != nil || mfaType != "totp" || secret != "otpauth://totp/test?secret=AAAA" {
+		t.Fatal("mfa was not stored: ", mfaType, secret, err)
+	}
+
+	admin, err := GetAdminUser("mfa_admin")
+	if err != nil || admin.MfaType != "totp" {
+		t.Fatal("admin model should show the mfa type: ", admin, err)
+	}
+
+	if err := ResetAdminMfa("mfa_admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if mfaType, secret, err := GetAdminMfa("mfa_admin"); err != nil || mfaType != "" || secret != "" {
+		t.Fatal("mfa was not reset: ", mfaType, secret, err)
+	}
+}
+
+func TestSSOAdmin(t *testing.T) {
+	if err := SetSSOAdmin("sso_admin", RoleHelpdesk); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteAdminUser("sso_admin")
+
+	admin, err := GetAdminUser("sso_admin")
+	if err != nil || !admin.SSO || admin.EffectiveRole() != RoleHelpdesk {
+		t.Fatal("sso admin was not created: ", admin, err)
+	}
+
+	if err := CompareAdminKeys("sso_admin", ""); err == nil {
+		t.Fatal("sso admins should not be able to login with a password")
+	}
+
+	if err := SetSSOAdmin("sso_admin", RoleAuditor); err != nil {
+		t.Fatal(err)
+	}
+
+	admin, err = GetAdminUser("sso_admin")
+	if err != nil || admin.EffectiveRole() != RoleAuditor {
+		t.Fatal("sso admin role was not updated: ", admin, err)
+	}
+
+	if err := CreateAdminUser("password_admin", "a very long password that is fine", false); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteAdminUser("password_admin")
+
+	if err := SetSSOAdmin("password_admin", RoleAuditor); err == nil {
+		t.Fatal("single sign on should not take over an administrator with a password")
+	}
+}
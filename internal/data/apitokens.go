@@ -0,0 +1,210 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	APITokensPrefix = "wag-apitokens-"
+
+	apiTokenPrefix = "wag_"
+
+	// MaxAPITokenLifetime stops tokens from being created that effectively never expire
+	MaxAPITokenLifetime = 365 * 24 * time.Hour
+)
+
+// APITokenScopes are the areas of the management api a token can be given access to, as "<scope>:read" or "<scope>:write". "*" grants everything
+var APITokenScopes = []string{"users", "devices", "policies", "groups", "registrations", "firewall", "audit", "settings", "cluster", "access"}
+
+var ErrInvalidAPIToken = errors.New("invalid or expired api token")
+
+// APIToken is a credential for the remote management api, only a hash of the secret is stored
+type APIToken struct {
+	ID        string
+	Name      string
+	Scopes    []string
+	Hash      string `json:",omitempty"`
+	CreatedBy string
+	Created   time.Time
+	Expires   time.Time
+}
+
+// NewAPIToken is returned when a token is created, it is the only time the secret is available
+type NewAPIToken struct {
+	Secret string
+	Token  APIToken
+}
+
+// Allows reports whether the token grants access to scope, write access implies read access
+func (t APIToken) Allows(scope string, write bool) bool {
+	for _, s := range t.Scopes {
+		if s == "*" || s == scope+":write" || (!write && s == scope+":read") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t APIToken) Expired() bool {
+	return time.Now().After(t.Expires)
+}
+
+func apiTokenKey(id string) string {
+	return APITokensPrefix + id
+}
+
+func hashAPITokenSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func validateAPITokenScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if scope == "*" {
+			continue
+		}
+
+		name, access, ok := strings.Cut(scope, ":")
+		if !ok || (access != "read" && access != "write") || !slices.Contains(APITokenScopes, name) {
+			return fmt.Errorf("invalid scope %q, scopes are \"*\" or <scope>:read or <scope>:write where scope is one of: %s", scope, strings.Join(APITokenScopes, ", "))
+		}
+	}
+
+	return nil
+}
+
+// CreateAPIToken creates a token that expires after lifetime, the returned secret is the only copy of the token and cannot be retrieved again
+func CreateAPIToken(name string, scopes []string, lifetime time.Duration, createdBy string) (secret string, token APIToken, err error) {
+	if name == "" {
+		return "", token, errors.New("api token name cannot be empty")
+	}
+
+	if err := validateAPITokenScopes(scopes); err != nil {
+		return "", token, err
+	}
+
+	if lifetime <= 0 || lifetime > MaxAPITokenLifetime {
+		return "", token, fmt.Errorf("api token lifetime must be between 0 and %s", MaxAPITokenLifetime)
+	}
+
+	id, err := generateRandomBytes(8)
+	if err != nil {
+		return "", token, err
+	}
+
+	random, err := generateRandomBytes(32)
+	if err != nil {
+		return "", token, err
+	}
+
+	token = APIToken{
+		ID:        id,
+		Name:      name,
+		Scopes:    scopes,
+		Hash:      hashAPITokenSecret(random),
+		CreatedBy: createdBy,
+		Created:   time.Now(),
+		Expires:   time.Now().Add(lifetime),
+	}
+
+	b, err := json.Marshal(token)
+	if err != nil {
+		return "", token, err
+	}
+
+	txn := etcd.Txn(context.Background())
+	txn.If(clientv3.Compare(clientv3.CreateRevision(apiTokenKey(id)), "=", 0))
+	txn.Then(clientv3.OpPut(apiTokenKey(id), string(b)))
+
+	resp, err := txn.Commit()
+	if err != nil {
+		return "", token, err
+	}
+
+	if !resp.Succeeded {
+		return "", token, errors.New("api token id collided, try again")
+	}
+
+	token.Hash = ""
+
+	return apiTokenPrefix + id + "_" + random, token, nil
+}
+
+// ListAPITokens returns every token, including expired tokens, without their hashes
+func ListAPITokens() (tokens []APIToken, err error) {
+	response, err := etcd.Get(context.Background(), APITokensPrefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kv := range response.Kvs {
+		var token APIToken
+		if err := json.Unmarshal(kv.Value, &token); err != nil {
+			return nil, err
+		}
+
+		token.Hash = ""
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+func DeleteAPIToken(id string) error {
+	response, err := etcd.Delete(context.Background(), apiTokenKey(id))
+	if err != nil {
+		return err
+	}
+
+	if response.Deleted == 0 {
+		return errors.New("api token not found")
+	}
+
+	return nil
+}
+
+// ValidateAPIToken checks a token presented to the management api, returning its details if it is valid and has not expired
+func ValidateAPIToken(secret string) (APIToken, error) {
+	id, random, ok := strings.Cut(strings.TrimPrefix(secret, apiTokenPrefix), "_")
+	if !strings.HasPrefix(secret, apiTokenPrefix) || !ok || id == "" || strings.ContainsAny(id, "/") {
+		return APIToken{}, ErrInvalidAPIToken
+	}
+
+	response, err := etcd.Get(context.Background(), apiTokenKey(id))
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	if len(response.Kvs) != 1 {
+		return APIToken{}, ErrInvalidAPIToken
+	}
+
+	var token APIToken
+	if err := json.Unmarshal(response.Kvs[0].Value, &token); err != nil {
+		return APIToken{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashAPITokenSecret(random))) != 1 || token.Expired() {
+		return APIToken{}, ErrInvalidAPIToken
+	}
+
+	token.Hash = ""
+
+	return token, nil
+}
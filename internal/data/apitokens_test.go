@@ -0,0 +1,78 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAPITokens(t *testing.T) {
+	secret, token, err := CreateAPIToken("automation", []string{"users:read", "policies:write"}, time.Hour, "tester")
+	if err != nil {
+		t.Fatal("unable to create api token: ", err)
+	}
+	t.Cleanup(func() {
+		DeleteAPIToken(token.ID)
+	})
+
+	validated, err := ValidateAPIToken(secret)
+	if err != nil {
+		t.Fatal("valid api token was rejected: ", err)
+	}
+
+	if validated.ID != token.ID || validated.Hash != "" {
+		t.Fatal("validated token did not match, or leaked its hash: ", validated)
+	}
+
+	if !validated.Allows("users", false) || validated.Allows("users", true) {
+		t.Fatal("read scope should only allow reads")
+	}
+
+	if !validated.Allows("policies", false) || !validated.Allows("policies", true) {
+		t.Fatal("write scope should allow reads and writes")
+	}
+
+	if validated.Allows("devices", false) {
+		t.Fatal("token should not have access to scopes it was not given")
+	}
+
+	tampered := []byte(secret)
+	tampered[len(tampered)-1] ^= 1
+
+	for _, bad := range []string{"", "wag_", "wag_" + token.ID, "wag_" + token.ID + "_00", string(tampered), "nope"} {
+		if _, err := ValidateAPIToken(bad); err != ErrInvalidAPIToken {
+			t.Fatalf("token %q should have been rejected, got: %v", bad, err)
+		}
+	}
+
+	tokens, err := ListAPITokens()
+	if err != nil || len(tokens) != 1 || tokens[0].Hash != "" {
+		t.Fatal("expected one token without a hash to be listed: ", tokens, err)
+	}
+
+	if _, _, err := CreateAPIToken("bad", []string{"users:admin"}, time.Hour, "tester"); err == nil {
+		t.Fatal("unknown scope access should be rejected")
+	}
+
+	if _, _, err := CreateAPIToken("forever", []string{"*"}, 0, "tester"); err == nil {
+		t.Fatal("tokens must expire")
+	}
+
+	expiredSecret, expired, err := CreateAPIToken("expired", []string{"*"}, time.Millisecond, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteAPIToken(expired.ID)
+
+	time.Sleep(5 * time.Millisecond)
+	if _, err := ValidateAPIToken(expiredSecret); err != ErrInvalidAPIToken {
+		t.Fatal("expired token should be rejected")
+	}
+
+	if err := DeleteAPIToken(token.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ValidateAPIToken(secret); err != ErrInvalidAPIToken {
+		t.Fatal("deleted token should be rejected")
+	}
+}
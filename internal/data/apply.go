@@ -0,0 +1,388 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/NHAS/wag/internal/acls"
+	"github.com/NHAS/wag/internal/routetypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	policiesPrefix = "wag-acls-"
+	groupsPrefix   = "wag-groups-"
+)
+
+var ErrPolicyPlanChanged = errors.New("policies or groups have changed since the plan was created, re-run the plan")
+
+// PolicySet is the complete desired state of policies and groups, it has the same shape as Acls in the config file
+type PolicySet struct {
+	Groups   map[string][]string
+	Policies map[string]acls.Acl
+}
+
+type PolicyChangeAction string
+
+const (
+	PolicyCreate PolicyChangeAction = "create"
+	PolicyUpdate PolicyChangeAction = "update"
+	PolicyDelete PolicyChangeAction = "delete"
+)
+
+type PolicyChange struct {
+	Action PolicyChangeAction
+	// Either "policy" or "group"
+	Kind string
+	Name string
+
+	Before json.RawMessage `json:",omitempty"`
+	After  json.RawMessage `json:",omitempty"`
+}
+
+// PolicyPlan is the set of changes needed to make etcd match a PolicySet, at the revision it was read
+type PolicyPlan struct {
+	Revision int64
+	Changes  []PolicyChange
+}
+
+// ApplyPoliciesRequest is sent to the control socket to apply a plan that has been reviewed
+type ApplyPoliciesRequest struct {
+	Desired PolicySet
+	Plan    PolicyPlan
+}
+
+type policyState struct {
+	revision int64
+
+	policies    map[string]*mvccValue
+	groups      map[string]*mvccValue
+	memberships map[string]*mvccValue
+}
+
+type mvccValue struct {
+	value       []byte
+	modRevision int64
+}
+
+func (p PolicySet) validate() error {
+	for name, policy := range p.Policies {
+		if name == "" {
+			return errors.New("policy names cannot be empty")
+		}
+
+		if err := routetypes.ValidateRulesWithStepUp(policy.Mfa, policy.Allow, policy.Deny, policy.StepUp); err != nil {
+			return fmt.Errorf("policy %q is invalid: %w", name, err)
+		}
+	}
+
+	for group, members := range p.Groups {
+		if group != "*" && !strings.HasPrefix(group, "group:") {
+			return fmt.Errorf("group %q did not have the 'group:' prefix", group)
+		}
+
+		for _, member := range members {
+			if member == "" {
+				return fmt.Errorf("group %q has an empty member", group)
+			}
+		}
+	}
+
+	return nil
+}
+
+func readPolicyState() (state policyState, err error) {
+	state.policies = map[string]*mvccValue{}
+	state.groups = map[string]*mvccValue{}
+	state.memberships = map[string]*mvccValue{}
+
+	response, err := etcd.Get(context.Background(), policiesPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return state, err
+	}
+	state.revision = response.Header.Revision
+
+	for _, kv := range response.Kvs {
+		state.policies[strings.TrimPrefix(string(kv.Key), policiesPrefix)] = &mvccValue{value: kv.Value, modRevision: kv.ModRevision}
+	}
+
+	// Read everything else at the same revision so the plan is built from a consistent view
+	response, err = etcd.Get(context.Background(), groupsPrefix, clientv3.WithPrefix(), clientv3.WithRev(state.revision))
+	if err != nil {
+		return state, err
+	}
+
+	for _, kv := range response.Kvs {
+		state.groups[strings.TrimPrefix(string(kv.Key), groupsPrefix)] = &mvccValue{value: kv.Value, modRevision: kv.ModRevision}
+	}
+
+	response, err = etcd.Get(context.Background(), MembershipKey+"-", clientv3.WithPrefix(), clientv3.WithRev(state.revision))
+	if err != nil {
+		return state, err
+	}
+
+	for _, kv := range response.Kvs {
+		state.memberships[strings.TrimPrefix(string(kv.Key), MembershipKey+"-")] = &mvccValue{value: kv.Value, modRevision: kv.ModRevision}
+	}
+
+	return state, nil
+}
+
+func groupMembers(value *mvccValue) (members []string, err error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	err = json.Unmarshal(value.value, &members)
+	return
+}
+
+func sameMembers(a, b []string) bool {
+	a = slices.Clone(a)
+	b = slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
+
+func diffPolicies(state policyState, desired PolicySet) (changes []PolicyChange, err error) {
+	for name, policy := range desired.Policies {
+		after, _ := json.Marshal(policy)
+
+		current, ok := state.policies[name]
+		if !ok {
+			changes = append(changes, PolicyChange{Action: PolicyCreate, Kind: "policy", Name: name, After: after})
+			continue
+		}
+
+		// Round trip the stored policy so formatting differences are not reported as changes
+		var existing acls.Acl
+		if err := json.Unmarshal(current.value, &existing); err != nil {
+			return nil, fmt.Errorf("stored policy %q is invalid: %w", name, err)
+		}
+
+		before, _ := json.Marshal(existing)
+		if string(before) != string(after) {
+			changes = append(changes, PolicyChange{Action: PolicyUpdate, Kind: "policy", Name: name, Before: before, After: after})
+		}
+	}
+
+	for name, current := range state.policies {
+		if _, ok := desired.Policies[name]; !ok {
+			var existing acls.Acl
+			if err := json.Unmarshal(current.value, &existing); err != nil {
+				return nil, fmt.Errorf("stored policy %q is invalid: %w", name, err)
+			}
+
+			before, _ := json.Marshal(existing)
+			changes = append(changes, PolicyChange{Action: PolicyDelete, Kind: "policy", Name: name, Before: before})
+		}
+	}
+
+	for group, members := range desired.Groups {
+		after, _ := json.Marshal(members)
+
+		current, ok := state.groups[group]
+		if !ok {
+			changes = append(changes, PolicyChange{Action: PolicyCreate, Kind: "group", Name: group, After: after})
+			continue
+		}
+
+		existing, err := groupMembers(current)
+		if err != nil {
+			return nil, fmt.Errorf("stored group %q is invalid: %w", group, err)
+		}
+
+		if !sameMembers(existing, members) {
+			before, _ := json.Marshal(existing)
+			changes = append(changes, PolicyChange{Action: PolicyUpdate, Kind: "group", Name: group, Before: before, After: after})
+		}
+	}
+
+	for group, current := range state.groups {
+		// The default group cannot be removed, so leaving it out of the desired state leaves it as is
+		if _, ok := desired.Groups[group]; !ok && group != "*" {
+			existing, err := groupMembers(current)
+			if err != nil {
+				return nil, fmt.Errorf("stored group %q is invalid: %w", group, err)
+			}
+
+			before, _ := json.Marshal(existing)
+			changes = append(changes, PolicyChange{Action: PolicyDelete, Kind: "group", Name: group, Before: before})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind > changes[j].Kind
+		}
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes, nil
+}
+
+// PlanPolicies validates the desired policies and groups and works out what needs to change in etcd to match them
+func PlanPolicies(desired PolicySet) (plan PolicyPlan, err error) {
+	if err := desired.validate(); err != nil {
+		return plan, err
+	}
+
+	state, err := readPolicyState()
+	if err != nil {
+		return plan, err
+	}
+
+	plan.Revision = state.revision
+	plan.Changes, err = diffPolicies(state, desired)
+
+	return
+}
+
+func samePlan(a, b []PolicyChange) bool {
+	return slices.EqualFunc(a, b, func(x, y PolicyChange) bool {
+		return x.Action == y.Action && x.Kind == y.Kind && x.Name == y.Name && string(x.Before) == string(y.Before) && string(x.After) == string(y.After)
+	})
+}
+
+// ApplyPolicies makes etcd match the desired policies and groups in a single transaction.
+// The changes must be the same as the approved plan, and nothing may change while they are written, otherwise ErrPolicyPlanChanged is returned and nothing is applied
+func ApplyPolicies(desired PolicySet, approved PolicyPlan) (PolicyPlan, error) {
+	if err := desired.validate(); err != nil {
+		return PolicyPlan{}, err
+	}
+
+	state, err := readPolicyState()
+	if err != nil {
+		return PolicyPlan{}, err
+	}
+
+	changes, err := diffPolicies(state, desired)
+	if err != nil {
+		return PolicyPlan{}, err
+	}
+
+	plan := PolicyPlan{Revision: state.revision, Changes: changes}
+	if !samePlan(changes, approved.Changes) {
+		return plan, ErrPolicyPlanChanged
+	}
+
+	if len(changes) == 0 {
+		return plan, nil
+	}
+
+	// Nothing may be created or modified under either prefix after our read
+	cmps := []clientv3.Cmp{
+		clientv3.Compare(clientv3.ModRevision(policiesPrefix).WithPrefix(), "<", state.revision+1),
+		clientv3.Compare(clientv3.ModRevision(groupsPrefix).WithPrefix(), "<", state.revision+1),
+	}
+	ops := []clientv3.Op{}
+
+	// username -> group -> is a member after the apply
+	membershipChanges := map[string]map[string]bool{}
+	setMembership := func(username, group string, member bool) {
+		if membershipChanges[username] == nil {
+			membershipChanges[username] = map[string]bool{}
+		}
+		membershipChanges[username][group] = member
+	}
+
+	for _, change := range changes {
+		prefix := policiesPrefix
+		existing := state.policies[change.Name]
+		if change.Kind == "group" {
+			prefix = groupsPrefix
+			existing = state.groups[change.Name]
+		}
+
+		key := prefix + change.Name
+		if existing != nil {
+			// Detects deletion, which the prefix comparisons cannot
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", existing.modRevision))
+		} else {
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+		}
+
+		switch change.Action {
+		case PolicyCreate, PolicyUpdate:
+			ops = append(ops, clientv3.OpPut(key, string(change.After)))
+		case PolicyDelete:
+			ops = append(ops, clientv3.OpDelete(key))
+		}
+
+		if change.Kind != "group" {
+			continue
+		}
+
+		before, err := groupMembers(existing)
+		if err != nil {
+			return plan, err
+		}
+
+		var after []string
+		if change.Action != PolicyDelete {
+			after = desired.Groups[change.Name]
+		}
+
+		for _, member := range before {
+			if !slices.Contains(after, member) {
+				setMembership(member, change.Name, false)
+			}
+		}
+
+		for _, member := range after {
+			if !slices.Contains(before, member) {
+				setMembership(member, change.Name, true)
+			}
+		}
+	}
+
+	for username, groups := range membershipChanges {
+		key := MembershipKey + "-" + username
+
+		var current []string
+		existing := state.memberships[username]
+		if existing != nil {
+			if err := json.Unmarshal(existing.value, &current); err != nil {
+				return plan, fmt.Errorf("membership of %q is invalid: %w", username, err)
+			}
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", existing.modRevision))
+		} else {
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+		}
+
+		for group, member := range groups {
+			if !member {
+				current = slices.DeleteFunc(current, func(s string) bool { return s == group })
+			} else if !slices.Contains(current, group) {
+				current = append(current, group)
+			}
+		}
+
+		if current == nil {
+			current = []string{}
+		}
+
+		membership, _ := json.Marshal(current)
+		ops = append(ops, clientv3.OpPut(key, string(membership)))
+	}
+
+	response, err := etcd.Txn(context.Background()).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return plan, fmt.Errorf("unable to apply %d changes: %w", len(changes), err)
+	}
+
+	if !response.Succeeded {
+		return plan, ErrPolicyPlanChanged
+	}
+
+	plan.Revision = response.Header.Revision
+
+	return plan, nil
+}
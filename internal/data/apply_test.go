@@ -0,0 +1,130 @@
+package data
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/NHAS/wag/internal/acls"
+)
+
+func TestApplyPolicies(t *testing.T) {
+	desired := PolicySet{
+		Groups: map[string][]string{
+			"group:apply_admins": {"apply_alice", "apply_bob"},
+		},
+		Policies: map[string]acls.Acl{
+			"group:apply_admins": {Mfa: []string{"10.1.0.0/16"}},
+			"apply_alice":        {Allow: []string{"10.2.0.1/32"}},
+		},
+	}
+
+	if _, err := PlanPolicies(PolicySet{Policies: map[string]acls.Acl{"bad": {Allow: []string{"not a rule"}}}}); err == nil {
+		t.Fatal("invalid rules should be rejected when planning")
+	}
+
+	if _, err := PlanPolicies(PolicySet{Groups: map[string][]string{"admins": {"a"}}}); err == nil {
+		t.Fatal("groups without the group: prefix should be rejected")
+	}
+
+	plan, err := PlanPolicies(desired)
+	if err != nil {
+		t.Fatal("unable to plan: ", err)
+	}
+
+	existing, err := GetPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deletes := 0
+	for _, change := range plan.Changes {
+		if change.Action == PolicyDelete {
+			deletes++
+		}
+	}
+
+	// Every policy and the only group from the test config are removed
+	if deletes != len(existing)+1 {
+		t.Fatalf("expected %d deletions got %d: %+v", len(existing)+1, deletes, plan.Changes)
+	}
+
+	_, err = ApplyPolicies(desired, plan)
+	if err != nil {
+		t.Fatal("unable to apply plan: ", err)
+	}
+
+	policies, err := GetPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(policies) != 2 {
+		t.Fatalf("expected only the 2 desired policies got: %+v", policies)
+	}
+
+	for _, user := range []string{"apply_alice", "apply_bob"} {
+		groups, err := GetUserGroupMembership(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Contains(groups, "group:apply_admins") {
+			t.Fatalf("%s was not added to group, has: %v", user, groups)
+		}
+	}
+
+	groups, err := GetUserGroupMembership("toaster")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slices.Contains(groups, "group:nerds") {
+		t.Fatal("membership of deleted group was not removed")
+	}
+
+	plan, err = PlanPolicies(desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Changes) != 0 {
+		t.Fatalf("applying should converge, still had changes: %+v", plan.Changes)
+	}
+
+	desired.Groups["group:apply_admins"] = []string{"apply_alice"}
+	plan, err = PlanPolicies(desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change state after the plan was shown, the stale plan must not be applied
+	err = SetGroup("group:apply_admins", []string{"apply_alice", "apply_bob", "apply_carol"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ApplyPolicies(desired, plan); !errors.Is(err, ErrPolicyPlanChanged) {
+		t.Fatal("expected a stale plan to be rejected got: ", err)
+	}
+
+	plan, err = PlanPolicies(desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ApplyPolicies(desired, plan); err != nil {
+		t.Fatal("unable to apply fresh plan: ", err)
+	}
+
+	for _, user := range []string{"apply_bob", "apply_carol"} {
+		groups, err := GetUserGroupMembership(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if slices.Contains(groups, "group:apply_admins") {
+			t.Fatalf("%s should have been removed from group, has: %v", user, groups)
+		}
+	}
+}
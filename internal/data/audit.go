@@ -0,0 +1,292 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	AuditPrefix = "wag/audit/"
+
+	AuditRetentionKey = "wag-config-audit-retention"
+)
+
+type AuditEventType string
+
+const (
+	AuditLogin              AuditEventType = "login"
+	AuditLoginFailed        AuditEventType = "login_failed"
+	AuditLogout             AuditEventType = "logout"
+	AuditSessionExtended    AuditEventType = "session_extended"
+	AuditLockout            AuditEventType = "lockout"
+	AuditLockoutExpired     AuditEventType = "lockout_expired"
+	AuditStepUp             AuditEventType = "stepup"
+	AuditStepUpFailed       AuditEventType = "stepup_failed"
+	AuditMFARegistered      AuditEventType = "mfa_registered"
+	AuditDeviceRegistered   AuditEventType = "device_registered"
+	AuditRegistrationFailed AuditEventType = "registration_failed"
+
+	AuditAdminLogin       AuditEventType = "admin_login"
+	AuditAdminLoginFailed AuditEventType = "admin_login_failed"
+	AuditAdminAction      AuditEventType = "admin_action"
+	AuditPolicyChange     AuditEventType = "policy_change"
+	AuditSettingsChange   AuditEventType = "settings_change"
+
+	AuditAccessRequested AuditEventType = "access_requested"
+	AuditAccessCancelled AuditEventType = "access_cancelled"
+	AuditAccessApproved  AuditEventType = "access_approved"
+	AuditAccessDenied    AuditEventType = "access_denied"
+	AuditAccessRevoked   AuditEventType = "access_revoked"
+	AuditAccessExpired   AuditEventType = "access_expired"
+
+	AuditAccountExpired  AuditEventType = "account_expired"
+	AuditAccountInactive AuditEventType = "account_inactive"
+
+	AuditKeysIssued  AuditEventType = "keys_issued"
+	AuditKeysRotated AuditEventType = "keys_rotated"
+)
+
+// AuditEvent is a single security relevant event, stored in etcd so it is replicated to every cluster member
+type AuditEvent struct {
+	ID       string
+	Time     time.Time
+	NodeID   string
+	Type     AuditEventType
+	Actor    string
+	SourceIP string
+	Target   string `json:",omitempty"`
+	Details  string `json:",omitempty"`
+}
+
+type AuditRetention struct {
+	// Events older than this are removed, 0 keeps events forever
+	Days int
+	// Oldest events are removed once there are more than this many, 0 is unlimited
+	MaxEntries int
+}
+
+type AuditFilter struct {
+	Since, Until time.Time
+	Type         AuditEventType
+	Actor        string
+	// Case insensitive substring match against the actor, source ip, target and details
+	Text string
+
+	// Maximum number of events to return, newest first. 0 returns everything that matches
+	Limit int
+}
+
+func auditKey(t time.Time) string {
+	// Zero padded so that keys sort in time order
+	return fmt.Sprintf("%s%020d", AuditPrefix, t.UnixNano())
+}
+
+// RecordAuditEvent writes an event to the audit log, the time, id and node are filled in if not set
+func RecordAuditEvent(event AuditEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if event.NodeID == "" {
+		event.NodeID = GetServerID()
+	}
+
+	var err error
+	event.ID, err = generateRandomBytes(8)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = etcd.Put(context.Background(), auditKey(event.Time)+"-"+event.ID, string(b))
+	return err
+}
+
+// Audit records an event, failing to write to the audit log should not stop the action that is being audited so errors are only logged
+func Audit(eventType AuditEventType, actor, sourceIP, target, details string) {
+	err := RecordAuditEvent(AuditEvent{
+		Type:     eventType,
+		Actor:    actor,
+		SourceIP: sourceIP,
+		Target:   target,
+		Details:  details,
+	})
+	if err != nil {
+		log.Println("unable to write audit event", eventType, actor, sourceIP, "err:", err)
+	}
+}
+
+func (f AuditFilter) matches(event AuditEvent) bool {
+	if f.Type != "" && f.Type != event.Type {
+		return false
+	}
+
+	if f.Actor != "" && f.Actor != event.Actor {
+		return false
+	}
+
+	if f.Text != "" {
+		text := strings.ToLower(f.Text)
+		found := false
+		for _, field := range []string{event.Actor, event.SourceIP, event.Target, event.Details} {
+			if strings.Contains(strings.ToLower(field), text) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SearchAuditEvents returns events that match the filter, newest first
+func SearchAuditEvents(filter AuditFilter) (events []AuditEvent, err error) {
+	const pageSize = 1000
+
+	start := AuditPrefix
+	if !filter.Since.IsZero() {
+		start = auditKey(filter.Since)
+	}
+
+	end := clientv3.GetPrefixRangeEnd(AuditPrefix)
+	if !filter.Until.IsZero() {
+		end = auditKey(filter.Until.Add(time.Nanosecond))
+	}
+
+	for {
+		response, err := etcd.Get(context.Background(), start, clientv3.WithRange(end), clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend), clientv3.WithLimit(pageSize))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, kv := range response.Kvs {
+			var event AuditEvent
+			err := json.Unmarshal(kv.Value, &event)
+			if err != nil {
+				return nil, err
+			}
+
+			if !filter.matches(event) {
+				continue
+			}
+
+			events = append(events, event)
+			if filter.Limit > 0 && len(events) >= filter.Limit {
+				return events, nil
+			}
+		}
+
+		if !response.More || len(response.Kvs) == 0 {
+			return events, nil
+		}
+
+		// End is exclusive, so the next page starts just before the oldest key we've seen
+		end = string(response.Kvs[len(response.Kvs)-1].Key)
+	}
+}
+
+func GetAuditRetention() (retention AuditRetention, err error) {
+	response, err := etcd.Get(context.Background(), AuditRetentionKey)
+	if err != nil {
+		return retention, err
+	}
+
+	if len(response.Kvs) != 1 {
+		return retention, errors.New("audit retention was not set")
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &retention)
+	return
+}
+
+func SetAuditRetention(retention AuditRetention) error {
+	if retention.Days < 0 || retention.MaxEntries < 0 {
+		return errors.New("audit retention values cannot be negative")
+	}
+
+	b, _ := json.Marshal(retention)
+	_, err := etcd.Put(context.Background(), AuditRetentionKey, string(b))
+	return err
+}
+
+// pruneAuditEvents removes events outside of the retention policy
+func pruneAuditEvents() error {
+	retention, err := GetAuditRetention()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if retention.Days > 0 {
+		cutoff := time.Now().Add(-time.Duration(retention.Days) * 24 * time.Hour)
+		_, err = etcd.Delete(ctx, AuditPrefix, clientv3.WithRange(auditKey(cutoff)))
+		if err != nil {
+			return err
+		}
+	}
+
+	if retention.MaxEntries > 0 {
+		count, err := etcd.Get(ctx, AuditPrefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			return err
+		}
+
+		excess := count.Count - int64(retention.MaxEntries)
+		if excess <= 0 {
+			return nil
+		}
+
+		oldest, err := etcd.Get(ctx, AuditPrefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend), clientv3.WithKeysOnly(), clientv3.WithLimit(excess))
+		if err != nil {
+			return err
+		}
+
+		if len(oldest.Kvs) == 0 {
+			return nil
+		}
+
+		_, err = etcd.Delete(ctx, AuditPrefix, clientv3.WithRange(string(oldest.Kvs[len(oldest.Kvs)-1].Key)+"\x00"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// auditRetention periodically prunes the audit log, only the cluster leader does this as the deletes are replicated
+func auditRetention() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !IsLeader() {
+				continue
+			}
+
+			if err := pruneAuditEvents(); err != nil {
+				log.Println("unable to apply audit log retention: ", err)
+			}
+		case <-exit:
+			return
+		}
+	}
+}
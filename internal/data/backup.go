@@ -0,0 +1,269 @@
+package data
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	BackupFormat = "wag-backup"
+	// Increment when the contents of a backup change in a way older versions of wag could not restore
+	BackupVersion = 1
+)
+
+// Keys that describe the running cluster rather than wag state, these are neither backed up nor replaced by a restore
+var backupExcludedPrefixes = []string{
+	NodeEvents,
+	SinkClaimsPrefix,
+	SinkOutboxPrefix,
+	ACMEChallengesPrefix,
+	// Leader election keys belong to leases of the running nodes, restoring them without their lease would leave a dead node as leader
+	electionPrefix,
+}
+
+type BackupEntry struct {
+	Key   string
+	Value []byte
+}
+
+// Backup is every wag key in etcd (users, devices, acls, groups, tokens, settings, admin users and the audit log) read at a single revision
+type Backup struct {
+	Version    int
+	WagVersion string
+	Created    time.Time
+	Revision   int64
+	Entries    []BackupEntry
+}
+
+// backupArchive is the on disk format, the backup is gzipped json which is sealed with a key derived from a passphrase when encrypted
+type backupArchive struct {
+	Format    string
+	Version   int
+	Created   time.Time
+	Encrypted bool
+	Salt      []byte `json:",omitempty"`
+	Nonce     []byte `json:",omitempty"`
+	Data      []byte
+}
+
+func excludedFromBackup(key string) bool {
+	for _, prefix := range backupExcludedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateBackup reads all wag state from etcd, every page is read at the revision of the first so the backup is consistent
+func CreateBackup() (backup Backup, err error) {
+	const pageSize = 1000
+
+	backup.Version = BackupVersion
+	backup.WagVersion = config.Version
+	backup.Created = time.Now()
+
+	options := []clientv3.OpOption{clientv3.WithFromKey(), clientv3.WithLimit(pageSize), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend)}
+
+	start := "\x00"
+	for {
+		response, err := etcd.Get(context.Background(), start, options...)
+		if err != nil {
+			return Backup{}, err
+		}
+
+		if backup.Revision == 0 {
+			backup.Revision = response.Header.Revision
+			options = append(options, clientv3.WithRev(backup.Revision))
+		}
+
+		for _, kv := range response.Kvs {
+			if excludedFromBackup(string(kv.Key)) {
+				continue
+			}
+
+			backup.Entries = append(backup.Entries, BackupEntry{Key: string(kv.Key), Value: kv.Value})
+		}
+
+		if !response.More || len(response.Kvs) == 0 {
+			return backup, nil
+		}
+
+		start = string(response.Kvs[len(response.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// RestoreBackup replaces all wag state in etcd with the contents of the backup.
+// This is not atomic for large backups, so it should only be done on a fresh cluster that is not yet serving clients
+func RestoreBackup(backup Backup) error {
+	if backup.Version > BackupVersion {
+		return fmt.Errorf("backup version %d is newer than this version of wag supports (%d)", backup.Version, BackupVersion)
+	}
+
+	existing, err := etcd.Get(context.Background(), "\x00", clientv3.WithFromKey(), clientv3.WithKeysOnly())
+	if err != nil {
+		return err
+	}
+
+	ops := []clientv3.Op{}
+	restored := map[string]bool{}
+	for _, entry := range backup.Entries {
+		if excludedFromBackup(entry.Key) {
+			continue
+		}
+
+		restored[entry.Key] = true
+		ops = append(ops, clientv3.OpPut(entry.Key, string(entry.Value)))
+	}
+
+	// Keys that are in the backup are overwritten, etcd does not allow a key to be deleted and put in the same transaction
+	for _, kv := range existing.Kvs {
+		if excludedFromBackup(string(kv.Key)) || restored[string(kv.Key)] {
+			continue
+		}
+
+		ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+	}
+
+	return applyInBatches(ops)
+}
+
+// applyInBatches commits operations in transactions that are under the default etcd operation limit
+func applyInBatches(ops []clientv3.Op) error {
+	const batchSize = 100
+
+	for len(ops) > 0 {
+		n := min(len(ops), batchSize)
+
+		_, err := etcd.Txn(context.Background()).Then(ops[:n]...).Commit()
+		if err != nil {
+			return err
+		}
+
+		ops = ops[n:]
+	}
+
+	return nil
+}
+
+func backupKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, 3, 64*1024, 4, 32)
+}
+
+// EncodeBackup creates a backup archive, if passphrase is not empty the backup is encrypted with AES-256-GCM
+func EncodeBackup(backup Backup, passphrase string) ([]byte, error) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if err := json.NewEncoder(gz).Encode(backup); err != nil {
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	archive := backupArchive{
+		Format:  BackupFormat,
+		Version: backup.Version,
+		Created: backup.Created,
+		Data:    compressed.Bytes(),
+	}
+
+	if passphrase != "" {
+		archive.Encrypted = true
+
+		archive.Salt = make([]byte, 16)
+		if _, err := rand.Read(archive.Salt); err != nil {
+			return nil, err
+		}
+
+		aead, err := backupCipher(passphrase, archive.Salt)
+		if err != nil {
+			return nil, err
+		}
+
+		archive.Nonce = make([]byte, aead.NonceSize())
+		if _, err := rand.Read(archive.Nonce); err != nil {
+			return nil, err
+		}
+
+		archive.Data = aead.Seal(nil, archive.Nonce, archive.Data, []byte(BackupFormat))
+	}
+
+	return json.Marshal(archive)
+}
+
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(backupKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// DecodeBackup reads a backup archive, the passphrase is only used if the archive is encrypted
+func DecodeBackup(raw []byte, passphrase string) (backup Backup, err error) {
+	var archive backupArchive
+	if err := json.Unmarshal(raw, &archive); err != nil {
+		return backup, fmt.Errorf("not a wag backup: %w", err)
+	}
+
+	if archive.Format != BackupFormat {
+		return backup, errors.New("not a wag backup")
+	}
+
+	if archive.Version > BackupVersion {
+		return backup, fmt.Errorf("backup version %d is newer than this version of wag supports (%d)", archive.Version, BackupVersion)
+	}
+
+	compressed := archive.Data
+	if archive.Encrypted {
+		if passphrase == "" {
+			return backup, errors.New("backup is encrypted, a passphrase is required")
+		}
+
+		aead, err := backupCipher(passphrase, archive.Salt)
+		if err != nil {
+			return backup, err
+		}
+
+		if len(archive.Nonce) != aead.NonceSize() {
+			return backup, errors.New("backup nonce is invalid")
+		}
+
+		compressed, err = aead.Open(nil, archive.Nonce, archive.Data, []byte(BackupFormat))
+		if err != nil {
+			return backup, errors.New("unable to decrypt backup, passphrase is incorrect or the backup is corrupt")
+		}
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return backup, err
+	}
+	defer gz.Close()
+
+	contents, err := io.ReadAll(gz)
+	if err != nil {
+		return backup, err
+	}
+
+	err = json.Unmarshal(contents, &backup)
+	return
+}
@@ -0,0 +1,221 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestMain(m *testing.M) {
+	if err := config.Load("../config/testing_config2.json"); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	k, err := wgtypes.GenerateKey()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	err = Load(fmt.Sprintf("file:%s?mode=memory&cache=shared", k.String()), "", true)
+	if err != nil {
+		log.Println("cannot load database: ", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	TearDown()
+
+	os.Exit(code)
+}
+
+func snapshot(t *testing.T) map[string]string {
+	backup, err := CreateBackup()
+	if err != nil {
+		t.Fatal("unable to create backup: ", err)
+	}
+
+	state := map[string]string{}
+	for _, entry := range backup.Entries {
+		state[entry.Key] = string(entry.Value)
+	}
+
+	return state
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	_, err := CreateUserDataAccount("backup_user")
+	if err != nil {
+		t.Fatal("unable to create user: ", err)
+	}
+
+	_, err = AddDevice("backup_user", "8mOh0G6KRFa2kCzMvwcAvUIk0ZK0c7nJJ3JQ3amD2iY=")
+	if err != nil {
+		t.Fatal("unable to add device: ", err)
+	}
+
+	err = AddRegistrationToken("0123456789abcdef0123456789abcdef0123456789abcdef", "backup_user", "", nil, 2, time.Time{})
+	if err != nil {
+		t.Fatal("unable to add registration token: ", err)
+	}
+
+	err = CreateAdminUser("backup_admin", "a very long password that is fine", false)
+	if err != nil {
+		t.Fatal("unable to add admin user: ", err)
+	}
+
+	// Runtime state should not be backed up or touched by a restore
+	_, err = etcd.Put(context.Background(), SinkOutboxPrefix+"runtime", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := snapshot(t)
+	if _, ok := original[SinkOutboxPrefix+"runtime"]; ok {
+		t.Fatal("runtime keys should be excluded from backups")
+	}
+
+	for _, key := range []string{"users-backup_user-", "tokens-0123456789abcdef0123456789abcdef0123456789abcdef", "admin-users-backup_admin"} {
+		if _, ok := original[key]; !ok {
+			t.Fatal("backup did not contain: ", key)
+		}
+	}
+
+	backup, err := CreateBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := EncodeBackup(backup, "correct horse battery staple")
+	if err != nil {
+		t.Fatal("unable to encode backup: ", err)
+	}
+
+	if _, err := DecodeBackup(archive, ""); err == nil {
+		t.Fatal("encrypted backup should not decode without a passphrase")
+	}
+
+	if _, err := DecodeBackup(archive, "wrong"); err == nil {
+		t.Fatal("encrypted backup should not decode with the wrong passphrase")
+	}
+
+	decoded, err := DecodeBackup(archive, "correct horse battery staple")
+	if err != nil {
+		t.Fatal("unable to decode backup: ", err)
+	}
+
+	// Change state after the backup was taken, the restore should undo all of it
+	err = DeleteUser("backup_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = CreateUserDataAccount("created_after_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RestoreBackup(decoded)
+	if err != nil {
+		t.Fatal("unable to restore backup: ", err)
+	}
+
+	restored := snapshot(t)
+	if len(restored) != len(original) {
+		t.Fatalf("restored state has %d keys, expected %d", len(restored), len(original))
+	}
+
+	for key, value := range original {
+		if restored[key] != value {
+			t.Fatalf("key %q was not restored, expected %q got %q", key, value, restored[key])
+		}
+	}
+
+	response, err := etcd.Get(context.Background(), SinkOutboxPrefix+"runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Count != 1 {
+		t.Fatal("restore should not remove runtime keys")
+	}
+
+	unencrypted, err := EncodeBackup(backup, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := DecodeBackup(unencrypted, "ignored")
+	if err != nil {
+		t.Fatal("unable to decode unencrypted backup: ", err)
+	}
+
+	if !slices.EqualFunc(plain.Entries, backup.Entries, func(a, b BackupEntry) bool { return a.Key == b.Key && string(a.Value) == string(b.Value) }) {
+		t.Fatal("unencrypted backup did not round trip")
+	}
+}
+
+func TestBackupElectionKeys(t *testing.T) {
+	lease, err := clientv3.NewLease(etcd).Grant(context.Background(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := electionPrefix + "live"
+	if _, err := etcd.Put(context.Background(), live, "node-a", clientv3.WithLease(lease.ID)); err != nil {
+		t.Fatal(err)
+	}
+	defer etcd.Delete(context.Background(), electionPrefix, clientv3.WithPrefix())
+
+	backup, err := CreateBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range backup.Entries {
+		if strings.HasPrefix(entry.Key, electionPrefix) {
+			t.Fatal("election keys should not be backed up: ", entry.Key)
+		}
+	}
+
+	// A backup that does contain an election key, e.g from an older version, should not bring it back
+	backup.Entries = append(backup.Entries, BackupEntry{Key: electionPrefix + "stale", Value: []byte("node-b")})
+
+	if err := RestoreBackup(backup); err != nil {
+		t.Fatal("unable to restore backup: ", err)
+	}
+
+	response, err := etcd.Get(context.Background(), electionPrefix, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Kvs) != 1 || string(response.Kvs[0].Key) != live || response.Kvs[0].Lease != int64(lease.ID) {
+		t.Fatal("restore should leave the live election keys alone and not restore old ones: ", response.Kvs)
+	}
+}
+
+func TestBackupVersion(t *testing.T) {
+	archive, err := EncodeBackup(Backup{Version: BackupVersion + 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeBackup(archive, ""); err == nil {
+		t.Fatal("backups from newer versions should be rejected")
+	}
+
+	if _, err := DecodeBackup([]byte(`{"Format":"something-else"}`), ""); err == nil {
+		t.Fatal("non wag archives should be rejected")
+	}
+}
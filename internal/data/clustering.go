@@ -34,42 +34,83 @@ type NodeControlRequest struct {
 }
 
 func GetServerID() string {
+	if config.Values.Clustering.IsExternal() {
+		return externalNodeID().String()
+	}
+
 	return etcdServer.Server.ID().String()
 }
 
 func GetLeader() types.ID {
+	if config.Values.Clustering.IsExternal() {
+		id, _ := strconv.ParseUint(externalLeader(), 16, 64)
+		return types.ID(id)
+	}
+
 	return etcdServer.Server.Leader()
 }
 
 func HasLeader() bool {
+	if config.Values.Clustering.IsExternal() {
+		return externalLeader() != ""
+	}
+
 	return etcdServer.Server.Leader() != 0
 }
 
 func IsLearner() bool {
+	if config.Values.Clustering.IsExternal() {
+		return false
+	}
+
 	return etcdServer.Server.IsLearner()
 }
 
 func IsLeader() bool {
+	if config.Values.Clustering.IsExternal() {
+		return externalLeader() == GetServerID()
+	}
+
 	return etcdServer.Server.Leader() == etcdServer.Server.ID()
 }
 
 // Called on a leader node, to transfer ownership to another node (demoted)
 func StepDown() error {
+	if config.Values.Clustering.IsExternal() {
+		return externalStepDown()
+	}
+
 	return etcdServer.Server.TransferLeadership()
 }
 
 func GetMembers() []*membership.Member {
+	if config.Values.Clustering.IsExternal() {
+		return externalMembers()
+	}
 
 	return etcdServer.Server.Cluster().Members()
 }
 
+func isMember(id uint64) bool {
+	if config.Values.Clustering.IsExternal() {
+		for _, member := range externalMembers() {
+			if uint64(member.ID) == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	return etcdServer.Server.Cluster().Member(types.ID(id)) != nil
+}
+
 func GetLastPing(idHex string) (time.Time, error) {
 	id, err := strconv.ParseUint(idHex, 16, 64)
 	if err != nil {
 		return time.Time{}, err
 	}
 
-	if etcdServer.Server.Cluster().Member(types.ID(id)) == nil {
+	if !isMember(id) {
 		return time.Time{}, errors.New("id is not part of cluster")
 	}
 
@@ -123,11 +164,90 @@ func IsDrained(idHex string) (bool, error) {
 	return isDrained.Count != 0, nil
 }
 
+// ClusterMember is the health of a cluster member as shown by the management ui and wag cluster
+type ClusterMember struct {
+	ID       string
+	Name     string
+	PeerURLs []string
+	Learner  bool
+	Leader   bool
+	Current  bool
+	Drained  bool
+	LastPing time.Time
+	Status   string
+}
+
+// MemberStatus describes the health of member from its drain state and when it last sent a ping
+func MemberStatus(member *membership.Member) (status string, lastPing time.Time, drained bool, err error) {
+	drained, err = IsDrained(member.ID.String())
+	if err != nil {
+		return "", lastPing, false, err
+	}
+
+	status = "healthy" // full liveness
+	if drained {
+		status = "drained"
+	} else if !member.IsStarted() {
+		status = "wait for first connection..."
+	} else if member.IsLearner {
+		status = "learner"
+	}
+
+	if status == "learner" {
+		return status, lastPing, drained, nil
+	}
+
+	lastPing, err = GetLastPing(member.ID.String())
+	if err != nil {
+		return "no last ping", lastPing, drained, nil
+	}
+
+	if lastPing.Before(time.Now().Add(-6 * time.Second)) {
+		status += "(lagging ping)"
+	}
+
+	if lastPing.Before(time.Now().Add(-14 * time.Second)) {
+		status = "dead"
+	}
+
+	return status, lastPing, drained, nil
+}
+
+// GetClusterMembers returns the status of every member of the cluster
+func GetClusterMembers() (members []ClusterMember, err error) {
+	leader := GetLeader()
+	current := GetServerID()
+
+	for _, member := range GetMembers() {
+		status, lastPing, drained, err := MemberStatus(member)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, ClusterMember{
+			ID:       member.ID.String(),
+			Name:     member.Name,
+			PeerURLs: member.PeerURLs,
+			Learner:  member.IsLearner,
+			Leader:   member.ID == leader,
+			Current:  member.ID.String() == current,
+			Drained:  drained,
+			LastPing: lastPing,
+			Status:   status,
+		})
+	}
+
+	return members, nil
+}
+
 // AddMember adds a new node to the etcd cluster, and subsequently wag.
 // This is done by creating a join token which allows an existing member to issue the CA private key, and download the wag config
 // etcPeerUrlAddress is where the new node etcd instance is contactable
 // newManagerAddressURL is where the tls manager will listen (i.e the place that serves tls certs and config)
 func AddMember(name, etcPeerUrlAddress, newManagerAddressURL string) (joinToken string, err error) {
+	if config.Values.Clustering.IsExternal() {
+		return "", errExternalCluster
+	}
 
 	if !strings.HasPrefix(etcPeerUrlAddress, "https://") {
 		return "", errors.New("url must be https://")
@@ -214,6 +334,10 @@ func AddMember(name, etcPeerUrlAddress, newManagerAddressURL string) (joinToken
 }
 
 func PromoteMember(idHex string) error {
+	if config.Values.Clustering.IsExternal() {
+		return errExternalCluster
+	}
+
 	id, err := strconv.ParseUint(idHex, 16, 64)
 	if err != nil {
 		return fmt.Errorf("bad member ID arg (%v), expecting ID in Hex", err)
@@ -239,6 +363,11 @@ func RemoveMember(idHex string) error {
 		return err
 	}
 
+	// Nodes of an external cluster are only clients, so forgetting them is all there is to do
+	if config.Values.Clustering.IsExternal() {
+		return nil
+	}
+
 	_, err = etcd.MemberRemove(context.Background(), id)
 	if err != nil {
 		return err
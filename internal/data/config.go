@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"strings"
 
 	"github.com/NHAS/wag/internal/data/validators"
@@ -14,6 +15,10 @@ import (
 )
 
 type OIDC struct {
+	// Name is only set for additional providers, the default provider stored under OidcDetailsKey has no name
+	Name        string `json:",omitempty" validate:"omitempty,alphanum"`
+	DisplayName string `json:",omitempty"`
+
 	IssuerURL           string
 	ClientSecret        string
 	ClientID            string
@@ -21,6 +26,44 @@ type OIDC struct {
 	DeviceUsernameClaim string
 }
 
+type SAML struct {
+	// Either the url to fetch the identity provider metadata from, or the metadata xml itself
+	IdPMetadataURL string
+	IdPMetadataXML string `json:",omitempty"`
+
+	// Entity ID of wag as a service provider, defaults to the metadata url <domain>/authorise/saml/metadata
+	EntityID string `json:",omitempty"`
+
+	// Attribute to take the device username from, if empty the NameID of the subject is used
+	UsernameAttribute string `json:",omitempty"`
+
+	// Attribute that contains group names, each value is mapped to the wag group "group:<value>"
+	GroupsAttribute string `json:",omitempty"`
+}
+
+type LDAP struct {
+	// Either ldaps://host:636, or ldap://host:389 with StartTLS set, plaintext binds are not allowed
+	URL                string
+	StartTLS           bool   `json:",omitempty"`
+	InsecureSkipVerify bool   `json:",omitempty"`
+	CACertificate      string `json:",omitempty"`
+
+	// If set the users dn is created from this template, e.g uid=%s,ou=people,dc=example,dc=com, otherwise the user is searched for under BaseDN
+	UserDNTemplate string `json:",omitempty"`
+
+	// Service account used to search for users, anonymous if empty
+	BindDN       string `json:",omitempty"`
+	BindPassword string `json:",omitempty"`
+	BaseDN       string `json:",omitempty"`
+	UserFilter   string `json:",omitempty"`
+
+	// Attribute that contains the distinguished names of the groups the user is a member of, defaults to memberOf
+	GroupAttribute string `json:",omitempty"`
+
+	// Also add groups that the users groups are members of
+	NestedGroups bool `json:",omitempty"`
+}
+
 type PAM struct {
 	ServiceName string
 }
@@ -40,15 +83,22 @@ const (
 
 	InactivityTimeoutKey = "wag-config-authentication-inactivity-timeout"
 	SessionLifetimeKey   = "wag-config-authentication-max-session-lifetime"
+	SessionExtensionKey  = "wag-config-authentication-session-extension"
 
 	LockoutKey           = "wag-config-authentication-lockout"
+	LockoutCooldownKey   = "wag-config-authentication-lockout-cooldown"
+	AttemptDelayKey      = "wag-config-authentication-attempt-delay"
 	IssuerKey            = "wag-config-authentication-issuer"
 	DomainKey            = "wag-config-authentication-domain"
 	MFAMethodsEnabledKey = "wag-config-authentication-methods"
 	DefaultMFAMethodKey  = "wag-config-authentication-default-method"
+	StepUpMethodsKey     = "wag-config-authentication-stepup-methods"
 
-	OidcDetailsKey = "wag-config-authentication-oidc"
-	PamDetailsKey  = "wag-config-authentication-pam"
+	OidcDetailsKey   = "wag-config-authentication-oidc"
+	OidcProvidersKey = "wag-config-authentication-oidc-providers"
+	SamlDetailsKey   = "wag-config-authentication-saml"
+	LdapDetailsKey   = "wag-config-authentication-ldap"
+	PamDetailsKey    = "wag-config-authentication-pam"
 
 	externalAddressKey = "wag-config-network-external-address"
 	dnsKey             = "wag-config-network-dns"
@@ -142,6 +192,120 @@ func GetOidc() (details OIDC, err error) {
 	return
 }
 
+func SetOidcProviders(providers []OIDC) error {
+	if err := validateOidcProviders(providers); err != nil {
+		return err
+	}
+
+	d, err := json.Marshal(providers)
+	if err != nil {
+		return err
+	}
+
+	_, err = etcd.Put(context.Background(), OidcProvidersKey, string(d))
+	return err
+}
+
+func GetOidcProviders() (providers []OIDC, err error) {
+
+	response, err := etcd.Get(context.Background(), OidcProvidersKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Kvs) == 0 {
+		return nil, nil
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &providers)
+	return
+}
+
+func GetOidcProvider(name string) (details OIDC, err error) {
+	providers, err := GetOidcProviders()
+	if err != nil {
+		return OIDC{}, err
+	}
+
+	for _, provider := range providers {
+		if provider.Name == name {
+			return provider, nil
+		}
+	}
+
+	return OIDC{}, fmt.Errorf("no oidc provider named %q found", name)
+}
+
+func validateOidcProviders(providers []OIDC) error {
+	seen := map[string]bool{}
+	for _, provider := range providers {
+		if provider.Name == "" {
+			return errors.New("additional oidc providers must have a name")
+		}
+
+		if seen[provider.Name] {
+			return fmt.Errorf("oidc provider name %q is not unique", provider.Name)
+		}
+		seen[provider.Name] = true
+
+		if provider.IssuerURL == "" || provider.ClientID == "" {
+			return fmt.Errorf("oidc provider %q must have an issuer url and client id", provider.Name)
+		}
+	}
+
+	return nil
+}
+
+func SetSaml(details SAML) error {
+	d, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	_, err = etcd.Put(context.Background(), SamlDetailsKey, string(d))
+	return err
+}
+
+func GetSaml() (details SAML, err error) {
+
+	response, err := etcd.Get(context.Background(), SamlDetailsKey)
+	if err != nil {
+		return SAML{}, err
+	}
+
+	if len(response.Kvs) == 0 {
+		return SAML{}, errors.New("no saml settings found")
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &details)
+	return
+}
+
+func SetLdap(details LDAP) error {
+	d, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	_, err = etcd.Put(context.Background(), LdapDetailsKey, string(d))
+	return err
+}
+
+func GetLdap() (details LDAP, err error) {
+
+	response, err := etcd.Get(context.Background(), LdapDetailsKey)
+	if err != nil {
+		return LDAP{}, err
+	}
+
+	if len(response.Kvs) == 0 {
+		return LDAP{}, errors.New("no ldap settings found")
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &details)
+	return
+}
+
 func GetWebauthn() (wba Webauthn, err error) {
 
 	txn := etcd.Txn(context.Background())
@@ -232,6 +396,32 @@ func GetAuthenicationMethods() (result []string, err error) {
 	return
 }
 
+func SetStepUpMethods(methods []string) error {
+	data, _ := json.Marshal(methods)
+	_, err := etcd.Put(context.Background(), StepUpMethodsKey, string(data))
+	return err
+}
+
+// GetStepUpMethods returns the authenticator types a user must have completed before step up routes are accessible, an empty list means step up is disabled
+func GetStepUpMethods() (result []string, err error) {
+
+	resp, err := etcd.Get(context.Background(), StepUpMethodsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Kvs) != 1 {
+		return nil, fmt.Errorf("incorrect number of %s keys", StepUpMethodsKey)
+	}
+
+	err = json.Unmarshal(resp.Kvs[0].Value, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
 func SetCheckUpdates(doChecks bool) error {
 
 	data, _ := json.Marshal(doChecks)
@@ -349,12 +539,16 @@ type LoginSettings struct {
 
 	DefaultMFAMethod  string   `validate:"required"`
 	EnabledMFAMethods []string `validate:"required,lt=10,dive,required"`
+	StepUpMFAMethods  []string `validate:"omitempty,lt=10,dive,required"`
 
 	Domain string `validate:"required"`
 	Issuer string `validate:"required"`
 
-	OidcDetails OIDC
-	PamDetails  PAM
+	OidcDetails   OIDC
+	OidcProviders []OIDC `validate:"omitempty,dive"`
+	SamlDetails   SAML
+	LdapDetails   LDAP
+	PamDetails    PAM
 }
 
 func (lg *LoginSettings) Validate() error {
@@ -363,7 +557,17 @@ func (lg *LoginSettings) Validate() error {
 
 	validate := validator.New(validator.WithRequiredStructEnabled())
 
-	return validate.Struct(lg)
+	if err := validate.Struct(lg); err != nil {
+		return err
+	}
+
+	for _, method := range lg.StepUpMFAMethods {
+		if !slices.Contains(lg.EnabledMFAMethods, method) {
+			return fmt.Errorf("step up method %q is not an enabled mfa method", method)
+		}
+	}
+
+	return validateOidcProviders(lg.OidcProviders)
 }
 
 func (lg *LoginSettings) ToWriteOps() (ret []clientv3.Op, err error) {
@@ -387,6 +591,9 @@ func (lg *LoginSettings) ToWriteOps() (ret []clientv3.Op, err error) {
 	b, _ = json.Marshal(lg.EnabledMFAMethods)
 	ret = append(ret, clientv3.OpPut(MFAMethodsEnabledKey, string(b)))
 
+	b, _ = json.Marshal(lg.StepUpMFAMethods)
+	ret = append(ret, clientv3.OpPut(StepUpMethodsKey, string(b)))
+
 	b, _ = json.Marshal(lg.Domain)
 	ret = append(ret, clientv3.OpPut(DomainKey, string(b)))
 
@@ -396,6 +603,15 @@ func (lg *LoginSettings) ToWriteOps() (ret []clientv3.Op, err error) {
 	b, _ = json.Marshal(lg.OidcDetails)
 	ret = append(ret, clientv3.OpPut(OidcDetailsKey, string(b)))
 
+	b, _ = json.Marshal(lg.OidcProviders)
+	ret = append(ret, clientv3.OpPut(OidcProvidersKey, string(b)))
+
+	b, _ = json.Marshal(lg.SamlDetails)
+	ret = append(ret, clientv3.OpPut(SamlDetailsKey, string(b)))
+
+	b, _ = json.Marshal(lg.LdapDetails)
+	ret = append(ret, clientv3.OpPut(LdapDetailsKey, string(b)))
+
 	b, _ = json.Marshal(lg.PamDetails)
 	ret = append(ret, clientv3.OpPut(PamDetailsKey, string(b)))
 
@@ -465,7 +681,11 @@ func GetAllSettings() (s AllSettings, err error) {
 		clientv3.OpGet(checkUpdatesKey),
 		clientv3.OpGet(OidcDetailsKey),
 		clientv3.OpGet(PamDetailsKey),
-		clientv3.OpGet(defaultWGFileNameKey)).Commit()
+		clientv3.OpGet(defaultWGFileNameKey),
+		clientv3.OpGet(OidcProvidersKey),
+		clientv3.OpGet(SamlDetailsKey),
+		clientv3.OpGet(LdapDetailsKey),
+		clientv3.OpGet(StepUpMethodsKey)).Commit()
 	if err != nil {
 		return s, err
 	}
@@ -569,6 +789,34 @@ func GetAllSettings() (s AllSettings, err error) {
 		}
 	}
 
+	if response.Responses[14].GetResponseRange().Count == 1 {
+		err := json.Unmarshal(response.Responses[14].GetResponseRange().Kvs[0].Value, &s.OidcProviders)
+		if err != nil {
+			return s, err
+		}
+	}
+
+	if response.Responses[15].GetResponseRange().Count == 1 {
+		err := json.Unmarshal(response.Responses[15].GetResponseRange().Kvs[0].Value, &s.SamlDetails)
+		if err != nil {
+			return s, err
+		}
+	}
+
+	if response.Responses[16].GetResponseRange().Count == 1 {
+		err := json.Unmarshal(response.Responses[16].GetResponseRange().Kvs[0].Value, &s.LdapDetails)
+		if err != nil {
+			return s, err
+		}
+	}
+
+	if response.Responses[17].GetResponseRange().Count == 1 {
+		err := json.Unmarshal(response.Responses[17].GetResponseRange().Kvs[0].Value, &s.StepUpMFAMethods)
+		if err != nil {
+			return s, err
+		}
+	}
+
 	return
 }
 
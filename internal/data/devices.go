@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"time"
 
@@ -23,6 +24,23 @@ type Device struct {
 	Attempts     int
 	Active       bool
 	Authorised   time.Time
+
+	// When the last attempt counted in Attempts was made, zero if the attempts were set by an administrator
+	LastAttempt time.Time `json:",omitempty"`
+	// Attempts made from endpoints other than the current one
+	EndpointAttempts map[string]AttemptCounter `json:",omitempty"`
+	// Failed attempts from every endpoint, the lockout applies to this as well as Attempts
+	TotalAttempts int `json:",omitempty"`
+
+	// Step up methods completed during the current session
+	StepUpFactors []string `json:",omitempty"`
+
+	// When the devices keys were last set, key rotation policies count from this
+	KeysRotated time.Time `json:",omitempty"`
+	// Keys issued to the device that it has not connected with yet, both these and the current keys are valid until it does or the grace period ends
+	PendingPublickey    string    `json:",omitempty"`
+	PendingPresharedKey string    `json:",omitempty"`
+	PendingSince        time.Time `json:",omitempty"`
 }
 
 func (d Device) String() string {
@@ -46,6 +64,11 @@ func UpdateDeviceEndpoint(address string, endpoint *net.UDPAddr) error {
 		return errors.New("device was not found")
 	}
 
+	lockout, err := GetLockout()
+	if err != nil {
+		return err
+	}
+
 	return doSafeUpdate(context.Background(), string(realKey.Kvs[0].Value), false, func(gr *clientv3.GetResponse) (string, error) {
 		if len(gr.Kvs) != 1 {
 			return "", errors.New("user device has multiple keys")
@@ -57,7 +80,9 @@ func UpdateDeviceEndpoint(address string, endpoint *net.UDPAddr) error {
 			return "", err
 		}
 
-		device.Endpoint = endpoint
+		if err := device.switchEndpoint(endpoint, lockout); err != nil {
+			return "", err
+		}
 
 		b, _ := json.Marshal(device)
 
@@ -87,7 +112,8 @@ func GetDevice(username, id string) (device Device, err error) {
 
 // Set device as authorized and clear authentication attempts
 func AuthoriseDevice(username, address string) error {
-	return doSafeUpdate(context.Background(), deviceKey(username, address), false, func(gr *clientv3.GetResponse) (string, error) {
+	var lastSeen time.Time
+	err := doSafeUpdate(context.Background(), deviceKey(username, address), false, func(gr *clientv3.GetResponse) (string, error) {
 		if len(gr.Kvs) != 1 {
 			return "", errors.New("user device has multiple keys")
 		}
@@ -108,13 +134,33 @@ func AuthoriseDevice(username, address string) error {
 			return "", errors.New("account is locked")
 		}
 
+		if u.Expired() {
+			return "", errors.New("account has expired")
+		}
+
+		lastSeen = u.LastSeen
+
 		device.Authorised = time.Now()
 		device.Attempts = 0
+		device.TotalAttempts = 0
+		device.LastAttempt = time.Time{}
+		device.StepUpFactors = nil
 
 		b, _ := json.Marshal(device)
 
 		return string(b), err
 	})
+	if err != nil {
+		return err
+	}
+
+	if time.Since(lastSeen) > lastSeenResolution {
+		if err := setUserLastSeen(username); err != nil {
+			log.Println("unable to update last seen time for", username, "err:", err)
+		}
+	}
+
+	return nil
 }
 
 func DeauthenticateDevice(address string) error {
@@ -140,6 +186,7 @@ func DeauthenticateDevice(address string) error {
 		}
 
 		device.Authorised = time.Time{}
+		device.StepUpFactors = nil
 
 		b, _ := json.Marshal(device)
 
@@ -160,6 +207,10 @@ func SetDeviceAuthenticationAttempts(username, address string, attempts int) err
 		}
 
 		device.Attempts = attempts
+		device.TotalAttempts = attempts
+		// Attempts set by an administrator are not unlocked by the lockout cooldown
+		device.LastAttempt = time.Time{}
+		device.EndpointAttempts = nil
 
 		b, _ := json.Marshal(device)
 
@@ -204,6 +255,7 @@ func AddDevice(username, publickey string) (Device, error) {
 		Publickey:    publickey,
 		Username:     username,
 		PresharedKey: preshared_key.String(),
+		KeysRotated:  time.Now(),
 	}
 
 	b, _ := json.Marshal(d)
@@ -229,6 +281,7 @@ func SetDevice(username, address, publickey, preshared_key string) (Device, erro
 		Publickey:    publickey,
 		Username:     username,
 		PresharedKey: preshared_key,
+		KeysRotated:  time.Now(),
 	}
 
 	b, _ := json.Marshal(d)
@@ -327,6 +380,10 @@ func UpdateDevicePublicKey(username, address string, publicKey wgtypes.Key) erro
 		}
 
 		device.Publickey = publicKey.String()
+		device.KeysRotated = time.Now()
+		device.PendingPublickey = ""
+		device.PendingPresharedKey = ""
+		device.PendingSince = time.Time{}
 
 		b, _ := json.Marshal(device)
 
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	eventMaxAttempts    = 6
+	eventInitialBackoff = 200 * time.Millisecond
+	eventMaxBackoff     = 10 * time.Second
+)
+
+// eventDispatcher applies the events for a key in the order etcd emitted them, while events for different keys are applied concurrently.
+// Handlers must be idempotent, as a failed event is retried and the same change may already have been partially applied
+type eventDispatcher struct {
+	ctx context.Context
+
+	mu      sync.Mutex
+	pending map[string][]func() error
+
+	initialBackoff time.Duration
+	maxAttempts    int
+}
+
+func newEventDispatcher(ctx context.Context) *eventDispatcher {
+	return &eventDispatcher{
+		ctx:            ctx,
+		pending:        map[string][]func() error{},
+		initialBackoff: eventInitialBackoff,
+		maxAttempts:    eventMaxAttempts,
+	}
+}
+
+// dispatch queues apply behind any events for key that have not been applied yet, onFailure is called if every attempt fails
+func (d *eventDispatcher) dispatch(key string, apply func() error, onFailure func(error)) {
+	work := func() error {
+		err := d.retry(apply)
+		if err != nil && onFailure != nil {
+			onFailure(err)
+		}
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queue, running := d.pending[key]
+	d.pending[key] = append(queue, work)
+	if running {
+		return
+	}
+
+	go d.drain(key)
+}
+
+func (d *eventDispatcher) drain(key string) {
+	for {
+		d.mu.Lock()
+		queue := d.pending[key]
+		if len(queue) == 0 {
+			delete(d.pending, key)
+			d.mu.Unlock()
+			return
+		}
+		next := queue[0]
+		d.pending[key] = queue[1:]
+		d.mu.Unlock()
+
+		// Failures have already been reported, later events for the key still need to be applied
+		_ = next()
+	}
+}
+
+func (d *eventDispatcher) retry(apply func() error) (err error) {
+	backoff := d.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err = apply()
+		if err == nil || attempt >= d.maxAttempts {
+			return err
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > eventMaxBackoff {
+			backoff = eventMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventDispatcherOrdering(t *testing.T) {
+	d := newEventDispatcher(context.Background())
+	d.initialBackoff = time.Millisecond
+
+	var (
+		mu      sync.Mutex
+		applied = map[string][]int{}
+		wg      sync.WaitGroup
+	)
+
+	for _, key := range []string{"devices-a", "devices-b"} {
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+
+			key, i := key, i
+			failed := false
+			d.dispatch(key, func() error {
+				// Fail the first attempt of some events, later events for the key must wait for the retry
+				if i%7 == 0 && !failed {
+					failed = true
+					return errors.New("transient")
+				}
+
+				mu.Lock()
+				applied[key] = append(applied[key], i)
+				mu.Unlock()
+
+				wg.Done()
+				return nil
+			}, func(err error) {
+				t.Error("event should not have failed: ", err)
+				wg.Done()
+			})
+		}
+	}
+
+	wg.Wait()
+
+	for key, order := range applied {
+		if len(order) != 50 {
+			t.Fatalf("%s: expected 50 events to be applied, got %d", key, len(order))
+		}
+
+		for i := range order {
+			if order[i] != i {
+				t.Fatalf("%s: events applied out of order: %v", key, order)
+			}
+		}
+	}
+}
+
+func TestEventDispatcherFailure(t *testing.T) {
+	d := newEventDispatcher(context.Background())
+	d.initialBackoff = time.Millisecond
+	d.maxAttempts = 3
+
+	attempts := 0
+	failed := make(chan error, 1)
+	d.dispatch("users-a", func() error {
+		attempts++
+		return fmt.Errorf("attempt %d", attempts)
+	}, func(err error) {
+		failed <- err
+	})
+
+	next := make(chan bool)
+	d.dispatch("users-a", func() error {
+		close(next)
+		return nil
+	}, nil)
+
+	select {
+	case err := <-failed:
+		if attempts != 3 || err.Error() != "attempt 3" {
+			t.Fatal("expected the last error after 3 attempts, got: ", attempts, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("failure was never reported")
+	}
+
+	select {
+	case <-next:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a failed event should not block later events for the same key")
+	}
+}
@@ -0,0 +1,927 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/encryption"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	EncryptionKeyringKey = "wag-encryption-keyring"
+
+	// Secrets are bound to the etcd key and field they are stored in, so a ciphertext cannot be moved to another record
+	encryptedSecretPrefix = "wag:enc:v2:"
+	// Secrets from before they were bound to their record, these are re-encrypted by the sweep on start
+	legacySecretPrefix = "wag:enc:v1:"
+
+	dataKeyAckInterval = 250 * time.Millisecond
+)
+
+// How long a rotation waits for every node to load the new data key before removing the old ones
+var dataKeyAckTimeout = 10 * time.Second
+
+var errSecretsCurrent = errors.New("secrets are already encrypted with the active key")
+
+// DataKey encrypts secrets, it is stored in etcd wrapped by the key encryption key named in KEK
+type DataKey struct {
+	ID      string
+	KEK     string
+	Wrapped []byte `json:",omitempty"`
+	Created time.Time
+
+	// Set on keys created after secrets were bound to their record, these never open legacy secrets
+	Bound bool `json:",omitempty"`
+}
+
+type encryptionKeyring struct {
+	Active string
+	Keys   []DataKey
+}
+
+type EncryptionStatus struct {
+	Enabled bool
+	KEK     string `json:",omitempty"`
+	Active  string `json:",omitempty"`
+	Keys    []DataKey
+
+	// Number of users, devices and administrators with secrets that are not encrypted with the active data key
+	Outdated int
+}
+
+// Unwrapped data keys, shared by every marshal and unmarshal of a model with secrets
+var secrets struct {
+	sync.RWMutex
+
+	kek      encryption.KeyEncryptionKey
+	previous []encryption.KeyEncryptionKey
+
+	active string
+	keys   map[string][]byte
+	bound  map[string]bool
+}
+
+func keyEncryptionKey(k config.EncryptionKey) (encryption.KeyEncryptionKey, error) {
+	switch {
+	case k.KeyFile != "":
+		return encryption.LoadKeyFile(k.KeyFile)
+	case k.KeyEnv != "":
+		return encryption.LoadKeyEnv(k.KeyEnv)
+	case k.KMS.Address != "":
+		return encryption.NewTransitKey(k.KMS.Address, k.KMS.Mount, k.KMS.KeyName, k.KMS.TokenFile, k.KMS.CACertificate)
+	}
+
+	return nil, errors.New("encryption key has no source")
+}
+
+// configureEncryption loads the key encryption keys from the config, data keys are loaded from etcd by loadKeyring
+func configureEncryption() error {
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	secrets.kek = nil
+	secrets.previous = nil
+	secrets.active = ""
+	secrets.keys = map[string][]byte{}
+	secrets.bound = map[string]bool{}
+
+	if !config.Values.Encryption.Configured() {
+		return nil
+	}
+
+	kek, err := keyEncryptionKey(config.Values.Encryption.EncryptionKey)
+	if err != nil {
+		return fmt.Errorf("unable to load encryption key: %w", err)
+	}
+	secrets.kek = kek
+
+	for i, previous := range config.Values.Encryption.PreviousKeys {
+		kek, err := keyEncryptionKey(previous)
+		if err != nil {
+			return fmt.Errorf("unable to load previous encryption key %d: %w", i, err)
+		}
+		secrets.previous = append(secrets.previous, kek)
+	}
+
+	return nil
+}
+
+func encryptionEnabled() bool {
+	secrets.RLock()
+	defer secrets.RUnlock()
+
+	return secrets.kek != nil
+}
+
+func getKeyring() (keyring encryptionKeyring, revision int64, err error) {
+	response, err := etcd.Get(context.Background(), EncryptionKeyringKey)
+	if err != nil {
+		return keyring, 0, err
+	}
+
+	if len(response.Kvs) == 0 {
+		return keyring, 0, nil
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &keyring)
+	return keyring, response.Kvs[0].ModRevision, err
+}
+
+// newDataKey creates a data key wrapped with the current key encryption key
+func newDataKey() (DataKey, []byte, error) {
+	key, err := encryption.NewDataKey()
+	if err != nil {
+		return DataKey{}, nil, err
+	}
+
+	id, err := generateRandomBytes(8)
+	if err != nil {
+		return DataKey{}, nil, err
+	}
+
+	secrets.RLock()
+	kek := secrets.kek
+	secrets.RUnlock()
+
+	wrapped, err := kek.Wrap(key)
+	if err != nil {
+		return DataKey{}, nil, fmt.Errorf("unable to wrap data key: %w", err)
+	}
+
+	return DataKey{ID: id, KEK: kek.ID(), Wrapped: wrapped, Created: time.Now(), Bound: true}, key, nil
+}
+
+// loadKeyring unwraps every data key in etcd, creating the first one if create is set.
+// Data keys wrapped by a previous key encryption key are re-wrapped with the current one
+func loadKeyring(create bool) error {
+	if !encryptionEnabled() {
+		keyring, _, err := getKeyring()
+		if err != nil {
+			return err
+		}
+
+		if len(keyring.Keys) > 0 {
+			return errors.New("secrets in etcd are encrypted but no Encryption key is configured")
+		}
+
+		return nil
+	}
+
+	keyring, revision, err := getKeyring()
+	if err != nil {
+		return err
+	}
+
+	if len(keyring.Keys) == 0 {
+		if !create {
+			return errors.New("encryption keyring has not been created yet")
+		}
+
+		dataKey, _, err := newDataKey()
+		if err != nil {
+			return err
+		}
+
+		keyring = encryptionKeyring{Active: dataKey.ID, Keys: []DataKey{dataKey}}
+		b, _ := json.Marshal(keyring)
+
+		// Another node may have created the keyring first, in which case use theirs
+		_, err = etcd.Txn(context.Background()).
+			If(clientv3.Compare(clientv3.CreateRevision(EncryptionKeyringKey), "=", 0)).
+			Then(clientv3.OpPut(EncryptionKeyringKey, string(b))).
+			Commit()
+		if err != nil {
+			return err
+		}
+
+		keyring, revision, err = getKeyring()
+		if err != nil {
+			return err
+		}
+	}
+
+	secrets.RLock()
+	keks := map[string]encryption.KeyEncryptionKey{secrets.kek.ID(): secrets.kek}
+	for _, kek := range secrets.previous {
+		keks[kek.ID()] = kek
+	}
+	current := secrets.kek
+	secrets.RUnlock()
+
+	keys := map[string][]byte{}
+	bound := map[string]bool{}
+	rewrapped := false
+	for i, dataKey := range keyring.Keys {
+		kek, ok := keks[dataKey.KEK]
+		if !ok {
+			return fmt.Errorf("data key %s is wrapped by %s which is not the current or a previous encryption key", dataKey.ID, dataKey.KEK)
+		}
+
+		key, err := kek.Unwrap(dataKey.Wrapped)
+		if err != nil {
+			return fmt.Errorf("unable to unwrap data key %s: %w", dataKey.ID, err)
+		}
+		keys[dataKey.ID] = key
+		bound[dataKey.ID] = dataKey.Bound
+
+		if kek != current {
+			keyring.Keys[i].Wrapped, err = current.Wrap(key)
+			if err != nil {
+				return fmt.Errorf("unable to re-wrap data key %s: %w", dataKey.ID, err)
+			}
+			keyring.Keys[i].KEK = current.ID()
+			rewrapped = true
+		}
+	}
+
+	if _, ok := keys[keyring.Active]; !ok {
+		return fmt.Errorf("active data key %s is not in the keyring", keyring.Active)
+	}
+
+	secrets.Lock()
+	secrets.active = keyring.Active
+	secrets.keys = keys
+	secrets.bound = bound
+	secrets.Unlock()
+
+	if err := acknowledgeDataKey(keyring.Active); err != nil {
+		return fmt.Errorf("unable to record active data key: %w", err)
+	}
+
+	if rewrapped {
+		b, _ := json.Marshal(keyring)
+		resp, err := etcd.Txn(context.Background()).
+			If(clientv3.Compare(clientv3.ModRevision(EncryptionKeyringKey), "=", revision)).
+			Then(clientv3.OpPut(EncryptionKeyringKey, string(b))).
+			Commit()
+		if err != nil {
+			return err
+		}
+
+		if resp.Succeeded {
+			log.Println("re-wrapped data keys with current encryption key", current.ID())
+		}
+	}
+
+	return nil
+}
+
+// watchKeyring picks up data keys created by rotations on other nodes, so this node encrypts with the same key as everyone else
+func watchKeyring() error {
+	if !encryptionEnabled() {
+		return nil
+	}
+
+	_, err := RegisterEventListener(EncryptionKeyringKey, false, func(_ string, _, _ encryptionKeyring, et EventType) error {
+		if et == DELETED {
+			return nil
+		}
+
+		return loadKeyring(false)
+	})
+	return err
+}
+
+// dataKeyAckKey is where a node records the data key it encrypts with, old data keys are only removed once every node has moved on from them
+func dataKeyAckKey(idHex string) string {
+	return path.Join(NodeEvents, idHex, "data-key")
+}
+
+func acknowledgeDataKey(active string) error {
+	_, err := etcd.Put(context.Background(), dataKeyAckKey(GetServerID()), active)
+	return err
+}
+
+// unacknowledgedNodes returns the voting members that have not loaded the active data key yet
+func unacknowledgedNodes(active string) (pending []string, err error) {
+	for _, member := range GetMembers() {
+		if member.IsLearner {
+			continue
+		}
+
+		response, err := etcd.Get(context.Background(), dataKeyAckKey(member.ID.String()))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(response.Kvs) == 0 || string(response.Kvs[0].Value) != active {
+			name := member.Name
+			if name == "" {
+				name = member.ID.String()
+			}
+			pending = append(pending, name)
+		}
+	}
+
+	return pending, nil
+}
+
+// secretAdditionalData binds a ciphertext to the data key, etcd key and field it was written with
+func secretAdditionalData(id, record, field string) []byte {
+	return []byte(id + "\x00" + record + "\x00" + field)
+}
+
+// parseSecret splits an encrypted secret into the id of the data key it was sealed with and the encoded ciphertext
+func parseSecret(value string) (id, encoded string, legacy, ok bool) {
+	prefix := encryptedSecretPrefix
+	if strings.HasPrefix(value, legacySecretPrefix) {
+		prefix, legacy = legacySecretPrefix, true
+	} else if !strings.HasPrefix(value, encryptedSecretPrefix) {
+		return "", "", false, false
+	}
+
+	id, encoded, ok = strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	return id, encoded, legacy, ok
+}
+
+func encryptSecret(plaintext, record, field string) (string, error) {
+	if plaintext == "" || !encryptionEnabled() {
+		return plaintext, nil
+	}
+
+	secrets.RLock()
+	id, key := secrets.active, secrets.keys[secrets.active]
+	secrets.RUnlock()
+
+	if key == nil {
+		// Learners do not load the keyring on start, as they cannot read from etcd until they are promoted
+		if err := loadKeyring(false); err != nil {
+			return "", fmt.Errorf("unable to load encryption keyring: %w", err)
+		}
+
+		secrets.RLock()
+		id, key = secrets.active, secrets.keys[secrets.active]
+		secrets.RUnlock()
+	}
+
+	sealed, err := encryption.Seal(key, []byte(plaintext), secretAdditionalData(id, record, field))
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedSecretPrefix + id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(value, record, field string) (string, error) {
+	// Secrets written before encryption was enabled are read as is, and encrypted the next time they are written.
+	// Processes without a key (e.g the cli) are given the ciphertext
+	if (!strings.HasPrefix(value, encryptedSecretPrefix) && !strings.HasPrefix(value, legacySecretPrefix)) || !encryptionEnabled() {
+		return value, nil
+	}
+
+	id, encoded, legacy, ok := parseSecret(value)
+	if !ok {
+		return "", errors.New("encrypted secret is malformed")
+	}
+
+	secrets.RLock()
+	key, bound := secrets.keys[id], secrets.bound[id]
+	secrets.RUnlock()
+
+	if key == nil {
+		// The key may have been created by another node and not have been seen by this one yet
+		if err := loadKeyring(false); err != nil {
+			return "", fmt.Errorf("unable to load encryption keyring: %w", err)
+		}
+
+		secrets.RLock()
+		key, bound = secrets.keys[id], secrets.bound[id]
+		secrets.RUnlock()
+
+		if key == nil {
+			return "", fmt.Errorf("secret is encrypted with unknown data key %s", id)
+		}
+	}
+
+	additionalData := secretAdditionalData(id, record, field)
+	if legacy {
+		if bound {
+			return "", fmt.Errorf("secret in %s is not bound to its record, but data key %s only encrypts bound secrets", record, id)
+		}
+		additionalData = []byte(id)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("encrypted secret is malformed: %w", err)
+	}
+
+	plaintext, err := encryption.Open(key, sealed, additionalData)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt %s of %s with data key %s: %w", field, record, id, err)
+	}
+
+	return string(plaintext), nil
+}
+
+func (um UserModel) MarshalJSON() ([]byte, error) {
+	type stored UserModel
+
+	record := UsersPrefix + um.Username + "-"
+
+	s := stored(um)
+	mfa, err := encryptSecret(um.Mfa, record, "Mfa")
+	if err != nil {
+		return nil, err
+	}
+	s.Mfa = mfa
+
+	// Step up secrets are encrypted here rather than by the factor, as the factor does not know which user it belongs to
+	if um.StepUp != nil {
+		s.StepUp = make(map[string]StepUpFactor, len(um.StepUp))
+		for method, factor := range um.StepUp {
+			factor.Mfa, err = encryptSecret(factor.Mfa, record, "StepUp."+method+".Mfa")
+			if err != nil {
+				return nil, err
+			}
+			s.StepUp[method] = factor
+		}
+	}
+
+	return json.Marshal(s)
+}
+
+func (um *UserModel) UnmarshalJSON(b []byte) error {
+	type stored UserModel
+
+	var s stored
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	record := UsersPrefix + s.Username + "-"
+
+	mfa, err := decryptSecret(s.Mfa, record, "Mfa")
+	if err != nil {
+		return err
+	}
+	s.Mfa = mfa
+
+	for method, factor := range s.StepUp {
+		factor.Mfa, err = decryptSecret(factor.Mfa, record, "StepUp."+method+".Mfa")
+		if err != nil {
+			return err
+		}
+		s.StepUp[method] = factor
+	}
+
+	*um = UserModel(s)
+	return nil
+}
+
+func (a admin) MarshalJSON() ([]byte, error) {
+	type stored admin
+
+	s := stored(a)
+	mfa, err := encryptSecret(a.Mfa, adminUsersPrefix+a.Username, "Mfa")
+	if err != nil {
+		return nil, err
+	}
+	s.Mfa = mfa
+
+	return json.Marshal(s)
+}
+
+func (a *admin) UnmarshalJSON(b []byte) error {
+	type stored admin
+
+	var s stored
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	mfa, err := decryptSecret(s.Mfa, adminUsersPrefix+s.Username, "Mfa")
+	if err != nil {
+		return err
+	}
+	s.Mfa = mfa
+
+	*a = admin(s)
+	return nil
+}
+
+func (d Device) MarshalJSON() ([]byte, error) {
+	type stored Device
+
+	record := deviceKey(d.Username, d.Address)
+
+	s := stored(d)
+	psk, err := encryptSecret(d.PresharedKey, record, "PresharedKey")
+	if err != nil {
+		return nil, err
+	}
+	s.PresharedKey = psk
+
+	pending, err := encryptSecret(d.PendingPresharedKey, record, "PendingPresharedKey")
+	if err != nil {
+		return nil, err
+	}
+	s.PendingPresharedKey = pending
+
+	return json.Marshal(s)
+}
+
+func (d *Device) UnmarshalJSON(b []byte) error {
+	type stored Device
+
+	var s stored
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	record := deviceKey(s.Username, s.Address)
+
+	psk, err := decryptSecret(s.PresharedKey, record, "PresharedKey")
+	if err != nil {
+		return err
+	}
+	s.PresharedKey = psk
+
+	pending, err := decryptSecret(s.PendingPresharedKey, record, "PendingPresharedKey")
+	if err != nil {
+		return err
+	}
+	s.PendingPresharedKey = pending
+
+	*d = Device(s)
+	return nil
+}
+
+func (a ACMEAccount) MarshalJSON() ([]byte, error) {
+	type stored ACMEAccount
+
+	s := stored(a)
+	key, err := encryptSecret(a.PrivateKey, ACMEAccountKey, "PrivateKey")
+	if err != nil {
+		return nil, err
+	}
+	s.PrivateKey = key
+
+	return json.Marshal(s)
+}
+
+func (a *ACMEAccount) UnmarshalJSON(b []byte) error {
+	type stored ACMEAccount
+
+	var s stored
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	key, err := decryptSecret(s.PrivateKey, ACMEAccountKey, "PrivateKey")
+	if err != nil {
+		return err
+	}
+	s.PrivateKey = key
+
+	*a = ACMEAccount(s)
+	return nil
+}
+
+func (c ACMECertificate) MarshalJSON() ([]byte, error) {
+	type stored ACMECertificate
+
+	s := stored(c)
+	key, err := encryptSecret(c.PrivateKey, ACMECertificatesPrefix+c.Domain, "PrivateKey")
+	if err != nil {
+		return nil, err
+	}
+	s.PrivateKey = key
+
+	return json.Marshal(s)
+}
+
+func (c *ACMECertificate) UnmarshalJSON(b []byte) error {
+	type stored ACMECertificate
+
+	var s stored
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	key, err := decryptSecret(s.PrivateKey, ACMECertificatesPrefix+s.Domain, "PrivateKey")
+	if err != nil {
+		return err
+	}
+	s.PrivateKey = key
+
+	*c = ACMECertificate(s)
+	return nil
+}
+
+// secretPrefixes are the keys of every model with secrets
+var secretPrefixes = []string{UsersPrefix, DevicesPrefix, adminUsersPrefix, ACMEAccountKey, ACMECertificatesPrefix}
+
+// storedSecrets is the raw form of every secret field in users, devices, administrators and ACME state, it is used to check which key they are encrypted with without decrypting them
+type storedSecrets struct {
+	Mfa                 string
+	PresharedKey        string
+	PendingPresharedKey string
+	PrivateKey          string
+	StepUp              map[string]struct {
+		Mfa string
+	}
+}
+
+func (s storedSecrets) values() []string {
+	values := []string{s.Mfa, s.PresharedKey, s.PendingPresharedKey, s.PrivateKey}
+	for _, factor := range s.StepUp {
+		values = append(values, factor.Mfa)
+	}
+
+	return values
+}
+
+func (s storedSecrets) current(active string) bool {
+	for _, value := range s.values() {
+		if value != "" && !strings.HasPrefix(value, encryptedSecretPrefix+active+":") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func secretsCurrent(value []byte, active string) (bool, error) {
+	var s storedSecrets
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, err
+	}
+
+	return s.current(active), nil
+}
+
+// ReencryptSecrets encrypts every user, device, administrator and ACME secret that is in plaintext, or encrypted with a data key that is no longer active
+func ReencryptSecrets() (updated int, err error) {
+	if !encryptionEnabled() {
+		return 0, errors.New("encryption is not configured")
+	}
+
+	secrets.RLock()
+	active := secrets.active
+	secrets.RUnlock()
+
+	for _, prefix := range secretPrefixes {
+		response, err := etcd.Get(context.Background(), prefix, clientv3.WithPrefix())
+		if err != nil {
+			return updated, err
+		}
+
+		for _, kv := range response.Kvs {
+			if current, err := secretsCurrent(kv.Value, active); err != nil || current {
+				continue
+			}
+
+			err := doSafeUpdate(context.Background(), string(kv.Key), false, func(gr *clientv3.GetResponse) (string, error) {
+				if current, err := secretsCurrent(gr.Kvs[0].Value, active); err != nil || current {
+					return "", errSecretsCurrent
+				}
+
+				var (
+					b   []byte
+					err error
+				)
+				switch prefix {
+				case UsersPrefix:
+					var user UserModel
+					if err = json.Unmarshal(gr.Kvs[0].Value, &user); err != nil {
+						return "", err
+					}
+					b, err = json.Marshal(user)
+				case adminUsersPrefix:
+					var a admin
+					if err = json.Unmarshal(gr.Kvs[0].Value, &a); err != nil {
+						return "", err
+					}
+					b, err = json.Marshal(a)
+				case ACMEAccountKey:
+					var account ACMEAccount
+					if err = json.Unmarshal(gr.Kvs[0].Value, &account); err != nil {
+						return "", err
+					}
+					b, err = json.Marshal(account)
+				case ACMECertificatesPrefix:
+					var certificate ACMECertificate
+					if err = json.Unmarshal(gr.Kvs[0].Value, &certificate); err != nil {
+						return "", err
+					}
+					b, err = json.Marshal(certificate)
+				default:
+					var device Device
+					if err = json.Unmarshal(gr.Kvs[0].Value, &device); err != nil {
+						return "", err
+					}
+					b, err = json.Marshal(device)
+				}
+
+				return string(b), err
+			})
+			if errors.Is(err, errSecretsCurrent) {
+				continue
+			}
+
+			if err != nil {
+				return updated, fmt.Errorf("unable to re-encrypt %s: %w", kv.Key, err)
+			}
+
+			updated++
+		}
+	}
+
+	return updated, nil
+}
+
+// RotateDataKey makes a new data key active, re-encrypts all secrets with it and then removes data keys that are no longer used
+func RotateDataKey() (EncryptionStatus, error) {
+	if !encryptionEnabled() {
+		return EncryptionStatus{}, errors.New("encryption is not configured")
+	}
+
+	dataKey, key, err := newDataKey()
+	if err != nil {
+		return EncryptionStatus{}, err
+	}
+
+	err = doSafeUpdate(context.Background(), EncryptionKeyringKey, false, func(gr *clientv3.GetResponse) (string, error) {
+		var keyring encryptionKeyring
+		if err := json.Unmarshal(gr.Kvs[0].Value, &keyring); err != nil {
+			return "", err
+		}
+
+		keyring.Active = dataKey.ID
+		keyring.Keys = append(keyring.Keys, dataKey)
+
+		b, _ := json.Marshal(keyring)
+		return string(b), nil
+	})
+	if err != nil {
+		return EncryptionStatus{}, fmt.Errorf("unable to add data key: %w", err)
+	}
+
+	secrets.Lock()
+	secrets.keys[dataKey.ID] = key
+	secrets.bound[dataKey.ID] = dataKey.Bound
+	secrets.active = dataKey.ID
+	secrets.Unlock()
+
+	if err := acknowledgeDataKey(dataKey.ID); err != nil {
+		return EncryptionStatus{}, fmt.Errorf("unable to record active data key: %w", err)
+	}
+
+	updated, err := ReencryptSecrets()
+	if err != nil {
+		return EncryptionStatus{}, err
+	}
+
+	log.Printf("rotated data key to %s, re-encrypted %d users and devices", dataKey.ID, updated)
+
+	if err := pruneDataKeys(dataKey.ID); err != nil {
+		// The rotation itself succeeded, the retired keys are removed by the next one
+		log.Println("unable to remove retired data keys: ", err)
+	}
+
+	return GetEncryptionStatus()
+}
+
+// pruneDataKeys removes inactive data keys that no secret is encrypted with.
+// Nodes that have not seen the new keyring yet still encrypt with the previous key, so nothing is removed until every node has loaded the active key
+func pruneDataKeys(active string) error {
+	deadline := time.Now().Add(dataKeyAckTimeout)
+	for {
+		pending, err := unacknowledgedNodes(active)
+		if err != nil {
+			return err
+		}
+
+		if len(pending) == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			log.Printf("keeping retired data keys until %s load data key %s, they are removed by the next rotation", strings.Join(pending, ", "), active)
+			return nil
+		}
+
+		time.Sleep(dataKeyAckInterval)
+	}
+
+	for i := 0; i < 5; i++ {
+		done, err := tryPruneDataKeys(active)
+		if err != nil || done {
+			return err
+		}
+	}
+
+	return errors.New("secrets kept changing while checking which data keys are in use")
+}
+
+// tryPruneDataKeys removes unused data keys if no secret has been written since they were checked, and reports whether it is finished
+func tryPruneDataKeys(active string) (bool, error) {
+	keyring, revision, err := getKeyring()
+	if err != nil {
+		return false, err
+	}
+
+	if keyring.Active != active {
+		// Another rotation has happened since, which removes these keys itself
+		return true, nil
+	}
+
+	unchanged := []clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(EncryptionKeyringKey), "=", revision)}
+
+	used := map[string]bool{}
+	for _, prefix := range secretPrefixes {
+		response, err := etcd.Get(context.Background(), prefix, clientv3.WithPrefix())
+		if err != nil {
+			return false, err
+		}
+
+		// A secret written after this read may use any of the keys, so the keys are only removed if there are none
+		unchanged = append(unchanged, clientv3.Compare(clientv3.ModRevision(prefix), "<", response.Header.Revision+1).WithPrefix())
+
+		for _, kv := range response.Kvs {
+			var s storedSecrets
+			if err := json.Unmarshal(kv.Value, &s); err != nil {
+				return false, err
+			}
+
+			for _, value := range s.values() {
+				if id, _, _, ok := parseSecret(value); ok {
+					used[id] = true
+				}
+			}
+		}
+	}
+
+	kept := []DataKey{}
+	for _, dataKey := range keyring.Keys {
+		if dataKey.ID == keyring.Active || used[dataKey.ID] {
+			kept = append(kept, dataKey)
+		}
+	}
+
+	if len(kept) == len(keyring.Keys) {
+		return true, nil
+	}
+	keyring.Keys = kept
+
+	b, _ := json.Marshal(keyring)
+	response, err := etcd.Txn(context.Background()).
+		If(unchanged...).
+		Then(clientv3.OpPut(EncryptionKeyringKey, string(b))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return response.Succeeded, nil
+}
+
+func GetEncryptionStatus() (status EncryptionStatus, err error) {
+	keyring, _, err := getKeyring()
+	if err != nil {
+		return status, err
+	}
+
+	secrets.RLock()
+	status.Enabled = secrets.kek != nil
+	if status.Enabled {
+		status.KEK = secrets.kek.ID()
+	}
+	secrets.RUnlock()
+
+	status.Active = keyring.Active
+	status.Keys = keyring.Keys
+	for i := range status.Keys {
+		status.Keys[i].Wrapped = nil
+	}
+
+	if !status.Enabled {
+		return status, nil
+	}
+
+	for _, prefix := range secretPrefixes {
+		response, err := etcd.Get(context.Background(), prefix, clientv3.WithPrefix())
+		if err != nil {
+			return status, err
+		}
+
+		for _, kv := range response.Kvs {
+			if current, err := secretsCurrent(kv.Value, keyring.Active); err == nil && !current {
+				status.Outdated++
+			}
+		}
+	}
+
+	return status, nil
+}
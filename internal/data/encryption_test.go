@@ -0,0 +1,459 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/encryption"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func writeTestKey(t *testing.T) string {
+	key, err := encryption.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func rawValue(t *testing.T, key string) string {
+	response, err := etcd.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Kvs) != 1 {
+		t.Fatal("key not found: ", key)
+	}
+
+	return string(response.Kvs[0].Value)
+}
+
+func enableTestEncryption(t *testing.T, current string, previous ...string) {
+	config.Values.Encryption.KeyFile = current
+	config.Values.Encryption.PreviousKeys = nil
+	for _, path := range previous {
+		config.Values.Encryption.PreviousKeys = append(config.Values.Encryption.PreviousKeys, config.EncryptionKey{KeyFile: path})
+	}
+
+	if err := configureEncryption(); err != nil {
+		t.Fatal("unable to configure encryption: ", err)
+	}
+
+	if err := loadKeyring(true); err != nil {
+		t.Fatal("unable to load keyring: ", err)
+	}
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+	t.Cleanup(func() {
+		DeleteUser("enc_plain")
+		DeleteUser("enc_user")
+		disableTestEncryption(t)
+	})
+
+	// Written before encryption is turned on
+	if _, err := CreateUserDataAccount("enc_plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetUserMfa("enc_plain", secret, "totp"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(rawValue(t, "users-enc_plain-"), secret) {
+		t.Fatal("secret should be stored in plaintext when encryption is not configured")
+	}
+
+	firstKey := writeTestKey(t)
+	enableTestEncryption(t, firstKey)
+
+	if _, err := CreateUserDataAccount("enc_user"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetUserMfa("enc_user", secret, "totp"); err != nil {
+		t.Fatal(err)
+	}
+
+	device, err := AddDevice("enc_user", "6mOh0G6KRFa2kCzMvwcAvUIk0ZK0c7nJJ3JQ3amD2iY=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := rawValue(t, "users-enc_user-")
+	if strings.Contains(stored, secret) || !strings.Contains(stored, encryptedSecretPrefix) {
+		t.Fatal("mfa secret was not encrypted: ", stored)
+	}
+
+	storedDevice := rawValue(t, deviceKey("enc_user", device.Address))
+	if strings.Contains(storedDevice, device.PresharedKey) {
+		t.Fatal("preshared key was not encrypted: ", storedDevice)
+	}
+
+	user, err := GetUserData("enc_user")
+	if err != nil || user.Mfa != secret {
+		t.Fatal("encrypted secret was not transparently decrypted: ", err)
+	}
+
+	readDevice, err := GetDevice("enc_user", device.Address)
+	if err != nil || readDevice.PresharedKey != device.PresharedKey {
+		t.Fatal("preshared key was not transparently decrypted: ", err)
+	}
+
+	// Plaintext from before encryption was enabled is still readable, and is encrypted by the sweep
+	user, err = GetUserData("enc_plain")
+	if err != nil || user.Mfa != secret {
+		t.Fatal("plaintext secret was not readable: ", err)
+	}
+
+	if _, err := ReencryptSecrets(); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(rawValue(t, "users-enc_plain-"), secret) {
+		t.Fatal("plaintext secret was not encrypted by the sweep")
+	}
+
+	before, err := GetEncryptionStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if before.Outdated != 0 {
+		t.Fatal("expected every secret to be encrypted with the active key, outdated: ", before.Outdated)
+	}
+
+	after, err := RotateDataKey()
+	if err != nil {
+		t.Fatal("unable to rotate data key: ", err)
+	}
+
+	if after.Active == before.Active || len(after.Keys) != 1 || after.Outdated != 0 {
+		t.Fatalf("rotation did not re-encrypt and prune: %+v", after)
+	}
+
+	if !strings.Contains(rawValue(t, "users-enc_user-"), encryptedSecretPrefix+after.Active+":") {
+		t.Fatal("secret was not re-encrypted with the new data key")
+	}
+
+	// Rotate the key encryption key, the old one is only needed to unwrap the data keys once
+	secondKey := writeTestKey(t)
+	enableTestEncryption(t, secondKey, firstKey)
+
+	keyring, _, err := getKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secrets.RLock()
+	currentKEK := secrets.kek.ID()
+	secrets.RUnlock()
+
+	for _, dataKey := range keyring.Keys {
+		if dataKey.KEK != currentKEK {
+			t.Fatal("data key was not re-wrapped with the new key encryption key")
+		}
+	}
+
+	enableTestEncryption(t, secondKey)
+	user, err = GetUserData("enc_user")
+	if err != nil || user.Mfa != secret {
+		t.Fatal("secret was not readable after key encryption key rotation: ", err)
+	}
+
+	config.Values.Encryption.KeyFile = writeTestKey(t)
+	configureEncryption()
+	if err := loadKeyring(false); err == nil {
+		t.Fatal("an unrelated key should not be able to load the keyring")
+	}
+
+	config.Values.Encryption.KeyFile = ""
+	configureEncryption()
+	if err := loadKeyring(false); err == nil {
+		t.Fatal("starting without a key when secrets are encrypted should fail")
+	}
+
+	// So the secrets can be decrypted again on clean up
+	enableTestEncryption(t, secondKey)
+}
+
+func decryptedRecords[T any](t *testing.T, prefix string) map[string]T {
+	response, err := etcd.Get(context.Background(), prefix, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := map[string]T{}
+	for _, kv := range response.Kvs {
+		var record T
+		if err := json.Unmarshal(kv.Value, &record); err != nil {
+			t.Fatal("unable to decrypt ", string(kv.Key), ": ", err)
+		}
+		records[string(kv.Key)] = record
+	}
+
+	return records
+}
+
+func putRecords[T any](t *testing.T, records map[string]T) {
+	for key, record := range records {
+		b, err := json.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := etcd.Put(context.Background(), key, string(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// disableTestEncryption turns encryption off, writing back in plaintext every secret the sweeps encrypted so other tests can still read them
+func disableTestEncryption(t *testing.T) {
+	users := decryptedRecords[UserModel](t, UsersPrefix)
+	devices := decryptedRecords[Device](t, DevicesPrefix)
+	admins := decryptedRecords[admin](t, adminUsersPrefix)
+	accounts := decryptedRecords[ACMEAccount](t, ACMEAccountKey)
+	certificates := decryptedRecords[ACMECertificate](t, ACMECertificatesPrefix)
+
+	config.Values.Encryption.KeyFile = ""
+	config.Values.Encryption.PreviousKeys = nil
+	if err := configureEncryption(); err != nil {
+		t.Fatal(err)
+	}
+
+	putRecords(t, users)
+	putRecords(t, devices)
+	putRecords(t, admins)
+	putRecords(t, accounts)
+	putRecords(t, certificates)
+
+	etcd.Delete(context.Background(), EncryptionKeyringKey)
+}
+
+func TestEncryptedSecretsBoundToRecord(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+	t.Cleanup(func() {
+		DeleteUser("bound_a")
+		DeleteUser("bound_b")
+		disableTestEncryption(t)
+	})
+
+	enableTestEncryption(t, writeTestKey(t))
+
+	for _, username := range []string{"bound_a", "bound_b"} {
+		if _, err := CreateUserDataAccount(username); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := SetUserMfa(username, secret+username, "totp"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var a, b map[string]any
+	json.Unmarshal([]byte(rawValue(t, "users-bound_a-")), &a)
+	json.Unmarshal([]byte(rawValue(t, "users-bound_b-")), &b)
+
+	// Moving the ciphertext of one user to another must not give the second user the first users secret
+	b["Mfa"] = a["Mfa"]
+	swapped, _ := json.Marshal(b)
+	if _, err := etcd.Put(context.Background(), "users-bound_b-", string(swapped)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetUserData("bound_b"); err == nil {
+		t.Fatal("a secret moved from another record should not decrypt")
+	}
+
+	user, err := GetUserData("bound_a")
+	if err != nil || user.Mfa != secret+"bound_a" {
+		t.Fatal("secret in its own record should still decrypt: ", err)
+	}
+
+	// The same within a record, between fields
+	device, err := AddDevice("bound_a", "6mOh0G6KRFa2kCzMvwcAvUIk0ZK0c7nJJ3JQ3amD2iY=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d map[string]any
+	json.Unmarshal([]byte(rawValue(t, deviceKey("bound_a", device.Address))), &d)
+	d["PendingPresharedKey"] = d["PresharedKey"]
+	swapped, _ = json.Marshal(d)
+	if _, err := etcd.Put(context.Background(), deviceKey("bound_a", device.Address), string(swapped)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetDevice("bound_a", device.Address); err == nil {
+		t.Fatal("a secret moved to another field should not decrypt")
+	}
+}
+
+func TestLegacySecrets(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+	t.Cleanup(func() {
+		DeleteUser("legacy_user")
+		disableTestEncryption(t)
+	})
+
+	enableTestEncryption(t, writeTestKey(t))
+
+	if _, err := CreateUserDataAccount("legacy_user"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Secrets written before they were bound to their record only have the data key id as additional data
+	writeLegacy := func() {
+		secrets.RLock()
+		id, key := secrets.active, secrets.keys[secrets.active]
+		secrets.RUnlock()
+
+		sealed, err := encryption.Seal(key, []byte(secret), []byte(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var u map[string]any
+		json.Unmarshal([]byte(rawValue(t, "users-legacy_user-")), &u)
+		u["Mfa"] = legacySecretPrefix + id + ":" + base64.RawStdEncoding.EncodeToString(sealed)
+		b, _ := json.Marshal(u)
+		if _, err := etcd.Put(context.Background(), "users-legacy_user-", string(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeLegacy()
+	if _, err := GetUserData("legacy_user"); err == nil {
+		t.Fatal("data keys created after secrets were bound should not open legacy secrets")
+	}
+
+	// A keyring from before secrets were bound
+	keyring, _, err := getKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range keyring.Keys {
+		keyring.Keys[i].Bound = false
+	}
+
+	b, _ := json.Marshal(keyring)
+	if _, err := etcd.Put(context.Background(), EncryptionKeyringKey, string(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadKeyring(false); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := GetUserData("legacy_user")
+	if err != nil || user.Mfa != secret {
+		t.Fatal("legacy secrets should be readable with the data key that wrote them: ", err)
+	}
+
+	status, err := GetEncryptionStatus()
+	if err != nil || status.Outdated == 0 {
+		t.Fatal("legacy secrets should need to be re-encrypted: ", status, err)
+	}
+
+	if _, err := ReencryptSecrets(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(rawValue(t, "users-legacy_user-"), encryptedSecretPrefix) {
+		t.Fatal("legacy secret was not re-encrypted bound to its record")
+	}
+
+	user, err = GetUserData("legacy_user")
+	if err != nil || user.Mfa != secret {
+		t.Fatal("re-encrypted secret was not readable: ", err)
+	}
+}
+
+func TestDataKeysKeptUntilAcknowledged(t *testing.T) {
+	previousTimeout := dataKeyAckTimeout
+	dataKeyAckTimeout = 500 * time.Millisecond
+
+	t.Cleanup(func() {
+		dataKeyAckTimeout = previousTimeout
+		disableTestEncryption(t)
+	})
+
+	enableTestEncryption(t, writeTestKey(t))
+
+	status, err := RotateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pending, err := unacknowledgedNodes(status.Active); err != nil || len(pending) != 0 {
+		t.Fatal("this node should have acknowledged the new data key: ", pending, err)
+	}
+
+	// A retired key that nothing uses, as left by a rotation on another node
+	retired, _, err := newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, _, err := getKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring.Keys = append(keyring.Keys, retired)
+
+	b, _ := json.Marshal(keyring)
+	if _, err := etcd.Put(context.Background(), EncryptionKeyringKey, string(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Let the keyring watch of this node settle before pretending it is behind
+	time.Sleep(time.Second)
+
+	if _, err := etcd.Put(context.Background(), dataKeyAckKey(GetServerID()), retired.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending, err := unacknowledgedNodes(status.Active); err != nil || len(pending) != 1 {
+		t.Fatal("a node still on an old data key should be pending: ", pending, err)
+	}
+
+	if err := pruneDataKeys(status.Active); err != nil {
+		t.Fatal(err)
+	}
+
+	if keyring, _, _ := getKeyring(); len(keyring.Keys) != 2 {
+		t.Fatal("retired keys should be kept while a node may still encrypt with them: ", keyring.Keys)
+	}
+
+	if err := acknowledgeDataKey(status.Active); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneDataKeys(status.Active); err != nil {
+		t.Fatal(err)
+	}
+
+	if keyring, _, _ := getKeyring(); len(keyring.Keys) != 1 || keyring.Keys[0].ID != status.Active {
+		t.Fatal("retired keys should be removed once every node has loaded the active key: ", keyring.Keys)
+	}
+}
@@ -9,8 +9,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/metrics"
 	"github.com/NHAS/wag/pkg/queue"
-	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
@@ -59,6 +60,14 @@ var (
 )
 
 func RegisterEventListener[T any](path string, isPrefix bool, f func(key string, current, previous T, et EventType) error) (string, error) {
+	return RegisterRevisionedEventListener(path, isPrefix, func(key string, _ int64, current, previous T, et EventType) error {
+		return f(key, current, previous, et)
+	})
+}
+
+// RegisterRevisionedEventListener is the same as RegisterEventListener, but also passes the etcd revision that the change was made in.
+// Every node sees the same key and revision for a change, so together they identify an event across the cluster
+func RegisterRevisionedEventListener[T any](path string, isPrefix bool, f func(key string, revision int64, current, previous T, et EventType) error) (string, error) {
 
 	options := []clientv3.OpOption{
 		clientv3.WithPrevKV(),
@@ -78,6 +87,8 @@ func RegisterEventListener[T any](path string, isPrefix bool, f func(key string,
 	contextMaps[key] = cancel
 	lck.Unlock()
 
+	dispatcher := newEventDispatcher(ctx)
+
 	wc := etcd.Watch(ctx, path, options...)
 	go func(wc clientv3.WatchChan) {
 		defer cancel()
@@ -112,20 +123,17 @@ func RegisterEventListener[T any](path string, isPrefix bool, f func(key string,
 					}
 				}
 
-				go func(key []byte, prevKv *mvccpb.KeyValue) {
-					if err := f(string(key), currentValue, previousValue, state); err != nil {
-						log.Println("applying event failed: ", state, currentValue, "err:", err)
-						err = RaiseError(err, value)
-						if err != nil {
-							log.Println("failed to raise error with cluster: ", err)
-							return
-						}
-						return
+				eventKey, revision, prevKv := string(event.Kv.Key), event.Kv.ModRevision, event.PrevKv
+
+				// Events for the same key are applied in order, so a modification followed by a delete cannot be applied the wrong way around
+				dispatcher.dispatch(eventKey, func() error {
+					if err := f(eventKey, revision, currentValue, previousValue, state); err != nil {
+						return err
 					}
 
 					switch state {
 					case DELETED, CREATED:
-						EventsQueue.Write([]byte(fmt.Sprintf("%s[%s]", key, state)))
+						EventsQueue.Write([]byte(fmt.Sprintf("%s[%s]", eventKey, state)))
 
 					case MODIFIED:
 
@@ -134,11 +142,19 @@ func RegisterEventListener[T any](path string, isPrefix bool, f func(key string,
 							previous = string(prevKv.Value)
 						}
 
-						EventsQueue.Write([]byte(fmt.Sprintf("%s[%s]: %s -> %s", key, state, previous, string(value))))
+						EventsQueue.Write([]byte(fmt.Sprintf("%s[%s]: %s -> %s", eventKey, state, previous, string(value))))
 
 					}
 
-				}(event.Kv.Key, event.PrevKv)
+					return nil
+				}, func(err error) {
+					log.Println("applying event failed: ", state, currentValue, "err:", err)
+					metrics.EventFailures.Inc()
+					err = RaiseError(err, value)
+					if err != nil {
+						log.Println("failed to raise error with cluster: ", err)
+					}
+				})
 
 			}
 		}
@@ -194,6 +210,11 @@ func checkClusterHealth() {
 	leaderMonitor := time.NewTicker(1 * time.Second)
 	go func() {
 		for range leaderMonitor.C {
+			// An external cluster reports its own health, we only find out if it is unusable when writing to it fails
+			if config.Values.Clustering.IsExternal() {
+				continue
+			}
+
 			if etcdServer.Server.Leader() == 0 {
 
 				notifyClusterHealthListeners("electing")
@@ -210,7 +231,7 @@ func checkClusterHealth() {
 	go func() {
 		for range clusterMonitor.C {
 			// If we're a learner we cant write to the cluster, so just wait until we're promoted
-			if !etcdServer.Server.IsLearner() {
+			if !IsLearner() {
 				testCluster()
 			}
 		}
@@ -239,7 +260,7 @@ func testCluster() {
 }
 
 func notifyHealthy() {
-	if etcdServer.Server.IsLearner() {
+	if IsLearner() {
 		notifyClusterHealthListeners("learner")
 	} else {
 		notifyClusterHealthListeners("healthy")
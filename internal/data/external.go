@@ -0,0 +1,267 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.etcd.io/etcd/client/v3/namespace"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/membership"
+)
+
+const (
+	electionPrefix = "wag/election/"
+
+	// wag apply writes every policy, group and membership change in one transaction, far more operations than the etcd default of 128
+	maxTxnOps = 4096
+
+	maxTxnOpsProbeKey = "wag/max-txn-ops"
+)
+
+var errExternalCluster = errors.New("cluster membership is managed by the external etcd cluster, start new wag nodes with the same clustering config instead")
+
+// With an external cluster there is no raft leader that belongs to wag, so nodes elect one amongst themselves for leader only work
+var election struct {
+	sync.Mutex
+	cancel context.CancelFunc
+	resign chan struct{}
+}
+
+// externalNodeID derives a stable id from the node name, as a client of an external cluster has no etcd member id
+func externalNodeID() types.ID {
+	hash := sha256.Sum256([]byte(config.Values.Clustering.Name))
+	return types.ID(binary.BigEndian.Uint64(hash[:8]))
+}
+
+// connectExternalEtcd connects to the configured etcd cluster, every key wag uses is transparently placed under the configured prefix
+func connectExternalEtcd() error {
+	external := config.Values.Clustering.External
+
+	clientConfig := clientv3.Config{
+		Endpoints:   external.Endpoints,
+		DialTimeout: 10 * time.Second,
+		Username:    external.Username,
+	}
+
+	if external.PasswordFile != "" {
+		password, err := os.ReadFile(external.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("unable to read external etcd password file: %w", err)
+		}
+		clientConfig.Password = strings.TrimSpace(string(password))
+	}
+
+	useTLS := external.CACertificate != "" || external.Certificate != ""
+	for _, endpoint := range external.Endpoints {
+		useTLS = useTLS || strings.HasPrefix(endpoint, "https://")
+	}
+
+	if useTLS {
+		tlsInfo := transport.TLSInfo{
+			CertFile:      external.Certificate,
+			KeyFile:       external.Key,
+			TrustedCAFile: external.CACertificate,
+		}
+
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return fmt.Errorf("external etcd tls configuration: %w", err)
+		}
+		clientConfig.TLS = tlsConfig
+	}
+
+	client, err := clientv3.New(clientConfig)
+	if err != nil {
+		return fmt.Errorf("unable to connect to external etcd: %w", err)
+	}
+
+	client.KV = namespace.NewKV(client.KV, external.Prefix)
+	client.Watcher = namespace.NewWatcher(client.Watcher, external.Prefix)
+	client.Lease = namespace.NewLease(client.Lease, external.Prefix)
+
+	// The client connects lazily, so check that the cluster is reachable and we are allowed to use it before going any further
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = client.Put(ctx, path.Join(NodeEvents, externalNodeID().String(), "name"), config.Values.Clustering.Name)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("unable to write to external etcd cluster: %w", err)
+	}
+
+	if err := checkMaxTxnOps(client, maxTxnOps); err != nil {
+		client.Close()
+		return err
+	}
+
+	etcd = client
+
+	log.Printf("Using external etcd cluster %s with key prefix %q as node %s (%s)", strings.Join(external.Endpoints, ","), external.Prefix, config.Values.Clustering.Name, GetServerID())
+
+	startElection()
+
+	return nil
+}
+
+// checkMaxTxnOps makes sure the cluster accepts transactions of size ops, as it is a server flag that cannot be read by clients
+func checkMaxTxnOps(client *clientv3.Client, ops int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	gets := make([]clientv3.Op, ops)
+	for i := range gets {
+		gets[i] = clientv3.OpGet(maxTxnOpsProbeKey)
+	}
+
+	_, err := client.Txn(ctx).Then(gets...).Commit()
+	if errors.Is(err, rpctypes.ErrTooManyOps) {
+		return fmt.Errorf("external etcd cluster must be started with --max-txn-ops of at least %d: %w", ops, err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to check external etcd cluster transaction limit: %w", err)
+	}
+
+	return nil
+}
+
+func startElection() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	election.Lock()
+	election.cancel = cancel
+	election.resign = make(chan struct{}, 1)
+	resign := election.resign
+	election.Unlock()
+
+	go func() {
+		for ctx.Err() == nil {
+			err := campaign(ctx, resign)
+			if err != nil && ctx.Err() == nil {
+				log.Println("leader election failed: ", err)
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+}
+
+func stopElection() {
+	election.Lock()
+	defer election.Unlock()
+
+	if election.cancel != nil {
+		election.cancel()
+		election.cancel = nil
+	}
+}
+
+func campaign(ctx context.Context, resign <-chan struct{}) error {
+	session, err := concurrency.NewSession(etcd, concurrency.WithTTL(10), concurrency.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	e := concurrency.NewElection(session, electionPrefix)
+	if err := e.Campaign(ctx, GetServerID()); err != nil {
+		return err
+	}
+
+	log.Println("this node is now the cluster leader")
+
+	select {
+	case <-session.Done():
+		return errors.New("leadership lost, session with etcd expired")
+	case <-ctx.Done():
+	case <-resign:
+		log.Println("stepping down as cluster leader")
+	}
+
+	resignCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err = e.Resign(resignCtx)
+
+	// Give the other nodes a chance to win the election before campaigning again
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+	}
+
+	return err
+}
+
+// externalLeader returns the id of the elected node, or an empty string if there is no leader
+func externalLeader() string {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	response, err := etcd.Get(ctx, electionPrefix, clientv3.WithFirstCreate()...)
+	if err != nil || len(response.Kvs) == 0 {
+		return ""
+	}
+
+	return string(response.Kvs[0].Value)
+}
+
+func externalStepDown() error {
+	if !IsLeader() {
+		return errors.New("this node is not the leader")
+	}
+
+	election.Lock()
+	defer election.Unlock()
+
+	select {
+	case election.resign <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// externalMembers lists the wag nodes that have connected to the external cluster
+func externalMembers() (members []*membership.Member) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	response, err := etcd.Get(ctx, NodeEvents, clientv3.WithPrefix())
+	if err != nil {
+		log.Println("unable to list cluster members: ", err)
+		return nil
+	}
+
+	for _, kv := range response.Kvs {
+		parts := strings.Split(strings.TrimPrefix(string(kv.Key), NodeEvents), "/")
+		if len(parts) != 2 || parts[1] != "name" {
+			continue
+		}
+
+		id, err := strconv.ParseUint(parts[0], 16, 64)
+		if err != nil {
+			continue
+		}
+
+		members = append(members, &membership.Member{
+			ID:         types.ID(id),
+			Attributes: membership.Attributes{Name: string(kv.Value)},
+		})
+	}
+
+	return members
+}
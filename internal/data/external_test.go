@@ -0,0 +1,207 @@
+package data
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.etcd.io/etcd/client/v3/namespace"
+)
+
+// useExternalEtcd connects to the embedded etcd server as if it were an external cluster, and returns a client for the whole keyspace
+func useExternalEtcd(t *testing.T, name, prefix string) *clientv3.Client {
+	embedded := etcd
+	previous := config.Values.Clustering
+
+	config.Values.Clustering.Name = name
+	config.Values.Clustering.External.Endpoints = []string{etcdServer.Config().ListenClientUrls[0].String()}
+	config.Values.Clustering.External.Prefix = prefix
+
+	t.Cleanup(func() {
+		stopElection()
+		if etcd != embedded {
+			etcd.Close()
+		}
+
+		etcd = embedded
+		config.Values.Clustering = previous
+
+		embedded.Delete(context.Background(), prefix, clientv3.WithPrefix())
+	})
+
+	if err := connectExternalEtcd(); err != nil {
+		t.Fatal(err)
+	}
+
+	return embedded
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	deadline := time.Now().Add(15 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestExternalKeyPrefix(t *testing.T) {
+	raw := useExternalEtcd(t, "external-node", "/wag-external-test/")
+	ctx := context.Background()
+
+	if _, err := etcd.Put(ctx, "prefix-test-key", "inside"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := raw.Put(ctx, "prefix-test-key", "outside"); err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Delete(ctx, "prefix-test-key")
+
+	response, err := raw.Get(ctx, "/wag-external-test/prefix-test-key")
+	if err != nil || len(response.Kvs) != 1 || string(response.Kvs[0].Value) != "inside" {
+		t.Fatal("keys should be stored under the prefix: ", response, err)
+	}
+
+	response, err = etcd.Get(ctx, "prefix-test-key")
+	if err != nil || len(response.Kvs) != 1 || string(response.Kvs[0].Value) != "inside" {
+		t.Fatal("keys outside of the prefix should not be visible: ", response, err)
+	}
+
+	// Settings written by the embedded wag are outside of the prefix
+	response, err = etcd.Get(ctx, "wag-", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil || response.Count != 0 {
+		t.Fatal("range reads should not escape the prefix: ", response.Count, err)
+	}
+
+	// Watches and leases are namespaced too
+	watch := etcd.Watch(ctx, "prefix-test-watch")
+
+	lease, err := etcd.Grant(ctx, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := raw.Put(ctx, "prefix-test-watch", "outside"); err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Delete(ctx, "prefix-test-watch")
+
+	if _, err := etcd.Put(ctx, "prefix-test-watch", "inside", clientv3.WithLease(lease.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-watch:
+		if len(event.Events) != 1 || string(event.Events[0].Kv.Key) != "prefix-test-watch" || string(event.Events[0].Kv.Value) != "inside" {
+			t.Fatal("watch saw changes outside of the prefix: ", event.Events)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not see the change inside the prefix")
+	}
+
+	if _, err := etcd.Revoke(ctx, lease.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err = raw.Get(ctx, "/wag-external-test/prefix-test-watch")
+	if err != nil || len(response.Kvs) != 0 {
+		t.Fatal("revoking the lease should delete its keys: ", response, err)
+	}
+}
+
+func TestExternalElection(t *testing.T) {
+	raw := useExternalEtcd(t, "external-leader", "/wag-external-election/")
+
+	if GetServerID() != externalNodeID().String() {
+		t.Fatal("server id should be derived from the node name")
+	}
+
+	waitFor(t, IsLeader, "only node should be elected leader")
+
+	if !HasLeader() || GetLeader().String() != GetServerID() {
+		t.Fatal("leader should be this node: ", GetLeader())
+	}
+
+	// Another wag node using the same prefix
+	other := clientv3.NewCtxClient(context.Background())
+	other.KV = namespace.NewKV(raw.KV, "/wag-external-election/")
+	other.Watcher = namespace.NewWatcher(raw.Watcher, "/wag-external-election/")
+	other.Lease = namespace.NewLease(raw.Lease, "/wag-external-election/")
+
+	otherID := "1234abcd"
+	if _, err := other.Put(context.Background(), path.Join(NodeEvents, otherID, "name"), "external-other"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Node state that is not a name, or a node outside of the prefix, is not a member
+	if _, err := other.Put(context.Background(), path.Join(NodeEvents, "5678", "data-key"), "ack"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := raw.Put(context.Background(), path.Join("/wag-external-other/", NodeEvents, "9abc", "name"), "elsewhere"); err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Delete(context.Background(), "/wag-external-other/", clientv3.WithPrefix())
+
+	members := map[string]string{}
+	for _, member := range GetMembers() {
+		members[member.ID.String()] = member.Name
+	}
+
+	if len(members) != 2 || members[GetServerID()] != "external-leader" || members[otherID] != "external-other" {
+		t.Fatal("unexpected members: ", members)
+	}
+
+	session, err := concurrency.NewSession(other, concurrency.WithTTL(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	campaign := make(chan error, 1)
+	go func() {
+		campaign <- concurrency.NewElection(session, electionPrefix).Campaign(context.Background(), otherID)
+	}()
+
+	if err := StepDown(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-campaign:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("other node did not win the election after the leader stepped down")
+	}
+
+	if IsLeader() || GetLeader().String() != otherID {
+		t.Fatal("leadership should have moved to the other node: ", GetLeader())
+	}
+
+	if err := StepDown(); err == nil {
+		t.Fatal("only the leader can step down")
+	}
+
+	// When the other node goes away this node takes over again
+	session.Close()
+	waitFor(t, IsLeader, "leadership was not taken back after the other node left")
+}
+
+func TestExternalMaxTxnOps(t *testing.T) {
+	if err := checkMaxTxnOps(etcd, maxTxnOps); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkMaxTxnOps(etcd, maxTxnOps+1); err == nil || !strings.Contains(err.Error(), "--max-txn-ops") {
+		t.Fatal("clusters with a lower transaction limit should be refused: ", err)
+	}
+}
@@ -83,7 +83,82 @@ func Load(path, joinToken string, testing bool) error {
 	}
 
 	var err error
+	if config.Values.Clustering.IsExternal() {
+		if joinToken != "" {
+			return errors.New("join tokens are not used with an external etcd cluster, start new nodes with the same clustering config instead")
+		}
+
+		err = connectExternalEtcd()
+	} else {
+		err = startEmbeddedEtcd(joinToken, testing)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Println("Successfully connected to etcd")
+
+	err = configureEncryption()
+	if err != nil {
+		return err
+	}
+
+	if !IsLearner() {
+
+		err = loadKeyring(true)
+		if err != nil {
+			return err
+		}
+
+		err = watchKeyring()
+		if err != nil {
+			return err
+		}
+
+		if doMigration {
+			// This will be kept for 2 major releases with reduced support.
+			// It is a no-op if a migration has already taken place
+			err = migrateFromSql(db)
+			if err != nil {
+				return err
+			}
+		}
 
+		// This will stay, so that the config can be used to easily spin up a new wag instance.
+		// After first run this will be a no-op
+		err = loadInitialSettings()
+		if err != nil {
+			return err
+		}
+
+		if encryptionEnabled() {
+			// Secrets written before encryption was enabled, or with a data key that has since been rotated, are encrypted with the active key
+			go func() {
+				updated, err := ReencryptSecrets()
+				if err != nil {
+					log.Println("unable to encrypt existing secrets: ", err)
+					return
+				}
+
+				if updated > 0 {
+					log.Printf("encrypted secrets of %d users and devices", updated)
+				}
+			}()
+		}
+	}
+
+	go checkClusterHealth()
+	go auditRetention()
+	go accessRequestExpiry()
+	go userDeprovisioning()
+	go lockoutCooldowns()
+	go keyRotations()
+
+	return nil
+}
+
+// startEmbeddedEtcd runs etcd inside wag, either creating a new cluster or joining an existing one with a join token
+func startEmbeddedEtcd(joinToken string, testing bool) (err error) {
 	if TLSManager == nil {
 		if joinToken == "" {
 			TLSManager, err = manager.New(config.Values.Clustering.TLSManagerStorage, config.Values.Clustering.TLSManagerListenURL)
@@ -134,6 +209,7 @@ func Load(path, joinToken string, testing bool) error {
 	cfg.AdvertisePeerUrls = cfg.ListenPeerUrls
 	cfg.AutoCompactionMode = "periodic"
 	cfg.AutoCompactionRetention = "1h"
+	cfg.MaxTxnOps = maxTxnOps
 
 	cfg.PeerTLSInfo.ClientCertAuth = true
 	cfg.PeerTLSInfo.TrustedCAFile = TLSManager.GetCACertPath()
@@ -175,30 +251,6 @@ func Load(path, joinToken string, testing bool) error {
 		return err
 	}
 
-	log.Println("Successfully connected to etcd")
-
-	if !etcdServer.Server.IsLearner() {
-
-		if doMigration {
-			// This will be kept for 2 major releases with reduced support.
-			// It is a no-op if a migration has already taken place
-			err = migrateFromSql(db)
-			if err != nil {
-				return err
-			}
-		}
-
-		// This will stay, so that the config can be used to easily spin up a new wag instance.
-		// After first run this will be a no-op
-		err = loadInitialSettings()
-		if err != nil {
-			return err
-		}
-
-	}
-
-	go checkClusterHealth()
-
 	return nil
 }
 
@@ -267,11 +319,26 @@ func loadInitialSettings() error {
 		return err
 	}
 
+	err = putIfNotFound(SessionExtensionKey, config.Values.SessionExtensionMinutes, "session extension")
+	if err != nil {
+		return err
+	}
+
 	err = putIfNotFound(LockoutKey, config.Values.Lockout, "lockout")
 	if err != nil {
 		return err
 	}
 
+	err = putIfNotFound(LockoutCooldownKey, config.Values.LockoutCooldownMinutes, "lockout cooldown")
+	if err != nil {
+		return err
+	}
+
+	err = putIfNotFound(AttemptDelayKey, config.Values.AttemptDelaySeconds, "attempt delay")
+	if err != nil {
+		return err
+	}
+
 	err = putIfNotFound(IssuerKey, config.Values.Authenticators.Issuer, "issuer name")
 	if err != nil {
 		return err
@@ -302,16 +369,71 @@ func loadInitialSettings() error {
 		return err
 	}
 
+	err = putIfNotFound(StepUpMethodsKey, config.Values.Authenticators.StepUpMethods, "step up methods")
+	if err != nil {
+		return err
+	}
+
 	err = putIfNotFound(OidcDetailsKey, config.Values.Authenticators.OIDC, "oidc settings")
 	if err != nil {
 		return err
 	}
 
+	err = putIfNotFound(OidcProvidersKey, config.Values.Authenticators.OIDCProviders, "additional oidc providers")
+	if err != nil {
+		return err
+	}
+
+	err = putIfNotFound(SamlDetailsKey, config.Values.Authenticators.SAML, "saml settings")
+	if err != nil {
+		return err
+	}
+
+	err = putIfNotFound(LdapDetailsKey, config.Values.Authenticators.LDAP, "ldap settings")
+	if err != nil {
+		return err
+	}
+
+	err = putIfNotFound(AuditRetentionKey, AuditRetention{
+		Days:       max(config.Values.Audit.RetentionDays, 0),
+		MaxEntries: max(config.Values.Audit.MaxEntries, 0),
+	}, "audit retention")
+	if err != nil {
+		return err
+	}
+
+	err = putIfNotFound(InactivityPolicyKey, InactivityPolicy{
+		Days:        config.Values.Inactivity.Days,
+		Action:      config.Values.Inactivity.Action,
+		WarningDays: config.Values.Inactivity.WarningDays,
+	}, "inactivity policy")
+	if err != nil {
+		return err
+	}
+
+	keyRotation := map[string]KeyRotationPolicy{}
+	for group, policy := range config.Values.KeyRotation {
+		keyRotation[group] = KeyRotationPolicy{
+			IntervalDays: policy.IntervalDays,
+			GraceHours:   policy.GraceHours,
+		}
+	}
+
+	err = putIfNotFound(KeyRotationKey, keyRotation, "key rotation policies")
+	if err != nil {
+		return err
+	}
+
 	err = putIfNotFound(PamDetailsKey, config.Values.Authenticators.PAM, "pam settings")
 	if err != nil {
 		return err
 	}
 
+	err = putIfNotFound(SinksKey, config.Values.Sinks, "event sinks")
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -425,7 +547,7 @@ func migrateFromSql(database *sql.DB) error {
 		}
 
 		for _, token := range tokens {
-			err := AddRegistrationToken(token.Token, token.Username, token.Overwrites, token.Groups, token.NumUses)
+			err := AddRegistrationToken(token.Token, token.Username, token.Overwrites, token.Groups, token.NumUses, time.Time{})
 			if err != nil {
 				return err
 			}
@@ -445,12 +567,15 @@ func migrateFromSql(database *sql.DB) error {
 
 func TearDown() {
 	close(exit)
-	if etcdServer != nil {
+	stopElection()
 
+	if etcd != nil {
 		etcd.Close()
-		etcdServer.Close()
-
 		etcd = nil
+	}
+
+	if etcdServer != nil {
+		etcdServer.Close()
 		etcdServer = nil
 	}
 }
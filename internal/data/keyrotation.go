@@ -0,0 +1,386 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	KeyRotationKey = "wag-config-key-rotation"
+
+	// The policy key for every user, rather than members of a group
+	AllUsersKeyRotation = "*"
+
+	DefaultKeyRotationGraceHours = 24
+)
+
+// KeyRotationPolicy is how often the devices of a group must rotate their wireguard keys
+type KeyRotationPolicy struct {
+	IntervalDays int
+	// After new keys are issued to a device its old keys stay valid for this many hours, or until it connects with the new keys
+	GraceHours int
+}
+
+func (p KeyRotationPolicy) Validate() error {
+	if p.IntervalDays < 0 || p.GraceHours < 0 {
+		return errors.New("key rotation interval and grace period cannot be negative")
+	}
+
+	return nil
+}
+
+func (p KeyRotationPolicy) interval() time.Duration {
+	return time.Duration(p.IntervalDays) * 24 * time.Hour
+}
+
+func (p KeyRotationPolicy) grace() time.Duration {
+	return time.Duration(p.GraceHours) * time.Hour
+}
+
+// KeyRotationStatus is when a device that is subject to a key rotation policy must next rotate its keys
+type KeyRotationStatus struct {
+	Username string
+	Address  string
+	Due      time.Time
+	Overdue  bool
+	// New keys have been issued and the device has not connected with them yet
+	Pending bool
+}
+
+func keyRotationStatus(device Device, policy KeyRotationPolicy) KeyRotationStatus {
+	due := device.KeysRotated.Add(policy.interval())
+
+	return KeyRotationStatus{
+		Username: device.Username,
+		Address:  device.Address,
+		Due:      due,
+		Overdue:  time.Now().After(due),
+		Pending:  device.PendingPublickey != "",
+	}
+}
+
+func GetKeyRotationPolicies() (map[string]KeyRotationPolicy, error) {
+	response, err := etcd.Get(context.Background(), KeyRotationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := map[string]KeyRotationPolicy{}
+	if len(response.Kvs) == 0 {
+		return policies, nil
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &policies)
+	return policies, err
+}
+
+// SetKeyRotationPolicy sets the key rotation policy of a group, or of every user with AllUsersKeyRotation. An interval of 0 removes the policy
+func SetKeyRotationPolicy(group string, policy KeyRotationPolicy) error {
+	if group != AllUsersKeyRotation && !strings.HasPrefix(group, "group:") {
+		return fmt.Errorf("key rotation policies are set on a group (group:name) or every user (%s)", AllUsersKeyRotation)
+	}
+
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	if policy.GraceHours == 0 {
+		policy.GraceHours = DefaultKeyRotationGraceHours
+	}
+
+	return doSafeUpdate(context.Background(), KeyRotationKey, true, func(gr *clientv3.GetResponse) (string, error) {
+		policies := map[string]KeyRotationPolicy{}
+		if len(gr.Kvs) == 1 {
+			if err := json.Unmarshal(gr.Kvs[0].Value, &policies); err != nil {
+				return "", err
+			}
+		}
+
+		if policy.IntervalDays == 0 {
+			delete(policies, group)
+		} else {
+			policies[group] = policy
+		}
+
+		b, _ := json.Marshal(policies)
+		return string(b), nil
+	})
+}
+
+// userKeyRotationPolicy is the strictest policy of any group the user is in, ok is false if none apply
+func userKeyRotationPolicy(policies map[string]KeyRotationPolicy, username string) (policy KeyRotationPolicy, ok bool, err error) {
+	if len(policies) == 0 {
+		return policy, false, nil
+	}
+
+	groups, err := GetUserGroupMembership(username)
+	if err != nil {
+		return policy, false, err
+	}
+
+	for _, group := range append(groups, AllUsersKeyRotation) {
+		p, exists := policies[group]
+		if !exists || p.IntervalDays == 0 {
+			continue
+		}
+
+		if !ok || p.IntervalDays < policy.IntervalDays {
+			policy, ok = p, true
+		}
+	}
+
+	return policy, ok, nil
+}
+
+// GetDeviceKeyRotation returns when the device must next rotate its keys and the policy that applies to it, ok is false if the device is not subject to a policy
+func GetDeviceKeyRotation(address string) (status KeyRotationStatus, policy KeyRotationPolicy, ok bool, err error) {
+	device, err := GetDeviceByAddress(address)
+	if err != nil {
+		return status, policy, false, err
+	}
+
+	policies, err := GetKeyRotationPolicies()
+	if err != nil {
+		return status, policy, false, err
+	}
+
+	policy, ok, err = userKeyRotationPolicy(policies, device.Username)
+	if err != nil || !ok {
+		return status, policy, false, err
+	}
+
+	return keyRotationStatus(device, policy), policy, true, nil
+}
+
+// GetKeyRotations returns the rotation status of every device that is subject to a key rotation policy, soonest due first
+func GetKeyRotations() (statuses []KeyRotationStatus, err error) {
+	policies, err := GetKeyRotationPolicies()
+	if err != nil || len(policies) == 0 {
+		return nil, err
+	}
+
+	devices, err := GetAllDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	type userPolicy struct {
+		policy KeyRotationPolicy
+		ok     bool
+	}
+
+	users := map[string]userPolicy{}
+	for _, device := range devices {
+		up, checked := users[device.Username]
+		if !checked {
+			up.policy, up.ok, err = userKeyRotationPolicy(policies, device.Username)
+			if err != nil {
+				return nil, err
+			}
+			users[device.Username] = up
+		}
+
+		if !up.ok || device.KeysRotated.IsZero() {
+			continue
+		}
+
+		statuses = append(statuses, keyRotationStatus(device, up.policy))
+	}
+
+	slices.SortFunc(statuses, func(a, b KeyRotationStatus) int {
+		return a.Due.Compare(b.Due)
+	})
+
+	return statuses, nil
+}
+
+// IssueDeviceKeys gives a device new keys, the public key is supplied by the device or generated with the private key given to it.
+// The device keeps its current keys until it connects with the new ones or the grace period ends. Issuing keys again replaces any that are pending
+func IssueDeviceKeys(address string, publicKey wgtypes.Key) (Device, error) {
+	presharedKey, err := wgtypes.GenerateKey()
+	if err != nil {
+		return Device{}, err
+	}
+
+	inUse, err := etcd.Get(context.Background(), "deviceref-"+publicKey.String())
+	if err != nil {
+		return Device{}, err
+	}
+
+	if inUse.Count > 0 {
+		return Device{}, errors.New("public key is already in use")
+	}
+
+	current, err := GetDeviceByAddress(address)
+	if err != nil {
+		return Device{}, err
+	}
+
+	var updated Device
+	err = doSafeUpdate(context.Background(), deviceKey(current.Username, address), false, func(gr *clientv3.GetResponse) (string, error) {
+		if len(gr.Kvs) != 1 {
+			return "", errors.New("device not found")
+		}
+
+		if err := json.Unmarshal(gr.Kvs[0].Value, &updated); err != nil {
+			return "", err
+		}
+
+		if updated.Publickey == publicKey.String() {
+			return "", errors.New("new public key is the same as the current one")
+		}
+
+		updated.PendingPublickey = publicKey.String()
+		updated.PendingPresharedKey = presharedKey.String()
+		updated.PendingSince = time.Now()
+
+		b, err := json.Marshal(updated)
+		return string(b), err
+	})
+
+	return updated, err
+}
+
+// CompleteKeyRotation replaces the keys of a device with its pending keys, publicKey must be the pending key so a rotation is only ever completed once
+func CompleteKeyRotation(address, publicKey string) error {
+	current, err := GetDeviceByAddress(address)
+	if err != nil {
+		return err
+	}
+
+	var previousKey string
+	err = doSafeUpdate(context.Background(), deviceKey(current.Username, address), false, func(gr *clientv3.GetResponse) (string, error) {
+		if len(gr.Kvs) != 1 {
+			return "", errors.New("device not found")
+		}
+
+		var device Device
+		if err := json.Unmarshal(gr.Kvs[0].Value, &device); err != nil {
+			return "", err
+		}
+
+		if device.PendingPublickey == "" || device.PendingPublickey != publicKey {
+			return "", errors.New("device has no pending key rotation for that key")
+		}
+
+		previousKey = device.Publickey
+
+		device.Publickey = device.PendingPublickey
+		device.PresharedKey = device.PendingPresharedKey
+		device.KeysRotated = time.Now()
+		device.PendingPublickey = ""
+		device.PendingPresharedKey = ""
+		device.PendingSince = time.Time{}
+
+		b, err := json.Marshal(device)
+		return string(b), err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = etcd.Txn(context.Background()).Then(
+		clientv3.OpDelete("deviceref-"+previousKey),
+		clientv3.OpPut("deviceref-"+publicKey, deviceKey(current.Username, address)),
+	).Commit()
+	if err != nil {
+		return err
+	}
+
+	Audit(AuditKeysRotated, current.Username, "", address, "rotated to "+publicKey)
+
+	return nil
+}
+
+// expireKeyRotations completes the rotations of devices whose grace period has ended, so their old keys stop working
+func expireKeyRotations() error {
+	policies, err := GetKeyRotationPolicies()
+	if err != nil {
+		return err
+	}
+
+	devices, err := GetAllDevices()
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		// Devices from before keys were recorded are given a full interval from when wag first sees them
+		if device.KeysRotated.IsZero() {
+			err := doSafeUpdate(context.Background(), deviceKey(device.Username, device.Address), false, func(gr *clientv3.GetResponse) (string, error) {
+				if len(gr.Kvs) != 1 {
+					return "", errors.New("device not found")
+				}
+
+				var d Device
+				if err := json.Unmarshal(gr.Kvs[0].Value, &d); err != nil {
+					return "", err
+				}
+
+				if d.KeysRotated.IsZero() {
+					d.KeysRotated = time.Now()
+				}
+
+				b, err := json.Marshal(d)
+				return string(b), err
+			})
+			if err != nil {
+				log.Println("unable to set key rotation time of device", device.Address, "err:", err)
+			}
+		}
+
+		if device.PendingPublickey == "" {
+			continue
+		}
+
+		policy, ok, err := userKeyRotationPolicy(policies, device.Username)
+		if err != nil {
+			log.Println("unable to get key rotation policy for", device.Username, "err:", err)
+			continue
+		}
+
+		if !ok {
+			policy.GraceHours = DefaultKeyRotationGraceHours
+		}
+
+		if time.Since(device.PendingSince) < policy.grace() {
+			continue
+		}
+
+		if err := CompleteKeyRotation(device.Address, device.PendingPublickey); err != nil {
+			log.Println("unable to complete key rotation of device", device.Address, "err:", err)
+		}
+	}
+
+	return nil
+}
+
+// keyRotations periodically ends the grace period of devices that were issued new keys, only the cluster leader does this so each rotation is only completed once
+func keyRotations() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !IsLeader() {
+				continue
+			}
+
+			if err := expireKeyRotations(); err != nil {
+				log.Println("unable to expire key rotation grace periods: ", err)
+			}
+		case <-exit:
+			return
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestKeyRotationPolicies(t *testing.T) {
+	if err := SetKeyRotationPolicy("contractors", KeyRotationPolicy{IntervalDays: 30}); err == nil {
+		t.Fatal("policies should only be set on groups or every user")
+	}
+
+	if err := SetKeyRotationPolicy("group:rotation", KeyRotationPolicy{IntervalDays: -1}); err == nil {
+		t.Fatal("negative intervals should be refused")
+	}
+
+	if err := SetKeyRotationPolicy("group:rotation", KeyRotationPolicy{IntervalDays: 30}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetKeyRotationPolicy("group:rotation", KeyRotationPolicy{})
+
+	if err := SetKeyRotationPolicy(AllUsersKeyRotation, KeyRotationPolicy{IntervalDays: 90, GraceHours: 2}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetKeyRotationPolicy(AllUsersKeyRotation, KeyRotationPolicy{})
+
+	policies, err := GetKeyRotationPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if policies["group:rotation"].GraceHours != DefaultKeyRotationGraceHours {
+		t.Fatal("grace period should default when unset: ", policies)
+	}
+
+	if err := SetGroup("group:rotation", []string{"rotation_member"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, ok, err := userKeyRotationPolicy(policies, "rotation_member")
+	if err != nil || !ok || policy.IntervalDays != 30 {
+		t.Fatal("the strictest policy of a users groups should apply: ", policy, ok, err)
+	}
+
+	policy, ok, err = userKeyRotationPolicy(policies, "rotation_nonmember")
+	if err != nil || !ok || policy.IntervalDays != 90 {
+		t.Fatal("the every user policy should apply to users without a group policy: ", policy, ok, err)
+	}
+
+	if err := SetKeyRotationPolicy(AllUsersKeyRotation, KeyRotationPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err = GetKeyRotationPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, _ := userKeyRotationPolicy(policies, "rotation_nonmember"); ok {
+		t.Fatal("an interval of 0 should remove the policy: ", policies)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	if _, err := CreateUserDataAccount("rotation_user"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("rotation_user")
+
+	if err := SetKeyRotationPolicy(AllUsersKeyRotation, KeyRotationPolicy{IntervalDays: 30, GraceHours: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetKeyRotationPolicy(AllUsersKeyRotation, KeyRotationPolicy{})
+
+	original, _ := wgtypes.GeneratePrivateKey()
+	device, err := AddDevice("rotation_user", original.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteDevice("rotation_user", device.Address)
+
+	status, _, ok, err := GetDeviceKeyRotation(device.Address)
+	if err != nil || !ok || status.Overdue || status.Due.Before(time.Now().Add(29*24*time.Hour)) {
+		t.Fatal("a new device should be due a full interval from now: ", status, ok, err)
+	}
+
+	setDevice(t, "rotation_user", device.Address, func(d *Device) {
+		d.KeysRotated = time.Now().Add(-31 * 24 * time.Hour)
+	})
+
+	status, _, _, err = GetDeviceKeyRotation(device.Address)
+	if err != nil || !status.Overdue {
+		t.Fatal("device should be overdue: ", status, err)
+	}
+
+	if _, err := IssueDeviceKeys(device.Address, original.PublicKey()); err == nil {
+		t.Fatal("the current key should not be accepted as a new key")
+	}
+
+	replaced, _ := wgtypes.GeneratePrivateKey()
+	if _, err := IssueDeviceKeys(device.Address, replaced.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	next, _ := wgtypes.GeneratePrivateKey()
+	issued, err := IssueDeviceKeys(device.Address, next.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if issued.Publickey != original.PublicKey().String() || issued.PendingPublickey != next.PublicKey().String() || issued.PendingPresharedKey == "" {
+		t.Fatal("issued keys should be pending, replacing any issued before, while the current key keeps working: ", issued)
+	}
+
+	if err := CompleteKeyRotation(device.Address, replaced.PublicKey().String()); err == nil {
+		t.Fatal("keys that were replaced before they were used should not complete a rotation")
+	}
+
+	if err := CompleteKeyRotation(device.Address, next.PublicKey().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := GetDeviceByAddress(device.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rotated.Publickey != next.PublicKey().String() || rotated.PresharedKey != issued.PendingPresharedKey || rotated.PendingPublickey != "" {
+		t.Fatal("pending keys should have become the devices keys: ", rotated)
+	}
+
+	if time.Since(rotated.KeysRotated) > time.Minute {
+		t.Fatal("rotation should restart the interval: ", rotated.KeysRotated)
+	}
+
+	if err := CompleteKeyRotation(device.Address, next.PublicKey().String()); err == nil {
+		t.Fatal("a rotation should only be completed once")
+	}
+}
+
+func TestKeyRotationGracePeriod(t *testing.T) {
+	if _, err := CreateUserDataAccount("rotation_grace"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("rotation_grace")
+
+	if err := SetKeyRotationPolicy(AllUsersKeyRotation, KeyRotationPolicy{IntervalDays: 30, GraceHours: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetKeyRotationPolicy(AllUsersKeyRotation, KeyRotationPolicy{})
+
+	original, _ := wgtypes.GeneratePrivateKey()
+	waiting, err := AddDevice("rotation_grace", original.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteDevice("rotation_grace", waiting.Address)
+
+	other, _ := wgtypes.GeneratePrivateKey()
+	expired, err := AddDevice("rotation_grace", other.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteDevice("rotation_grace", expired.Address)
+
+	first, _ := wgtypes.GeneratePrivateKey()
+	if _, err := IssueDeviceKeys(waiting.Address, first.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	second, _ := wgtypes.GeneratePrivateKey()
+	if _, err := IssueDeviceKeys(expired.Address, second.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	setDevice(t, "rotation_grace", expired.Address, func(d *Device) {
+		d.PendingSince = time.Now().Add(-2 * time.Hour)
+	})
+
+	if err := expireKeyRotations(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d, err := GetDeviceByAddress(waiting.Address); err != nil || d.Publickey != original.PublicKey().String() || d.PendingPublickey == "" {
+		t.Fatal("both keys should be valid during the grace period: ", d, err)
+	}
+
+	if d, err := GetDeviceByAddress(expired.Address); err != nil || d.Publickey != second.PublicKey().String() || d.PendingPublickey != "" {
+		t.Fatal("old keys should be replaced once the grace period ends: ", d, err)
+	}
+}
@@ -0,0 +1,246 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	InactivityPolicyKey = "wag-config-inactivity"
+
+	DeprovisionLock   = "lock"
+	DeprovisionDelete = "delete"
+
+	DeprovisionExpired  = "expired"
+	DeprovisionInactive = "inactive"
+
+	// Authorisations closer together than this do not update the users last seen time, to avoid a write to the user on every login
+	lastSeenResolution = time.Hour
+)
+
+type InactivityPolicy struct {
+	// Accounts whose devices have not authorised for this many days are deprovisioned, 0 disables
+	Days int
+	// DeprovisionLock or DeprovisionDelete
+	Action string
+	// Administrators are warned in the management ui this many days before an account is deprovisioned
+	WarningDays int
+}
+
+func (p InactivityPolicy) Validate() error {
+	if p.Days < 0 || p.WarningDays < 0 {
+		return errors.New("inactivity days cannot be negative")
+	}
+
+	if p.Action != DeprovisionLock && p.Action != DeprovisionDelete {
+		return fmt.Errorf("inactivity action must be %s or %s", DeprovisionLock, DeprovisionDelete)
+	}
+
+	return nil
+}
+
+// Deprovision is an account that will be, or is due to be, locked or deleted because it expired or has been inactive
+type Deprovision struct {
+	Username string
+	// DeprovisionExpired or DeprovisionInactive
+	Reason string
+	// DeprovisionLock or DeprovisionDelete
+	Action string
+	Due    time.Time
+}
+
+func GetInactivityPolicy() (policy InactivityPolicy, err error) {
+	response, err := etcd.Get(context.Background(), InactivityPolicyKey)
+	if err != nil {
+		return policy, err
+	}
+
+	if len(response.Kvs) != 1 {
+		return policy, errors.New("inactivity policy was not set")
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &policy)
+	return
+}
+
+func SetInactivityPolicy(policy InactivityPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	b, _ := json.Marshal(policy)
+	_, err := etcd.Put(context.Background(), InactivityPolicyKey, string(b))
+	return err
+}
+
+func updateUser(username string, f func(user *UserModel) error) error {
+	return doSafeUpdate(context.Background(), "users-"+username+"-", false, func(gr *clientv3.GetResponse) (string, error) {
+		if len(gr.Kvs) != 1 {
+			return "", errors.New("user not found")
+		}
+
+		var user UserModel
+		if err := json.Unmarshal(gr.Kvs[0].Value, &user); err != nil {
+			return "", err
+		}
+
+		if err := f(&user); err != nil {
+			return "", err
+		}
+
+		b, _ := json.Marshal(user)
+
+		return string(b), nil
+	})
+}
+
+// SetUserExpiry locks the account once expires has passed, a zero time removes the expiry
+func SetUserExpiry(username string, expires time.Time) error {
+	return updateUser(username, func(user *UserModel) error {
+		user.Expires = expires
+		return nil
+	})
+}
+
+func setUserLastSeen(username string) error {
+	return updateUser(username, func(user *UserModel) error {
+		user.LastSeen = time.Now()
+		return nil
+	})
+}
+
+// GetDeprovisions returns the accounts that will be locked or deleted within the next period, including those that are already due
+func GetDeprovisions(within time.Duration) (deprovisions []Deprovision, err error) {
+	policy, err := GetInactivityPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(within)
+	for _, user := range users {
+		if !user.Expires.IsZero() && !user.Locked && user.Expires.Before(cutoff) {
+			deprovisions = append(deprovisions, Deprovision{
+				Username: user.Username,
+				Reason:   DeprovisionExpired,
+				Action:   DeprovisionLock,
+				Due:      user.Expires,
+			})
+			continue
+		}
+
+		if policy.Days == 0 || (user.Locked && policy.Action == DeprovisionLock) {
+			continue
+		}
+
+		// Accounts from before last seen was recorded are given a full period from when wag first sees them
+		lastActive := user.LastActive()
+		if lastActive.IsZero() {
+			continue
+		}
+
+		due := lastActive.Add(time.Duration(policy.Days) * 24 * time.Hour)
+		if due.Before(cutoff) {
+			deprovisions = append(deprovisions, Deprovision{
+				Username: user.Username,
+				Reason:   DeprovisionInactive,
+				Action:   policy.Action,
+				Due:      due,
+			})
+		}
+	}
+
+	slices.SortFunc(deprovisions, func(a, b Deprovision) int {
+		return a.Due.Compare(b.Due)
+	})
+
+	return deprovisions, nil
+}
+
+// DeprovisionWarnings returns the accounts that administrators should be warned about under the current policy
+func DeprovisionWarnings() ([]Deprovision, error) {
+	policy, err := GetInactivityPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	return GetDeprovisions(time.Duration(policy.WarningDays) * 24 * time.Hour)
+}
+
+// deprovisionUsers locks or deletes every account that has expired or been inactive for too long
+func deprovisionUsers() error {
+	users, err := GetAllUsers()
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if user.LastActive().IsZero() {
+			if err := setUserLastSeen(user.Username); err != nil {
+				log.Println("unable to set last seen time for", user.Username, "err:", err)
+			}
+		}
+	}
+
+	due, err := GetDeprovisions(0)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		switch d.Action {
+		case DeprovisionLock:
+			err = SetUserLock(d.Username)
+		case DeprovisionDelete:
+			err = DeleteUser(d.Username)
+		}
+
+		if err != nil {
+			log.Println("unable to", d.Action, d.Reason, "account", d.Username, "err:", err)
+			continue
+		}
+
+		log.Println(d.Reason, "account", d.Username, "deprovisioned, action:", d.Action)
+
+		eventType := AuditAccountExpired
+		if d.Reason == DeprovisionInactive {
+			eventType = AuditAccountInactive
+		}
+
+		Audit(eventType, "wag", "", d.Username, fmt.Sprintf("action: %s, due: %s", d.Action, d.Due.Format(time.RFC3339)))
+	}
+
+	return nil
+}
+
+// userDeprovisioning periodically applies account expiry and the inactivity policy, only the cluster leader does this so each account is only acted on once
+func userDeprovisioning() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !IsLeader() {
+				continue
+			}
+
+			if err := deprovisionUsers(); err != nil {
+				log.Println("unable to deprovision expired or inactive accounts: ", err)
+			}
+		case <-exit:
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountExpiry(t *testing.T) {
+	if _, err := CreateUserDataAccount("expiring_user"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("expiring_user")
+
+	if err := SetUserExpiry("expiring_user", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := GetUserData("expiring_user")
+	if err != nil || !user.Expired() {
+		t.Fatal("user should have expired: ", user, err)
+	}
+
+	if err := deprovisionUsers(); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err = GetUserData("expiring_user")
+	if err != nil || !user.Locked {
+		t.Fatal("expired user should have been locked: ", user, err)
+	}
+
+	if err := SetUserExpiry("expiring_user", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err = GetUserData("expiring_user")
+	if err != nil || user.Expired() {
+		t.Fatal("expiry should have been removed: ", user, err)
+	}
+}
+
+func TestInactivityDeprovisioning(t *testing.T) {
+	original, err := GetInactivityPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetInactivityPolicy(original)
+
+	if err := SetInactivityPolicy(InactivityPolicy{Days: 30, Action: "archive"}); err == nil {
+		t.Fatal("unknown actions should be rejected")
+	}
+
+	if err := SetInactivityPolicy(InactivityPolicy{Days: 30, Action: DeprovisionDelete, WarningDays: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateUserDataAccount("inactive_user"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("inactive_user")
+
+	if _, err := CreateUserDataAccount("soon_inactive_user"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("soon_inactive_user")
+
+	if err := updateUser("inactive_user", func(user *UserModel) error {
+		user.LastSeen = time.Now().Add(-31 * 24 * time.Hour)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updateUser("soon_inactive_user", func(user *UserModel) error {
+		user.LastSeen = time.Now().Add(-25 * 24 * time.Hour)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	warnings, err := DeprovisionWarnings()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warned := map[string]Deprovision{}
+	for _, w := range warnings {
+		warned[w.Username] = w
+	}
+
+	if warned["inactive_user"].Reason != DeprovisionInactive || warned["soon_inactive_user"].Action != DeprovisionDelete {
+		t.Fatal("both inactive users should have warnings: ", warnings)
+	}
+
+	if err := deprovisionUsers(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetUserData("inactive_user"); err == nil {
+		t.Fatal("inactive user should have been deleted")
+	}
+
+	if _, err := GetUserData("soon_inactive_user"); err != nil {
+		t.Fatal("user inside the inactivity period should not have been deleted: ", err)
+	}
+}
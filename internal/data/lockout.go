@@ -0,0 +1,258 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// The delay between attempts doubles with each failure up to this
+	maxAttemptDelay = 5 * time.Minute
+
+	// Counters for this many endpoints are kept per device, the oldest are dropped first unless they are locked out
+	maxEndpointCounters = 16
+)
+
+// AttemptCounter is the failed authentication attempts a device has made from one endpoint
+type AttemptCounter struct {
+	Attempts    int
+	LastAttempt time.Time
+}
+
+func endpointHost(endpoint *net.UDPAddr) string {
+	if endpoint == nil {
+		return ""
+	}
+
+	return endpoint.IP.String()
+}
+
+// LockoutAttempts is the number of failed attempts the lockout applies to. The device wide total is counted
+// from every endpoint, so changing endpoint cannot be used to get more guesses than the lockout allows
+func (d Device) LockoutAttempts() int {
+	return max(d.Attempts, d.TotalAttempts)
+}
+
+// lastAttempt is when the device last failed from any endpoint, zero if its attempts were only set by an administrator
+func (d Device) lastAttempt() time.Time {
+	latest := d.LastAttempt
+	for _, counter := range d.EndpointAttempts {
+		if counter.LastAttempt.After(latest) {
+			latest = counter.LastAttempt
+		}
+	}
+
+	return latest
+}
+
+// switchEndpoint keeps the attempts made from the devices previous endpoint and restores any made from the new one,
+// so the progressive delay of one endpoint does not slow down another. Counters at or over the lockout are never dropped,
+// if there is no room left for another endpoint the switch is refused
+func (d *Device) switchEndpoint(endpoint *net.UDPAddr, lockout int) error {
+	previous, next := endpointHost(d.Endpoint), endpointHost(endpoint)
+	if previous == next {
+		d.Endpoint = endpoint
+		return nil
+	}
+
+	counters := map[string]AttemptCounter{}
+	for host, counter := range d.EndpointAttempts {
+		counters[host] = counter
+	}
+
+	if d.Attempts > 0 {
+		counters[previous] = AttemptCounter{Attempts: d.Attempts, LastAttempt: d.LastAttempt}
+	}
+
+	counter := counters[next]
+	delete(counters, next)
+
+	for len(counters) > maxEndpointCounters {
+		oldest, found := "", false
+		for host, c := range counters {
+			if c.Attempts >= lockout {
+				continue
+			}
+
+			if !found || c.LastAttempt.Before(counters[oldest].LastAttempt) {
+				oldest, found = host, true
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("device has failed attempts from more than %d endpoints, it must be unlocked before using another", maxEndpointCounters)
+		}
+
+		delete(counters, oldest)
+	}
+
+	d.Endpoint = endpoint
+	d.EndpointAttempts = counters
+	d.Attempts = counter.Attempts
+	d.LastAttempt = counter.LastAttempt
+
+	return nil
+}
+
+// attemptDelay is how long a device must wait after its last attempt before trying again, doubling with each failure
+func attemptDelay(attempts int, base time.Duration) time.Duration {
+	if attempts == 0 || base == 0 {
+		return 0
+	}
+
+	delay := base
+	for i := 1; i < attempts && delay < maxAttemptDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxAttemptDelay)
+}
+
+func SetLockoutCooldown(minutes int) error {
+	if minutes < 0 {
+		return errors.New("lockout cooldown cannot be negative")
+	}
+
+	data, _ := json.Marshal(minutes)
+	_, err := etcd.Put(context.Background(), LockoutCooldownKey, string(data))
+	return err
+}
+
+// GetLockoutCooldown is how long after its last attempt a locked device is automatically unlocked, 0 means it stays locked until an administrator unlocks it
+func GetLockoutCooldown() (time.Duration, error) {
+	minutes, err := getInt(LockoutCooldownKey)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+func SetAttemptDelay(seconds int) error {
+	if seconds < 0 {
+		return errors.New("attempt delay cannot be negative")
+	}
+
+	data, _ := json.Marshal(seconds)
+	_, err := etcd.Put(context.Background(), AttemptDelayKey, string(data))
+	return err
+}
+
+// GetAttemptDelay is the delay after the first failed attempt, it doubles with each further failure. 0 disables the delay
+func GetAttemptDelay() (time.Duration, error) {
+	seconds, err := getInt(AttemptDelayKey)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// expireLockouts resets the attempts of devices that have not made an attempt within the cooldown, devices locked by an administrator have no last attempt and are left alone
+func expireLockouts() error {
+	cooldown, err := GetLockoutCooldown()
+	if err != nil || cooldown == 0 {
+		return err
+	}
+
+	lockout, err := GetLockout()
+	if err != nil {
+		return err
+	}
+
+	devices, err := GetAllDevices()
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		if !device.expireAttempts(cooldown) {
+			continue
+		}
+
+		wasLocked := false
+		err := doSafeUpdate(context.Background(), deviceKey(device.Username, device.Address), false, func(gr *clientv3.GetResponse) (string, error) {
+			if len(gr.Kvs) != 1 {
+				return "", errors.New("device not found")
+			}
+
+			var d Device
+			if err := json.Unmarshal(gr.Kvs[0].Value, &d); err != nil {
+				return "", err
+			}
+
+			locked := d.LockoutAttempts() >= lockout
+			d.expireAttempts(cooldown)
+			wasLocked = locked && d.LockoutAttempts() < lockout
+
+			b, _ := json.Marshal(d)
+			return string(b), nil
+		})
+		if err != nil {
+			log.Println("unable to expire lockout of device", device.Address, "err:", err)
+			continue
+		}
+
+		if wasLocked {
+			Audit(AuditLockoutExpired, "wag", device.Address, device.Username, fmt.Sprintf("unlocked after %s cooldown", cooldown))
+		}
+	}
+
+	return nil
+}
+
+// expireAttempts resets counters with no attempts within the cooldown and returns whether any were reset.
+// The device wide total is only reset once every endpoint has been quiet for the cooldown
+func (d *Device) expireAttempts(cooldown time.Duration) (changed bool) {
+	stale := func(attempts int, last time.Time) bool {
+		return attempts > 0 && !last.IsZero() && time.Since(last) > cooldown
+	}
+
+	if stale(d.TotalAttempts, d.lastAttempt()) {
+		d.TotalAttempts = 0
+		changed = true
+	}
+
+	if stale(d.Attempts, d.LastAttempt) {
+		d.Attempts = 0
+		d.LastAttempt = time.Time{}
+		changed = true
+	}
+
+	for host, counter := range d.EndpointAttempts {
+		if stale(counter.Attempts, counter.LastAttempt) {
+			delete(d.EndpointAttempts, host)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// lockoutCooldowns periodically unlocks devices whose lockout cooldown has passed, only the cluster leader does this so each unlock is audited once
+func lockoutCooldowns() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !IsLeader() {
+				continue
+			}
+
+			if err := expireLockouts(); err != nil {
+				log.Println("unable to expire device lockouts: ", err)
+			}
+		case <-exit:
+			return
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestAttemptDelay(t *testing.T) {
+	for attempts, want := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		if got := attemptDelay(attempts, time.Second); got != want {
+			t.Fatalf("delay after %d attempts should be %s not %s", attempts, want, got)
+		}
+	}
+
+	if got := attemptDelay(100, time.Second); got != maxAttemptDelay {
+		t.Fatal("delay should be capped: ", got)
+	}
+
+	if got := attemptDelay(3, 0); got != 0 {
+		t.Fatal("a base of 0 should disable the delay: ", got)
+	}
+}
+
+func switchEndpoint(t *testing.T, device *Device, endpoint *net.UDPAddr, lockout int) {
+	if err := device.switchEndpoint(endpoint, lockout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointAttempts(t *testing.T) {
+	const lockout = 10
+
+	home := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820}
+	elsewhere := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 4000}
+
+	device := Device{Endpoint: home}
+
+	switchEndpoint(t, &device, elsewhere, lockout)
+	device.Attempts = 5
+	device.LastAttempt = time.Now()
+
+	switchEndpoint(t, &device, home, lockout)
+	if device.Attempts != 0 {
+		t.Fatal("attempts from another endpoint should not count against the endpoint: ", device.Attempts)
+	}
+
+	home.Port = 51821
+	switchEndpoint(t, &device, home, lockout)
+	if device.Attempts != 0 || len(device.EndpointAttempts) != 1 {
+		t.Fatal("a port change should not switch counters: ", device)
+	}
+
+	switchEndpoint(t, &device, elsewhere, lockout)
+	if device.Attempts != 5 || len(device.EndpointAttempts) != 0 {
+		t.Fatal("attempts should be restored when the endpoint returns: ", device)
+	}
+}
+
+func TestLockedEndpointsKept(t *testing.T) {
+	const lockout = 5
+
+	device := Device{
+		Endpoint:         &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820},
+		Attempts:         lockout,
+		LastAttempt:      time.Now(),
+		TotalAttempts:    lockout,
+		EndpointAttempts: map[string]AttemptCounter{},
+	}
+
+	for i := 0; i < maxEndpointCounters; i++ {
+		device.EndpointAttempts[fmt.Sprintf("198.51.100.%d", i)] = AttemptCounter{Attempts: lockout, LastAttempt: time.Now().Add(-time.Hour)}
+	}
+
+	if err := device.switchEndpoint(&net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 4000}, lockout); err == nil {
+		t.Fatal("a new endpoint should be refused rather than dropping a locked out counter")
+	}
+
+	if len(device.EndpointAttempts) != maxEndpointCounters || device.Attempts != lockout || endpointHost(device.Endpoint) != "192.0.2.1" {
+		t.Fatal("a refused switch should not change the device: ", device)
+	}
+}
+
+func TestEndpointRotationLockout(t *testing.T) {
+	if _, err := CreateUserDataAccount("rotating_user"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("rotating_user")
+
+	key, _ := wgtypes.GenerateKey()
+	device, err := AddDevice("rotating_user", key.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lockout, err := GetLockout()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// More endpoints than there are counters, each only failing once
+	for i := 0; i < maxEndpointCounters+4; i++ {
+		endpoint := &net.UDPAddr{IP: net.IPv4(198, 51, 100, byte(i+1)), Port: 4000 + i}
+		if err := UpdateDeviceEndpoint(device.Address, endpoint); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := IncrementAuthenticationAttempt("rotating_user", device.Address); err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, attempts, _, err := GetAuthenticationDetails("rotating_user", device.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i+1 >= lockout && attempts < lockout {
+			t.Fatalf("device should be locked after %d attempts from different endpoints, has %d", i+1, attempts)
+		}
+
+		if attempts > lockout+1 {
+			t.Fatal("attempts should stop counting once the device is locked: ", attempts)
+		}
+	}
+
+	d, err := GetDevice("rotating_user", device.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.EndpointAttempts) > maxEndpointCounters {
+		t.Fatal("endpoint counters should be bounded: ", len(d.EndpointAttempts))
+	}
+
+	if err := SetDeviceAuthenticationAttempts("rotating_user", device.Address, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if d, _ := GetDevice("rotating_user", device.Address); d.LockoutAttempts() != 0 || len(d.EndpointAttempts) != 0 {
+		t.Fatal("unlocking should reset every counter: ", d)
+	}
+}
+
+func setDevice(t *testing.T, username, address string, f func(d *Device)) {
+	err := doSafeUpdate(context.Background(), deviceKey(username, address), false, func(gr *clientv3.GetResponse) (string, error) {
+		var d Device
+		if err := json.Unmarshal(gr.Kvs[0].Value, &d); err != nil {
+			return "", err
+		}
+
+		f(&d)
+
+		b, _ := json.Marshal(d)
+		return string(b), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockoutCooldown(t *testing.T) {
+	if _, err := CreateUserDataAccount("lockout_user"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("lockout_user")
+
+	first, _ := wgtypes.GenerateKey()
+	second, _ := wgtypes.GenerateKey()
+
+	locked, err := AddDevice("lockout_user", first.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminLocked, err := AddDevice("lockout_user", second.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lockout, err := GetLockout()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetLockoutCooldown(10); err != nil {
+		t.Fatal(err)
+	}
+	defer SetLockoutCooldown(0)
+
+	setDevice(t, "lockout_user", locked.Address, func(d *Device) {
+		d.Attempts = lockout + 1
+		d.LastAttempt = time.Now().Add(-11 * time.Minute)
+	})
+
+	if err := SetDeviceAuthenticationAttempts("lockout_user", adminLocked.Address, lockout+1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := expireLockouts(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d, err := GetDevice("lockout_user", locked.Address); err != nil || d.Attempts != 0 {
+		t.Fatal("device should have been unlocked after the cooldown: ", d, err)
+	}
+
+	if d, err := GetDevice("lockout_user", adminLocked.Address); err != nil || d.Attempts != lockout+1 {
+		t.Fatal("devices locked by an administrator should stay locked: ", d, err)
+	}
+}
+
+func TestAttemptDelayEnforced(t *testing.T) {
+	if _, err := CreateUserDataAccount("delay_user"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("delay_user")
+
+	key, _ := wgtypes.GenerateKey()
+	device, err := AddDevice("delay_user", key.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetAttemptDelay(60); err != nil {
+		t.Fatal(err)
+	}
+	defer SetAttemptDelay(0)
+
+	if err := IncrementAuthenticationAttempt("delay_user", device.Address); err != nil {
+		t.Fatal("first attempt should not be delayed: ", err)
+	}
+
+	if err := IncrementAuthenticationAttempt("delay_user", device.Address); err == nil {
+		t.Fatal("second attempt straight after should be delayed")
+	}
+
+	if d, err := GetDevice("delay_user", device.Address); err != nil || d.Attempts != 1 {
+		t.Fatal("delayed attempts should not be counted: ", d, err)
+	}
+}
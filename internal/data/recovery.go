@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	NumRecoveryCodes = 10
+
+	// 10 bytes of randomness, which base32 encodes to exactly 16 characters
+	recoveryCodeBytes = 10
+)
+
+func hashRecoveryCode(code string, salt []byte) []byte {
+	return argon2.IDKey([]byte(normaliseRecoveryCode(code)), salt, 1, 10*1024, 4, 32)
+}
+
+// Codes are displayed in groups of four, but users will type them in all sorts of ways
+func normaliseRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+// GenerateRecoveryCodes replaces all of the users recovery codes with new ones, only the hashes are stored so the returned codes cannot be retrieved again
+func GenerateRecoveryCodes(username string) (codes []string, err error) {
+
+	var hashes []string
+	for i := 0; i < NumRecoveryCodes; i++ {
+		random := make([]byte, recoveryCodeBytes)
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, err
+		}
+
+		code := base32.StdEncoding.EncodeToString(random)
+		codes = append(codes, code[0:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:16])
+
+		salt, err := generateSalt()
+		if err != nil {
+			return nil, err
+		}
+
+		hashes = append(hashes, base64.RawStdEncoding.EncodeToString(append(hashRecoveryCode(code, salt), salt...)))
+	}
+
+	err = doSafeUpdate(context.Background(), "users-"+username+"-", false, func(gr *clientv3.GetResponse) (string, error) {
+		var result UserModel
+		err := json.Unmarshal(gr.Kvs[0].Value, &result)
+		if err != nil {
+			return "", err
+		}
+
+		result.RecoveryCodes = hashes
+
+		b, _ := json.Marshal(result)
+
+		return string(b), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode checks the code against the users remaining recovery codes, and if it matches removes it so it cannot be used again
+func UseRecoveryCode(username, code string) error {
+	return doSafeUpdate(context.Background(), "users-"+username+"-", false, func(gr *clientv3.GetResponse) (string, error) {
+		var result UserModel
+		err := json.Unmarshal(gr.Kvs[0].Value, &result)
+		if err != nil {
+			return "", err
+		}
+
+		// Check every code so the time taken doesnt show how many codes are left
+		matched := -1
+		for i, stored := range result.RecoveryCodes {
+			rawHashSalt, err := base64.RawStdEncoding.DecodeString(stored)
+			if err != nil || len(rawHashSalt) <= 16 {
+				continue
+			}
+
+			thisHash := hashRecoveryCode(code, rawHashSalt[len(rawHashSalt)-16:])
+			if subtle.ConstantTimeCompare(thisHash, rawHashSalt[:len(rawHashSalt)-16]) == 1 {
+				matched = i
+			}
+		}
+
+		if matched == -1 {
+			return "", errors.New("recovery code did not match")
+		}
+
+		result.RecoveryCodes = append(result.RecoveryCodes[:matched], result.RecoveryCodes[matched+1:]...)
+
+		b, _ := json.Marshal(result)
+
+		return string(b), nil
+	})
+}
+
+func GetNumberOfRecoveryCodes(username string) (int, error) {
+	user, err := GetUserData(username)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(user.RecoveryCodes), nil
+}
+
+func DeleteRecoveryCodes(username string) error {
+	return doSafeUpdate(context.Background(), "users-"+username+"-", false, func(gr *clientv3.GetResponse) (string, error) {
+		var result UserModel
+		err := json.Unmarshal(gr.Kvs[0].Value, &result)
+		if err != nil {
+			return "", err
+		}
+
+		result.RecoveryCodes = nil
+
+		b, _ := json.Marshal(result)
+
+		return string(b), nil
+	})
+}
@@ -19,7 +19,7 @@ func restrationKey(token string) string {
 	return fmt.Sprintf("tokens-%s", token)
 }
 
-func GetRegistrationToken(token string) (username, overwrites string, group []string, err error) {
+func GetRegistrationToken(token string) (username, overwrites string, group []string, accountExpires time.Time, err error) {
 
 	minTime := time.After(1 * time.Second)
 
@@ -42,7 +42,12 @@ func GetRegistrationToken(token string) (username, overwrites string, group []st
 		return
 	}
 
-	return result.Username, result.Overwrites, result.Groups, nil
+	if result.NumUses <= 0 {
+		err = errors.New("registration token has expired")
+		return
+	}
+
+	return result.Username, result.Overwrites, result.Groups, result.AccountExpires, nil
 }
 
 // Returns list of tokens
@@ -76,9 +81,10 @@ func DeleteRegistrationToken(identifier string) error {
 }
 
 // FinaliseRegistration may or may not delete the token in question depending on whether the number of uses is <= 0
+// The decremented use count is always written first, so that watchers (i.e event sinks) can tell a used token apart from one that was deleted
 func FinaliseRegistration(token string) error {
 
-	errVal := errors.New("registration token has expired")
+	remaining := 0
 	err := doSafeUpdate(context.Background(), "tokens-"+token, false, func(gr *clientv3.GetResponse) (string, error) {
 
 		var result control.RegistrationResult
@@ -88,40 +94,38 @@ func FinaliseRegistration(token string) error {
 		}
 
 		result.NumUses--
-
-		if result.NumUses <= 0 {
-			err = errVal
-		}
+		remaining = result.NumUses
 
 		b, _ := json.Marshal(result)
 
-		return string(b), err
+		return string(b), nil
 	})
 
-	if err == errVal {
-		return DeleteRegistrationToken(token)
-	}
-
 	if err != nil {
 		return err
 	}
 
+	if remaining <= 0 {
+		return DeleteRegistrationToken(token)
+	}
+
 	return nil
 }
 
 // Randomly generate a token for a specific username
-func GenerateToken(username, overwrite string, groups []string, uses int) (token string, err error) {
+func GenerateToken(username, overwrite string, groups []string, uses int, accountExpires time.Time) (token string, err error) {
 	token, err = generateRandomBytes(32)
 	if err != nil {
 		return "", err
 	}
 
-	err = AddRegistrationToken(token, username, overwrite, groups, uses)
+	err = AddRegistrationToken(token, username, overwrite, groups, uses, accountExpires)
 	return
 }
 
-// Add a token to the database to add or overwrite a device for a user, may fail of the token does not meet complexity requirements
-func AddRegistrationToken(token, username, overwrite string, groups []string, uses int) error {
+// Add a token to the database to add or overwrite a device for a user, may fail of the token does not meet complexity requirements.
+// If accountExpires is set it becomes the expiry of the users account when the token is used
+func AddRegistrationToken(token, username, overwrite string, groups []string, uses int, accountExpires time.Time) error {
 	if len(token) < 32 {
 		return errors.New("registration token is too short")
 	}
@@ -153,6 +157,8 @@ func AddRegistrationToken(token, username, overwrite string, groups []string, us
 		Overwrites: overwrite,
 		Groups:     groups,
 		NumUses:    uses,
+
+		AccountExpires: accountExpires,
 	}
 
 	b, _ := json.Marshal(result)
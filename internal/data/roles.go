@@ -0,0 +1,145 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type AdminRole string
+
+const (
+	RoleSuperAdmin   AdminRole = "superadmin"
+	RolePolicyEditor AdminRole = "policy_editor"
+	RoleHelpdesk     AdminRole = "helpdesk"
+	RoleAuditor      AdminRole = "auditor"
+)
+
+var AdminRoles = []AdminRole{RoleSuperAdmin, RolePolicyEditor, RoleHelpdesk, RoleAuditor}
+
+// Permission is an area of the management ui and control api an administrator may change, every role can view everything except secrets
+type Permission string
+
+const (
+	PermissionView Permission = "view"
+	// Lock and unlock users and devices
+	PermissionLockAccounts Permission = "lock_accounts"
+	// Reset mfa, remove webauthn keys and generate recovery codes
+	PermissionResetMFA       Permission = "reset_mfa"
+	PermissionRegistrations  Permission = "registrations"
+	PermissionDeleteAccounts Permission = "delete_accounts"
+	// Policies and groups
+	PermissionPolicies Permission = "policies"
+	// Approve, deny and revoke just in time access requests
+	PermissionAccessRequests Permission = "access_requests"
+	PermissionSettings       Permission = "settings"
+	PermissionCluster        Permission = "cluster"
+	// Administrators, api tokens, backups, encryption keys and shutting down wag
+	PermissionAdministration Permission = "administration"
+)
+
+var rolePermissions = map[AdminRole][]Permission{
+	RoleSuperAdmin: {PermissionView, PermissionLockAccounts, PermissionResetMFA, PermissionRegistrations, PermissionDeleteAccounts,
+		PermissionPolicies, PermissionAccessRequests, PermissionSettings, PermissionCluster, PermissionAdministration},
+	RolePolicyEditor: {PermissionView, PermissionPolicies, PermissionAccessRequests},
+	RoleHelpdesk:     {PermissionView, PermissionLockAccounts, PermissionResetMFA, PermissionRegistrations},
+	RoleAuditor:      {PermissionView},
+}
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+func ValidateAdminRole(role AdminRole, groups []string) error {
+	if _, ok := rolePermissions[role]; !ok {
+		roles := []string{}
+		for _, r := range AdminRoles {
+			roles = append(roles, string(r))
+		}
+		return fmt.Errorf("unknown role %q, roles are: %s", role, strings.Join(roles, ", "))
+	}
+
+	if role == RoleSuperAdmin && len(groups) > 0 {
+		return errors.New("superadmins cannot be limited to groups")
+	}
+
+	for _, group := range groups {
+		if !strings.HasPrefix(group, "group:") {
+			return fmt.Errorf("group %q must start with group:", group)
+		}
+	}
+
+	return nil
+}
+
+// EffectiveRole is the role of the administrator, admins created before roles existed are superadmins
+func (a AdminModel) EffectiveRole() AdminRole {
+	if a.Role == "" {
+		return RoleSuperAdmin
+	}
+
+	return a.Role
+}
+
+func (a AdminModel) Can(permission Permission) bool {
+	return slices.Contains(rolePermissions[a.EffectiveRole()], permission)
+}
+
+// Scoped reports whether the administrator may only act on members of some groups
+func (a AdminModel) Scoped() bool {
+	return len(a.Groups) > 0
+}
+
+// InScope reports whether group is one the administrator may act on
+func (a AdminModel) InScope(group string) bool {
+	return !a.Scoped() || slices.Contains(a.Groups, group)
+}
+
+// CanActOnUser reports whether username is a member of one of the groups the administrator is limited to
+func (a AdminModel) CanActOnUser(username string) (bool, error) {
+	if !a.Scoped() {
+		return true, nil
+	}
+
+	groups, err := GetUserGroupMembership(username)
+	if err != nil {
+		return false, err
+	}
+
+	for _, group := range groups {
+		if slices.Contains(a.Groups, group) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func SetAdminRole(username string, role AdminRole, groups []string) error {
+	if err := ValidateAdminRole(role, groups); err != nil {
+		return err
+	}
+
+	return doSafeUpdate(context.Background(), "admin-users-"+username, false, func(gr *clientv3.GetResponse) (value string, err error) {
+
+		if len(gr.Kvs) != 1 {
+			return "", errors.New("invalid number of admin users")
+		}
+
+		var admin admin
+		err = json.Unmarshal(gr.Kvs[0].Value, &admin)
+		if err != nil {
+			return "", err
+		}
+
+		admin.Role = role
+		admin.Groups = groups
+
+		b, _ := json.Marshal(admin)
+
+		return string(b), nil
+	})
+}
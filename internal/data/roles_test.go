@@ -0,0 +1,80 @@
+package data
+
+import "testing"
+
+func TestAdminRoles(t *testing.T) {
+	legacy := AdminModel{Username: "legacy"}
+	if legacy.EffectiveRole() != RoleSuperAdmin || !legacy.Can(PermissionAdministration) {
+		t.Fatal("admins without a role should be superadmins")
+	}
+
+	auditor := AdminModel{Role: RoleAuditor}
+	if !auditor.Can(PermissionView) || auditor.Can(PermissionLockAccounts) || auditor.Can(PermissionSettings) {
+		t.Fatal("auditors should only be able to view")
+	}
+
+	helpdesk := AdminModel{Role: RoleHelpdesk}
+	if !helpdesk.Can(PermissionLockAccounts) || !helpdesk.Can(PermissionResetMFA) || !helpdesk.Can(PermissionRegistrations) ||
+		helpdesk.Can(PermissionDeleteAccounts) || helpdesk.Can(PermissionPolicies) {
+		t.Fatal("helpdesk has the wrong permissions")
+	}
+
+	if err := ValidateAdminRole("overlord", nil); err == nil {
+		t.Fatal("unknown roles should be rejected")
+	}
+
+	if err := ValidateAdminRole(RoleSuperAdmin, []string{"group:a"}); err == nil {
+		t.Fatal("superadmins should not be scoped")
+	}
+
+	if err := ValidateAdminRole(RoleHelpdesk, []string{"a"}); err == nil {
+		t.Fatal("groups without the group: prefix should be rejected")
+	}
+}
+
+func TestAdminGroupScope(t *testing.T) {
+	if _, err := CreateUserDataAccount("scoped_target"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("scoped_target")
+
+	if err := SetGroup("group:helpdesk_scope", []string{"scoped_target"}, false); err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveGroup("group:helpdesk_scope")
+
+	if err := CreateAdminUserWithRole("scoped_admin", "a very long password that is fine", false, RoleHelpdesk, []string{"group:helpdesk_scope"}); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteAdminUser("scoped_admin")
+
+	admin, err := GetAdminUser("scoped_admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if admin.EffectiveRole() != RoleHelpdesk || !admin.Scoped() || !admin.InScope("group:helpdesk_scope") || admin.InScope("group:other") {
+		t.Fatal("role and groups were not stored: ", admin)
+	}
+
+	if ok, err := admin.CanActOnUser("scoped_target"); err != nil || !ok {
+		t.Fatal("admin should be able to act on members of their groups: ", err)
+	}
+
+	if ok, err := admin.CanActOnUser("not_in_scope"); err != nil || ok {
+		t.Fatal("admin should not be able to act on users outside their groups: ", err)
+	}
+
+	if err := SetAdminRole("scoped_admin", RoleSuperAdmin, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	admin, err = GetAdminUser("scoped_admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := admin.CanActOnUser("not_in_scope"); !ok || admin.EffectiveRole() != RoleSuperAdmin {
+		t.Fatal("unscoped admins should be able to act on anyone")
+	}
+}
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func SetSessionExtension(minutes int) error {
+	if minutes < 0 {
+		return errors.New("session extension cannot be negative")
+	}
+
+	data, _ := json.Marshal(minutes)
+	_, err := etcd.Put(context.Background(), SessionExtensionKey, string(data))
+	return err
+}
+
+// GetSessionExtension is how long before its session reaches the maximum lifetime a device may re-authenticate to extend it, 0 means sessions cannot be extended
+func GetSessionExtension() (time.Duration, error) {
+	minutes, err := getInt(SessionExtensionKey)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+// canExtendSession is true if the session of the device is active, has a maximum lifetime, and ends within the extension window
+func canExtendSession(device Device, lifetimeMinutes int, window time.Duration) bool {
+	if window <= 0 || lifetimeMinutes <= 0 || device.Authorised.IsZero() {
+		return false
+	}
+
+	remaining := time.Until(device.Authorised.Add(time.Duration(lifetimeMinutes) * time.Minute))
+
+	return remaining > 0 && remaining <= window
+}
+
+// CanExtendSession returns whether the device may re-authenticate now to extend its session in place
+func CanExtendSession(address string) (bool, error) {
+	window, err := GetSessionExtension()
+	if err != nil {
+		return false, err
+	}
+
+	if window == 0 {
+		return false, nil
+	}
+
+	lifetime, err := GetSessionLifetimeMinutes()
+	if err != nil {
+		return false, err
+	}
+
+	device, err := GetDeviceByAddress(address)
+	if err != nil {
+		return false, err
+	}
+
+	return canExtendSession(device, lifetime, window), nil
+}
+
+// ExtendDeviceSession restarts the session of an authorised device without deauthenticating it first, so its connections carry on.
+// Step up factors are cleared as only the primary factor has been proven again, they must be completed again in the new session
+func ExtendDeviceSession(username, address string) error {
+	window, err := GetSessionExtension()
+	if err != nil {
+		return err
+	}
+
+	lifetime, err := GetSessionLifetimeMinutes()
+	if err != nil {
+		return err
+	}
+
+	var lastSeen time.Time
+	err = doSafeUpdate(context.Background(), deviceKey(username, address), false, func(gr *clientv3.GetResponse) (string, error) {
+		if len(gr.Kvs) != 1 {
+			return "", errors.New("user device has multiple keys")
+		}
+
+		var device Device
+		err := json.Unmarshal(gr.Kvs[0].Value, &device)
+		if err != nil {
+			return "", err
+		}
+
+		if !canExtendSession(device, lifetime, window) {
+			return "", errors.New("session cannot be extended")
+		}
+
+		u, err := GetUserData(device.Username)
+		if err != nil {
+			return "", err
+		}
+
+		if u.Locked {
+			return "", errors.New("account is locked")
+		}
+
+		if u.Expired() {
+			return "", errors.New("account has expired")
+		}
+
+		lastSeen = u.LastSeen
+
+		device.Authorised = time.Now()
+		device.Attempts = 0
+		device.TotalAttempts = 0
+		device.LastAttempt = time.Time{}
+		device.StepUpFactors = nil
+
+		b, _ := json.Marshal(device)
+
+		return string(b), err
+	})
+	if err != nil {
+		return err
+	}
+
+	if time.Since(lastSeen) > lastSeenResolution {
+		if err := setUserLastSeen(username); err != nil {
+			log.Println("unable to update last seen time for", username, "err:", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestSessionExtension(t *testing.T) {
+	if _, err := CreateUserDataAccount("extension_user"); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteUser("extension_user")
+
+	key, _ := wgtypes.GenerateKey()
+	device, err := AddDevice("extension_user", key.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteDevice("extension_user", device.Address)
+
+	lifetime, err := GetSessionLifetimeMinutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetSessionLifetimeMinutes(60); err != nil {
+		t.Fatal(err)
+	}
+	defer SetSessionLifetimeMinutes(lifetime)
+
+	if err := SetSessionExtension(10); err != nil {
+		t.Fatal(err)
+	}
+	defer SetSessionExtension(0)
+
+	if err := ExtendDeviceSession("extension_user", device.Address); err == nil {
+		t.Fatal("a device without a session should not be able to extend it")
+	}
+
+	started := time.Now().Add(-55 * time.Minute)
+	setDevice(t, "extension_user", device.Address, func(d *Device) {
+		d.Authorised = time.Now().Add(-30 * time.Minute)
+		d.StepUpFactors = []string{"webauthn"}
+	})
+
+	if ok, err := CanExtendSession(device.Address); err != nil || ok {
+		t.Fatal("sessions should only be extendable close to their end: ", ok, err)
+	}
+
+	if err := ExtendDeviceSession("extension_user", device.Address); err == nil {
+		t.Fatal("extension outside of the window should be refused")
+	}
+
+	setDevice(t, "extension_user", device.Address, func(d *Device) {
+		d.Authorised = started
+	})
+
+	if ok, err := CanExtendSession(device.Address); err != nil || !ok {
+		t.Fatal("session should be extendable within the window: ", ok, err)
+	}
+
+	if err := ExtendDeviceSession("extension_user", device.Address); err != nil {
+		t.Fatal(err)
+	}
+
+	extended, err := GetDeviceByAddress(device.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !extended.Authorised.After(started.Add(time.Minute)) {
+		t.Fatal("extension should restart the session: ", extended.Authorised)
+	}
+
+	if len(extended.StepUpFactors) != 0 {
+		t.Fatal("extension should clear the step up factors of the session, they were not proven again: ", extended.StepUpFactors)
+	}
+
+	if err := SetSessionExtension(0); err != nil {
+		t.Fatal(err)
+	}
+
+	setDevice(t, "extension_user", device.Address, func(d *Device) {
+		d.Authorised = started
+	})
+
+	if ok, _ := CanExtendSession(device.Address); ok {
+		t.Fatal("an extension window of 0 should disable extension")
+	}
+}
@@ -0,0 +1,157 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SettingNames are the individual settings that can be changed with UpdateSetting, lists are ',' delimited
+var SettingNames = []string{
+	"help_mail",
+	"external_address",
+	"dns",
+	"wireguard_config_filename",
+	"check_updates",
+	"session_inactivity_timeout",
+	"max_session_lifetime",
+	"session_extension",
+	"lockout",
+	"lockout_cooldown",
+	"attempt_delay",
+	"default_mfa_method",
+	"enabled_mfa_methods",
+	"step_up_mfa_methods",
+	"domain",
+	"issuer",
+	"inactivity_days",
+	"inactivity_action",
+	"inactivity_warning_days",
+}
+
+func splitList(value string) (list []string) {
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
+
+// UpdateSetting changes a single setting, the rest of the general or login settings are validated with it so a change cannot leave them inconsistent
+func UpdateSetting(name, value string) error {
+	current, err := GetAllSettings()
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "inactivity_days", "inactivity_action", "inactivity_warning_days":
+		return updateInactivitySetting(name, value)
+	case "session_extension":
+		v, err := strconv.Atoi(value)
+		if err != nil || v < 0 {
+			return fmt.Errorf("%s must be a whole number of minutes, 0 disables it", name)
+		}
+
+		return SetSessionExtension(v)
+	case "lockout_cooldown", "attempt_delay":
+		v, err := strconv.Atoi(value)
+		if err != nil || v < 0 {
+			return fmt.Errorf("%s must be a whole number, 0 disables it", name)
+		}
+
+		if name == "lockout_cooldown" {
+			return SetLockoutCooldown(v)
+		}
+		return SetAttemptDelay(v)
+	}
+
+	general := current.GeneralSettings
+	login := current.LoginSettings
+
+	minutes := func(target *int) error {
+		v, err := strconv.Atoi(value)
+		if err != nil || v < 1 {
+			return fmt.Errorf("%s must be a whole number of minutes above 0", name)
+		}
+		*target = v
+		return nil
+	}
+
+	switch name {
+	case "help_mail":
+		general.HelpMail = value
+	case "external_address":
+		general.ExternalAddress = value
+	case "dns":
+		general.DNS = splitList(value)
+	case "wireguard_config_filename":
+		general.WireguardConfigFilename = value
+	case "check_updates":
+		general.CheckUpdates, err = strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("check_updates must be true or false")
+		}
+
+	case "session_inactivity_timeout":
+		if err := minutes(&login.SessionInactivityTimeoutMinutes); err != nil {
+			return err
+		}
+	case "max_session_lifetime":
+		if err := minutes(&login.MaxSessionLifetimeMinutes); err != nil {
+			return err
+		}
+	case "lockout":
+		login.Lockout, err = strconv.Atoi(value)
+		if err != nil || login.Lockout < 1 {
+			return errors.New("cannot set lockout to be below 1 as all accounts would be locked out")
+		}
+	case "default_mfa_method":
+		login.DefaultMFAMethod = value
+	case "enabled_mfa_methods":
+		login.EnabledMFAMethods = splitList(value)
+	case "step_up_mfa_methods":
+		login.StepUpMFAMethods = splitList(value)
+	case "domain":
+		login.Domain = value
+	case "issuer":
+		login.Issuer = value
+	default:
+		return fmt.Errorf("unknown setting %q, settings are: %s", name, strings.Join(SettingNames, ", "))
+	}
+
+	switch name {
+	case "help_mail", "external_address", "dns", "wireguard_config_filename", "check_updates":
+		return SetGeneralSettings(general)
+	}
+
+	return SetLoginSettings(login)
+}
+
+func updateInactivitySetting(name, value string) error {
+	policy, err := GetInactivityPolicy()
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "inactivity_days", "inactivity_warning_days":
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return fmt.Errorf("%s must be a whole number of days, 0 disables", name)
+		}
+
+		if name == "inactivity_days" {
+			policy.Days = days
+		} else {
+			policy.WarningDays = days
+		}
+	case "inactivity_action":
+		policy.Action = value
+	}
+
+	return SetInactivityPolicy(policy)
+}
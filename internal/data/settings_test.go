@@ -0,0 +1,43 @@
+package data
+
+import "testing"
+
+func TestUpdateSetting(t *testing.T) {
+	original := GetHelpMail()
+	t.Cleanup(func() {
+		UpdateSetting("help_mail", original)
+	})
+
+	if err := UpdateSetting("help_mail", "admin@example.com"); err != nil {
+		t.Fatal("unable to update help mail: ", err)
+	}
+
+	if GetHelpMail() != "admin@example.com" {
+		t.Fatal("help mail was not updated: ", GetHelpMail())
+	}
+
+	if err := UpdateSetting("help_mail", "not an email"); err == nil {
+		t.Fatal("invalid help mail should be rejected")
+	}
+
+	if GetHelpMail() != "admin@example.com" {
+		t.Fatal("rejected change should not have been written: ", GetHelpMail())
+	}
+
+	if err := UpdateSetting("dns", "1.1.1.1, 8.8.8.8"); err != nil {
+		t.Fatal("unable to update dns: ", err)
+	}
+
+	dns, err := GetDNS()
+	if err != nil || len(dns) != 2 || dns[1] != "8.8.8.8" {
+		t.Fatal("dns was not split into a list: ", dns, err)
+	}
+
+	if err := UpdateSetting("lockout", "0"); err == nil {
+		t.Fatal("lockout below 1 should be rejected")
+	}
+
+	if err := UpdateSetting("nonsense", "1"); err == nil {
+		t.Fatal("unknown settings should be rejected")
+	}
+}
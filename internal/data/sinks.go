@@ -0,0 +1,256 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	SinksKey = "wag-config-sinks"
+
+	SinkClaimsPrefix = "wag/sinks/claims/"
+	SinkOutboxPrefix = "wag/sinks/outbox/"
+)
+
+type SinkType string
+
+const (
+	WebhookSink SinkType = "webhook"
+	SyslogSink  SinkType = "syslog"
+	FileSink    SinkType = "file"
+)
+
+type Sink struct {
+	Name string
+	Type SinkType
+	// Event types to send, all events are sent if empty
+	Events []string `json:",omitempty"`
+
+	URL        string `json:",omitempty"`
+	Secret     string `json:",omitempty"`
+	MaxRetries int    `json:",omitempty"`
+
+	Address            string `json:",omitempty"`
+	TLS                bool   `json:",omitempty"`
+	InsecureSkipVerify bool   `json:",omitempty"`
+	CACertificate      string `json:",omitempty"`
+
+	Path string `json:",omitempty"`
+}
+
+// SinkEvent is what is sent to sinks, the ID is the same on every node so it can be used by receivers to deduplicate
+type SinkEvent struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Time   time.Time       `json:"time"`
+	NodeID string          `json:"node"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// PendingSinkEvent is an event that has been claimed by a node but has not yet been delivered to all of its sinks
+type PendingSinkEvent struct {
+	Event SinkEvent
+	// Names of the sinks that have not yet accepted the event
+	Sinks    []string
+	Owner    string
+	Claimed  time.Time
+	Attempts int
+
+	revision int64
+}
+
+// SinkEventID creates an id for a change to an etcd key that every node will agree on
+func SinkEventID(eventType, key string, revision int64) string {
+	hash := sha256.Sum256([]byte(eventType + "\x00" + key + "\x00" + strconv.FormatInt(revision, 10)))
+	return hex.EncodeToString(hash[:16])
+}
+
+func validateSinks(sinks []Sink) error {
+	seen := map[string]bool{}
+	for _, sink := range sinks {
+		if sink.Name == "" {
+			return errors.New("sinks must have a name")
+		}
+
+		if seen[sink.Name] {
+			return fmt.Errorf("sink name %q is not unique", sink.Name)
+		}
+		seen[sink.Name] = true
+
+		switch sink.Type {
+		case WebhookSink:
+			if sink.URL == "" {
+				return fmt.Errorf("webhook sink %q must have a url", sink.Name)
+			}
+		case SyslogSink:
+			if sink.Address == "" {
+				return fmt.Errorf("syslog sink %q must have an address", sink.Name)
+			}
+		case FileSink:
+			if sink.Path == "" {
+				return fmt.Errorf("file sink %q must have a path", sink.Name)
+			}
+		default:
+			return fmt.Errorf("sink %q has unknown type %q", sink.Name, sink.Type)
+		}
+
+		if sink.MaxRetries < 0 {
+			return fmt.Errorf("sink %q cannot have a negative number of retries", sink.Name)
+		}
+	}
+
+	return nil
+}
+
+func SetSinks(sinks []Sink) error {
+	if err := validateSinks(sinks); err != nil {
+		return err
+	}
+
+	d, err := json.Marshal(sinks)
+	if err != nil {
+		return err
+	}
+
+	_, err = etcd.Put(context.Background(), SinksKey, string(d))
+	return err
+}
+
+func GetSinks() (sinks []Sink, err error) {
+
+	response, err := etcd.Get(context.Background(), SinksKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Kvs) == 0 {
+		return nil, nil
+	}
+
+	err = json.Unmarshal(response.Kvs[0].Value, &sinks)
+	return
+}
+
+// ClaimSinkEvent atomically marks the event as owned by this node, if another node has already claimed it false is returned and the event should not be sent.
+// The claim is kept after delivery so nodes that see the change late do not send it again, while the outbox entry is kept until every sink has accepted the event
+func ClaimSinkEvent(event SinkEvent, sinks []string) (bool, error) {
+
+	pending := PendingSinkEvent{
+		Event:   event,
+		Sinks:   sinks,
+		Owner:   GetServerID(),
+		Claimed: time.Now(),
+	}
+
+	b, err := json.Marshal(pending)
+	if err != nil {
+		return false, err
+	}
+
+	claimKey := SinkClaimsPrefix + event.ID
+
+	resp, err := etcd.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.CreateRevision(claimKey), "=", 0)).
+		Then(
+			clientv3.OpPut(claimKey, pending.Claimed.Format(time.RFC3339)),
+			clientv3.OpPut(SinkOutboxPrefix+event.ID, string(b)),
+		).Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Succeeded, nil
+}
+
+// UpdateSinkEvent records which sinks are still waiting for the event, once there are none the event is removed from the outbox
+func UpdateSinkEvent(pending PendingSinkEvent) error {
+	if len(pending.Sinks) == 0 {
+		_, err := etcd.Delete(context.Background(), SinkOutboxPrefix+pending.Event.ID)
+		return err
+	}
+
+	b, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+
+	_, err = etcd.Put(context.Background(), SinkOutboxPrefix+pending.Event.ID, string(b))
+	return err
+}
+
+// GetStaleSinkEvents returns outbox entries that were claimed longer ago than olderThan, i.e their owner failed to deliver them or died while doing so
+func GetStaleSinkEvents(olderThan time.Duration) (stale []PendingSinkEvent, err error) {
+	response, err := etcd.Get(context.Background(), SinkOutboxPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kv := range response.Kvs {
+		var pending PendingSinkEvent
+		err := json.Unmarshal(kv.Value, &pending)
+		if err != nil {
+			return nil, err
+		}
+
+		if time.Since(pending.Claimed) < olderThan {
+			continue
+		}
+
+		pending.revision = kv.ModRevision
+		stale = append(stale, pending)
+	}
+
+	return stale, nil
+}
+
+// ReclaimSinkEvent takes ownership of a stale outbox entry, failing if it has been changed since it was read
+func ReclaimSinkEvent(pending PendingSinkEvent) (PendingSinkEvent, bool, error) {
+	key := SinkOutboxPrefix + pending.Event.ID
+
+	pending.Owner = GetServerID()
+	pending.Claimed = time.Now()
+	pending.Attempts++
+
+	b, err := json.Marshal(pending)
+	if err != nil {
+		return pending, false, err
+	}
+
+	resp, err := etcd.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", pending.revision)).
+		Then(clientv3.OpPut(key, string(b))).
+		Commit()
+	if err != nil {
+		return pending, false, err
+	}
+
+	return pending, resp.Succeeded, nil
+}
+
+// PruneSinkClaims removes claims older than maxAge, by which point every node will have long since processed the change
+func PruneSinkClaims(maxAge time.Duration) error {
+	response, err := etcd.Get(context.Background(), SinkClaimsPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+
+	ops := []clientv3.Op{}
+	for _, kv := range response.Kvs {
+		claimed, err := time.Parse(time.RFC3339, string(kv.Value))
+		if err == nil && time.Since(claimed) < maxAge {
+			continue
+		}
+
+		ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+	}
+
+	return applyInBatches(ops)
+}
@@ -0,0 +1,315 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// StepUpFactor is an additional authenticator registered by a user, these are only ever used after the user has authorised with their primary mfa method
+type StepUpFactor struct {
+	Mfa       string
+	Enforcing bool
+}
+
+// MissingStepUpFactors returns which of the required step up methods the device has not completed in its current session, the users primary mfa method counts towards the requirement
+func MissingStepUpFactors(required []string, primaryMfaType string, device Device) (missing []string) {
+	for _, method := range required {
+		if method == primaryMfaType || slices.Contains(device.StepUpFactors, method) {
+			continue
+		}
+
+		missing = append(missing, method)
+	}
+
+	return
+}
+
+// stepUpGranted decides whether a user should have their step up routes installed. Routes are per user rather than per device, so every device that currently has a session must have completed step up.
+// Otherwise a device that has only done its primary authentication would inherit the step up of another device
+func stepUpGranted(required []string, user UserModel, devices []Device, sessionLifetimeMinutes int) bool {
+	if len(required) == 0 || user.Locked {
+		return false
+	}
+
+	granted := false
+	for _, device := range devices {
+		if device.Authorised.IsZero() {
+			continue
+		}
+
+		if sessionLifetimeMinutes > 0 && time.Now().After(device.Authorised.Add(time.Duration(sessionLifetimeMinutes)*time.Minute)) {
+			continue
+		}
+
+		if len(MissingStepUpFactors(required, user.MfaType, device)) != 0 {
+			return false
+		}
+
+		granted = true
+	}
+
+	return granted
+}
+
+// IsStepUpGranted reads the current step up state for a user, see stepUpGranted
+func IsStepUpGranted(username string) (bool, error) {
+	txn := etcd.Txn(context.Background())
+	resp, err := txn.Then(
+		clientv3.OpGet(StepUpMethodsKey),
+		clientv3.OpGet("users-"+username+"-"),
+		clientv3.OpGet(DevicesPrefix+username+"-", clientv3.WithPrefix()),
+		clientv3.OpGet(SessionLifetimeKey),
+	).Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return stepUpGrantedFromKvs(resp.Responses[0].GetResponseRange().Kvs, resp.Responses[1].GetResponseRange().Kvs, resp.Responses[2].GetResponseRange().Kvs, resp.Responses[3].GetResponseRange().Kvs)
+}
+
+func stepUpGrantedFromKvs(methodKvs, userKvs, deviceKvs, lifetimeKvs []*mvccpb.KeyValue) (bool, error) {
+
+	var required []string
+	if len(methodKvs) == 1 {
+		if err := json.Unmarshal(methodKvs[0].Value, &required); err != nil {
+			return false, err
+		}
+	}
+
+	if len(required) == 0 {
+		return false, nil
+	}
+
+	if len(userKvs) != 1 {
+		return false, errors.New("invalid number of users for entry")
+	}
+
+	var user UserModel
+	if err := json.Unmarshal(userKvs[0].Value, &user); err != nil {
+		return false, err
+	}
+
+	var devices []Device
+	for _, kv := range deviceKvs {
+		var device Device
+		if err := json.Unmarshal(kv.Value, &device); err != nil {
+			return false, err
+		}
+		devices = append(devices, device)
+	}
+
+	sessionLifetime := 0
+	if len(lifetimeKvs) == 1 {
+		if err := json.Unmarshal(lifetimeKvs[0].Value, &sessionLifetime); err != nil {
+			return false, err
+		}
+	}
+
+	return stepUpGranted(required, user, devices, sessionLifetime), nil
+}
+
+// SetUserStepUpMfa stores the (not yet enforced) secret for a step up authenticator
+func SetUserStepUpMfa(username, value, mfaType string) error {
+	return doSafeUpdate(context.Background(), "users-"+username+"-", false, func(gr *clientv3.GetResponse) (string, error) {
+		var result UserModel
+		err := json.Unmarshal(gr.Kvs[0].Value, &result)
+		if err != nil {
+			return "", err
+		}
+
+		if result.StepUp == nil {
+			result.StepUp = map[string]StepUpFactor{}
+		}
+
+		factor := result.StepUp[mfaType]
+		factor.Mfa = value
+		result.StepUp[mfaType] = factor
+
+		b, _ := json.Marshal(result)
+
+		return string(b), nil
+	})
+}
+
+func SetStepUpEnforcing(username, mfaType string) error {
+	return doSafeUpdate(context.Background(), "users-"+username+"-", false, func(gr *clientv3.GetResponse) (string, error) {
+		var result UserModel
+		err := json.Unmarshal(gr.Kvs[0].Value, &result)
+		if err != nil {
+			return "", err
+		}
+
+		factor, ok := result.StepUp[mfaType]
+		if !ok {
+			return "", errors.New("step up method " + mfaType + " is not registered")
+		}
+
+		factor.Enforcing = true
+		result.StepUp[mfaType] = factor
+
+		b, _ := json.Marshal(result)
+
+		return string(b), nil
+	})
+}
+
+// Has the user finished registering this step up method. Like IsEnforcingMFA this fails closed
+func IsEnforcingStepUp(username, mfaType string) bool {
+	user, err := GetUserData(username)
+	if err != nil {
+		return true
+	}
+
+	return user.StepUp[mfaType].Enforcing
+}
+
+func GetStepUpMFASecret(username, mfaType string) (string, error) {
+	user, err := GetUserData(username)
+	if err != nil {
+		return "", err
+	}
+
+	factor, ok := user.StepUp[mfaType]
+	if !ok {
+		return "", errors.New("step up method " + mfaType + " is not registered")
+	}
+
+	// Same as GetMFASecret, the webauthn "secret" is needed to login but isnt returned to the client
+	if factor.Enforcing && mfaType != "webauthn" {
+		return "", errors.New("step up MFA is set to enforcing, cannot reveal secret")
+	}
+
+	return factor.Mfa, nil
+}
+
+// ResetStepUp removes all of the users step up authenticators, and clears any step up their devices have done
+func ResetStepUp(username string) error {
+	err := doSafeUpdate(context.Background(), "users-"+username+"-", false, func(gr *clientv3.GetResponse) (string, error) {
+		var result UserModel
+		err := json.Unmarshal(gr.Kvs[0].Value, &result)
+		if err != nil {
+			return "", err
+		}
+
+		result.StepUp = nil
+
+		b, _ := json.Marshal(result)
+
+		return string(b), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	devices, err := GetDevicesByUser(username)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		if len(device.StepUpFactors) == 0 {
+			continue
+		}
+
+		err = setDeviceStepUpFactors(username, device.Address, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func GetStepUpAuthenticationDetails(username, device, mfaType string) (mfa string, attempts int, locked bool, authorised time.Time, err error) {
+
+	txn := etcd.Txn(context.Background())
+	resp, err := txn.Then(clientv3.OpGet("users-"+username+"-"), clientv3.OpGet(deviceKey(username, device))).Commit()
+	if err != nil {
+		return
+	}
+
+	if resp.Responses[0].GetResponseRange().Count != 1 {
+		err = errors.New("invalid number of user entries")
+		return
+	}
+
+	if resp.Responses[1].GetResponseRange().Count != 1 {
+		err = errors.New("invalid number of device entries")
+		return
+	}
+
+	var user UserModel
+	err = json.Unmarshal(resp.Responses[0].GetResponseRange().Kvs[0].Value, &user)
+	if err != nil {
+		return
+	}
+
+	factor, ok := user.StepUp[mfaType]
+	if !ok {
+		err = errors.New("step up method " + mfaType + " is not registered")
+		return
+	}
+
+	var deviceModel Device
+	err = json.Unmarshal(resp.Responses[1].GetResponseRange().Kvs[0].Value, &deviceModel)
+	if err != nil {
+		return
+	}
+
+	return factor.Mfa, deviceModel.LockoutAttempts(), user.Locked, deviceModel.Authorised, nil
+}
+
+// AddDeviceStepUpFactor records that the device has completed a step up method for its current session, and clears authentication attempts
+func AddDeviceStepUpFactor(username, address, mfaType string) error {
+	return doSafeUpdate(context.Background(), deviceKey(username, address), false, func(gr *clientv3.GetResponse) (string, error) {
+		if len(gr.Kvs) != 1 {
+			return "", errors.New("user device has multiple keys")
+		}
+
+		var device Device
+		err := json.Unmarshal(gr.Kvs[0].Value, &device)
+		if err != nil {
+			return "", err
+		}
+
+		if device.Authorised.IsZero() {
+			return "", errors.New("device is not authorised")
+		}
+
+		if !slices.Contains(device.StepUpFactors, mfaType) {
+			device.StepUpFactors = append(device.StepUpFactors, mfaType)
+		}
+		device.Attempts = 0
+		device.TotalAttempts = 0
+
+		b, _ := json.Marshal(device)
+
+		return string(b), err
+	})
+}
+
+func setDeviceStepUpFactors(username, address string, factors []string) error {
+	return doSafeUpdate(context.Background(), deviceKey(username, address), false, func(gr *clientv3.GetResponse) (string, error) {
+		if len(gr.Kvs) != 1 {
+			return "", errors.New("user device has multiple keys")
+		}
+
+		var device Device
+		err := json.Unmarshal(gr.Kvs[0].Value, &device)
+		if err != nil {
+			return "", err
+		}
+
+		device.StepUpFactors = factors
+
+		b, _ := json.Marshal(device)
+
+		return string(b), err
+	})
+}
@@ -24,11 +24,21 @@ type AdminModel struct {
 	LastLogin string `json:"last_login"`
 	IP        string `json:"ip"`
 	Change    bool   `json:"change"`
+
+	Role AdminRole `json:"role,omitempty"`
+	// If set the administrator can only act on users in these groups
+	Groups []string `json:"groups,omitempty"`
+
+	// Empty until the administrator has enrolled an mfa method for the management ui
+	MfaType string `json:"mfa_type,omitempty"`
+	// Created by logging in with single sign on, these administrators have no password
+	SSO bool `json:"sso,omitempty"`
 }
 
 type admin struct {
 	AdminModel
 	Hash string
+	Mfa  string `json:",omitempty"`
 }
 
 func generateSalt() ([]byte, error) {
@@ -71,6 +81,14 @@ func IncrementAdminAuthenticationAttempt(username string) error {
 }
 
 func CreateAdminUser(username, password string, changeOnFirstUse bool) error {
+	return CreateAdminUserWithRole(username, password, changeOnFirstUse, RoleSuperAdmin, nil)
+}
+
+func CreateAdminUserWithRole(username, password string, changeOnFirstUse bool, role AdminRole, groups []string) error {
+	if err := ValidateAdminRole(role, groups); err != nil {
+		return err
+	}
+
 	if len(password) < minPasswordLength {
 		return fmt.Errorf("password is too short for administrative console (must be greater than %d characters)", minPasswordLength)
 	}
@@ -87,6 +105,8 @@ func CreateAdminUser(username, password string, changeOnFirstUse bool) error {
 			Username:  username,
 			DateAdded: time.Now().Format(time.RFC3339),
 			Change:    changeOnFirstUse,
+			Role:      role,
+			Groups:    groups,
 		},
 		Hash: base64.RawStdEncoding.EncodeToString(append(hash, salt...)),
 	}
@@ -126,6 +146,11 @@ func CompareAdminKeys(username, password string) error {
 			return "", errors.New("account locked")
 		}
 
+		if result.SSO || result.Hash == "" {
+			wasteTime()
+			return "", errors.New("administrator logs in with single sign on")
+		}
+
 		rawHashSalt, err := base64.RawStdEncoding.DecodeString(result.Hash)
 		if err != nil {
 			return "", err
@@ -137,7 +162,7 @@ func CompareAdminKeys(username, password string) error {
 			return "", errors.New("passwords did not match")
 		}
 
-		result.Attempts = 0
+		// Attempts are reset by SetLastLoginInformation once mfa is also complete
 		b, _ := json.Marshal(result)
 
 		return string(b), nil
@@ -303,6 +328,7 @@ func SetLastLoginInformation(username, ip string) error {
 
 		admin.LastLogin = time.Now().Format(time.RFC3339)
 		admin.IP = ip
+		admin.Attempts = 0
 
 		b, _ := json.Marshal(admin)
 
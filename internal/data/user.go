@@ -5,7 +5,9 @@ import (
 	"crypto/sha1"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/NHAS/wag/internal/webserver/authenticators/types"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -18,6 +20,32 @@ type UserModel struct {
 	MfaType   string
 	Locked    bool
 	Enforcing bool
+
+	// Additional authenticators, by mfa type, that are used for step up authentication
+	StepUp map[string]StepUpFactor `json:",omitempty"`
+
+	// Hashed one time codes that can be used instead of the users mfa method
+	RecoveryCodes []string `json:",omitempty"`
+
+	// When the account was created, and when one of its devices last authorised
+	Created  time.Time `json:",omitempty"`
+	LastSeen time.Time `json:",omitempty"`
+
+	// The account is locked once this has passed, zero never expires
+	Expires time.Time `json:",omitempty"`
+}
+
+func (um UserModel) Expired() bool {
+	return !um.Expires.IsZero() && time.Now().After(um.Expires)
+}
+
+// LastActive is when the account last authorised a device, or was created if it never has
+func (um UserModel) LastActive() time.Time {
+	if um.LastSeen.IsZero() {
+		return um.Created
+	}
+
+	return um.LastSeen
 }
 
 func (um *UserModel) GetID() [20]byte {
@@ -43,8 +71,23 @@ func IncrementAuthenticationAttempt(username, device string) error {
 			return "", err
 		}
 
-		if userDevice.Attempts <= l {
+		delay, err := GetAttemptDelay()
+		if err != nil {
+			return "", err
+		}
+
+		// Locked devices are rejected by the caller, the delay only slows down guesses before that
+		if userDevice.LockoutAttempts() < l {
+			wait := attemptDelay(userDevice.Attempts, delay) - time.Since(userDevice.LastAttempt)
+			if wait > 0 {
+				return "", fmt.Errorf("too many attempts, try again in %s", wait.Round(time.Second))
+			}
+		}
+
+		if userDevice.LockoutAttempts() <= l {
 			userDevice.Attempts++
+			userDevice.TotalAttempts++
+			userDevice.LastAttempt = time.Now()
 		}
 
 		b, _ := json.Marshal(userDevice)
@@ -92,7 +135,7 @@ func GetAuthenticationDetails(username, device string) (mfa, mfaType string, att
 		return
 	}
 
-	attempts = deviceModel.Attempts
+	attempts = deviceModel.LockoutAttempts()
 
 	return
 }
@@ -129,6 +172,8 @@ func SetUserUnlock(username string) error {
 		}
 
 		result.Locked = false
+		// Restart the inactivity period, otherwise an account locked for inactivity would be locked again straight away
+		result.LastSeen = time.Now()
 
 		b, _ := json.Marshal(result)
 
@@ -326,6 +371,7 @@ func CreateUserDataAccount(username string) (UserModel, error) {
 		Username: username,
 		Mfa:      string(types.Unset),
 		MfaType:  string(types.Unset),
+		Created:  time.Now(),
 	}
 	b, _ := json.Marshal(&newUser)
 
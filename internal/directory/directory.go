@@ -0,0 +1,220 @@
+package directory
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultUserFilter     = "(&(objectClass=person)(uid=%s))"
+	defaultGroupAttribute = "memberOf"
+
+	// Stop runaway group nesting, or loops that arent caught for some reason
+	maxNestingDepth = 10
+)
+
+// Authenticate binds to the directory as the user with the supplied password and returns the users groups (as wag "group:<cn>" names)
+// Connections must either be ldaps:// or use StartTLS, as we send the users password in a simple bind
+func Authenticate(details data.LDAP, username, password string) (groups []string, err error) {
+	if username == "" || password == "" {
+		// An empty password is an unauthenticated bind, which most servers will happily "succeed"
+		return nil, errors.New("username and password must be set")
+	}
+
+	conn, err := dial(details)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	userDN, err := findUserDN(conn, details, username)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.Bind(userDN, password)
+	if err != nil {
+		return nil, fmt.Errorf("bind as %q failed: %w", userDN, err)
+	}
+
+	groupDNs, err := memberOf(conn, details, userDN)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dn := range groupDNs {
+		name, err := groupName(dn)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, "group:"+name)
+	}
+
+	return groups, nil
+}
+
+func dial(details data.LDAP) (*ldap.Conn, error) {
+	u, err := url.Parse(details.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap url was invalid: %w", err)
+	}
+
+	switch u.Scheme {
+	case "ldaps":
+	case "ldap":
+		if !details.StartTLS {
+			return nil, errors.New("plaintext ldap:// is not allowed, use ldaps:// or enable StartTLS")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ldap url scheme %q", u.Scheme)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: details.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if details.CACertificate != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(details.CACertificate)) {
+			return nil, errors.New("could not parse ldap ca certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	conn, err := ldap.DialURL(details.URL, ldap.DialWithTLSConfig(tlsConfig), ldap.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}))
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to ldap server: %w", err)
+	}
+
+	conn.SetTimeout(10 * time.Second)
+
+	if u.Scheme == "ldap" {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starttls failed: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+func findUserDN(conn *ldap.Conn, details data.LDAP, username string) (string, error) {
+	if details.UserDNTemplate != "" {
+		return fmt.Sprintf(details.UserDNTemplate, ldap.EscapeDN(username)), nil
+	}
+
+	if details.BindDN != "" {
+		err := conn.Bind(details.BindDN, details.BindPassword)
+		if err != nil {
+			return "", fmt.Errorf("service account bind failed: %w", err)
+		}
+	}
+
+	filter := details.UserFilter
+	if filter == "" {
+		filter = defaultUserFilter
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		details.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(filter, ldap.EscapeFilter(username)),
+		[]string{"dn"},
+		nil,
+	))
+	if err != nil {
+		return "", fmt.Errorf("user search failed: %w", err)
+	}
+
+	if len(result.Entries) != 1 {
+		return "", fmt.Errorf("user search for %q returned %d entries, expected 1", username, len(result.Entries))
+	}
+
+	return result.Entries[0].DN, nil
+}
+
+// memberOf returns the distinguished names of the groups the entry is a member of, if NestedGroups is set it will also return the groups those groups are members of
+func memberOf(conn *ldap.Conn, details data.LDAP, dn string) ([]string, error) {
+	attribute := details.GroupAttribute
+	if attribute == "" {
+		attribute = defaultGroupAttribute
+	}
+
+	seen := map[string]bool{}
+	var result []string
+
+	toVisit := []string{dn}
+	for depth := 0; len(toVisit) > 0; depth++ {
+		if depth > maxNestingDepth {
+			return nil, fmt.Errorf("group nesting is deeper than %d", maxNestingDepth)
+		}
+
+		var next []string
+		for _, current := range toVisit {
+			entry, err := conn.Search(ldap.NewSearchRequest(
+				current,
+				ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+				"(objectClass=*)",
+				[]string{attribute},
+				nil,
+			))
+			if err != nil {
+				if current != dn && ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+					// Dangling group references shouldnt stop the user from logging in
+					continue
+				}
+				return nil, fmt.Errorf("could not read %q of %q: %w", attribute, current, err)
+			}
+
+			if len(entry.Entries) != 1 {
+				continue
+			}
+
+			for _, group := range entry.Entries[0].GetAttributeValues(attribute) {
+				key := strings.ToLower(group)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				result = append(result, group)
+				next = append(next, group)
+			}
+		}
+
+		if !details.NestedGroups {
+			break
+		}
+
+		toVisit = next
+	}
+
+	return result, nil
+}
+
+// groupName takes the value of the first relative distinguished name of the group, e.g cn=admins,ou=groups,dc=example,dc=com is admins
+func groupName(dn string) (string, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return "", fmt.Errorf("group dn %q was invalid: %w", dn, err)
+	}
+
+	if len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return "", fmt.Errorf("group dn %q was empty", dn)
+	}
+
+	return parsed.RDNs[0].Attributes[0].Value, nil
+}
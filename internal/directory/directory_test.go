@@ -0,0 +1,397 @@
+package directory
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+type testEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+// testServer is a very small ldap server that supports simple binds, base/subtree searches with and/equality/present filters and StartTLS
+type testServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	caPEM     string
+
+	entries map[string]testEntry
+}
+
+var testDirectory = map[string]testEntry{
+	"cn=service,dc=wag,dc=test": {
+		password: "servicepassword",
+	},
+	"uid=alice,ou=people,dc=wag,dc=test": {
+		password: "alicepassword",
+		attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"alice"},
+			"memberOf":    {"cn=devs,ou=groups,dc=wag,dc=test", "cn=dangling,ou=groups,dc=wag,dc=test"},
+		},
+	},
+	"uid=bob,ou=people,dc=wag,dc=test": {
+		password: "bobpassword",
+		attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"bob"},
+		},
+	},
+	"cn=devs,ou=groups,dc=wag,dc=test": {
+		attributes: map[string][]string{
+			"objectClass": {"groupOfNames"},
+			"memberOf":    {"cn=engineering,ou=groups,dc=wag,dc=test"},
+		},
+	},
+	"cn=engineering,ou=groups,dc=wag,dc=test": {
+		attributes: map[string][]string{
+			"objectClass": {"groupOfNames"},
+			// Loop, should not cause issues
+			"memberOf": {"cn=devs,ou=groups,dc=wag,dc=test"},
+		},
+	},
+}
+
+func newTestServer(t *testing.T, ldaps bool) *testServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		},
+		caPEM:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		entries: map[string]testEntry{},
+	}
+
+	for dn, entry := range testDirectory {
+		s.entries[strings.ToLower(dn)] = entry
+	}
+
+	if ldaps {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		s.listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *testServer) URL(scheme string) string {
+	return scheme + "://" + s.listener.Addr().String()
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+	}()
+
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := request.Children[1].Value.(string)
+			password := request.Children[2].Data.String()
+
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if entry, ok := s.entries[strings.ToLower(dn)]; ok && entry.password != "" && entry.password == password {
+				code = ldap.LDAPResultSuccess
+				bound = true
+			}
+
+			s.write(conn, result(messageID, ldap.ApplicationBindResponse, code))
+
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				s.write(conn, result(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				continue
+			}
+
+			base := strings.ToLower(request.Children[0].Value.(string))
+			scope := request.Children[1].Value.(int64)
+			filter := request.Children[6]
+
+			var attributes []string
+			for _, attribute := range request.Children[7].Children {
+				attributes = append(attributes, attribute.Value.(string))
+			}
+
+			code := uint16(ldap.LDAPResultSuccess)
+			if scope == ldap.ScopeBaseObject {
+				entry, ok := s.entries[base]
+				if !ok {
+					code = ldap.LDAPResultNoSuchObject
+				} else if matches(filter, entry) {
+					s.write(conn, searchEntry(messageID, base, entry, attributes))
+				}
+			} else {
+				for dn, entry := range s.entries {
+					if strings.HasSuffix(dn, base) && matches(filter, entry) {
+						s.write(conn, searchEntry(messageID, dn, entry, attributes))
+					}
+				}
+			}
+
+			s.write(conn, result(messageID, ldap.ApplicationSearchResultDone, code))
+
+		case ldap.ApplicationExtendedRequest:
+			if request.Children[0].Data.String() != "1.3.6.1.4.1.1466.20037" {
+				s.write(conn, result(messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError))
+				continue
+			}
+
+			s.write(conn, result(messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+			conn = tls.Server(conn, s.tlsConfig)
+
+		default:
+			return
+		}
+	}
+}
+
+func (s *testServer) write(conn net.Conn, packet *ber.Packet) {
+	conn.Write(packet.Bytes())
+}
+
+func envelope(messageID int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+	packet.AppendChild(op)
+	return packet
+}
+
+func result(messageID int64, tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+
+	return envelope(messageID, op)
+}
+
+func searchEntry(messageID int64, dn string, entry testEntry, attributes []string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+
+	attributesPacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range entry.attributes {
+		if !slices.ContainsFunc(attributes, func(s string) bool { return strings.EqualFold(s, name) }) {
+			continue
+		}
+
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		}
+		attribute.AppendChild(set)
+
+		attributesPacket.AppendChild(attribute)
+	}
+	op.AppendChild(attributesPacket)
+
+	return envelope(messageID, op)
+}
+
+func matches(filter *ber.Packet, entry testEntry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterEqualityMatch:
+		attribute := filter.Children[0].Data.String()
+		value := filter.Children[1].Data.String()
+		for name, values := range entry.attributes {
+			if strings.EqualFold(name, attribute) && slices.Contains(values, value) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		attribute := filter.Data.String()
+		if strings.EqualFold(attribute, "objectClass") {
+			return true
+		}
+		_, ok := entry.attributes[attribute]
+		return ok
+	}
+
+	return false
+}
+
+func TestAuthenticateLDAPS(t *testing.T) {
+	server := newTestServer(t, true)
+
+	details := data.LDAP{
+		URL:           server.URL("ldaps"),
+		CACertificate: server.caPEM,
+		BindDN:        "cn=service,dc=wag,dc=test",
+		BindPassword:  "servicepassword",
+		BaseDN:        "ou=people,dc=wag,dc=test",
+	}
+
+	groups, err := Authenticate(details, "alice", "alicepassword")
+	if err != nil {
+		t.Fatal("alice should have been able to authenticate: ", err)
+	}
+
+	if !slices.Equal(groups, []string{"group:devs", "group:dangling"}) {
+		t.Fatal("groups were incorrect, got: ", groups)
+	}
+
+	groups, err = Authenticate(details, "bob", "bobpassword")
+	if err != nil {
+		t.Fatal("bob should have been able to authenticate: ", err)
+	}
+
+	if len(groups) != 0 {
+		t.Fatal("bob should not be a member of any groups: ", groups)
+	}
+
+	_, err = Authenticate(details, "alice", "bobpassword")
+	if err == nil {
+		t.Fatal("alice should not have been able to authenticate with the wrong password")
+	}
+
+	_, err = Authenticate(details, "alice", "")
+	if err == nil {
+		t.Fatal("empty passwords should not be allowed (unauthenticated bind)")
+	}
+
+	_, err = Authenticate(details, "*", "alicepassword")
+	if err == nil {
+		t.Fatal("username should have been escaped in the search filter")
+	}
+
+	_, err = Authenticate(details, "charlie", "alicepassword")
+	if err == nil {
+		t.Fatal("charlie does not exist and should not be able to authenticate")
+	}
+}
+
+func TestAuthenticateNestedGroups(t *testing.T) {
+	server := newTestServer(t, true)
+
+	details := data.LDAP{
+		URL:            server.URL("ldaps"),
+		CACertificate:  server.caPEM,
+		UserDNTemplate: "uid=%s,ou=people,dc=wag,dc=test",
+		NestedGroups:   true,
+	}
+
+	groups, err := Authenticate(details, "alice", "alicepassword")
+	if err != nil {
+		t.Fatal("alice should have been able to authenticate: ", err)
+	}
+
+	if !slices.Equal(groups, []string{"group:devs", "group:dangling", "group:engineering"}) {
+		t.Fatal("nested groups were incorrect, got: ", groups)
+	}
+}
+
+func TestAuthenticateStartTLS(t *testing.T) {
+	server := newTestServer(t, false)
+
+	details := data.LDAP{
+		URL:            server.URL("ldap"),
+		CACertificate:  server.caPEM,
+		UserDNTemplate: "uid=%s,ou=people,dc=wag,dc=test",
+	}
+
+	_, err := Authenticate(details, "alice", "alicepassword")
+	if err == nil {
+		t.Fatal("plaintext ldap should not be allowed without StartTLS")
+	}
+
+	details.StartTLS = true
+
+	groups, err := Authenticate(details, "alice", "alicepassword")
+	if err != nil {
+		t.Fatal("alice should have been able to authenticate over StartTLS: ", err)
+	}
+
+	if !slices.Equal(groups, []string{"group:devs", "group:dangling"}) {
+		t.Fatal("groups were incorrect, got: ", groups)
+	}
+}
+
+func TestAuthenticateUntrustedCertificate(t *testing.T) {
+	server := newTestServer(t, true)
+
+	details := data.LDAP{
+		URL:            server.URL("ldaps"),
+		UserDNTemplate: "uid=%s,ou=people,dc=wag,dc=test",
+	}
+
+	_, err := Authenticate(details, "alice", "alicepassword")
+	if err == nil {
+		t.Fatal("should not have connected to a server with an untrusted certificate")
+	}
+}
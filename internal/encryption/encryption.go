@@ -0,0 +1,149 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const KeySize = 32
+
+// KeyEncryptionKey wraps the data keys that secrets are actually encrypted with, so the key that has to be kept safe never touches etcd
+type KeyEncryptionKey interface {
+	// ID identifies the key, it is stored next to each wrapped data key so the right key can be chosen when several are configured
+	ID() string
+	Wrap(dataKey []byte) ([]byte, error)
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// NewDataKey creates a random AES-256 key
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+// Seal encrypts plaintext with AES-256-GCM, the nonce is prepended to the result
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts the output of Seal
+func Open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+type localKey struct {
+	id  string
+	key []byte
+}
+
+// NewLocalKey uses a 32 byte key held by wag as the key encryption key
+func NewLocalKey(key []byte) (KeyEncryptionKey, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	// The id is derived from the key so it is the same on every node without being configured
+	hash := sha256.Sum256(append([]byte("wag-kek\x00"), key...))
+
+	return &localKey{
+		id:  "local:" + hex.EncodeToString(hash[:8]),
+		key: key,
+	}, nil
+}
+
+func (l *localKey) ID() string {
+	return l.id
+}
+
+func (l *localKey) Wrap(dataKey []byte) ([]byte, error) {
+	return Seal(l.key, dataKey, []byte(l.id))
+}
+
+func (l *localKey) Unwrap(wrapped []byte) ([]byte, error) {
+	return Open(l.key, wrapped, []byte(l.id))
+}
+
+// ParseKey decodes a base64 encoded 32 byte key, e.g the output of "openssl rand -base64 32"
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not valid base64: %w", err)
+	}
+
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	return key, nil
+}
+
+// LoadKeyFile reads a base64 encoded key from a file
+func LoadKeyFile(path string) (KeyEncryptionKey, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read encryption key file: %w", err)
+	}
+
+	key, err := ParseKey(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return NewLocalKey(key)
+}
+
+// LoadKeyEnv reads a base64 encoded key from an environment variable
+func LoadKeyEnv(name string) (KeyEncryptionKey, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("encryption key environment variable %s is not set", name)
+	}
+
+	key, err := ParseKey(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return NewLocalKey(key)
+}
@@ -0,0 +1,137 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalKey(t *testing.T) {
+	raw, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(raw)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	kek, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatal("unable to load key file: ", err)
+	}
+
+	t.Setenv("WAG_TEST_KEY", base64.StdEncoding.EncodeToString(raw))
+	fromEnv, err := LoadKeyEnv("WAG_TEST_KEY")
+	if err != nil {
+		t.Fatal("unable to load key from environment: ", err)
+	}
+
+	if kek.ID() != fromEnv.ID() {
+		t.Fatal("the same key should have the same id regardless of where it was loaded from")
+	}
+
+	dataKey, _ := NewDataKey()
+	wrapped, err := kek.Wrap(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := fromEnv.Unwrap(wrapped)
+	if err != nil {
+		t.Fatal("unable to unwrap: ", err)
+	}
+
+	if !bytes.Equal(unwrapped, dataKey) {
+		t.Fatal("unwrapped key did not match")
+	}
+
+	other, _ := NewDataKey()
+	otherKek, _ := NewLocalKey(other)
+	if _, err := otherKek.Unwrap(wrapped); err == nil {
+		t.Fatal("a different key should not be able to unwrap")
+	}
+
+	if _, err := ParseKey(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Fatal("short keys should be rejected")
+	}
+}
+
+func TestSeal(t *testing.T) {
+	key, _ := NewDataKey()
+
+	sealed, err := Seal(key, []byte("secret"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Fatal("ciphertext contained plaintext")
+	}
+
+	if _, err := Open(key, sealed, []byte("other")); err == nil {
+		t.Fatal("opening with different additional data should fail")
+	}
+
+	plaintext, err := Open(key, sealed, []byte("aad"))
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatal("unable to open sealed data: ", err)
+	}
+}
+
+func TestTransitKey(t *testing.T) {
+	// A minimal stand in for a transit secrets engine, it "encrypts" by base64 encoding with a version prefix
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/wag":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"ciphertext": "vault:v1:" + body["plaintext"]}})
+		case "/v1/transit/decrypt/wag":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"plaintext": strings.TrimPrefix(body["ciphertext"], "vault:v1:")}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv(TransitTokenEnv, "token")
+	kek, err := NewTransitKey(server.URL, "", "wag", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey, _ := NewDataKey()
+	wrapped, err := kek.Wrap(dataKey)
+	if err != nil {
+		t.Fatal("unable to wrap with transit key: ", err)
+	}
+
+	if !strings.HasPrefix(string(wrapped), "vault:v1:") {
+		t.Fatal("wrapped key was not the kms ciphertext: ", string(wrapped))
+	}
+
+	unwrapped, err := kek.Unwrap(wrapped)
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Fatal("unable to unwrap with transit key: ", err)
+	}
+
+	kek.Token = "wrong"
+	if _, err := kek.Wrap(dataKey); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatal("expected kms error to be returned, got: ", err)
+	}
+}
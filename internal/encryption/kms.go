@@ -0,0 +1,143 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const TransitTokenEnv = "WAG_KMS_TOKEN"
+
+// TransitKey wraps data keys with a remote key that never leaves the KMS.
+// It speaks the transit encrypt/decrypt API (as implemented by Vault and OpenBao), so a local dev server can stand in for a full KMS
+type TransitKey struct {
+	Address string
+	Mount   string
+	KeyName string
+	Token   string
+
+	client *http.Client
+}
+
+type transitResponse struct {
+	Data struct {
+		Ciphertext string `json:"ciphertext"`
+		Plaintext  string `json:"plaintext"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// NewTransitKey creates a transit key, if tokenFile is empty the token is read from WAG_KMS_TOKEN
+func NewTransitKey(address, mount, keyName, tokenFile, caCertificate string) (*TransitKey, error) {
+	if address == "" || keyName == "" {
+		return nil, errors.New("kms encryption key must have an address and key name")
+	}
+
+	if mount == "" {
+		mount = "transit"
+	}
+
+	token := os.Getenv(TransitTokenEnv)
+	if tokenFile != "" {
+		contents, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read kms token file: %w", err)
+		}
+		token = strings.TrimSpace(string(contents))
+	}
+
+	tlsConfig := &tls.Config{}
+	if caCertificate != "" {
+		pem, err := os.ReadFile(caCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read kms ca certificate: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("kms ca certificate contained no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &TransitKey{
+		Address: strings.TrimSuffix(address, "/"),
+		Mount:   strings.Trim(mount, "/"),
+		KeyName: keyName,
+		Token:   token,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+func (t *TransitKey) ID() string {
+	return "kms:" + t.Address + "/" + t.Mount + "/" + t.KeyName
+}
+
+func (t *TransitKey) do(operation string, body map[string]string) (result transitResponse, err error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return result, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, t.Address+"/v1/"+t.Mount+"/"+operation+"/"+t.KeyName, bytes.NewReader(b))
+	if err != nil {
+		return result, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Vault-Token", t.Token)
+
+	response, err := t.client.Do(request)
+	if err != nil {
+		return result, fmt.Errorf("kms %s failed: %w", operation, err)
+	}
+	defer response.Body.Close()
+
+	contents, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return result, err
+	}
+
+	if err := json.Unmarshal(contents, &result); err != nil && response.StatusCode == http.StatusOK {
+		return result, fmt.Errorf("kms %s returned invalid response: %w", operation, err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("kms %s failed with status %d: %s", operation, response.StatusCode, strings.Join(result.Errors, ", "))
+	}
+
+	return result, nil
+}
+
+func (t *TransitKey) Wrap(dataKey []byte) ([]byte, error) {
+	result, err := t.do("encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Data.Ciphertext == "" {
+		return nil, errors.New("kms returned an empty ciphertext")
+	}
+
+	return []byte(result.Data.Ciphertext), nil
+}
+
+func (t *TransitKey) Unwrap(wrapped []byte) ([]byte, error) {
+	result, err := t.do("decrypt", map[string]string{"ciphertext": string(wrapped)})
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(result.Data.Plaintext)
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "wag"
+
+var (
+	// Registry contains every wag metric, it is used instead of the global default registry so that only what is registered here is exposed
+	Registry = prometheus.NewRegistry()
+
+	MFAAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mfa_attempts_total",
+		Help:      "Number of mfa authentication attempts handled by this node, by method and result (success, failure, locked)",
+	}, []string{"method", "result"})
+
+	Registrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Number of registration token uses handled by this node, by result (success, failure)",
+	}, []string{"result"})
+
+	EndpointChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "endpoint_changes_total",
+		Help:      "Number of times a wireguard peer connected to this node has roamed to a new endpoint",
+	})
+
+	EventFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_failures_total",
+		Help:      "Number of cluster events this node failed to apply after retrying",
+	})
+
+	ReconcileRepairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_repairs_total",
+		Help:      "Number of differences between etcd and the wireguard device or firewall maps repaired by reconciliation, by kind",
+	}, []string{"kind"})
+)
+
+func init() {
+	Registry.MustRegister(
+		MFAAttempts,
+		Registrations,
+		EndpointChanges,
+		EventFailures,
+		ReconcileRepairs,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/NHAS/wag/internal/certificates"
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/metrics"
+	"github.com/NHAS/wag/internal/proxyprotocol"
+	"github.com/NHAS/wag/internal/router"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	HTTPServer  *http.Server
+	HTTPSServer *http.Server
+
+	registerOnce sync.Once
+)
+
+// Start exposes the metrics registry on /metrics, if enabled
+func Start(errs chan<- error) error {
+	if !config.Values.Metrics.Enabled {
+		return nil
+	}
+
+	var err error
+	registerOnce.Do(func() {
+		var c *collector
+		c, err = newCollector()
+		if err != nil {
+			return
+		}
+
+		err = metrics.Registry.Register(c)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to register metrics collector: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+
+	if config.Values.Metrics.SupportsTLS() {
+		tlsConfig, err := certificates.TLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}, config.Values.Metrics.ACMEDomain, config.Values.Metrics.CertPath, config.Values.Metrics.KeyPath)
+		if err != nil {
+			return fmt.Errorf("unable to load certificate for metrics listener: %w", err)
+		}
+
+		go func() {
+			HTTPSServer = &http.Server{
+				Addr:         config.Values.Metrics.ListenAddress,
+				ReadTimeout:  5 * time.Second,
+				WriteTimeout: 10 * time.Second,
+				IdleTimeout:  120 * time.Second,
+				TLSConfig:    tlsConfig,
+				Handler:      mux,
+				ConnContext:  proxyprotocol.ConnContext(config.Values.Metrics.ProxyProtocol),
+			}
+
+			listener, err := proxyprotocol.Listen(HTTPSServer.Addr, config.Values.Metrics.ProxyProtocol)
+			if err != nil {
+				errs <- fmt.Errorf("TLS metrics listener failed: %v", err)
+				return
+			}
+
+			if err := HTTPSServer.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
+				errs <- fmt.Errorf("TLS metrics listener failed: %v", err)
+			}
+		}()
+	} else {
+		go func() {
+			HTTPServer = &http.Server{
+				Addr:         config.Values.Metrics.ListenAddress,
+				ReadTimeout:  5 * time.Second,
+				WriteTimeout: 10 * time.Second,
+				IdleTimeout:  120 * time.Second,
+				Handler:      mux,
+				ConnContext:  proxyprotocol.ConnContext(config.Values.Metrics.ProxyProtocol),
+			}
+
+			listener, err := proxyprotocol.Listen(HTTPServer.Addr, config.Values.Metrics.ProxyProtocol)
+			if err != nil {
+				errs <- fmt.Errorf("metrics listener failed: %v", err)
+				return
+			}
+
+			if err := HTTPServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+				errs <- fmt.Errorf("metrics listener failed: %v", err)
+			}
+		}()
+	}
+
+	log.Println("Started metrics:\n\t\t\tListening:", config.Values.Metrics.ListenAddress)
+
+	return nil
+}
+
+func Teardown() {
+	if HTTPServer != nil {
+		HTTPServer.Close()
+	}
+
+	if HTTPSServer != nil {
+		HTTPSServer.Close()
+	}
+
+	if config.Values.Metrics.Enabled {
+		log.Println("Stopped metrics")
+	}
+}
+
+// collector gathers metrics that describe current state when scraped, rather than counting events as they happen
+type collector struct {
+	sync.RWMutex
+	clusterHealth string
+
+	authorisedSessions *prometheus.Desc
+	devices            *prometheus.Desc
+	lockedDevices      *prometheus.Desc
+	lockedUsers        *prometheus.Desc
+	registrationTokens *prometheus.Desc
+
+	clusterHasLeader *prometheus.Desc
+	clusterIsLeader  *prometheus.Desc
+	clusterIsLearner *prometheus.Desc
+	clusterHealthy   *prometheus.Desc
+
+	peerReceiveBytes  *prometheus.Desc
+	peerTransmitBytes *prometheus.Desc
+	peerLastHandshake *prometheus.Desc
+}
+
+func newCollector() (*collector, error) {
+	c := &collector{
+		// The metrics server is only started once the node is healthy, after that the health listener keeps this up to date
+		clusterHealth: "healthy",
+
+		authorisedSessions: prometheus.NewDesc("wag_authorised_sessions", "Number of devices with an active authorised session", nil, nil),
+		devices:            prometheus.NewDesc("wag_devices", "Number of registered devices per user", []string{"username"}, nil),
+		lockedDevices:      prometheus.NewDesc("wag_locked_devices", "Number of devices that have exceeded the authentication attempt lockout", nil, nil),
+		lockedUsers:        prometheus.NewDesc("wag_locked_users", "Number of locked user accounts", nil, nil),
+		registrationTokens: prometheus.NewDesc("wag_registration_tokens", "Number of unused registration tokens", nil, nil),
+
+		clusterHasLeader: prometheus.NewDesc("wag_cluster_has_leader", "Whether the etcd cluster currently has a leader", nil, nil),
+		clusterIsLeader:  prometheus.NewDesc("wag_cluster_is_leader", "Whether this node is the etcd cluster leader", nil, nil),
+		clusterIsLearner: prometheus.NewDesc("wag_cluster_is_learner", "Whether this node is an etcd learner", nil, nil),
+		clusterHealthy:   prometheus.NewDesc("wag_cluster_healthy", "Whether this node can currently write to the cluster", nil, nil),
+
+		peerReceiveBytes:  prometheus.NewDesc("wag_peer_receive_bytes_total", "Bytes received from a wireguard peer", []string{"public_key", "address"}, nil),
+		peerTransmitBytes: prometheus.NewDesc("wag_peer_transmit_bytes_total", "Bytes sent to a wireguard peer", []string{"public_key", "address"}, nil),
+		peerLastHandshake: prometheus.NewDesc("wag_peer_last_handshake_seconds", "Unix time of the last handshake with a wireguard peer", []string{"public_key", "address"}, nil),
+	}
+
+	_, err := data.RegisterClusterHealthListener(func(status string) {
+		c.Lock()
+		defer c.Unlock()
+		c.clusterHealth = status
+	})
+
+	return c, err
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.authorisedSessions
+	ch <- c.devices
+	ch <- c.lockedDevices
+	ch <- c.lockedUsers
+	ch <- c.registrationTokens
+
+	ch <- c.clusterHasLeader
+	ch <- c.clusterIsLeader
+	ch <- c.clusterIsLearner
+	ch <- c.clusterHealthy
+
+	ch <- c.peerReceiveBytes
+	ch <- c.peerTransmitBytes
+	ch <- c.peerLastHandshake
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.RLock()
+	healthy := c.clusterHealth == "healthy" || c.clusterHealth == "learner"
+	c.RUnlock()
+
+	ch <- prometheus.MustNewConstMetric(c.clusterHasLeader, prometheus.GaugeValue, boolValue(data.HasLeader()))
+	ch <- prometheus.MustNewConstMetric(c.clusterIsLeader, prometheus.GaugeValue, boolValue(data.IsLeader()))
+	ch <- prometheus.MustNewConstMetric(c.clusterIsLearner, prometheus.GaugeValue, boolValue(data.IsLearner()))
+	ch <- prometheus.MustNewConstMetric(c.clusterHealthy, prometheus.GaugeValue, boolValue(healthy))
+
+	if devices, err := data.GetAllDevices(); err != nil {
+		log.Println("metrics: unable to get devices: ", err)
+	} else {
+		lockout, err := data.GetLockout()
+		if err != nil {
+			log.Println("metrics: unable to get lockout: ", err)
+		}
+
+		perUser := map[string]int{}
+		locked := 0
+		for _, device := range devices {
+			perUser[device.Username]++
+			if err == nil && device.LockoutAttempts() >= lockout {
+				locked++
+			}
+		}
+
+		for username, count := range perUser {
+			ch <- prometheus.MustNewConstMetric(c.devices, prometheus.GaugeValue, float64(count), username)
+		}
+
+		if err == nil {
+			ch <- prometheus.MustNewConstMetric(c.lockedDevices, prometheus.GaugeValue, float64(locked))
+		}
+	}
+
+	if users, err := data.GetAllUsers(); err != nil {
+		log.Println("metrics: unable to get users: ", err)
+	} else {
+		locked := 0
+		for _, user := range users {
+			if user.Locked {
+				locked++
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(c.lockedUsers, prometheus.GaugeValue, float64(locked))
+	}
+
+	if tokens, err := data.GetRegistrationTokens(); err != nil {
+		log.Println("metrics: unable to get registration tokens: ", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.registrationTokens, prometheus.GaugeValue, float64(len(tokens)))
+	}
+
+	// Sessions and peers are local to this nodes wireguard device and firewall, which witnesses do not have
+	if config.Values.Clustering.Witness {
+		return
+	}
+
+	if authorised, err := router.GetAllAuthorised(); err != nil {
+		log.Println("metrics: unable to get authorised devices: ", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.authorisedSessions, prometheus.GaugeValue, float64(len(authorised)))
+	}
+
+	peers, err := router.ListPeers()
+	if err != nil {
+		log.Println("metrics: unable to list wireguard peers: ", err)
+		return
+	}
+
+	for _, peer := range peers {
+		address := "-"
+		if len(peer.AllowedIPs) > 0 {
+			address = peer.AllowedIPs[0].IP.String()
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.peerReceiveBytes, prometheus.CounterValue, float64(peer.ReceiveBytes), peer.PublicKey.String(), address)
+		ch <- prometheus.MustNewConstMetric(c.peerTransmitBytes, prometheus.CounterValue, float64(peer.TransmitBytes), peer.PublicKey.String(), address)
+		if !peer.LastHandshakeTime.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.peerLastHandshake, prometheus.GaugeValue, float64(peer.LastHandshakeTime.Unix()), peer.PublicKey.String(), address)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestMain(m *testing.M) {
+	if err := config.Load("../../config/testing_config2.json"); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	// Sessions and peers come from the wireguard device and firewall, which are not set up here
+	config.Values.Clustering.Witness = true
+
+	k, err := wgtypes.GenerateKey()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	err = data.Load(fmt.Sprintf("file:%s?mode=memory&cache=shared", k.String()), "", true)
+	if err != nil {
+		log.Println("cannot load database: ", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	data.TearDown()
+
+	os.Exit(code)
+}
+
+func TestCollectorLockedDevices(t *testing.T) {
+	if _, err := data.CreateUserDataAccount("metrics_user"); err != nil {
+		t.Fatal(err)
+	}
+	defer data.DeleteUser("metrics_user")
+
+	lockout, err := data.GetLockout()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One device exactly at the lockout, which is locked, and one just below it
+	for _, attempts := range []int{lockout, lockout - 1} {
+		key, _ := wgtypes.GenerateKey()
+		device, err := data.AddDevice("metrics_user", key.PublicKey().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := data.SetDeviceAuthenticationAttempts("metrics_user", device.Address, attempts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := data.SetUserLock("metrics_user"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := newCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# HELP wag_devices Number of registered devices per user
+# TYPE wag_devices gauge
+wag_devices{username="metrics_user"} 2
+# HELP wag_locked_devices Number of devices that have exceeded the authentication attempt lockout
+# TYPE wag_locked_devices gauge
+wag_locked_devices 1
+# HELP wag_locked_users Number of locked user accounts
+# TYPE wag_locked_users gauge
+wag_locked_users 1
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "wag_devices", "wag_locked_devices", "wag_locked_users"); err != nil {
+		t.Fatal(err)
+	}
+}
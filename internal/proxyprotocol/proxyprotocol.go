@@ -0,0 +1,309 @@
+// Package proxyprotocol reads PROXY protocol v1 and v2 headers, so listeners behind TCP load balancers see the real client address
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+)
+
+// How long a trusted source has to send the header once a connection is used
+const headerTimeout = 5 * time.Second
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+)
+
+// Listen listens on address, and if PROXY protocol is enabled takes client addresses from the headers sent by trusted sources
+func Listen(address string, settings config.ProxyProtocolDetails) (net.Listener, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if !settings.Enabled {
+		return l, nil
+	}
+
+	trusted, err := settings.Trusted()
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return NewListener(l, trusted), nil
+}
+
+type listenerContextKey struct{}
+
+// ConnContext is the http.Server ConnContext for listeners with the given settings. Client addresses on listeners with PROXY protocol
+// enabled come from the connection, which marks their requests so the X-Forwarded-For header is not used for them
+func ConnContext(settings config.ProxyProtocolDetails) func(context.Context, net.Conn) context.Context {
+	if !settings.Enabled {
+		return nil
+	}
+
+	return func(ctx context.Context, _ net.Conn) context.Context {
+		return context.WithValue(ctx, listenerContextKey{}, true)
+	}
+}
+
+// FromListener reports whether a request arrived on a listener with PROXY protocol enabled
+func FromListener(ctx context.Context) bool {
+	enabled, _ := ctx.Value(listenerContextKey{}).(bool)
+	return enabled
+}
+
+type listener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+// NewListener wraps inner so connections from trusted sources must start with a PROXY protocol header, whose source address is returned by RemoteAddr.
+// Connections from other sources are returned unchanged
+func NewListener(inner net.Listener, trusted []*net.IPNet) net.Listener {
+	return &listener{Listener: inner, trusted: trusted}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.isTrusted(c.RemoteAddr()) {
+		return c, nil
+	}
+
+	// The header is read on first use rather than here, so a slow peer cannot hold up accepting other connections
+	return &conn{Conn: c, reader: bufio.NewReader(c)}, nil
+}
+
+func (l *listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, network := range l.trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type conn struct {
+	net.Conn
+
+	once   sync.Once
+	reader *bufio.Reader
+	err    error
+
+	remoteAddr net.Addr
+
+	deadlineLck  sync.Mutex
+	readDeadline time.Time
+}
+
+func (c *conn) readHeader() {
+	c.once.Do(func() {
+		// Dont clobber a deadline the server has already set
+		c.deadlineLck.Lock()
+		deadline := c.readDeadline
+		c.deadlineLck.Unlock()
+
+		headerDeadline := time.Now().Add(headerTimeout)
+		if deadline.IsZero() || headerDeadline.Before(deadline) {
+			c.Conn.SetReadDeadline(headerDeadline)
+			defer c.Conn.SetReadDeadline(deadline)
+		}
+
+		c.remoteAddr, c.err = parseHeader(c.reader)
+		if c.err != nil {
+			c.err = fmt.Errorf("invalid PROXY protocol header from %s: %w", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.err != nil || c.remoteAddr == nil {
+		return c.Conn.RemoteAddr()
+	}
+
+	return c.remoteAddr
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.deadlineLck.Lock()
+	c.readDeadline = t
+	c.deadlineLck.Unlock()
+
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.deadlineLck.Lock()
+	c.readDeadline = t
+	c.deadlineLck.Unlock()
+
+	return c.Conn.SetReadDeadline(t)
+}
+
+// parseHeader reads a v1 or v2 header and returns the client address it carries, or nil if the header does not describe a proxied client
+// (v1 UNKNOWN, v2 LOCAL such as load balancer health checks, or address families other than TCP over IPv4/IPv6)
+func parseHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(start, v1Prefix) {
+		return parseV1(r)
+	}
+
+	start, err = r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(start, v2Signature) {
+		return parseV2(r)
+	}
+
+	return nil, errors.New("no header")
+}
+
+func parseV1(r *bufio.Reader) (net.Addr, error) {
+	// The longest possible v1 header is 107 bytes
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+
+		if len(line) >= 107 {
+			return nil, errors.New("v1 header too long")
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("v1 header not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 {
+		return nil, errors.New("v1 header missing protocol")
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("v1 header has unknown protocol %q", fields[1])
+	}
+
+	if len(fields) != 6 {
+		return nil, errors.New("v1 header has the wrong number of fields")
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("v1 header has invalid source address %q", fields[2])
+	}
+
+	if net.ParseIP(fields[3]) == nil {
+		return nil, fmt.Errorf("v1 header has invalid destination address %q", fields[3])
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("v1 header has invalid source port %q", fields[4])
+	}
+
+	if _, err := strconv.ParseUint(fields[5], 10, 16); err != nil {
+		return nil, fmt.Errorf("v1 header has invalid destination port %q", fields[5])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func parseV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("v2 header has unsupported version %d", header[12]>>4)
+	}
+
+	command := header[12] & 0x0F
+	family := header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0x0:
+		// LOCAL, the connection was made by the load balancer itself
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("v2 header has unknown command %d", command)
+	}
+
+	switch family {
+	case 0x11:
+		// TCP over IPv4, source and destination addresses then ports
+		if len(body) < 12 {
+			return nil, errors.New("v2 header too short for IPv4 addresses")
+		}
+
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21:
+		// TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("v2 header too short for IPv6 addresses")
+		}
+
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+
+	// UDP and unix sockets are not client addresses for a http listener, TLVs after the addresses are ignored
+	return nil, nil
+}
@@ -0,0 +1,125 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func v2Header(command, family byte, addresses []byte) []byte {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestParseHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 10, 0, 0, 1}
+	ipv4 = binary.BigEndian.AppendUint16(ipv4, 51234)
+	ipv4 = binary.BigEndian.AppendUint16(ipv4, 443)
+
+	ipv6 := append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...)
+	ipv6 = binary.BigEndian.AppendUint16(ipv6, 51234)
+	ipv6 = binary.BigEndian.AppendUint16(ipv6, 443)
+
+	tests := []struct {
+		name    string
+		header  []byte
+		address string
+		wantErr bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.0.2.1 10.0.0.1 51234 443\r\n"), "192.0.2.1:51234", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 51234 443\r\n"), "[2001:db8::1]:51234", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 family mismatch", []byte("PROXY TCP4 2001:db8::1 10.0.0.1 51234 443\r\n"), "", true},
+		{"v1 bad port", []byte("PROXY TCP4 192.0.2.1 10.0.0.1 99999 443\r\n"), "", true},
+		{"v1 missing crlf", []byte("PROXY TCP4 192.0.2.1 10.0.0.1 51234 443\n"), "", true},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"), "", true},
+		{"v2 tcp4", v2Header(0x1, 0x11, ipv4), "192.0.2.1:51234", false},
+		{"v2 tcp6", v2Header(0x1, 0x21, ipv6), "[2001:db8::1]:51234", false},
+		{"v2 local", v2Header(0x0, 0x00, nil), "", false},
+		{"v2 short addresses", v2Header(0x1, 0x11, ipv4[:6]), "", true},
+		{"no header", []byte("GET / HTTP/1.1\r\n\r\n"), "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(test.header), strings.NewReader("body")))
+
+			address, err := parseHeader(r)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got address: ", address)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (address == nil && test.address != "") || (address != nil && address.String() != test.address) {
+				t.Fatalf("expected address %q got %v", test.address, address)
+			}
+
+			rest, _ := io.ReadAll(r)
+			if string(rest) != "body" {
+				t.Fatalf("header was not fully consumed, remaining: %q", rest)
+			}
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	for _, trusted := range []bool{true, false} {
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer inner.Close()
+
+		var networks []*net.IPNet
+		if trusted {
+			_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+			networks = append(networks, loopback)
+		}
+
+		l := NewListener(inner, networks)
+
+		client, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		if _, err := client.Write([]byte("PROXY TCP4 192.0.2.1 10.0.0.1 51234 443\r\nhello")); err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		remote := c.RemoteAddr().String()
+		if trusted && remote != "192.0.2.1:51234" {
+			t.Fatal("trusted source should set the client address, got: ", remote)
+		}
+
+		if !trusted && remote != client.LocalAddr().String() {
+			t.Fatal("untrusted source should not be able to set the client address, got: ", remote)
+		}
+
+		b := make([]byte, 5)
+		if trusted {
+			if _, err := io.ReadFull(c, b); err != nil || string(b) != "hello" {
+				t.Fatalf("expected data after the header, got %q %v", b, err)
+			}
+		}
+	}
+}
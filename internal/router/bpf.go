@@ -237,6 +237,43 @@ func isAuthed(address string) bool {
 	return isAccountLocked == 0 && sessionValid && sessionActive
 }
 
+// GetSession returns when the session of a device ends and when it last sent traffic, a zero expiry means the session has no maximum lifetime.
+// The times are only meaningful if the device is authorised
+func GetSession(address string) (expires, lastPacket time.Time, err error) {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() == nil {
+		return expires, lastPacket, errors.New("address " + address + " is not parsable as an IPv4 address")
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+
+	var deviceStruct fwentry
+	deviceBytes, err := xdpObjects.Devices.LookupBytes([]byte(ip.To4()))
+	if err != nil {
+		return expires, lastPacket, err
+	}
+
+	if err := deviceStruct.Unpack(deviceBytes); err != nil {
+		return expires, lastPacket, err
+	}
+
+	// The xdp program uses boot time, so convert it to wall clock time relative to now
+	now, currentTime := time.Now(), GetTimeStamp()
+	sinceBoot := func(timestamp uint64) time.Time {
+		if timestamp >= currentTime {
+			return now.Add(time.Duration(timestamp - currentTime))
+		}
+		return now.Add(-time.Duration(currentTime - timestamp))
+	}
+
+	if deviceStruct.sessionExpiry != math.MaxUint64 {
+		expires = sinceBoot(deviceStruct.sessionExpiry)
+	}
+
+	return expires, sinceBoot(deviceStruct.lastPacketTime), nil
+}
+
 func xdpRemoveDevice(address string) error {
 	ip := net.ParseIP(address)
 	if ip == nil {
@@ -268,17 +305,19 @@ func xdpAddDevice(username, address string) error {
 		return errors.New("Device " + username + " does not have an internal IP address assigned to it, this is a big bug")
 	}
 
+	userid := sha1.Sum([]byte(username))
+
 	var deviceStruct fwentry
-	deviceBytes := make([]byte, deviceStruct.Size())
-	err := xdpObjects.Devices.Lookup(ip.To4(), &deviceBytes)
-	if err == nil {
-		return errors.New("attempted to add a device with address that already exists")
+	deviceBytes, err := xdpObjects.Devices.LookupBytes(ip.To4())
+	if err == nil && deviceBytes != nil && deviceStruct.Unpack(deviceBytes) == nil && deviceStruct.user_id == userid {
+		// Already added, keep the existing session so that applying the same event twice is harmless
+		return nil
 	}
 
 	//Defaultly add device that is not authenticated
 	deviceStruct.lastPacketTime = 0
 	deviceStruct.sessionExpiry = 0
-	deviceStruct.user_id = sha1.Sum([]byte(username))
+	deviceStruct.user_id = userid
 
 	if err := xdpUserExists(deviceStruct.user_id); err != nil {
 		return err
@@ -311,7 +350,7 @@ func SetLockAccount(username string, locked uint32) error {
 // Takes the LPM table and associates a route to a policy
 func xdpAddRoute(usersRouteTable *ebpf.Map, userAcls acls.Acl) error {
 
-	rules, errs := routetypes.ParseRules(userAcls.Mfa, userAcls.Allow, userAcls.Deny)
+	rules, errs := routetypes.ParseRulesWithStepUp(userAcls.Mfa, userAcls.Allow, userAcls.Deny, userAcls.StepUp)
 	if len(errs) != 0 {
 		log.Println("Parsing rules for user had errors: ", errs)
 	}
@@ -348,22 +387,24 @@ func AddUser(username string, acls acls.Acl) error {
 	defer lock.Unlock()
 
 	userid := sha1.Sum([]byte(username))
-	if xdpUserExists(userid) == nil {
-		return errors.New("user already exists")
-	}
 
-	// New users are obviously unlocked
-	err := xdpObjects.AccountLocked.Put(userid, uint32(0))
-	if err != nil {
-		return err
+	// If the user already exists only the policies are updated, so that applying the same event twice is harmless
+	if xdpUserExists(userid) != nil {
+		// New users are obviously unlocked
+		err := xdpObjects.AccountLocked.Put(userid, uint32(0))
+		if err != nil {
+			return err
+		}
 	}
 
-	err = setSingleUserMap(userid, acls)
+	err := setSingleUserMap(userid, acls)
 	if err != nil {
 		return err
 	}
 
-	usersToAddresses[username] = make(map[string]string)
+	if _, ok := usersToAddresses[username]; !ok {
+		usersToAddresses[username] = make(map[string]string)
+	}
 
 	return nil
 }
@@ -453,7 +494,7 @@ func bulkCreateUserMaps(users []data.UserModel) []error {
 				continue
 			}
 
-			err := xdpAddRoute(policiesInnerTable, data.GetEffectiveAcl(user.Username))
+			err := xdpAddRoute(policiesInnerTable, data.GetFirewallAcl(user.Username))
 
 			if err != nil {
 				errors = append(errors, err)
@@ -497,7 +538,7 @@ func bulkCreateUserMaps(users []data.UserModel) []error {
 
 	// As we created maps for this, we dont need to clear things
 	for username, m := range maps {
-		err := xdpAddRoute(m, data.GetEffectiveAcl(username))
+		err := xdpAddRoute(m, data.GetFirewallAcl(username))
 		if err != nil {
 			errors = append(errors, err)
 		}
@@ -514,7 +555,7 @@ func RemoveUser(username string) error {
 	userid := sha1.Sum([]byte(username))
 
 	err := xdpObjects.AccountLocked.Delete(userid)
-	if err != nil {
+	if err != nil && !strings.Contains(err.Error(), ebpf.ErrKeyNotExist.Error()) {
 		return err
 	}
 
@@ -588,7 +629,7 @@ func RefreshUserAcls(username string) error {
 
 	userid := sha1.Sum([]byte(username))
 
-	acls := data.GetEffectiveAcl(username)
+	acls := data.GetFirewallAcl(username)
 
 	return setSingleUserMap(userid, acls)
 }
@@ -128,6 +128,21 @@ func TestAddUser(t *testing.T) {
 	}
 }
 
+func TestAddIsIdempotent(t *testing.T) {
+	// Events may be retried, so applying the same addition twice must not fail or reset state
+	for _, device := range devices {
+		err := AddUser(device.Username, data.GetEffectiveAcl(device.Username))
+		if err != nil {
+			t.Fatal("adding existing user failed: ", err)
+		}
+
+		err = xdpAddDevice(device.Username, device.Address)
+		if err != nil {
+			t.Fatal("adding existing device failed: ", err)
+		}
+	}
+}
+
 func contains(x, y []string) bool {
 	f := map[string]bool{}
 	for _, nx := range x {
@@ -10,9 +10,11 @@ import (
 
 	"github.com/NHAS/wag/internal/config"
 	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/metrics"
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/mdlayher/netlink"
 	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 var (
@@ -52,9 +54,12 @@ func Setup(errorChan chan<- error, iptables bool) (err error) {
 
 	handleEvents(errorChan)
 
+	go reconcileLoop()
+
 	go func() {
 		startup := true
 		cache := map[string]string{}
+		rotating := map[wgtypes.Key]bool{}
 		d, err := data.GetAllDevices()
 		if err != nil {
 			errorChan <- err
@@ -79,6 +84,21 @@ func Setup(errorChan chan<- error, iptables bool) (err error) {
 
 				for _, p := range dev.Peers {
 
+					// Keys issued by a key rotation have no allowed ips, the rotation is completed once the device handshakes with them
+					if address, ok := pendingPeerAddress(p.PublicKey.String()); ok && len(p.AllowedIPs) == 0 {
+						if !p.LastHandshakeTime.IsZero() && !rotating[p.PublicKey] {
+							rotating[p.PublicKey] = true
+
+							err := data.CompleteKeyRotation(address, p.PublicKey.String())
+							if err != nil {
+								log.Println(address, "unable to complete key rotation: ", err)
+								continue
+							}
+							log.Println(address, "completed key rotation")
+						}
+						continue
+					}
+
 					if len(p.AllowedIPs) != 1 {
 						log.Println("Warning, peer ", p.PublicKey.String(), " len(p.AllowedIPs) != 1, which is not supported")
 						continue
@@ -106,6 +126,7 @@ func Setup(errorChan chan<- error, iptables bool) (err error) {
 						//Dont try and remove rules, if we've just started
 						if !startup {
 							log.Println(ip, "endpoint changed", d.Endpoint.String(), "->", p.Endpoint.String())
+							metrics.EndpointChanges.Inc()
 							if err := Deauthenticate(ip); err != nil {
 								log.Println(ip, "unable to remove forwards for device: ", err)
 							}
@@ -114,6 +135,12 @@ func Setup(errorChan chan<- error, iptables bool) (err error) {
 
 				}
 
+				for key := range rotating {
+					if _, ok := pendingPeerAddress(key.String()); !ok {
+						delete(rotating, key)
+					}
+				}
+
 				startup = false
 			}
 
@@ -141,7 +168,7 @@ func Setup(errorChan chan<- error, iptables bool) (err error) {
 func TearDown(force bool) {
 
 	if !force {
-		cancel <- true
+		close(cancel)
 	}
 
 	log.Println("Removing wireguard device")
@@ -0,0 +1,304 @@
+package router
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/metrics"
+	"github.com/cilium/ebpf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const reconcileInterval = 5 * time.Minute
+
+func reconcileLoop() {
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-time.After(reconcileInterval):
+			repairs, err := Reconcile()
+			if err != nil {
+				log.Println("reconciliation failed: ", err)
+			}
+
+			if repairs > 0 {
+				log.Printf("reconciliation repaired %d differences between etcd and the firewall", repairs)
+			}
+		}
+	}
+}
+
+// Reconcile compares the users and devices in etcd with the xdp maps and wireguard peers, and repairs anything that has drifted.
+// For example when an event could not be applied even after retrying. Sessions are only ever removed, never granted
+func Reconcile() (repairs int, err error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Read while holding the lock, any event that has not been applied yet will find its change already made, which is fine as the handlers are idempotent
+	users, devices, err := data.GetInitialData()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get users and devices: %w", err)
+	}
+
+	lockout, err := data.GetLockout()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get lockout: %w", err)
+	}
+
+	inactivityTimeoutMinutes, err := data.GetSessionInactivityTimeoutMinutes()
+	if err != nil {
+		return 0, fmt.Errorf("unable to get inactivity timeout: %w", err)
+	}
+
+	repaired := func(kind, format string, args ...any) {
+		repairs++
+		metrics.ReconcileRepairs.WithLabelValues(kind).Inc()
+		log.Printf("reconcile: "+format, args...)
+	}
+
+	var errs []error
+
+	wantUsers := map[[20]byte]bool{}
+	for _, user := range users {
+		userid := sha1.Sum([]byte(user.Username))
+		wantUsers[userid] = true
+
+		wantLocked := uint32(0)
+		if user.Locked {
+			wantLocked = 1
+		}
+
+		var locked uint32
+		if err := xdpObjects.AccountLocked.Lookup(userid, &locked); err != nil {
+			if err := xdpObjects.AccountLocked.Put(userid, wantLocked); err != nil {
+				errs = append(errs, fmt.Errorf("adding user %s: %w", user.Username, err))
+				continue
+			}
+
+			if err := setSingleUserMap(userid, data.GetFirewallAcl(user.Username)); err != nil {
+				errs = append(errs, fmt.Errorf("adding policies for user %s: %w", user.Username, err))
+				continue
+			}
+
+			repaired("user", "added missing user %s", user.Username)
+		} else if locked != wantLocked {
+			if err := xdpObjects.AccountLocked.Put(userid, wantLocked); err != nil {
+				errs = append(errs, fmt.Errorf("setting lock for user %s: %w", user.Username, err))
+				continue
+			}
+
+			repaired("lock", "set locked=%t for user %s", user.Locked, user.Username)
+		}
+	}
+
+	var (
+		userid [20]byte
+		locked uint32
+		stray  [][20]byte
+	)
+	userIter := xdpObjects.AccountLocked.Iterate()
+	for userIter.Next(&userid, &locked) {
+		if !wantUsers[userid] {
+			stray = append(stray, userid)
+		}
+	}
+	if err := userIter.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("listing users: %w", err))
+	}
+
+	for _, id := range stray {
+		if err := xdpObjects.AccountLocked.Delete(id); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			errs = append(errs, fmt.Errorf("removing user %x: %w", id, err))
+			continue
+		}
+
+		if err := xdpObjects.PoliciesTable.Delete(id); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			errs = append(errs, fmt.Errorf("removing policies for user %x: %w", id, err))
+		}
+		delete(userPolicyMaps, id)
+
+		repaired("user", "removed user %x that no longer exists", id)
+	}
+
+	dev, err := ctrl.Device(config.Values.Wireguard.DevName)
+	if err != nil {
+		return repairs, errors.Join(append(errs, fmt.Errorf("unable to get wireguard device: %w", err))...)
+	}
+
+	peers := map[wgtypes.Key]wgtypes.Peer{}
+	for _, peer := range dev.Peers {
+		peers[peer.PublicKey] = peer
+	}
+
+	var (
+		wantPeers   = map[wgtypes.Key]bool{}
+		wantDevices = map[string]bool{}
+		peerChanges []wgtypes.PeerConfig
+
+		newUsersToAddresses = map[string]map[string]string{}
+		newAddressesToUsers = map[string]string{}
+		newPendingPeers     = map[string]string{}
+	)
+
+	for _, user := range users {
+		newUsersToAddresses[user.Username] = map[string]string{}
+	}
+
+	for _, device := range devices {
+		pk, err := wgtypes.ParseKey(device.Publickey)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("device %s has an invalid public key: %w", device.Address, err))
+			continue
+		}
+
+		ip := net.ParseIP(device.Address).To4()
+		if ip == nil {
+			errs = append(errs, fmt.Errorf("device %s does not have an ipv4 address", device.Address))
+			continue
+		}
+
+		wantPeers[pk] = true
+		wantDevices[ip.String()] = true
+
+		if newUsersToAddresses[device.Username] == nil {
+			newUsersToAddresses[device.Username] = map[string]string{}
+		}
+		newUsersToAddresses[device.Username][device.Address] = pk.String()
+		newAddressesToUsers[device.Address] = device.Username
+
+		var psk wgtypes.Key
+		if key, err := wgtypes.ParseKey(device.PresharedKey); device.PresharedKey != "unset" && err == nil {
+			psk = key
+		}
+
+		network := net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+
+		peer, ok := peers[pk]
+		if !ok || len(peer.AllowedIPs) != 1 || peer.AllowedIPs[0].String() != network.String() || peer.PresharedKey != psk {
+			pc := wgtypes.PeerConfig{
+				PublicKey:         pk,
+				ReplaceAllowedIPs: true,
+				AllowedIPs:        []net.IPNet{network},
+				PresharedKey:      &psk,
+			}
+
+			if config.Values.Wireguard.ServerPersistentKeepAlive > 0 {
+				d := time.Duration(config.Values.Wireguard.ServerPersistentKeepAlive) * time.Second
+				pc.PersistentKeepaliveInterval = &d
+			}
+
+			peerChanges = append(peerChanges, pc)
+			repaired("peer", "set wireguard peer for device %s", device.Address)
+		}
+
+		if device.PendingPublickey != "" {
+			pending, err := pendingPeerConfig(device)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("device %s has an invalid pending key: %w", device.Address, err))
+			} else {
+				wantPeers[pending.PublicKey] = true
+				newPendingPeers[device.PendingPublickey] = device.Address
+
+				peer, ok := peers[pending.PublicKey]
+				if !ok || len(peer.AllowedIPs) != 0 || peer.PresharedKey != *pending.PresharedKey {
+					peerChanges = append(peerChanges, pending)
+					repaired("peer", "set pending wireguard peer for device %s", device.Address)
+				}
+			}
+		}
+
+		var entry fwentry
+		entryBytes, err := xdpObjects.Devices.LookupBytes(ip)
+		if err != nil || entryBytes == nil || entry.Unpack(entryBytes) != nil || entry.user_id != sha1.Sum([]byte(device.Username)) {
+			// The device entry is reset, so the device must authorise again
+			if err := xdpRemoveDevice(device.Address); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			if err := xdpAddDevice(device.Username, device.Address); err != nil {
+				errs = append(errs, fmt.Errorf("adding device %s: %w", device.Address, err))
+				continue
+			}
+
+			repaired("device", "added missing firewall entry for device %s", device.Address)
+			continue
+		}
+
+		if entry.sessionExpiry != 0 && (device.Authorised.IsZero() || device.LockoutAttempts() > lockout) {
+			if err := _deauthenticate(device.Address); err != nil {
+				errs = append(errs, fmt.Errorf("deauthenticating device %s: %w", device.Address, err))
+				continue
+			}
+
+			repaired("session", "removed session of device %s that is not authorised", device.Address)
+		}
+	}
+
+	// An externally managed wireguard device may have peers that are not ours
+	if !config.Values.Wireguard.External {
+		for pk := range peers {
+			if !wantPeers[pk] {
+				peerChanges = append(peerChanges, wgtypes.PeerConfig{PublicKey: pk, Remove: true})
+				repaired("peer", "removed unknown wireguard peer %s", pk.String())
+			}
+		}
+	}
+
+	if len(peerChanges) > 0 {
+		if err := ctrl.ConfigureDevice(config.Values.Wireguard.DevName, wgtypes.Config{Peers: peerChanges}); err != nil {
+			errs = append(errs, fmt.Errorf("configuring wireguard peers: %w", err))
+		}
+	}
+
+	var (
+		ipBytes    = make([]byte, 4)
+		entryBytes = make([]byte, fwentry{}.Size())
+		strayIPs   []net.IP
+	)
+	deviceIter := xdpObjects.Devices.Iterate()
+	for deviceIter.Next(&ipBytes, &entryBytes) {
+		if !wantDevices[net.IP(ipBytes).String()] {
+			strayIPs = append(strayIPs, net.IP(append([]byte{}, ipBytes...)))
+		}
+	}
+	if err := deviceIter.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("listing devices: %w", err))
+	}
+
+	for _, ip := range strayIPs {
+		if err := xdpRemoveDevice(ip.String()); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		repaired("device", "removed firewall entry for device %s that no longer exists", ip.String())
+	}
+
+	usersToAddresses = newUsersToAddresses
+	addressesToUsers = newAddressesToUsers
+	pendingPeers = newPendingPeers
+
+	wantTimeout := uint64(inactivityTimeoutMinutes) * 60000000000
+	if inactivityTimeoutMinutes < 0 {
+		wantTimeout = ^uint64(0)
+	}
+
+	var timeout uint64
+	if err := xdpObjects.InactivityTimeoutMinutes.Lookup(uint32(0), &timeout); err != nil || timeout != wantTimeout {
+		if err := setInactivityTimeout(inactivityTimeoutMinutes); err != nil {
+			errs = append(errs, err)
+		} else {
+			repaired("inactivity_timeout", "set inactivity timeout to %d minutes", inactivityTimeoutMinutes)
+		}
+	}
+
+	return repairs, errors.Join(errs...)
+}
@@ -3,6 +3,7 @@ package router
 import (
 	"fmt"
 	"log"
+	"slices"
 	"strings"
 
 	"github.com/NHAS/wag/internal/acls"
@@ -49,6 +50,30 @@ func handleEvents(erroChan chan<- error) {
 		return
 	}
 
+	_, err = data.RegisterEventListener(data.StepUpMethodsKey, true, stepUpMethodsChanges)
+	if err != nil {
+		erroChan <- err
+		return
+	}
+
+	_, err = data.RegisterEventListener(data.AccessGrantsPrefix, true, accessGrantChanges)
+	if err != nil {
+		erroChan <- err
+		return
+	}
+
+}
+
+func stepUpMethodsChanges(key string, current, previous []string, et data.EventType) error {
+	switch et {
+	case data.CREATED, data.DELETED, data.MODIFIED:
+		err := RefreshConfiguration()
+		if err != nil {
+			return fmt.Errorf("failed to refresh configuration: %s", err)
+		}
+	}
+
+	return nil
 }
 
 func inactivityTimeoutChanges(key string, current, previous int, et data.EventType) error {
@@ -74,6 +99,13 @@ func deviceChanges(key string, current, previous data.Device, et data.EventType)
 		}
 		log.Println("removed peer: ", current.Address)
 
+		if current.PendingPublickey != "" {
+			err := RemovePendingPeer(current.PendingPublickey)
+			if err != nil {
+				return fmt.Errorf("unable to remove pending peer: %s: err: %s", current.Address, err)
+			}
+		}
+
 	case data.CREATED:
 
 		key, _ := wgtypes.ParseKey(current.Publickey)
@@ -87,19 +119,37 @@ func deviceChanges(key string, current, previous data.Device, et data.EventType)
 	case data.MODIFIED:
 		if current.Publickey != previous.Publickey {
 			key, _ := wgtypes.ParseKey(current.Publickey)
-			err := ReplacePeer(previous, key)
+			err := ReplacePeer(previous, key, current.PresharedKey)
 			if err != nil {
 				return fmt.Errorf("failed to replace peer pub key: %s", err)
 			}
 			log.Println("replaced peer public key: ", current.Address)
 		}
 
+		// New keys were issued to the device, or the ones it was issued were replaced or became its current keys
+		if current.PendingPublickey != previous.PendingPublickey {
+			if previous.PendingPublickey != "" && previous.PendingPublickey != current.Publickey {
+				err := RemovePendingPeer(previous.PendingPublickey)
+				if err != nil {
+					return fmt.Errorf("failed to remove pending peer: %s", err)
+				}
+			}
+
+			if current.PendingPublickey != "" {
+				err := AddPendingPeer(current)
+				if err != nil {
+					return fmt.Errorf("failed to add pending peer: %s", err)
+				}
+				log.Println("added pending peer for key rotation: ", current.Address)
+			}
+		}
+
 		lockout, err := data.GetLockout()
 		if err != nil {
 			return fmt.Errorf("cannot get lockout: %s", err)
 		}
 
-		if (current.Attempts != previous.Attempts && current.Attempts > lockout) || // If the number of authentication attempts on a device has exceeded the max
+		if (current.LockoutAttempts() != previous.LockoutAttempts() && current.LockoutAttempts() > lockout) || // If the number of authentication attempts on a device has exceeded the max
 			current.Endpoint.String() != previous.Endpoint.String() || // If the client ip has changed
 			current.Authorised.IsZero() { // If we've explicitly deauthorised a device
 			err := Deauthenticate(current.Address)
@@ -111,7 +161,7 @@ func deviceChanges(key string, current, previous data.Device, et data.EventType)
 		}
 
 		if current.Authorised != previous.Authorised {
-			if !current.Authorised.IsZero() && current.Attempts <= lockout {
+			if !current.Authorised.IsZero() && current.LockoutAttempts() <= lockout {
 				err := SetAuthorized(current.Address, current.Username)
 				if err != nil {
 					return fmt.Errorf("cannot authorize device %s: %s", current.Address, err)
@@ -120,6 +170,14 @@ func deviceChanges(key string, current, previous data.Device, et data.EventType)
 			}
 		}
 
+		// Step up is granted per user, so any change to a devices session may add or remove the users step up routes
+		if current.Authorised != previous.Authorised || !slices.Equal(current.StepUpFactors, previous.StepUpFactors) {
+			err := RefreshUserAcls(current.Username)
+			if err != nil {
+				return fmt.Errorf("cannot refresh acls for %s after step up change: %s", current.Username, err)
+			}
+		}
+
 	default:
 		panic("unknown state")
 	}
@@ -145,7 +203,7 @@ func membershipChanges(key string, current, previous []string, et data.EventType
 func userChanges(key string, current, previous data.UserModel, et data.EventType) error {
 	switch et {
 	case data.CREATED:
-		acls := data.GetEffectiveAcl(current.Username)
+		acls := data.GetFirewallAcl(current.Username)
 		err := AddUser(current.Username, acls)
 		if err != nil {
 			log.Printf("cannot create user %s: %s", current.Username, err)
@@ -200,6 +258,19 @@ func aclsChanges(key string, current, previous acls.Acl, et data.EventType) erro
 	return nil
 }
 
+// accessGrantChanges installs approved access requests, and removes them when they are revoked or their lease expires
+func accessGrantChanges(key string, current, previous data.AccessRequest, et data.EventType) error {
+	switch et {
+	case data.CREATED, data.DELETED, data.MODIFIED:
+		err := RefreshUserAcls(current.Username)
+		if err != nil {
+			return fmt.Errorf("failed to refresh acls for user %s after access grant change: %s", current.Username, err)
+		}
+	}
+
+	return nil
+}
+
 func groupChanges(key string, current, previous []string, et data.EventType) error {
 	switch et {
 	case data.CREATED, data.DELETED, data.MODIFIED:
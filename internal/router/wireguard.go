@@ -19,6 +19,9 @@ import (
 
 var (
 	ctrl *wgctrl.Client
+
+	// Keys issued to devices by a key rotation that they have not connected with yet, public key to device address
+	pendingPeers = map[string]string{}
 )
 
 type IfInfomsg struct {
@@ -115,6 +118,16 @@ func setupWireguard(devices []data.Device) error {
 		addressesToUsers[device.Address] = device.Username
 
 		c.Peers = append(c.Peers, pc)
+
+		if device.PendingPublickey != "" {
+			pending, err := pendingPeerConfig(device)
+			if err != nil {
+				return fmt.Errorf("device %s has an invalid pending key: %s", device.Address, err)
+			}
+
+			pendingPeers[device.PendingPublickey] = device.Address
+			c.Peers = append(c.Peers, pending)
+		}
 	}
 
 	var err error
@@ -177,7 +190,7 @@ func _removePeer(publickey, address string) error {
 	}
 
 	if err2 != nil {
-		return err1
+		return err2
 	}
 
 	user := addressesToUsers[address]
@@ -189,7 +202,7 @@ func _removePeer(publickey, address string) error {
 }
 
 // Takes the device to replace and returns the address of said device
-func ReplacePeer(device data.Device, newPublicKey wgtypes.Key) error {
+func ReplacePeer(device data.Device, newPublicKey wgtypes.Key, presharedKey string) error {
 
 	lock.Lock()
 	defer lock.Unlock()
@@ -200,6 +213,11 @@ func ReplacePeer(device data.Device, newPublicKey wgtypes.Key) error {
 		return err
 	}
 
+	var psk *wgtypes.Key
+	if key, err := wgtypes.ParseKey(presharedKey); presharedKey != "unset" && err == nil {
+		psk = &key
+	}
+
 	var c wgtypes.Config
 	c.Peers = append(c.Peers, wgtypes.PeerConfig{
 		PublicKey: oldPublicKey,
@@ -221,6 +239,7 @@ func ReplacePeer(device data.Device, newPublicKey wgtypes.Key) error {
 			PublicKey:         newPublicKey,
 			ReplaceAllowedIPs: true,
 			AllowedIPs:        []net.IPNet{*network},
+			PresharedKey:      psk,
 		},
 	}
 
@@ -229,6 +248,9 @@ func ReplacePeer(device data.Device, newPublicKey wgtypes.Key) error {
 		return err
 	}
 
+	// If the new key was pending it is now the devices key
+	delete(pendingPeers, newPublicKey.String())
+
 	addresses := usersToAddresses[device.Username]
 	addresses[device.Address] = newPublicKey.String()
 	usersToAddresses[device.Username] = addresses
@@ -236,6 +258,75 @@ func ReplacePeer(device data.Device, newPublicKey wgtypes.Key) error {
 	return nil
 }
 
+// pendingPeerConfig is the peer for keys issued to a device that it has not connected with yet, it has no allowed ips so it cannot send traffic until the rotation is completed
+func pendingPeerConfig(device data.Device) (wgtypes.PeerConfig, error) {
+	pk, err := wgtypes.ParseKey(device.PendingPublickey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, err
+	}
+
+	psk, err := wgtypes.ParseKey(device.PendingPresharedKey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, err
+	}
+
+	return wgtypes.PeerConfig{
+		PublicKey:         pk,
+		ReplaceAllowedIPs: true,
+		PresharedKey:      &psk,
+	}, nil
+}
+
+// AddPendingPeer lets a device handshake with the keys it was issued by a key rotation, while its current keys keep working
+func AddPendingPeer(device data.Device) error {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	pc, err := pendingPeerConfig(device)
+	if err != nil {
+		return err
+	}
+
+	err = ctrl.ConfigureDevice(config.Values.Wireguard.DevName, wgtypes.Config{Peers: []wgtypes.PeerConfig{pc}})
+	if err != nil {
+		return err
+	}
+
+	pendingPeers[device.PendingPublickey] = device.Address
+
+	return nil
+}
+
+// RemovePendingPeer removes keys issued by a key rotation that were replaced before the device used them
+func RemovePendingPeer(publickey string) error {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	pk, err := wgtypes.ParseKey(publickey)
+	if err != nil {
+		return err
+	}
+
+	err = ctrl.ConfigureDevice(config.Values.Wireguard.DevName, wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: pk, Remove: true}}})
+	if err != nil {
+		return err
+	}
+
+	delete(pendingPeers, publickey)
+
+	return nil
+}
+
+func pendingPeerAddress(publickey string) (string, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	address, ok := pendingPeers[publickey]
+	return address, ok
+}
+
 func ListPeers() ([]wgtypes.Peer, error) {
 
 	lock.Lock()
@@ -36,27 +36,34 @@ var (
 	globalCache = map[string][]Rule{}
 )
 
-func hash(mfa, public, deny []string) string {
+func hash(mfa, public, deny, stepUp []string) string {
 
 	sort.Strings(mfa)
 	sort.Strings(public)
 	sort.Strings(deny)
+	sort.Strings(stepUp)
 
 	b := bytes.NewBuffer(nil)
 	encoder := json.NewEncoder(b)
 	encoder.Encode(mfa)
 	encoder.Encode(public)
 	encoder.Encode(deny)
+	encoder.Encode(stepUp)
 
 	result := sha1.Sum(b.Bytes())
 	return hex.EncodeToString(result[:])
 }
 
 func ParseRules(mfa, public, deny []string) (result []Rule, errs []error) {
+	return ParseRulesWithStepUp(mfa, public, deny, nil)
+}
+
+// ParseRulesWithStepUp is ParseRules with an additional set of rules that require step up authentication, these are marked with the STEPUP flag
+func ParseRulesWithStepUp(mfa, public, deny, stepUp []string) (result []Rule, errs []error) {
 
 	cache := map[string]int{}
 
-	parseKey := hash(mfa, public, deny)
+	parseKey := hash(mfa, public, deny, stepUp)
 
 	rwLock.RLock()
 	if entry, ok := globalCache[parseKey]; ok {
@@ -66,68 +73,31 @@ func ParseRules(mfa, public, deny []string) (result []Rule, errs []error) {
 	}
 	rwLock.RUnlock()
 
-	// Add
-
-	for _, rule := range mfa {
-
-		r, err := parseRule(0, rule)
-		if err != nil {
-			errs = append(errs, err)
-			continue
-		}
-
-		for i := range r.Keys {
-			if index, ok := cache[r.Keys[i].String()]; ok {
-				// Maybe do deduplication here? But I'll resolve this if it ever becomes an issue for someone
-				result[index].Values = append(result[index].Values, r.Values...)
+	add := func(restrictionType PolicyType, rules []string) {
+		for _, rule := range rules {
+			r, err := parseRule(restrictionType, rule)
+			if err != nil {
+				errs = append(errs, err)
 				continue
 			}
 
-			result = append(result, r)
-			cache[r.Keys[i].String()] = len(result) - 1
-		}
-
-	}
-
-	for _, rule := range public {
-		r, err := parseRule(PUBLIC, rule)
-		if err != nil {
-			errs = append(errs, err)
-			continue
-		}
+			for i := range r.Keys {
+				if index, ok := cache[r.Keys[i].String()]; ok {
+					// Maybe do deduplication here? But I'll resolve this if it ever becomes an issue for someone
+					result[index].Values = append(result[index].Values, r.Values...)
+					continue
+				}
 
-		for i := range r.Keys {
-			if index, ok := cache[r.Keys[i].String()]; ok {
-				// Maybe do deduplication here? But I'll resolve this if it ever becomes an issue for someone
-				result[index].Values = append(result[index].Values, r.Values...)
-				continue
+				result = append(result, r)
+				cache[r.Keys[i].String()] = len(result) - 1
 			}
-
-			result = append(result, r)
-			cache[r.Keys[i].String()] = len(result) - 1
 		}
-
 	}
 
-	for _, rule := range deny {
-		r, err := parseRule(DENY, rule)
-		if err != nil {
-			errs = append(errs, err)
-			continue
-		}
-
-		for i := range r.Keys {
-			if index, ok := cache[r.Keys[i].String()]; ok {
-				// Maybe do deduplication here? But I'll resolve this if it ever becomes an issue for someone
-				result[index].Values = append(result[index].Values, r.Values...)
-				continue
-			}
-
-			result = append(result, r)
-			cache[r.Keys[i].String()] = len(result) - 1
-		}
-
-	}
+	add(0, mfa)
+	add(PUBLIC, public)
+	add(DENY, deny)
+	add(STEPUP, stepUp)
 
 	for i := range result {
 		if len(result[i].Values) > MAX_POLICIES {
@@ -244,7 +214,11 @@ func parseKeys(address string) (keys []Key, err error) {
 }
 
 func ValidateRules(mfa, public, deny []string) error {
-	_, errs := ParseRules(mfa, public, deny)
+	return ValidateRulesWithStepUp(mfa, public, deny, nil)
+}
+
+func ValidateRulesWithStepUp(mfa, public, deny, stepUp []string) error {
+	_, errs := ParseRulesWithStepUp(mfa, public, deny, stepUp)
 
 	if len(errs) == 0 {
 		return nil
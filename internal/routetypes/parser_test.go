@@ -403,3 +403,36 @@ func TestParseRulesDuplicates(t *testing.T) {
 	}
 
 }
+
+func TestParseRulesStepUp(t *testing.T) {
+
+	result, err := ParseRulesWithStepUp([]string{"192.168.34.1/32 22/tcp"}, []string{}, []string{}, []string{"192.168.34.1/32 3389/tcp", "192.168.35.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 2 {
+		t.Fatal("resulting number of rules was wrong, expected 2 got: ", len(result))
+	}
+
+	if result[0].NumPolicies != 2 {
+		t.Fatal("step up policy was not merged with mfa policy on the same key")
+	}
+
+	if result[0].Values[0].Is(STEPUP) || !result[0].Values[1].Is(STEPUP) {
+		t.Fatal("step up flag was set on the wrong policy: ", result[0].Values[0], result[0].Values[1])
+	}
+
+	if !result[1].Values[0].Is(STEPUP) || result[1].Values[0].Is(PUBLIC) || result[1].Values[0].Is(DENY) {
+		t.Fatal("step up only route had the wrong flags: ", result[1].Values[0])
+	}
+
+	plain, err := ParseRules([]string{"192.168.34.1/32 22/tcp"}, []string{}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plain) != 1 || plain[0].NumPolicies != 1 {
+		t.Fatal("step up rules leaked in to cached result of rules without step up")
+	}
+}
@@ -17,6 +17,8 @@ const (
 	SINGLE
 
 	DENY // Deny flag which is additional to RANGE/SINGLE types
+
+	STEPUP // Step up flag, the xdp program treats these as mfa routes, wag only installs them for users that have completed every required step up factor
 )
 
 // Format
@@ -76,6 +78,10 @@ func (r Policy) String() string {
 		restrictionType = "public"
 	}
 
+	if r.Is(STEPUP) {
+		restrictionType = "stepup"
+	}
+
 	if r.Is(DENY) {
 		restrictionType = "deny"
 	}
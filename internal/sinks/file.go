@@ -0,0 +1,40 @@
+package sinks
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/NHAS/wag/internal/data"
+)
+
+// file appends events as json lines, the file is written on whichever node delivers the event so in a cluster it should be on shared storage
+type file struct {
+	sync.Mutex
+	path string
+}
+
+func newFile(sink data.Sink) *file {
+	return &file{
+		path: sink.Path,
+	}
+}
+
+func (f *file) Send(event data.SinkEvent, body []byte) error {
+	f.Lock()
+	defer f.Unlock()
+
+	// Opened per event so that the file can be rotated from underneath us
+	fd, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open sink file: %w", err)
+	}
+	defer fd.Close()
+
+	_, err = fd.Write(append(body, '\n'))
+	return err
+}
+
+func (f *file) Close() error {
+	return nil
+}
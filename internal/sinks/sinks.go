@@ -0,0 +1,256 @@
+package sinks
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+)
+
+const (
+	// How long an event can sit in the outbox before the leader assumes its owner has failed and tries to deliver it again
+	redeliveryDelay = 5 * time.Minute
+	maxRedeliveries = 10
+
+	// Claims only need to outlive the time it takes every node to see a change
+	claimRetention = 24 * time.Hour
+)
+
+type sender interface {
+	Send(event data.SinkEvent, body []byte) error
+	Close() error
+}
+
+type configuredSink struct {
+	data.Sink
+	sender sender
+}
+
+var (
+	lck   sync.RWMutex
+	sinks = map[string]configuredSink{}
+
+	listenerKeys []string
+	cancel       chan bool
+)
+
+func (s configuredSink) wants(eventType string) bool {
+	return len(s.Events) == 0 || slices.Contains(s.Events, eventType)
+}
+
+func newSender(sink data.Sink) (sender, error) {
+	switch sink.Type {
+	case data.WebhookSink:
+		return newWebhook(sink), nil
+	case data.SyslogSink:
+		return newSyslog(sink)
+	case data.FileSink:
+		return newFile(sink), nil
+	}
+
+	return nil, fmt.Errorf("unknown sink type %q", sink.Type)
+}
+
+func loadSinks(configured []data.Sink) {
+	newSinks := map[string]configuredSink{}
+	for _, sink := range configured {
+		s, err := newSender(sink)
+		if err != nil {
+			log.Println("unable to configure event sink", sink.Name, ": ", err)
+			continue
+		}
+
+		newSinks[sink.Name] = configuredSink{Sink: sink, sender: s}
+	}
+
+	lck.Lock()
+	old := sinks
+	sinks = newSinks
+	lck.Unlock()
+
+	for _, s := range old {
+		s.sender.Close()
+	}
+}
+
+func sinksChanged(key string, current, previous []data.Sink, et data.EventType) error {
+	switch et {
+	case data.DELETED:
+		loadSinks(nil)
+	case data.CREATED, data.MODIFIED:
+		loadSinks(current)
+	}
+
+	return nil
+}
+
+// Start loads the configured sinks and begins watching for events, every node does this but each event is only delivered by the node that claims it first
+func Start() error {
+	configured, err := data.GetSinks()
+	if err != nil {
+		return fmt.Errorf("unable to get event sinks: %w", err)
+	}
+
+	loadSinks(configured)
+
+	key, err := data.RegisterEventListener(data.SinksKey, false, sinksChanged)
+	if err != nil {
+		return err
+	}
+	listenerKeys = append(listenerKeys, key)
+
+	err = registerSources()
+	if err != nil {
+		Teardown()
+		return err
+	}
+
+	cancel = make(chan bool)
+	go redeliver(cancel)
+	go monitorClusterMembers(cancel)
+
+	return nil
+}
+
+func Teardown() {
+	for _, key := range listenerKeys {
+		data.DeregisterEventListener(key)
+	}
+	listenerKeys = nil
+
+	if cancel != nil {
+		close(cancel)
+		cancel = nil
+	}
+
+	loadSinks(nil)
+}
+
+// emit sends an event to every sink that wants it, provided no other node has already claimed it.
+// The id must be derived from the change that caused the event so that every node generates the same one
+func emit(id, eventType string, eventData any) {
+	lck.RLock()
+	var names []string
+	for name, sink := range sinks {
+		if sink.wants(eventType) {
+			names = append(names, name)
+		}
+	}
+	lck.RUnlock()
+
+	if len(names) == 0 {
+		return
+	}
+
+	b, err := json.Marshal(eventData)
+	if err != nil {
+		log.Println("unable to marshal sink event", eventType, ": ", err)
+		return
+	}
+
+	event := data.SinkEvent{
+		ID:     id,
+		Type:   eventType,
+		Time:   time.Now(),
+		NodeID: data.GetServerID(),
+		Data:   b,
+	}
+
+	claimed, err := data.ClaimSinkEvent(event, names)
+	if err != nil {
+		log.Println("unable to claim sink event", eventType, id, ": ", err)
+		return
+	}
+
+	if !claimed {
+		return
+	}
+
+	deliver(data.PendingSinkEvent{Event: event, Sinks: names})
+}
+
+// deliver sends the event to each of its outstanding sinks, and records the ones that failed so the event can be retried later
+func deliver(pending data.PendingSinkEvent) {
+	body, err := json.Marshal(pending.Event)
+	if err != nil {
+		log.Println("unable to marshal sink event", pending.Event.ID, ": ", err)
+		return
+	}
+
+	var failed []string
+	for _, name := range pending.Sinks {
+		lck.RLock()
+		sink, ok := sinks[name]
+		lck.RUnlock()
+
+		// The sink has been removed since the event was claimed
+		if !ok {
+			continue
+		}
+
+		err := sink.sender.Send(pending.Event, body)
+		if err != nil {
+			log.Println("unable to send event", pending.Event.ID, "to sink", name, ": ", err)
+			failed = append(failed, name)
+		}
+	}
+
+	pending.Sinks = failed
+	err = data.UpdateSinkEvent(pending)
+	if err != nil {
+		log.Println("unable to update sink outbox for event", pending.Event.ID, ": ", err)
+	}
+}
+
+// redeliver retries events that were not delivered to all of their sinks, either because a sink was down or the node that claimed the event died.
+// Only the leader does this, and the outbox entry is reclaimed atomically so a leadership change cannot cause duplicates
+func redeliver(cancel <-chan bool) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !data.IsLeader() {
+				continue
+			}
+
+			if err := data.PruneSinkClaims(claimRetention); err != nil {
+				log.Println("unable to prune sink event claims: ", err)
+			}
+
+			stale, err := data.GetStaleSinkEvents(redeliveryDelay)
+			if err != nil {
+				log.Println("unable to get undelivered sink events: ", err)
+				continue
+			}
+
+			for _, pending := range stale {
+				if pending.Attempts >= maxRedeliveries {
+					log.Println("giving up on sink event", pending.Event.ID, pending.Event.Type, "undelivered sinks:", pending.Sinks)
+					pending.Sinks = nil
+					if err := data.UpdateSinkEvent(pending); err != nil {
+						log.Println("unable to remove sink event from outbox: ", err)
+					}
+					continue
+				}
+
+				pending, ok, err := data.ReclaimSinkEvent(pending)
+				if err != nil {
+					log.Println("unable to reclaim sink event", pending.Event.ID, ": ", err)
+					continue
+				}
+
+				if ok {
+					deliver(pending)
+				}
+			}
+		case <-cancel:
+			return
+		}
+	}
+}
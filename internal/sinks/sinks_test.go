@@ -0,0 +1,182 @@
+package sinks
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+)
+
+func testEvent() data.SinkEvent {
+	return data.SinkEvent{
+		ID:     data.SinkEventID(DeviceLocked, "devices-toaster-192.168.1.2", 42),
+		Type:   DeviceLocked,
+		Time:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		NodeID: "1",
+		Data:   []byte(`{"username":"toaster"}`),
+	}
+}
+
+func TestSinkEventID(t *testing.T) {
+	a := data.SinkEventID(DeviceLocked, "devices-toaster-192.168.1.2", 42)
+	if a != data.SinkEventID(DeviceLocked, "devices-toaster-192.168.1.2", 42) {
+		t.Fatal("the same change should produce the same id")
+	}
+
+	if a == data.SinkEventID(DeviceLocked, "devices-toaster-192.168.1.2", 43) {
+		t.Fatal("different revisions should produce different ids")
+	}
+
+	if a == data.SinkEventID(DeviceAuthorised, "devices-toaster-192.168.1.2", 42) {
+		t.Fatal("different event types from the same change should produce different ids")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var (
+		calls   atomic.Int32
+		failing atomic.Bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first attempt to check that the webhook is retried
+		if calls.Add(1) == 1 || failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign([]byte("secret"), body) {
+			t.Error("signature did not match body: ", r.Header.Get(SignatureHeader))
+		}
+
+		if r.Header.Get(EventHeader) != DeviceLocked {
+			t.Error("event header was incorrect: ", r.Header.Get(EventHeader))
+		}
+
+		if r.Header.Get(DeliveryHeader) != testEvent().ID {
+			t.Error("delivery header was incorrect: ", r.Header.Get(DeliveryHeader))
+		}
+	}))
+	defer server.Close()
+
+	wh := newWebhook(data.Sink{Name: "test", Type: data.WebhookSink, URL: server.URL, Secret: "secret"})
+	wh.backoff = time.Millisecond
+
+	err := wh.Send(testEvent(), []byte(`{"test":true}`))
+	if err != nil {
+		t.Fatal("webhook should have succeeded after retrying: ", err)
+	}
+
+	if calls.Load() != 2 {
+		t.Fatal("expected 2 calls got: ", calls.Load())
+	}
+
+	wh.maxRetries = 1
+	failing.Store(true)
+	err = wh.Send(testEvent(), []byte(`{"test":true}`))
+	if err == nil {
+		t.Fatal("webhook should have failed after running out of retries")
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 2)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		for {
+			// Octet counting framing, "<length> <message>"
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+
+			n, err := strconv.Atoi(strings.TrimSpace(length))
+			if err != nil {
+				t.Error("invalid frame length: ", length)
+				return
+			}
+
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(reader, msg); err != nil {
+				return
+			}
+
+			received <- string(msg)
+		}
+	}()
+
+	s, err := newSyslog(data.Sink{Name: "test", Type: data.SyslogSink, Address: listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		err = s.Send(testEvent(), []byte("{\"multi\":\n\"line\"}"))
+		if err != nil {
+			t.Fatal("unable to send syslog message: ", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			// local0 (16) * 8 + warning (4)
+			if !strings.HasPrefix(msg, "<132>1 2024-01-02T03:04:05Z ") {
+				t.Fatal("message had incorrect header: ", msg)
+			}
+
+			if !strings.Contains(msg, " wag ") || !strings.Contains(msg, " "+DeviceLocked+" - ") {
+				t.Fatal("message did not contain app name or msgid: ", msg)
+			}
+
+			if !strings.HasSuffix(msg, "{\"multi\":\n\"line\"}") {
+				t.Fatal("message body was not intact: ", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for syslog message")
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+
+	f := newFile(data.Sink{Name: "test", Type: data.FileSink, Path: path})
+	for _, body := range []string{`{"a":1}`, `{"b":2}`} {
+		if err := f.Send(testEvent(), []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(contents) != "{\"a\":1}\n{\"b\":2}\n" {
+		t.Fatalf("file contents were incorrect: %q", contents)
+	}
+}
@@ -0,0 +1,221 @@
+package sinks
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control"
+)
+
+// Event types that can be sent to sinks
+const (
+	DeviceCreated     = "device_created"
+	DeviceDeleted     = "device_deleted"
+	DeviceAuthorised  = "device_authorised"
+	DeviceLocked      = "device_locked"
+	UserLocked        = "user_locked"
+	UserUnlocked      = "user_unlocked"
+	RegistrationUsed  = "registration_used"
+	ClusterMemberLost = "cluster_member_lost"
+	Error             = "error"
+	Audit             = "audit"
+)
+
+// A member is considered lost when it has not written a liveness ping for this long, the same as the management ui
+const memberLostThreshold = 14 * time.Second
+
+type deviceEvent struct {
+	Username   string       `json:"username"`
+	Address    string       `json:"address"`
+	Publickey  string       `json:"public_key"`
+	Endpoint   *net.UDPAddr `json:"endpoint,omitempty"`
+	Attempts   int          `json:"attempts"`
+	Authorised time.Time    `json:"authorised,omitempty"`
+}
+
+type userEvent struct {
+	Username string `json:"username"`
+}
+
+type registrationEvent struct {
+	Username   string   `json:"username"`
+	Groups     []string `json:"groups,omitempty"`
+	Overwrites string   `json:"overwrites,omitempty"`
+	Remaining  int      `json:"remaining"`
+}
+
+type clusterMemberEvent struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	LastPing time.Time `json:"last_ping"`
+}
+
+func registerSources() error {
+	key, err := data.RegisterRevisionedEventListener(data.DevicesPrefix, true, deviceEvents)
+	if err != nil {
+		return err
+	}
+	listenerKeys = append(listenerKeys, key)
+
+	key, err = data.RegisterRevisionedEventListener(data.UsersPrefix, true, userEvents)
+	if err != nil {
+		return err
+	}
+	listenerKeys = append(listenerKeys, key)
+
+	key, err = data.RegisterRevisionedEventListener("tokens-", true, registrationEvents)
+	if err != nil {
+		return err
+	}
+	listenerKeys = append(listenerKeys, key)
+
+	key, err = data.RegisterRevisionedEventListener(data.NodeErrors, true, errorEvents)
+	if err != nil {
+		return err
+	}
+	listenerKeys = append(listenerKeys, key)
+
+	key, err = data.RegisterRevisionedEventListener(data.AuditPrefix, true, auditEvents)
+	if err != nil {
+		return err
+	}
+	listenerKeys = append(listenerKeys, key)
+
+	return nil
+}
+
+// Sink listeners never return errors, as errors are raised with the cluster which would in turn create another event
+
+func deviceEvents(key string, revision int64, current, previous data.Device, et data.EventType) error {
+	event := deviceEvent{
+		Username:   current.Username,
+		Address:    current.Address,
+		Publickey:  current.Publickey,
+		Endpoint:   current.Endpoint,
+		Attempts:   current.LockoutAttempts(),
+		Authorised: current.Authorised,
+	}
+
+	switch et {
+	case data.CREATED:
+		emit(data.SinkEventID(DeviceCreated, key, revision), DeviceCreated, event)
+	case data.DELETED:
+		emit(data.SinkEventID(DeviceDeleted, key, revision), DeviceDeleted, event)
+	case data.MODIFIED:
+		if !current.Authorised.IsZero() && !current.Authorised.Equal(previous.Authorised) {
+			emit(data.SinkEventID(DeviceAuthorised, key, revision), DeviceAuthorised, event)
+		}
+
+		if current.LockoutAttempts() > previous.LockoutAttempts() {
+			lockout, err := data.GetLockout()
+			if err != nil {
+				log.Println("unable to get lockout for sink event: ", err)
+				return nil
+			}
+
+			if previous.LockoutAttempts() <= lockout && current.LockoutAttempts() > lockout {
+				emit(data.SinkEventID(DeviceLocked, key, revision), DeviceLocked, event)
+			}
+		}
+	}
+
+	return nil
+}
+
+func userEvents(key string, revision int64, current, previous data.UserModel, et data.EventType) error {
+	if et == data.DELETED {
+		return nil
+	}
+
+	event := userEvent{Username: current.Username}
+	if current.Locked && !previous.Locked {
+		emit(data.SinkEventID(UserLocked, key, revision), UserLocked, event)
+	} else if !current.Locked && previous.Locked {
+		emit(data.SinkEventID(UserUnlocked, key, revision), UserUnlocked, event)
+	}
+
+	return nil
+}
+
+func registrationEvents(key string, revision int64, current, previous control.RegistrationResult, et data.EventType) error {
+	// Tokens are deleted after their last use has been written, so only modifications are uses
+	if et != data.MODIFIED || current.NumUses >= previous.NumUses {
+		return nil
+	}
+
+	emit(data.SinkEventID(RegistrationUsed, key, revision), RegistrationUsed, registrationEvent{
+		Username:   current.Username,
+		Groups:     current.Groups,
+		Overwrites: current.Overwrites,
+		Remaining:  max(current.NumUses, 0),
+	})
+
+	return nil
+}
+
+func errorEvents(key string, revision int64, current, previous data.EventError, et data.EventType) error {
+	if et != data.CREATED {
+		return nil
+	}
+
+	emit(data.SinkEventID(Error, key, revision), Error, current)
+	return nil
+}
+
+func auditEvents(key string, revision int64, current, previous data.AuditEvent, et data.EventType) error {
+	if et != data.CREATED {
+		return nil
+	}
+
+	emit(data.SinkEventID(Audit, key, revision), Audit, current)
+	return nil
+}
+
+// monitorClusterMembers emits an event when a member stops pinging the cluster, liveness is not a key change so only the leader watches for it.
+// The event id is derived from the members last ping, so if leadership changes the new leader will not send it again
+func monitorClusterMembers(cancel <-chan bool) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	lost := map[string]bool{}
+	for {
+		select {
+		case <-ticker.C:
+			if !data.IsLeader() {
+				continue
+			}
+
+			for _, member := range data.GetMembers() {
+				id := member.ID.String()
+				if id == data.GetServerID() {
+					continue
+				}
+
+				lastPing, err := data.GetLastPing(id)
+				if err != nil {
+					continue
+				}
+
+				if time.Since(lastPing) < memberLostThreshold {
+					delete(lost, id)
+					continue
+				}
+
+				if lost[id] {
+					continue
+				}
+				lost[id] = true
+
+				emit(data.SinkEventID(ClusterMemberLost, id, lastPing.Unix()), ClusterMemberLost, clusterMemberEvent{
+					ID:       id,
+					Name:     member.Name,
+					LastPing: lastPing,
+				})
+			}
+		case <-cancel:
+			return
+		}
+	}
+}
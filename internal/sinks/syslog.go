@@ -0,0 +1,140 @@
+package sinks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+)
+
+const (
+	facilityLocal0 = 16
+
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// syslog sends events as RFC5424 messages over TCP, using octet counting framing (RFC6587) so messages may contain newlines
+type syslog struct {
+	sync.Mutex
+
+	address   string
+	tlsConfig *tls.Config
+	hostname  string
+
+	conn net.Conn
+}
+
+func newSyslog(sink data.Sink) (*syslog, error) {
+	s := &syslog{
+		address: sink.Address,
+	}
+
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+
+	if sink.TLS {
+		host, _, err := net.SplitHostPort(sink.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog address: %w", err)
+		}
+
+		s.tlsConfig = &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: sink.InsecureSkipVerify,
+			MinVersion:         tls.VersionTLS12,
+		}
+
+		if sink.CACertificate != "" {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM([]byte(sink.CACertificate)) {
+				return nil, errors.New("could not parse syslog ca certificate")
+			}
+			s.tlsConfig.RootCAs = pool
+		}
+	}
+
+	return s, nil
+}
+
+func severity(eventType string) int {
+	switch eventType {
+	case DeviceLocked, UserLocked, ClusterMemberLost, Error:
+		return severityWarning
+	}
+
+	return severityInfo
+}
+
+// formatSyslog creates an RFC5424 message with no structured data, the event json is the message body
+func formatSyslog(hostname string, event data.SinkEvent, body []byte) []byte {
+	msg := fmt.Sprintf("<%d>1 %s %s wag %d %s - %s",
+		facilityLocal0*8+severity(event.Type),
+		event.Time.Format(time.RFC3339Nano),
+		hostname,
+		os.Getpid(),
+		event.Type,
+		body,
+	)
+
+	return []byte(fmt.Sprintf("%d %s", len(msg), msg))
+}
+
+func (s *syslog) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if s.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", s.address, s.tlsConfig)
+	}
+
+	return dialer.Dial("tcp", s.address)
+}
+
+func (s *syslog) Send(event data.SinkEvent, body []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	msg := formatSyslog(s.hostname, event, body)
+
+	// The connection is kept open between events, so if writing fails it may have been closed by the server, reconnect and try once more
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			s.conn, err = s.dial()
+			if err != nil {
+				return fmt.Errorf("unable to connect to syslog server: %w", err)
+			}
+		}
+
+		s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, err = s.conn.Write(msg)
+		if err == nil {
+			return nil
+		}
+
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return fmt.Errorf("unable to write to syslog server: %w", err)
+}
+
+func (s *syslog) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
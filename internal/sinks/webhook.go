@@ -0,0 +1,105 @@
+package sinks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+)
+
+const (
+	SignatureHeader = "X-Wag-Signature"
+	EventHeader     = "X-Wag-Event"
+	DeliveryHeader  = "X-Wag-Delivery"
+
+	defaultWebhookRetries = 3
+)
+
+type webhook struct {
+	url        string
+	secret     []byte
+	maxRetries int
+
+	// Initial delay between attempts, doubled after each failure
+	backoff time.Duration
+
+	client *http.Client
+}
+
+func newWebhook(sink data.Sink) *webhook {
+	retries := sink.MaxRetries
+	if retries == 0 {
+		retries = defaultWebhookRetries
+	}
+
+	return &webhook{
+		url:        sink.URL,
+		secret:     []byte(sink.Secret),
+		maxRetries: retries,
+		backoff:    time.Second,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Sign returns the value of the signature header for a webhook body, receivers should compute the same value and compare them in constant time
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wh *webhook) Send(event data.SinkEvent, body []byte) error {
+	var err error
+	backoff := wh.backoff
+	for attempt := 0; attempt <= wh.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		err = wh.post(event, body)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("webhook failed after %d attempts: %w", wh.maxRetries+1, err)
+}
+
+func (wh *webhook) post(event data.SinkEvent, body []byte) error {
+	req, err := http.NewRequest("POST", wh.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event.Type)
+	req.Header.Set(DeliveryHeader, event.ID)
+	if len(wh.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(wh.secret, body))
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (wh *webhook) Close() error {
+	wh.client.CloseIdleConnections()
+	return nil
+}
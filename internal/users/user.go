@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/metrics"
 	"github.com/NHAS/wag/internal/webserver/authenticators/types"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
@@ -27,6 +29,16 @@ func (u *user) ResetMfa() error {
 		return err
 	}
 
+	err = data.ResetStepUp(u.Username)
+	if err != nil {
+		return err
+	}
+
+	err = data.DeleteRecoveryCodes(u.Username)
+	if err != nil {
+		return err
+	}
+
 	return u.UnenforceMFA()
 }
 
@@ -102,6 +114,31 @@ func (u *user) Delete() error {
 }
 
 func (u *user) Authenticate(device, mfaType string, authenticator types.AuthenticatorFunc) error {
+	err := u.authenticate(device, mfaType, authenticator)
+	u.auditAuthentication(data.AuditLogin, data.AuditLoginFailed, device, mfaType, err)
+
+	return err
+}
+
+// auditAuthentication records the result of an authentication attempt in the audit log and metrics, rejections due to a lock are recorded as lockouts
+func (u *user) auditAuthentication(success, failure data.AuditEventType, device, method string, err error) {
+	if err == nil {
+		metrics.MFAAttempts.WithLabelValues(method, "success").Inc()
+		data.Audit(success, u.Username, device, "", "method: "+method)
+		return
+	}
+
+	if strings.Contains(err.Error(), "device is locked") || strings.Contains(err.Error(), "account is locked") {
+		metrics.MFAAttempts.WithLabelValues(method, "locked").Inc()
+		data.Audit(data.AuditLockout, u.Username, device, "", "method: "+method+", "+err.Error())
+		return
+	}
+
+	metrics.MFAAttempts.WithLabelValues(method, "failure").Inc()
+	data.Audit(failure, u.Username, device, "", "method: "+method+", "+err.Error())
+}
+
+func (u *user) authenticate(device, mfaType string, authenticator types.AuthenticatorFunc) error {
 
 	// Make sure that the attempts is always incremented first to stop race condition attacks
 	err := data.IncrementAuthenticationAttempt(u.Username, device)
@@ -141,6 +178,8 @@ func (u *user) Authenticate(device, mfaType string, authenticator types.Authenti
 		if err != nil {
 			return fmt.Errorf("%s %s failed to set MFA to enforcing: %s", u.Username, device, err)
 		}
+
+		data.Audit(data.AuditMFARegistered, u.Username, device, "", "method: "+mfaType)
 	}
 
 	err = data.AuthoriseDevice(u.Username, device)
@@ -151,6 +190,179 @@ func (u *user) Authenticate(device, mfaType string, authenticator types.Authenti
 	return nil
 }
 
+// ExtendSession checks the users mfa method again for a device that is already authorised, restarting its session without dropping its connections
+func (u *user) ExtendSession(device, mfaType string, authenticator types.AuthenticatorFunc) error {
+	err := u.extendSession(device, mfaType, authenticator)
+	u.auditAuthentication(data.AuditSessionExtended, data.AuditLoginFailed, device, mfaType, err)
+
+	return err
+}
+
+func (u *user) extendSession(device, mfaType string, authenticator types.AuthenticatorFunc) error {
+
+	// Extension shares the device attempt counter with normal authentication, so it cannot be used to get more guesses
+	err := data.IncrementAuthenticationAttempt(u.Username, device)
+	if err != nil {
+		return err
+	}
+
+	mfa, userMfaType, attempts, locked, err := data.GetAuthenticationDetails(u.Username, device)
+	if err != nil {
+		return err
+	}
+
+	lockout, err := data.GetLockout()
+	if err != nil {
+		return errors.New("could not get lockout value")
+	}
+
+	if attempts >= lockout {
+		return errors.New("device is locked")
+	}
+
+	if locked {
+		return errors.New("account is locked")
+	}
+
+	if !u.IsEnforcingMFA() {
+		return errors.New("sessions can only be extended once mfa has been registered")
+	}
+
+	if userMfaType != mfaType {
+		return errors.New("authenticator " + mfaType + " used for user with " + userMfaType)
+	}
+
+	if err := authenticator(mfa, u.Username); err != nil {
+		return err
+	}
+
+	err = data.ExtendDeviceSession(u.Username, device)
+	if err != nil {
+		return fmt.Errorf("%s %s unable to extend session: %s", u.Username, device, err)
+	}
+
+	return nil
+}
+
+// AuthenticateRecoveryCode authorises the device with one of the users one time recovery codes instead of their mfa method
+func (u *user) AuthenticateRecoveryCode(device, code string) error {
+	err := u.authenticateRecoveryCode(device, code)
+	u.auditAuthentication(data.AuditLogin, data.AuditLoginFailed, device, "recovery code", err)
+
+	return err
+}
+
+func (u *user) authenticateRecoveryCode(device, code string) error {
+
+	err := data.IncrementAuthenticationAttempt(u.Username, device)
+	if err != nil {
+		return err
+	}
+
+	_, _, attempts, locked, err := data.GetAuthenticationDetails(u.Username, device)
+	if err != nil {
+		return err
+	}
+
+	lockout, err := data.GetLockout()
+	if err != nil {
+		return errors.New("could not get lockout value")
+	}
+
+	if attempts >= lockout {
+		return errors.New("device is locked")
+	}
+
+	if locked {
+		return errors.New("account is locked")
+	}
+
+	if !u.IsEnforcingMFA() {
+		return errors.New("recovery codes can only be used once mfa has been registered")
+	}
+
+	err = data.UseRecoveryCode(u.Username, code)
+	if err != nil {
+		return err
+	}
+
+	err = data.AuthoriseDevice(u.Username, device)
+	if err != nil {
+		return fmt.Errorf("%s %s unable to reset number of mfa attempts: %s", u.Username, device, err)
+	}
+
+	return nil
+}
+
+func (u *user) GenerateRecoveryCodes() ([]string, error) {
+	return data.GenerateRecoveryCodes(u.Username)
+}
+
+// AuthenticateStepUp checks an additional factor for a device that has already authorised with the users primary mfa method
+func (u *user) AuthenticateStepUp(device, mfaType string, authenticator types.AuthenticatorFunc) error {
+	err := u.authenticateStepUp(device, mfaType, authenticator)
+	u.auditAuthentication(data.AuditStepUp, data.AuditStepUpFailed, device, mfaType, err)
+
+	return err
+}
+
+func (u *user) authenticateStepUp(device, mfaType string, authenticator types.AuthenticatorFunc) error {
+
+	// Step up shares the device attempt counter with normal authentication, so it cannot be used to get more guesses
+	err := data.IncrementAuthenticationAttempt(u.Username, device)
+	if err != nil {
+		return err
+	}
+
+	mfa, attempts, locked, authorised, err := data.GetStepUpAuthenticationDetails(u.Username, device, mfaType)
+	if err != nil {
+		return err
+	}
+
+	lockout, err := data.GetLockout()
+	if err != nil {
+		return errors.New("could not get lockout value")
+	}
+
+	if attempts >= lockout {
+		return errors.New("device is locked")
+	}
+
+	if locked {
+		return errors.New("account is locked")
+	}
+
+	if authorised.IsZero() {
+		return errors.New("device must authorise with " + u.GetMFAType() + " before step up")
+	}
+
+	if err := authenticator(mfa, u.Username); err != nil {
+		return err
+	}
+
+	if !u.IsEnforcingStepUp(mfaType) {
+		err := data.SetStepUpEnforcing(u.Username, mfaType)
+		if err != nil {
+			return fmt.Errorf("%s %s failed to set step up %s to enforcing: %s", u.Username, device, mfaType, err)
+		}
+	}
+
+	err = data.AddDeviceStepUpFactor(u.Username, device, mfaType)
+	if err != nil {
+		return fmt.Errorf("%s %s unable to record step up: %s", u.Username, device, err)
+	}
+
+	return nil
+}
+
+func (u *user) IsEnforcingStepUp(mfaType string) bool {
+	return data.IsEnforcingStepUp(u.Username, mfaType)
+}
+
+func (u *user) StepUpMFA(mfaType string) (string, error) {
+	return data.GetStepUpMFASecret(u.Username, mfaType)
+}
+
 func (u *user) Deauthenticate(device string) error {
 	return data.DeauthenticateDevice(device)
 }
@@ -1,9 +1,11 @@
 package users
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/NHAS/wag/internal/config"
@@ -197,3 +199,250 @@ func TestDeleteUser(t *testing.T) {
 	}
 
 }
+
+func TestStepUp(t *testing.T) {
+
+	user, err := CreateUser("fronk5")
+	if err != nil {
+		t.Fatal("could not make user:", err)
+	}
+
+	pubkey, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device, err := user.AddDevice(pubkey)
+	if err != nil {
+		t.Fatal("unable to add device:", err)
+	}
+
+	err = data.SetStepUpMethods([]string{"totp", "webauthn"})
+	if err != nil {
+		t.Fatal("unable to set step up methods:", err)
+	}
+	defer data.SetStepUpMethods(nil)
+
+	err = data.SetUserMfa(user.Username, "secret", "totp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = data.SetUserStepUpMfa(user.Username, "stepsecret", "webauthn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSecret := func(expected string) func(mfaSecret, username string) error {
+		return func(mfaSecret, username string) error {
+			if mfaSecret != expected {
+				return fmt.Errorf("authenticator was given %q rather than %q", mfaSecret, expected)
+			}
+			return nil
+		}
+	}
+
+	err = user.AuthenticateStepUp(device.Address, "webauthn", checkSecret("stepsecret"))
+	if err == nil {
+		t.Fatal("step up should not be possible before the device has authorised")
+	}
+
+	err = user.Authenticate(device.Address, "totp", checkSecret("secret"))
+	if err != nil {
+		t.Fatal("unable to authenticate:", err)
+	}
+
+	granted, err := data.IsStepUpGranted(user.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if granted {
+		t.Fatal("step up was granted with only the primary factor")
+	}
+
+	err = user.AuthenticateStepUp(device.Address, "webauthn", checkSecret("stepsecret"))
+	if err != nil {
+		t.Fatal("unable to step up:", err)
+	}
+
+	if !user.IsEnforcingStepUp("webauthn") {
+		t.Fatal("step up factor should be enforcing after use")
+	}
+
+	granted, err = data.IsStepUpGranted(user.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !granted {
+		t.Fatal("step up was not granted after all factors were completed")
+	}
+
+	err = user.Deauthenticate(device.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deauthed, err := user.GetDevice(device.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deauthed.StepUpFactors) != 0 {
+		t.Fatal("step up factors should be cleared when the session ends")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+
+	user, err := CreateUser("fronk6")
+	if err != nil {
+		t.Fatal("could not make user:", err)
+	}
+
+	pubkey, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device, err := user.AddDevice(pubkey)
+	if err != nil {
+		t.Fatal("unable to add device:", err)
+	}
+
+	err = data.SetUserMfa(user.Username, "secret", "totp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := user.GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal("unable to generate recovery codes:", err)
+	}
+
+	if len(codes) != data.NumRecoveryCodes {
+		t.Fatal("expected", data.NumRecoveryCodes, "codes got", len(codes))
+	}
+
+	err = user.AuthenticateRecoveryCode(device.Address, codes[0])
+	if err == nil {
+		t.Fatal("recovery codes should not work before mfa is registered")
+	}
+
+	err = user.EnforceMFA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.AuthenticateRecoveryCode(device.Address, "AAAA-AAAA-AAAA-AAAA")
+	if err == nil {
+		t.Fatal("invalid recovery code was accepted")
+	}
+
+	// Codes should be accepted regardless of case or dashes
+	err = user.AuthenticateRecoveryCode(device.Address, strings.ToLower(strings.ReplaceAll(codes[1], "-", "")))
+	if err != nil {
+		t.Fatal("unable to authenticate with recovery code:", err)
+	}
+
+	err = user.Deauthenticate(device.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.AuthenticateRecoveryCode(device.Address, codes[1])
+	if err == nil {
+		t.Fatal("recovery code was accepted twice")
+	}
+
+	remaining, err := data.GetNumberOfRecoveryCodes(user.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if remaining != data.NumRecoveryCodes-1 {
+		t.Fatal("expected", data.NumRecoveryCodes-1, "remaining codes got", remaining)
+	}
+
+	err = user.ResetMfa()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, err = data.GetNumberOfRecoveryCodes(user.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if remaining != 0 {
+		t.Fatal("resetting mfa should remove recovery codes")
+	}
+}
+
+func TestAuditAuthentication(t *testing.T) {
+
+	user, err := CreateUser("fronk7")
+	if err != nil {
+		t.Fatal("could not make user:", err)
+	}
+
+	pubkey, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device, err := user.AddDevice(pubkey)
+	if err != nil {
+		t.Fatal("unable to add device:", err)
+	}
+
+	err = data.SetUserMfa(user.Username, "secret", "totp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = user.Authenticate(device.Address, "totp", func(mfaSecret, username string) error {
+		return errors.New("wrong code")
+	})
+	if err == nil {
+		t.Fatal("authentication should have failed")
+	}
+
+	err = user.Authenticate(device.Address, "totp", func(mfaSecret, username string) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal("unable to authenticate:", err)
+	}
+
+	events, err := data.SearchAuditEvents(data.AuditFilter{Actor: user.Username})
+	if err != nil {
+		t.Fatal("unable to search audit log:", err)
+	}
+
+	// Newest first
+	expected := []data.AuditEventType{data.AuditLogin, data.AuditMFARegistered, data.AuditLoginFailed}
+	if len(events) != len(expected) {
+		t.Fatal("expected", len(expected), "events got", len(events), events)
+	}
+
+	for i := range expected {
+		if events[i].Type != expected[i] {
+			t.Fatal("expected event", i, "to be", expected[i], "got", events[i].Type)
+		}
+
+		if events[i].SourceIP != device.Address {
+			t.Fatal("event source was not the device address:", events[i].SourceIP)
+		}
+	}
+
+	events, err = data.SearchAuditEvents(data.AuditFilter{Actor: user.Username, Type: data.AuditLoginFailed})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || !strings.Contains(events[0].Details, "wrong code") {
+		t.Fatal("type filter did not return the failed login", events)
+	}
+}
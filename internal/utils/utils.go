@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/proxyprotocol"
 )
 
 func GetIP(addr string) string {
@@ -22,7 +23,8 @@ func GetIP(addr string) string {
 func GetIPFromRequest(r *http.Request) net.IP {
 
 	//Do not respect the X-Forwarded-For header until we are explictly told we are being proxied.
-	if config.Values.NumberProxies > 0 {
+	// Listeners with PROXY protocol already have the client address, and a client behind a TCP load balancer could set the header to anything
+	if config.Values.NumberProxies > 0 && !proxyprotocol.FromListener(r.Context()) {
 		ips := r.Header.Get("X-Forwarded-For")
 		addresses := strings.Split(ips, ",")
 
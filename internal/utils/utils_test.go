@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/proxyprotocol"
+)
+
+// serveClientIP serves GetIPFromRequest over a real listener, with PROXY protocol enabled for connections from loopback if proxyProtocol is set
+func serveClientIP(t *testing.T, proxyProtocol bool) string {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := config.ProxyProtocolDetails{Enabled: proxyProtocol, TrustedSources: []string{"127.0.0.0/8"}}
+
+	listener := inner
+	if proxyProtocol {
+		trusted, err := settings.Trusted()
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener = proxyprotocol.NewListener(inner, trusted)
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(GetIPFromRequest(r).String()))
+		}),
+		ConnContext: proxyprotocol.ConnContext(settings),
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return inner.Addr().String()
+}
+
+func requestClientIP(t *testing.T, address string, proxyHeader bool) string {
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	request := "GET / HTTP/1.1\r\nHost: wag\r\nX-Forwarded-For: 198.51.100.9\r\nConnection: close\r\n\r\n"
+	if proxyHeader {
+		request = "PROXY TCP4 192.0.2.1 10.0.0.1 51234 443\r\n" + request
+	}
+
+	if _, err := c.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, body, _ := strings.Cut(string(response), "\r\n\r\n")
+	return body
+}
+
+func TestGetIPFromRequestProxyProtocol(t *testing.T) {
+	previous := config.Values.NumberProxies
+	config.Values.NumberProxies = 1
+	defer func() { config.Values.NumberProxies = previous }()
+
+	if ip := requestClientIP(t, serveClientIP(t, false), false); ip != "198.51.100.9" {
+		t.Fatal("X-Forwarded-For should be used on listeners without PROXY protocol when NumberProxies is set, got: ", ip)
+	}
+
+	// A client behind the load balancer must not be able to pick its address with the header
+	if ip := requestClientIP(t, serveClientIP(t, true), true); ip != "192.0.2.1" {
+		t.Fatal("the PROXY protocol address should be used instead of X-Forwarded-For, got: ", ip)
+	}
+}
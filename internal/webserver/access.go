@@ -0,0 +1,123 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/router"
+	"github.com/NHAS/wag/internal/users"
+	"github.com/NHAS/wag/internal/utils"
+	"github.com/NHAS/wag/internal/webserver/resources"
+)
+
+// accessRequests lets an authorised user see their just in time access requests, and request temporary access to more routes
+func accessRequests(w http.ResponseWriter, r *http.Request) {
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	if !router.IsAuthed(clientTunnelIp.String()) {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		requests, err := data.GetAccessRequests(user.Username)
+		if err != nil {
+			log.Println(user.Username, clientTunnelIp, "unable to get access requests:", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		page := resources.AccessRequests{
+			HelpMail: data.GetHelpMail(),
+			MaxHours: int(data.MaxAccessDuration.Hours()),
+		}
+
+		for _, request := range requests {
+			entry := resources.AccessRequestEntry{
+				ID:        request.ID,
+				Routes:    strings.Join(request.Routes, ", "),
+				Duration:  request.Duration.String(),
+				Reason:    request.Reason,
+				Status:    string(request.Status),
+				Requested: request.Requested.Format(time.DateTime),
+				Pending:   request.Status == data.AccessPending,
+			}
+
+			if request.Active() {
+				entry.Status = "active until " + request.Expires.Format(time.DateTime)
+			}
+
+			page.Requests = append(page.Requests, entry)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		if err := resources.Render("access.html", w, &page); err != nil {
+			log.Println(user.Username, clientTunnelIp, "unable to render access requests template: ", err)
+		}
+
+	case "POST":
+		// Only accepting json stops other sites submitting requests as the user, as browsers will not send it cross origin without a preflight
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			http.Error(w, "Bad request", 400)
+			return
+		}
+
+		var request struct {
+			Routes   []string
+			Minutes  int
+			Reason   string
+			CancelID string
+		}
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8192)).Decode(&request); err != nil {
+			http.Error(w, "Bad request", 400)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if request.CancelID != "" {
+			cancelled, err := data.CancelAccessRequest(request.CancelID, user.Username)
+			if err != nil {
+				log.Println(user.Username, clientTunnelIp, "unable to cancel access request:", err)
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(err.Error())
+				return
+			}
+
+			data.Audit(data.AuditAccessCancelled, user.Username, clientTunnelIp.String(), user.Username, cancelled.ID)
+			json.NewEncoder(w).Encode("OK")
+			return
+		}
+
+		created, err := data.CreateAccessRequest(user.Username, request.Routes, time.Duration(request.Minutes)*time.Minute, request.Reason)
+		if err != nil {
+			log.Println(user.Username, clientTunnelIp, "unable to create access request:", err)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(err.Error())
+			return
+		}
+
+		log.Println(user.Username, clientTunnelIp, "requested access to", created.Routes, "for", created.Duration)
+		data.Audit(data.AuditAccessRequested, user.Username, clientTunnelIp.String(), user.Username,
+			fmt.Sprintf("%s: %s for %s, reason: %s", created.ID, strings.Join(created.Routes, ", "), created.Duration, created.Reason))
+
+		json.NewEncoder(w).Encode("OK")
+
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,535 @@
+package webserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/router"
+	"github.com/NHAS/wag/internal/users"
+	"github.com/NHAS/wag/internal/utils"
+	"github.com/NHAS/wag/internal/webserver/authenticators"
+	"github.com/gorilla/websocket"
+)
+
+// The json api for native clients, e.g tray applications, is served on the tunnel web server under this prefix.
+// Breaking changes are made under a new version so older clients keep working
+const apiPrefix = "/api/v1/"
+
+const (
+	// How often the events websocket checks the session of a device
+	apiEventInterval = 5 * time.Second
+	// Clients are warned this long before their session reaches its maximum lifetime
+	sessionExpiryWarning = 5 * time.Minute
+)
+
+const (
+	apiEventSession         = "session"
+	apiEventAuthorised      = "authorised"
+	apiEventSessionExpiring = "session_expiring"
+	apiEventSessionIdle     = "session_idle"
+	apiEventSessionExtended = "session_extended"
+	apiEventDeauthenticated = "deauthenticated"
+)
+
+var apiUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+func addAPIRoutes(tunnel *http.ServeMux) {
+	tunnel.HandleFunc(apiPrefix+"session", apiSession)
+	tunnel.HandleFunc(apiPrefix+"device", apiDevice)
+	tunnel.HandleFunc(apiPrefix+"mfa", apiMFA)
+	tunnel.HandleFunc(apiPrefix+"mfa/start", apiStartMFA)
+	tunnel.HandleFunc(apiPrefix+"logout", apiLogout)
+	tunnel.HandleFunc(apiPrefix+"events", apiEvents)
+
+	// Anything else under /api/ is json rather than the html of the index
+	tunnel.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, "not found", http.StatusNotFound)
+	})
+}
+
+func apiResponse(w http.ResponseWriter, v any, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, message string, status int) {
+	apiResponse(w, struct {
+		Error string `json:"error"`
+	}{message}, status)
+}
+
+// apiRequest checks the method and content type of a request, writing an error if they are wrong
+func apiRequest(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		apiError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	// Only accepting json stops other sites making requests as the user, as browsers will not send it cross origin without a preflight
+	if method == "POST" && !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		apiError(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+
+	return true
+}
+
+type apiSessionState struct {
+	Authorised bool `json:"authorised"`
+	// When the session reaches its maximum lifetime, unset if the session has no maximum lifetime or the device is not authorised
+	Expires *time.Time `json:"expires,omitempty"`
+	// -1 if the session has no maximum lifetime
+	RemainingSeconds int64 `json:"remaining_seconds"`
+	// The session also ends if the device sends no traffic for this long, -1 if disabled
+	InactivityTimeoutMinutes int        `json:"inactivity_timeout_minutes"`
+	LastActivity             *time.Time `json:"last_activity,omitempty"`
+	// When the session ends if the device sends no more traffic
+	InactivityExpires *time.Time `json:"inactivity_expires,omitempty"`
+	// The session reaches its maximum lifetime or inactivity timeout within the warning period
+	Expiring bool `json:"expiring"`
+	// The device can re-authenticate at /extend/ to restart its session without dropping its connections
+	CanExtend bool `json:"can_extend"`
+	// Step up methods the device must complete to reach step up routes
+	StepUpRequired []string `json:"step_up_required"`
+}
+
+func sessionState(address string) (state apiSessionState, err error) {
+	state.Authorised = router.IsAuthed(address)
+	state.StepUpRequired = []string{}
+
+	state.InactivityTimeoutMinutes, err = data.GetSessionInactivityTimeoutMinutes()
+	if err != nil {
+		return state, err
+	}
+
+	if !state.Authorised {
+		return state, nil
+	}
+
+	expires, lastPacket, err := router.GetSession(address)
+	if err != nil {
+		return state, err
+	}
+
+	state.setLifetime(expires, lastPacket, time.Now())
+
+	state.CanExtend, err = data.CanExtendSession(address)
+	if err != nil {
+		return state, err
+	}
+
+	missing, err := missingStepUpFactors(address)
+	if err != nil {
+		return state, err
+	}
+
+	if missing != nil {
+		state.StepUpRequired = missing
+	}
+
+	return state, nil
+}
+
+// setLifetime works out when an authorised session ends, from its maximum lifetime and the last traffic seen from the device
+func (state *apiSessionState) setLifetime(expires, lastPacket, now time.Time) {
+	state.LastActivity = &lastPacket
+
+	state.RemainingSeconds = -1
+	if !expires.IsZero() {
+		state.Expires = &expires
+		state.RemainingSeconds = max(int64(expires.Sub(now).Seconds()), 0)
+		state.Expiring = expires.Sub(now) <= sessionExpiryWarning
+	}
+
+	if state.InactivityTimeoutMinutes > 0 {
+		inactivityExpires := lastPacket.Add(time.Duration(state.InactivityTimeoutMinutes) * time.Minute)
+		state.InactivityExpires = &inactivityExpires
+		state.Expiring = state.Expiring || inactivityExpires.Sub(now) <= sessionExpiryWarning
+	}
+}
+
+func apiSession(w http.ResponseWriter, r *http.Request) {
+	if !apiRequest(w, r, "GET") {
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+	address := clientTunnelIp.String()
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		apiError(w, "unknown device", http.StatusBadRequest)
+		return
+	}
+
+	state, err := sessionState(address)
+	if err != nil {
+		log.Println(user.Username, address, "unable to get session state:", err)
+		apiError(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	apiResponse(w, state, http.StatusOK)
+}
+
+func apiDevice(w http.ResponseWriter, r *http.Request) {
+	if !apiRequest(w, r, "GET") {
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+	address := clientTunnelIp.String()
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		apiError(w, "unknown device", http.StatusBadRequest)
+		return
+	}
+
+	device, err := data.GetDeviceByAddress(address)
+	if err != nil {
+		log.Println(user.Username, address, "unable to get device:", err)
+		apiError(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	lockout, err := data.GetLockout()
+	if err != nil {
+		log.Println(user.Username, address, "unable to get lockout:", err)
+		apiError(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	rotation, _, rotates, err := data.GetDeviceKeyRotation(address)
+	if err != nil {
+		log.Println(user.Username, address, "unable to get key rotation status:", err)
+		apiError(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	type keyRotation struct {
+		Due     time.Time `json:"due"`
+		Overdue bool      `json:"overdue"`
+		Pending bool      `json:"pending"`
+	}
+
+	info := struct {
+		Username    string       `json:"username"`
+		Address     string       `json:"address"`
+		PublicKey   string       `json:"public_key"`
+		Endpoint    string       `json:"endpoint,omitempty"`
+		Locked      bool         `json:"locked"`
+		Attempts    int          `json:"attempts"`
+		KeyRotation *keyRotation `json:"key_rotation,omitempty"`
+	}{
+		Username:  device.Username,
+		Address:   device.Address,
+		PublicKey: device.Publickey,
+		Locked:    device.LockoutAttempts() >= lockout,
+		Attempts:  device.LockoutAttempts(),
+	}
+
+	// Devices that have never connected have no endpoint
+	if device.Endpoint != nil {
+		info.Endpoint = device.Endpoint.String()
+	}
+
+	if rotates {
+		info.KeyRotation = &keyRotation{Due: rotation.Due, Overdue: rotation.Overdue, Pending: rotation.Pending}
+	}
+
+	apiResponse(w, info, http.StatusOK)
+}
+
+type apiMFAMethod struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+func apiMFA(w http.ResponseWriter, r *http.Request) {
+	if !apiRequest(w, r, "GET") {
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		apiError(w, "unknown device", http.StatusBadRequest)
+		return
+	}
+
+	info := struct {
+		Registered bool `json:"registered"`
+		// The method the user authorises with, unset until they have registered
+		Method string `json:"method,omitempty"`
+		// Methods the user can register with
+		Methods []apiMFAMethod `json:"methods"`
+	}{
+		Registered: user.IsEnforcingMFA(),
+		Methods:    []apiMFAMethod{},
+	}
+
+	if info.Registered {
+		info.Method = user.GetMFAType()
+	}
+
+	for _, method := range authenticators.GetAllEnabledMethods() {
+		info.Methods = append(info.Methods, apiMFAMethod{Type: method.Type(), Name: method.FriendlyName()})
+	}
+
+	apiResponse(w, info, http.StatusOK)
+}
+
+// apiStartMFA returns where the user authorises, or registers mfa if they have not yet. Clients open the url in a browser, or post to
+// the api path of methods that take a code directly (e.g totp)
+func apiStartMFA(w http.ResponseWriter, r *http.Request) {
+	if !apiRequest(w, r, "POST") {
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+	address := clientTunnelIp.String()
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		apiError(w, "unknown device", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		// Method to register with, ignored if the user has registered already. Defaults to the default mfa method
+		Method string `json:"method"`
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&request); err != nil {
+		apiError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if router.IsAuthed(address) {
+		apiError(w, "device is already authorised", http.StatusConflict)
+		return
+	}
+
+	var path, apiPath, method string
+	if user.IsEnforcingMFA() {
+		method = user.GetMFAType()
+		path = "/authorise/"
+		apiPath = "/authorise/" + method + "/"
+	} else {
+		method = request.Method
+		if method == "" {
+			method, _ = data.GetDefaultMfaMethod()
+		}
+
+		path = "/register_mfa/?method=" + url.QueryEscape(method)
+		apiPath = "/register_mfa/" + method + "/"
+	}
+
+	if _, ok := authenticators.GetMethod(method); !ok {
+		apiError(w, "mfa method is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	apiResponse(w, struct {
+		Method   string `json:"method"`
+		Register bool   `json:"register"`
+		URL      string `json:"url"`
+		API      string `json:"api"`
+	}{
+		Method:   method,
+		Register: !user.IsEnforcingMFA(),
+		URL:      scheme + "://" + r.Host + path,
+		API:      apiPath,
+	}, http.StatusOK)
+}
+
+func apiLogout(w http.ResponseWriter, r *http.Request) {
+	if !apiRequest(w, r, "POST") {
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+	address := clientTunnelIp.String()
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		apiError(w, "unknown device", http.StatusBadRequest)
+		return
+	}
+
+	if !router.IsAuthed(address) {
+		apiError(w, "device is not authorised", http.StatusConflict)
+		return
+	}
+
+	err = user.Deauthenticate(address)
+	if err != nil {
+		log.Println(user.Username, address, "could not deauthenticate:", err)
+		apiError(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	data.Audit(data.AuditLogout, user.Username, address, "", "")
+
+	// Methods connected to an identity provider may need the browser to visit their logout path too
+	logoutPath := "/"
+	if method, ok := authenticators.GetMethod(user.GetMFAType()); ok {
+		logoutPath = method.LogoutPath()
+	}
+
+	apiResponse(w, struct {
+		LogoutPath string `json:"logout_path"`
+	}{logoutPath}, http.StatusOK)
+}
+
+// movedLater is true if a session expiry has been pushed back, e.g by the session being extended. Expiry times are derived from the
+// boot clock the firewall uses so they drift slightly between reads, anything less than a minute is ignored
+func movedLater(previous, current *time.Time) bool {
+	return previous != nil && current != nil && current.Sub(*previous) > time.Minute
+}
+
+// sessionWarnings tracks the warnings an events websocket has sent, so that each is only sent once
+type sessionWarnings struct {
+	expiry *time.Time
+	idle   bool
+}
+
+// event returns the event to send for the change in session state, or an empty string if there is nothing new to tell the client
+func (warnings *sessionWarnings) event(previous, state apiSessionState, now time.Time) string {
+	switch {
+	case previous.Authorised && !state.Authorised:
+		warnings.expiry, warnings.idle = nil, false
+		return apiEventDeauthenticated
+	case !previous.Authorised && state.Authorised:
+		return apiEventAuthorised
+	case movedLater(previous.Expires, state.Expires):
+		return apiEventSessionExtended
+	case state.Authorised && state.Expires != nil && state.Expires.Sub(now) <= sessionExpiryWarning && (warnings.expiry == nil || movedLater(warnings.expiry, state.Expires)):
+		// Only warn once for each session, a new session or an extended one has a different expiry
+		warnings.expiry = state.Expires
+		return apiEventSessionExpiring
+	case state.Authorised && state.InactivityExpires != nil && state.InactivityExpires.Sub(now) <= sessionExpiryWarning:
+		// Any traffic from the device pushes the inactivity timeout back, so only warn again once it has been
+		if warnings.idle {
+			return ""
+		}
+
+		warnings.idle = true
+		return apiEventSessionIdle
+	default:
+		warnings.idle = false
+		return ""
+	}
+}
+
+type apiEvent struct {
+	Type    string          `json:"type"`
+	Time    time.Time       `json:"time"`
+	Session apiSessionState `json:"session"`
+}
+
+// apiEvents is a websocket that sends the session state when it connects, then an event when the device is authorised, deauthenticated,
+// its session is extended, or its session is about to reach its maximum lifetime or inactivity timeout
+func apiEvents(w http.ResponseWriter, r *http.Request) {
+	if !apiRequest(w, r, "GET") {
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+	address := clientTunnelIp.String()
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		apiError(w, "unknown device", http.StatusBadRequest)
+		return
+	}
+
+	// Browsers send an origin, which the upgrader checks is the tunnel web server itself
+	conn, err := apiUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(user.Username, address, "unable to upgrade events websocket:", err)
+		return
+	}
+	defer conn.Close()
+
+	// Reading is needed to handle control messages, and tells us when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(eventType string, state apiSessionState) error {
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(apiEvent{Type: eventType, Time: time.Now(), Session: state})
+	}
+
+	state, err := sessionState(address)
+	if err != nil {
+		log.Println(user.Username, address, "unable to get session state:", err)
+		return
+	}
+
+	if err := send(apiEventSession, state); err != nil {
+		return
+	}
+
+	var warnings sessionWarnings
+
+	ticker := time.NewTicker(apiEventInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+
+		previous := state
+		state, err = sessionState(address)
+		if err != nil {
+			log.Println(user.Username, address, "unable to get session state:", err)
+			return
+		}
+
+		eventType := warnings.event(previous, state, time.Now())
+		if eventType == "" {
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+			continue
+		}
+
+		if err := send(eventType, state); err != nil {
+			return
+		}
+	}
+}
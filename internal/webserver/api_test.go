@@ -0,0 +1,178 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIUnknownPaths(t *testing.T) {
+	mux := http.NewServeMux()
+	addAPIRoutes(mux)
+
+	for _, path := range []string{"/api/", "/api/v1/unknown", "/api/v2/session", "/api/v1/session/extra"} {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if recorder.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected status %d got %d", path, http.StatusNotFound, recorder.Code)
+		}
+
+		if recorder.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("%s: expected a json error, got content type %q", path, recorder.Header().Get("Content-Type"))
+		}
+
+		var response struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil || response.Error != "not found" {
+			t.Fatalf("%s: unexpected body: %v %v", path, response, err)
+		}
+	}
+}
+
+func TestAPIRequestValidation(t *testing.T) {
+	mux := http.NewServeMux()
+	addAPIRoutes(mux)
+
+	for _, path := range []string{apiPrefix + "mfa/start", apiPrefix + "logout"} {
+		for _, test := range []struct {
+			method, contentType string
+			status              int
+		}{
+			{http.MethodGet, "", http.StatusMethodNotAllowed},
+			{http.MethodPut, "application/json", http.StatusMethodNotAllowed},
+			{http.MethodPost, "", http.StatusUnsupportedMediaType},
+			{http.MethodPost, "text/plain", http.StatusUnsupportedMediaType},
+			{http.MethodPost, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		} {
+			r := httptest.NewRequest(test.method, path, strings.NewReader("{}"))
+			if test.contentType != "" {
+				r.Header.Set("Content-Type", test.contentType)
+			}
+
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, r)
+
+			if recorder.Code != test.status || recorder.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("%s %s (%q): expected json error with status %d got %d", test.method, path, test.contentType, test.status, recorder.Code)
+			}
+		}
+	}
+
+	for _, contentType := range []string{"application/json", "application/json; charset=utf-8"} {
+		r := httptest.NewRequest(http.MethodPost, apiPrefix+"logout", strings.NewReader("{}"))
+		r.Header.Set("Content-Type", contentType)
+
+		recorder := httptest.NewRecorder()
+		if !apiRequest(recorder, r, http.MethodPost) || recorder.Body.Len() != 0 {
+			t.Fatalf("%q should be accepted: %s", contentType, recorder.Body.String())
+		}
+	}
+}
+
+func TestSessionLifetime(t *testing.T) {
+	now := time.Now()
+
+	for _, test := range []struct {
+		name              string
+		expires           time.Time
+		lastPacket        time.Time
+		inactivityMinutes int
+		remaining         int64
+		expiring          bool
+	}{
+		{"no maximum lifetime", time.Time{}, now, -1, -1, false},
+		{"hour left", now.Add(time.Hour), now, -1, 3600, false},
+		{"within warning", now.Add(4 * time.Minute), now, -1, 240, true},
+		{"already expired", now.Add(-time.Minute), now, -1, 0, true},
+		{"active device", time.Time{}, now.Add(-time.Minute), 30, -1, false},
+		{"idle device", time.Time{}, now.Add(-27 * time.Minute), 30, -1, true},
+		{"idle before maximum lifetime", now.Add(time.Hour), now.Add(-26 * time.Minute), 30, 3600, true},
+	} {
+		state := apiSessionState{Authorised: true, InactivityTimeoutMinutes: test.inactivityMinutes}
+		state.setLifetime(test.expires, test.lastPacket, now)
+
+		if state.RemainingSeconds != test.remaining || state.Expiring != test.expiring {
+			t.Fatalf("%s: expected %d seconds remaining and expiring %t, got %d and %t", test.name, test.remaining, test.expiring, state.RemainingSeconds, state.Expiring)
+		}
+
+		if test.expires.IsZero() != (state.Expires == nil) {
+			t.Fatalf("%s: expires should only be set for sessions with a maximum lifetime: %v", test.name, state.Expires)
+		}
+
+		if test.inactivityMinutes > 0 {
+			if state.InactivityExpires == nil || !state.InactivityExpires.Equal(test.lastPacket.Add(time.Duration(test.inactivityMinutes)*time.Minute)) {
+				t.Fatalf("%s: inactivity expiry should be measured from the last packet: %v", test.name, state.InactivityExpires)
+			}
+		} else if state.InactivityExpires != nil {
+			t.Fatalf("%s: inactivity expiry set with the timeout disabled", test.name)
+		}
+	}
+}
+
+func TestSessionEvents(t *testing.T) {
+	now := time.Now()
+
+	session := func(authorised bool, expires time.Time) apiSessionState {
+		state := apiSessionState{Authorised: authorised}
+		if authorised {
+			state.Expires = &expires
+		}
+		return state
+	}
+
+	var warnings sessionWarnings
+	expires := now.Add(time.Hour)
+
+	for _, step := range []struct {
+		name     string
+		previous apiSessionState
+		state    apiSessionState
+		now      time.Time
+		event    string
+	}{
+		{"login", session(false, expires), session(true, expires), now, apiEventAuthorised},
+		{"nothing changed", session(true, expires), session(true, expires), now, ""},
+		{"near expiry", session(true, expires), session(true, expires), expires.Add(-4 * time.Minute), apiEventSessionExpiring},
+		{"warned already", session(true, expires), session(true, expires), expires.Add(-3 * time.Minute), ""},
+		// Expiry times are derived from the boot clock so drift slightly between reads
+		{"clock drift", session(true, expires), session(true, expires.Add(time.Second)), expires.Add(-2 * time.Minute), ""},
+		{"extended", session(true, expires), session(true, expires.Add(time.Hour)), expires.Add(-time.Minute), apiEventSessionExtended},
+		{"extended session near expiry", session(true, expires.Add(time.Hour)), session(true, expires.Add(time.Hour)), expires.Add(56 * time.Minute), apiEventSessionExpiring},
+		{"extended session warned already", session(true, expires.Add(time.Hour)), session(true, expires.Add(time.Hour)), expires.Add(57 * time.Minute), ""},
+		{"expired", session(true, expires.Add(time.Hour)), session(false, time.Time{}), expires.Add(time.Hour), apiEventDeauthenticated},
+		{"still logged out", session(false, time.Time{}), session(false, time.Time{}), expires.Add(time.Hour), ""},
+		// A new session that happens to have the same expiry is warned about again
+		{"login again", session(false, time.Time{}), session(true, expires.Add(time.Hour)), expires.Add(time.Hour), apiEventAuthorised},
+		{"new session near expiry", session(true, expires.Add(time.Hour)), session(true, expires.Add(time.Hour)), expires.Add(time.Hour), apiEventSessionExpiring},
+	} {
+		if event := warnings.event(step.previous, step.state, step.now); event != step.event {
+			t.Fatalf("%s: expected event %q got %q", step.name, step.event, event)
+		}
+	}
+
+	idle := func(inactivityExpires time.Time) apiSessionState {
+		return apiSessionState{Authorised: true, InactivityExpires: &inactivityExpires}
+	}
+
+	warnings = sessionWarnings{}
+	for _, step := range []struct {
+		name  string
+		state apiSessionState
+		event string
+	}{
+		{"active", idle(now.Add(20 * time.Minute)), ""},
+		{"idle", idle(now.Add(4 * time.Minute)), apiEventSessionIdle},
+		{"still idle", idle(now.Add(3 * time.Minute)), ""},
+		{"traffic", idle(now.Add(30 * time.Minute)), ""},
+		{"idle again", idle(now.Add(2 * time.Minute)), apiEventSessionIdle},
+	} {
+		if event := warnings.event(step.state, step.state, now); event != step.event {
+			t.Fatalf("%s: expected event %q got %q", step.name, step.event, event)
+		}
+	}
+}
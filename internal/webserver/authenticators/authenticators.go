@@ -16,9 +16,15 @@ var (
 		types.Totp:     new(Totp),
 		types.Webauthn: new(Webauthn),
 		types.Oidc:     new(Oidc),
+		types.Saml:     new(Saml),
 		types.Pam:      new(Pam),
+		types.Ldap:     new(Ldap),
 	}
 	lck sync.RWMutex
+
+	// Kept so that routes can be added for oidc providers that are created after startup
+	mfaRoutes        *http.ServeMux
+	registeredRoutes = map[types.MFA]bool{}
 )
 
 func GetMethod(method string) (Authenticator, bool) {
@@ -132,9 +138,16 @@ func AddMFARoutes(mux *http.ServeMux) error {
 	lck.Lock()
 	defer lck.Unlock()
 
-	for method, handler := range allMfa {
-		mux.HandleFunc("/authorise/"+string(method)+"/", checkEnabled(handler, handler.AuthorisationAPI))
-		mux.HandleFunc("/register_mfa/"+string(method)+"/", checkEnabled(handler, handler.RegistrationAPI))
+	mfaRoutes = mux
+
+	providers, err := data.GetOidcProviders()
+	if err != nil {
+		return err
+	}
+	syncOidcProviders(providers)
+
+	for method := range allMfa {
+		addRoutes(method)
 	}
 
 	enabledMethods, err := data.GetAuthenicationMethods()
@@ -143,26 +156,105 @@ func AddMFARoutes(mux *http.ServeMux) error {
 	}
 
 	for _, method := range enabledMethods {
-		err := allMfa[types.MFA(method)].Init()
+		a, ok := allMfa[types.MFA(method)]
+		if !ok {
+			log.Println("enabled method: ", method, "does not exist")
+			continue
+		}
+
+		err := a.Init()
 		if err != nil {
 			log.Println("failed to initialise method: ", method, "err: ", err)
 			continue
 		}
-		allMfa[types.MFA(method)].Enable()
+		a.Enable()
 	}
 
 	return nil
 }
 
-func checkEnabled(a Authenticator, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+// SyncOidcProviders adds an authenticator for each named oidc provider that doesnt already have one, and removes authenticators for providers that no longer exist
+// Newly added providers are not initialised or enabled
+func SyncOidcProviders(providers []data.OIDC) {
+	lck.Lock()
+	defer lck.Unlock()
+
+	syncOidcProviders(providers)
+}
+
+func syncOidcProviders(providers []data.OIDC) {
+	current := map[types.MFA]bool{}
+	for _, provider := range providers {
+		if provider.Name == "" {
+			continue
+		}
+
+		method := types.OidcProvider(provider.Name)
+		current[method] = true
+
+		if _, ok := allMfa[method]; !ok {
+			allMfa[method] = &Oidc{name: provider.Name}
+			addRoutes(method)
+		}
+	}
+
+	for method, a := range allMfa {
+		if method != types.Oidc && method.IsOidc() && !current[method] {
+			a.Disable()
+			delete(allMfa, method)
+		}
+	}
+}
+
+// OidcMethods returns the default oidc method, and all named oidc providers
+func OidcMethods() (r []types.MFA) {
+	lck.RLock()
+	defer lck.RUnlock()
+
+	for method := range allMfa {
+		if method.IsOidc() {
+			r = append(r, method)
+		}
+	}
+
+	return
+}
+
+func addRoutes(method types.MFA) {
+	if mfaRoutes == nil || registeredRoutes[method] {
+		return
+	}
+
+	mfaRoutes.HandleFunc("/authorise/"+string(method)+"/", checkEnabled(method, func(a Authenticator) http.HandlerFunc { return a.AuthorisationAPI }))
+	mfaRoutes.HandleFunc("/register_mfa/"+string(method)+"/", checkEnabled(method, func(a Authenticator) http.HandlerFunc { return a.RegistrationAPI }))
+
+	if CanStepUp(string(method)) {
+		mfaRoutes.HandleFunc("/stepup/authorise/"+string(method)+"/", checkStepUp(method, func(a Authenticator) http.HandlerFunc { return a.AuthorisationAPI }))
+		mfaRoutes.HandleFunc("/stepup/register_mfa/"+string(method)+"/", checkStepUp(method, func(a Authenticator) http.HandlerFunc { return a.RegistrationAPI }))
+	}
+
+	if CanExtend(string(method)) {
+		mfaRoutes.HandleFunc("/extend/authorise/"+string(method)+"/", checkExtension(method, func(a Authenticator) http.HandlerFunc { return a.AuthorisationAPI }))
+	}
+
+	if method == types.Webauthn {
+		mfaRoutes.HandleFunc("/mfa/webauthn/", checkEnabled(method, func(a Authenticator) http.HandlerFunc { return a.(*Webauthn).CredentialsAPI }))
+	}
+
+	registeredRoutes[method] = true
+}
+
+// Authenticators are looked up on each request as oidc providers may be removed or replaced while running
+func checkEnabled(method types.MFA, f func(a Authenticator) http.HandlerFunc) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		if !a.IsEnabled() {
+		a, ok := GetMethod(string(method))
+		if !ok {
 			http.NotFound(w, r)
 			return
 		}
 
-		f(w, r)
+		f(a)(w, r)
 	}
 }
 
@@ -0,0 +1,53 @@
+package authenticators
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/router"
+	"github.com/NHAS/wag/internal/utils"
+	"github.com/NHAS/wag/internal/webserver/authenticators/types"
+)
+
+type extensionContextKey struct{}
+
+// CanExtend returns whether the method can extend a session in place. Methods that redirect to an external identity provider
+// start a new session instead, so users of those methods sign in again once their session ends
+func CanExtend(method string) bool {
+	return CanStepUp(method)
+}
+
+// AsExtension marks a request as re-authorising an active session to extend it, rather than starting a new one
+func AsExtension(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), extensionContextKey{}, true))
+}
+
+func IsExtension(r *http.Request) bool {
+	extension, _ := r.Context().Value(extensionContextKey{}).(bool)
+	return extension
+}
+
+// canExtend is true if the device has an active session that may be extended now
+func canExtend(ip string) bool {
+	if !router.IsAuthed(ip) {
+		return false
+	}
+
+	ok, err := data.CanExtendSession(ip)
+	return err == nil && ok
+}
+
+// checkExtension only allows extension requests from devices with an active session, it must still be in the extension window when the user authenticates
+func checkExtension(method types.MFA, f func(a Authenticator) http.HandlerFunc) func(w http.ResponseWriter, r *http.Request) {
+	enabled := checkEnabled(method, f)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !router.IsAuthed(utils.GetIPFromRequest(r).String()) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		enabled(w, AsExtension(r))
+	}
+}
@@ -32,6 +32,8 @@ func resultMessage(err error) (string, int) {
 		msg = "Account is locked contact: " + mail
 	} else if strings.Contains(err.Error(), "device is locked") {
 		msg = "Device is locked contact: " + mail
+	} else if strings.Contains(err.Error(), "too many attempts") {
+		msg = "Too many attempts, " + err.Error()[strings.Index(err.Error(), "try again"):]
 	}
 	return msg, http.StatusBadRequest
 }
@@ -0,0 +1,178 @@
+package authenticators
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/directory"
+	"github.com/NHAS/wag/internal/users"
+	"github.com/NHAS/wag/internal/utils"
+	"github.com/NHAS/wag/internal/webserver/authenticators/types"
+	"github.com/NHAS/wag/internal/webserver/resources"
+)
+
+type Ldap struct {
+	enable
+}
+
+func (l *Ldap) Init() error {
+	details, err := data.GetLdap()
+	if err != nil {
+		return err
+	}
+
+	if details.URL == "" {
+		return errors.New("no ldap url set")
+	}
+
+	return nil
+}
+
+func (l *Ldap) Type() string {
+	return string(types.Ldap)
+}
+
+func (l *Ldap) FriendlyName() string {
+	return "Directory Login"
+}
+
+func (l *Ldap) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	if isAuthed(r, clientTunnelIp.String(), l.Type()) {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		resources.Render("success.html", w, nil)
+		return
+	}
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	if isEnforcing(r, &user, l.Type()) {
+		log.Println(user.Username, clientTunnelIp, "tried to re-register mfa despite already being registered")
+
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		err = setUserMfa(r, user.Username, "LDAPauth", l.Type())
+		if err != nil {
+			log.Println(user.Username, clientTunnelIp, "unable to save LDAP key to db:", err)
+			http.Error(w, "Unknown error", 500)
+			return
+		}
+
+		jsonResponse(w, user.Username, 200)
+
+	case "POST":
+		err = authenticate(r, &user, clientTunnelIp.String(), l.Type(), l.AuthoriseFunc(w, r))
+		msg, status := resultMessage(err)
+		jsonResponse(w, msg, status)
+
+		if err != nil {
+			log.Println(user.Username, clientTunnelIp, "failed to authorise: ", err.Error())
+			return
+		}
+
+		log.Println(user.Username, clientTunnelIp, "authorised")
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+}
+
+func (l *Ldap) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	if isAuthed(r, clientTunnelIp.String(), l.Type()) {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		resources.Render("success.html", w, nil)
+		return
+	}
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	if !isEnforcing(r, &user, l.Type()) {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	err = authenticate(r, &user, clientTunnelIp.String(), l.Type(), l.AuthoriseFunc(w, r))
+
+	msg, status := resultMessage(err)
+	jsonResponse(w, msg, status)
+
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "failed to authorise: ", err.Error())
+		return
+	}
+
+	log.Println(user.Username, clientTunnelIp, "authorised")
+}
+
+// AuthoriseFunc binds to the directory as the user, and on success replaces the users group membership with their directory groups
+func (l *Ldap) AuthoriseFunc(w http.ResponseWriter, r *http.Request) types.AuthenticatorFunc {
+	return func(mfaSecret, username string) error {
+		err := r.ParseForm()
+		if err != nil {
+			return err
+		}
+
+		details, err := data.GetLdap()
+		if err != nil {
+			return errors.New("unable to get ldap details: " + err.Error())
+		}
+
+		log.Println(username, "attempting to authorise with LDAP (using ", details.URL, ")")
+
+		groups, err := directory.Authenticate(details, username, r.FormValue("password"))
+		if err != nil {
+			return errors.New("LDAP authentication failed: " + err.Error())
+		}
+
+		log.Println(username, "directory groups: ", groups)
+
+		return data.SetUserGroupMembership(username, groups)
+	}
+}
+
+func (l *Ldap) MFAPromptUI(w http.ResponseWriter, r *http.Request, username, ip string) {
+	if err := resources.Render("prompt_mfa_ldap.html", w, &resources.Msg{
+		HelpMail:   data.GetHelpMail(),
+		NumMethods: NumberOfMethods(),
+	}); err != nil {
+		log.Println(username, ip, "unable to render ldap prompt template: ", err)
+	}
+}
+
+func (l *Ldap) RegistrationUI(w http.ResponseWriter, r *http.Request, username, ip string) {
+	if err := resources.Render("register_mfa_ldap.html", w, &resources.Msg{
+		HelpMail:   data.GetHelpMail(),
+		NumMethods: NumberOfMethods(),
+	}); err != nil {
+		log.Println(username, ip, "unable to render ldap mfa template: ", err)
+	}
+}
+
+func (l *Ldap) LogoutPath() string {
+	return "/"
+}
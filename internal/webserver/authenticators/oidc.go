@@ -25,11 +25,17 @@ import (
 
 type issuer struct {
 	Issuer string
+
+	// Name of the provider the user registered with, empty for the default provider
+	Provider string `json:",omitempty"`
 }
 
 type Oidc struct {
 	enable
 
+	// Empty for the default provider
+	name string
+
 	provider rp.RelyingParty
 	details  data.OIDC
 }
@@ -51,11 +57,19 @@ func (o *Oidc) Init() error {
 		return errors.New("failed to get random key: " + err.Error())
 	}
 
-	o.details, err = data.GetOidc()
+	if o.name == "" {
+		o.details, err = data.GetOidc()
+	} else {
+		o.details, err = data.GetOidcProvider(o.name)
+	}
 	if err != nil {
 		return err
 	}
 
+	if o.details.GroupsClaimName == "" {
+		o.details.GroupsClaimName = "groups"
+	}
+
 	cookieHandler := httphelper.NewCookieHandler(key, key, httphelper.WithUnsecure())
 
 	options := []rp.Option{
@@ -73,7 +87,7 @@ func (o *Oidc) Init() error {
 		return err
 	}
 
-	u.Path = path.Join(u.Path, "/authorise/oidc/")
+	u.Path = path.Join(u.Path, "/authorise/"+o.Type()+"/")
 	log.Println("OIDC callback: ", u.String())
 	log.Println("Connecting to OIDC provider: ", o.details.IssuerURL)
 
@@ -88,10 +102,18 @@ func (o *Oidc) Init() error {
 }
 
 func (o *Oidc) Type() string {
-	return string(types.Oidc)
+	return string(types.OidcProvider(o.name))
 }
 
 func (o *Oidc) FriendlyName() string {
+	if o.details.DisplayName != "" {
+		return o.details.DisplayName
+	}
+
+	if o.name != "" {
+		return "Single Sign On (" + o.name + ")"
+	}
+
 	return "Single Sign On"
 }
 
@@ -118,10 +140,11 @@ func (o *Oidc) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Println(user.Username, clientTunnelIp, "registering with oidc")
+	log.Println(user.Username, clientTunnelIp, "registering with", o.Type())
 
 	value, _ := json.Marshal(issuer{
-		Issuer: o.provider.Issuer(),
+		Issuer:   o.provider.Issuer(),
+		Provider: o.name,
 	})
 
 	err = data.SetUserMfa(user.Username, string(value), o.Type())
@@ -190,18 +213,12 @@ func (o *Oidc) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Will set enforcing on first use
-		err = user.Authenticate(clientTunnelIp.String(), user.GetMFAType(), func(issuerString, username string) error {
+		err = user.Authenticate(clientTunnelIp.String(), o.Type(), func(issuerString, username string) error {
 
-			var issuerDetails issuer
-			err := json.Unmarshal([]byte(issuerString), &issuerDetails)
-			if err != nil {
+			if err := o.checkIssuer(issuerString, rp.Issuer()); err != nil {
 				return err
 			}
 
-			if issuerDetails.Issuer != rp.Issuer() {
-				return errors.New("stored issuer " + issuerDetails.Issuer + " did not equal actual issuer: " + rp.Issuer())
-			}
-
 			if deviceUsername != username {
 				log.Printf("Error logging in user, idP supplied device username (%s) does not equal expected username (%s)", deviceUsername, username)
 				return errors.New("user is not associated with device")
@@ -245,6 +262,25 @@ func (o *Oidc) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 	rp.CodeExchangeHandler(rp.UserinfoCallback(marshalUserinfo), o.provider)(w, r)
 }
 
+// checkIssuer makes sure the user is logging in with the same provider and issuer they registered with
+func (o *Oidc) checkIssuer(issuerString, actualIssuer string) error {
+	var issuerDetails issuer
+	err := json.Unmarshal([]byte(issuerString), &issuerDetails)
+	if err != nil {
+		return err
+	}
+
+	if issuerDetails.Provider != o.name {
+		return errors.New("stored oidc provider " + issuerDetails.Provider + " did not equal actual provider: " + o.name)
+	}
+
+	if issuerDetails.Issuer != actualIssuer {
+		return errors.New("stored issuer " + issuerDetails.Issuer + " did not equal actual issuer: " + actualIssuer)
+	}
+
+	return nil
+}
+
 func (o *Oidc) MFAPromptUI(w http.ResponseWriter, r *http.Request, username, ip string) {
 	rp.AuthURLHandler(o.state, o.provider)(w, r)
 }
@@ -0,0 +1,175 @@
+package authenticators
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/webserver/authenticators/types"
+)
+
+// testAuthenticator stands in for a provider that has been initialised, recording the requests routed to it
+type testAuthenticator struct {
+	enable
+	method types.MFA
+	called []string
+}
+
+func (a *testAuthenticator) Init() error          { return nil }
+func (a *testAuthenticator) Type() string         { return string(a.method) }
+func (a *testAuthenticator) FriendlyName() string { return string(a.method) }
+func (a *testAuthenticator) LogoutPath() string   { return "/" }
+
+func (a *testAuthenticator) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
+	a.called = append(a.called, "register")
+}
+
+func (a *testAuthenticator) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
+	a.called = append(a.called, "authorise")
+}
+
+func (a *testAuthenticator) MFAPromptUI(w http.ResponseWriter, r *http.Request, username, ip string) {
+}
+
+func (a *testAuthenticator) RegistrationUI(w http.ResponseWriter, r *http.Request, username, ip string) {
+}
+
+func serve(mux *http.ServeMux, path string) int {
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder.Code
+}
+
+func TestSyncOidcProviders(t *testing.T) {
+	mux := http.NewServeMux()
+
+	lck.Lock()
+	previous := mfaRoutes
+	mfaRoutes = mux
+	lck.Unlock()
+
+	corp, partner := types.OidcProvider("corp"), types.OidcProvider("partner")
+
+	defer func() {
+		SyncOidcProviders(nil)
+
+		lck.Lock()
+		mfaRoutes = previous
+		delete(registeredRoutes, corp)
+		delete(registeredRoutes, partner)
+		lck.Unlock()
+	}()
+
+	SyncOidcProviders([]data.OIDC{{Name: "corp"}, {Name: "partner"}, {Name: ""}})
+
+	methods := OidcMethods()
+	if len(methods) != 3 || !slices.Contains(methods, types.Oidc) || !slices.Contains(methods, corp) || !slices.Contains(methods, partner) {
+		t.Fatal("expected the default and both named providers: ", methods)
+	}
+
+	for _, path := range []string{"/authorise/oidc-corp/", "/register_mfa/oidc-corp/", "/authorise/oidc-partner/", "/register_mfa/oidc-partner/"} {
+		if _, pattern := mux.Handler(httptest.NewRequest(http.MethodGet, path, nil)); pattern != path {
+			t.Fatalf("%s was not registered, matched %q", path, pattern)
+		}
+	}
+
+	// Identity provider methods replace the session, so cannot be used to step up or extend it
+	for _, path := range []string{"/stepup/authorise/oidc-corp/", "/extend/authorise/oidc-corp/"} {
+		if _, pattern := mux.Handler(httptest.NewRequest(http.MethodGet, path, nil)); pattern != "" {
+			t.Fatalf("%s should not be registered, matched %q", path, pattern)
+		}
+	}
+
+	// Newly added providers are not enabled until they have been initialised
+	if _, ok := GetMethod(string(corp)); ok || serve(mux, "/authorise/oidc-corp/") != http.StatusNotFound {
+		t.Fatal("new provider should not be enabled")
+	}
+
+	if a, ok := allMfa[corp].(*Oidc); !ok || a.name != "corp" || a.Type() != string(corp) {
+		t.Fatal("provider authenticator was not created for the named provider: ", allMfa[corp])
+	}
+
+	fake := &testAuthenticator{method: corp}
+	lck.Lock()
+	allMfa[corp] = fake
+	lck.Unlock()
+	EnableMethods(corp)
+
+	serve(mux, "/authorise/oidc-corp/")
+	serve(mux, "/register_mfa/oidc-corp/")
+	if !slices.Equal(fake.called, []string{"authorise", "register"}) {
+		t.Fatal("routes should be handled by the provider: ", fake.called)
+	}
+
+	if serve(mux, "/authorise/oidc-partner/") != http.StatusNotFound {
+		t.Fatal("routes of a disabled provider should not be handled")
+	}
+
+	// Removing a provider while running disables it, the route stays registered but is no longer handled
+	SyncOidcProviders([]data.OIDC{{Name: "partner"}})
+
+	if fake.IsEnabled() {
+		t.Fatal("removed provider should be disabled")
+	}
+
+	if _, ok := allMfa[corp]; ok || slices.Contains(OidcMethods(), corp) {
+		t.Fatal("removed provider should no longer be a method")
+	}
+
+	if !slices.Contains(OidcMethods(), types.Oidc) {
+		t.Fatal("the default provider should never be removed")
+	}
+
+	fake.called = nil
+	if serve(mux, "/authorise/oidc-corp/") != http.StatusNotFound || len(fake.called) != 0 {
+		t.Fatal("removed provider should not handle requests")
+	}
+
+	// Adding it back must not register its routes a second time, which would panic
+	SyncOidcProviders([]data.OIDC{{Name: "partner"}, {Name: "corp"}})
+
+	if a, ok := allMfa[corp].(*Oidc); !ok || a.IsEnabled() {
+		t.Fatal("provider that was added again should have a new, disabled, authenticator: ", allMfa[corp])
+	}
+}
+
+func TestOidcProviderMismatch(t *testing.T) {
+	registered := func(provider, issuerURL string) string {
+		value, _ := json.Marshal(issuer{Issuer: issuerURL, Provider: provider})
+		return string(value)
+	}
+
+	corp := &Oidc{name: "corp"}
+	if err := corp.checkIssuer(registered("corp", "https://corp.example.com"), "https://corp.example.com"); err != nil {
+		t.Fatal("user registered with the provider should be able to log in: ", err)
+	}
+
+	// Users registered with one provider cannot log in with another, even if both use the same issuer
+	if err := corp.checkIssuer(registered("", "https://corp.example.com"), "https://corp.example.com"); err == nil {
+		t.Fatal("user registered with the default provider logged in with a named provider")
+	}
+
+	if err := corp.checkIssuer(registered("partner", "https://corp.example.com"), "https://corp.example.com"); err == nil {
+		t.Fatal("user registered with another provider was able to log in")
+	}
+
+	if err := (&Oidc{}).checkIssuer(registered("corp", "https://corp.example.com"), "https://corp.example.com"); err == nil {
+		t.Fatal("user registered with a named provider logged in with the default provider")
+	}
+
+	if err := corp.checkIssuer(registered("corp", "https://old.example.com"), "https://corp.example.com"); err == nil {
+		t.Fatal("user registered with a different issuer was able to log in")
+	}
+
+	// Registrations from before named providers existed have no provider, and belong to the default one
+	if err := (&Oidc{}).checkIssuer(`{"Issuer":"https://corp.example.com"}`, "https://corp.example.com"); err != nil {
+		t.Fatal("registration without a provider should belong to the default provider: ", err)
+	}
+
+	if err := corp.checkIssuer("not json", "https://corp.example.com"); err == nil {
+		t.Fatal("invalid registration should be refused")
+	}
+}
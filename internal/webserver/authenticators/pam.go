@@ -8,7 +8,6 @@ import (
 	"fmt"
 
 	"github.com/NHAS/wag/internal/data"
-	"github.com/NHAS/wag/internal/router"
 	"github.com/NHAS/wag/internal/users"
 	"github.com/NHAS/wag/internal/utils"
 	"github.com/NHAS/wag/internal/webserver/authenticators/types"
@@ -35,7 +34,7 @@ func (t *Pam) FriendlyName() string {
 func (t *Pam) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 	clientTunnelIp := utils.GetIPFromRequest(r)
 
-	if router.IsAuthed(clientTunnelIp.String()) {
+	if isAuthed(r, clientTunnelIp.String(), t.Type()) {
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 		resources.Render("success.html", w, nil)
 		return
@@ -48,7 +47,7 @@ func (t *Pam) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if user.IsEnforcingMFA() {
+	if isEnforcing(r, &user, t.Type()) {
 		log.Println(user.Username, clientTunnelIp, "tried to re-register mfa despite already being registered")
 
 		http.Error(w, "Bad request", 400)
@@ -57,7 +56,7 @@ func (t *Pam) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case "GET":
-		err = data.SetUserMfa(user.Username, "PAMauth", t.Type())
+		err = setUserMfa(r, user.Username, "PAMauth", t.Type())
 		if err != nil {
 			log.Println(user.Username, clientTunnelIp, "unable to save PAM key to db:", err)
 			http.Error(w, "Unknown error", 500)
@@ -67,7 +66,7 @@ func (t *Pam) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 		jsonResponse(w, user.Username, 200)
 
 	case "POST":
-		err = user.Authenticate(clientTunnelIp.String(), t.Type(), t.AuthoriseFunc(w, r))
+		err = authenticate(r, &user, clientTunnelIp.String(), t.Type(), t.AuthoriseFunc(w, r))
 		msg, status := resultMessage(err)
 		jsonResponse(w, msg, status)
 
@@ -93,7 +92,7 @@ func (t *Pam) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 
 	clientTunnelIp := utils.GetIPFromRequest(r)
 
-	if router.IsAuthed(clientTunnelIp.String()) {
+	if isAuthed(r, clientTunnelIp.String(), t.Type()) {
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 		resources.Render("success.html", w, nil)
 		return
@@ -106,12 +105,12 @@ func (t *Pam) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !user.IsEnforcingMFA() {
+	if !isEnforcing(r, &user, t.Type()) {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	err = user.Authenticate(clientTunnelIp.String(), t.Type(), t.AuthoriseFunc(w, r))
+	err = authenticate(r, &user, clientTunnelIp.String(), t.Type(), t.AuthoriseFunc(w, r))
 
 	msg, status := resultMessage(err)
 	jsonResponse(w, msg, status)
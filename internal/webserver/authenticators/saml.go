@@ -0,0 +1,383 @@
+package authenticators
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/router"
+	"github.com/NHAS/wag/internal/users"
+	"github.com/NHAS/wag/internal/utils"
+	"github.com/NHAS/wag/internal/webserver/authenticators/types"
+	"github.com/NHAS/wag/internal/webserver/resources"
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+)
+
+type samlRequest struct {
+	id      string
+	expires time.Time
+}
+
+type Saml struct {
+	enable
+
+	sp      *saml.ServiceProvider
+	details data.SAML
+
+	// Outstanding authentication requests by client tunnel address, so that the InResponseTo of the assertion can be checked
+	requestsLck sync.Mutex
+	requests    map[string]samlRequest
+}
+
+func (s *Saml) Init() error {
+	var err error
+	s.details, err = data.GetSaml()
+	if err != nil {
+		return err
+	}
+
+	domain, err := data.GetDomain()
+	if err != nil {
+		return err
+	}
+
+	acsURL, err := url.Parse(domain)
+	if err != nil {
+		return err
+	}
+
+	// Trailing slash is required, otherwise the mux will redirect the identity providers POST to the acs as a GET
+	acsURL.Path = path.Join(acsURL.Path, "/authorise/", s.Type()) + "/"
+
+	metadataURL := *acsURL
+	metadataURL.Path = path.Join(acsURL.Path, "metadata")
+
+	var idpMetadata *saml.EntityDescriptor
+	switch {
+	case s.details.IdPMetadataXML != "":
+		idpMetadata, err = samlsp.ParseMetadata([]byte(s.details.IdPMetadataXML))
+		if err != nil {
+			return errors.New("could not parse identity provider metadata: " + err.Error())
+		}
+	case s.details.IdPMetadataURL != "":
+		idpMetadataURL, err := url.Parse(s.details.IdPMetadataURL)
+		if err != nil {
+			return errors.New("identity provider metadata url was invalid: " + err.Error())
+		}
+
+		log.Println("Fetching SAML identity provider metadata: ", idpMetadataURL.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		idpMetadata, err = samlsp.FetchMetadata(ctx, http.DefaultClient, *idpMetadataURL)
+		if err != nil {
+			return errors.New("could not fetch identity provider metadata: " + err.Error())
+		}
+	default:
+		return errors.New("no saml identity provider metadata set")
+	}
+
+	s.sp = &saml.ServiceProvider{
+		EntityID:          s.details.EntityID,
+		MetadataURL:       metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idpMetadata,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+	}
+
+	if s.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		return errors.New("identity provider does not support the HTTP-Redirect binding")
+	}
+
+	s.requestsLck.Lock()
+	s.requests = map[string]samlRequest{}
+	s.requestsLck.Unlock()
+
+	log.Println("SAML assertion consumer service: ", acsURL.String())
+	log.Println("SAML service provider metadata: ", metadataURL.String())
+
+	return nil
+}
+
+func (s *Saml) Type() string {
+	return string(types.Saml)
+}
+
+func (s *Saml) FriendlyName() string {
+	return "Single Sign On (SAML)"
+}
+
+func (s *Saml) LogoutPath() string {
+	return "/"
+}
+
+func (s *Saml) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	if router.IsAuthed(clientTunnelIp.String()) {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		resources.Render("success.html", w, nil)
+		return
+	}
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	if user.IsEnforcingMFA() {
+		log.Println(user.Username, clientTunnelIp, "tried to re-register mfa despite already being registered")
+
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	log.Println(user.Username, clientTunnelIp, "registering with saml")
+
+	value, _ := json.Marshal(issuer{
+		Issuer: s.sp.IDPMetadata.EntityID,
+	})
+
+	err = data.SetUserMfa(user.Username, string(value), s.Type())
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "unable to set authentication method as saml key to db:", err)
+		http.Error(w, "Unknown error", 500)
+		return
+	}
+
+	s.redirectToIdP(w, r, clientTunnelIp.String())
+}
+
+// AuthorisationAPI is both the assertion consumer service (POST) and the service provider metadata endpoint (GET /authorise/saml/metadata)
+func (s *Saml) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/metadata") {
+		metadata, err := xml.MarshalIndent(s.sp.Metadata(), "", "  ")
+		if err != nil {
+			log.Println("unable to marshal saml service provider metadata: ", err)
+			http.Error(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/samlmetadata+xml")
+		w.Write(metadata)
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	if router.IsAuthed(clientTunnelIp.String()) {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		resources.Render("success.html", w, nil)
+		return
+	}
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	if r.Method != "POST" {
+		s.redirectToIdP(w, r, clientTunnelIp.String())
+		return
+	}
+
+	assertion, err := s.validateResponse(r, clientTunnelIp.String())
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "saml assertion was invalid: ", err)
+		s.renderError(w, user.Username, clientTunnelIp.String(), "Validation failed")
+		return
+	}
+
+	deviceUsername, groups, err := s.identity(assertion)
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "Error, ", err)
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	err = user.Authenticate(clientTunnelIp.String(), s.Type(), func(issuerString, username string) error {
+
+		var issuerDetails issuer
+		err := json.Unmarshal([]byte(issuerString), &issuerDetails)
+		if err != nil {
+			return err
+		}
+
+		if issuerDetails.Issuer != s.sp.IDPMetadata.EntityID {
+			return errors.New("stored issuer " + issuerDetails.Issuer + " did not equal actual issuer: " + s.sp.IDPMetadata.EntityID)
+		}
+
+		if deviceUsername != username {
+			log.Printf("Error logging in user, idP supplied device username (%s) does not equal expected username (%s)", deviceUsername, username)
+			return errors.New("user is not associated with device")
+		}
+
+		if s.details.GroupsAttribute == "" {
+			return nil
+		}
+
+		return data.SetUserGroupMembership(username, groups)
+	})
+
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "failed to authorise: ", err.Error())
+
+		msg, _ := resultMessage(err)
+		if strings.Contains(err.Error(), "not associated with device") {
+			msg = "username '" + deviceUsername + "' not associated with device, device owned by '" + user.Username + "'"
+		}
+
+		s.renderError(w, user.Username, clientTunnelIp.String(), msg)
+		return
+	}
+
+	log.Println(user.Username, clientTunnelIp, "used saml to login with groups: ", groups)
+
+	log.Println(user.Username, clientTunnelIp, "authorised")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *Saml) MFAPromptUI(w http.ResponseWriter, r *http.Request, username, ip string) {
+	s.redirectToIdP(w, r, ip)
+}
+
+func (s *Saml) RegistrationUI(w http.ResponseWriter, r *http.Request, username, ip string) {
+	s.RegistrationAPI(w, r)
+}
+
+func (s *Saml) redirectToIdP(w http.ResponseWriter, r *http.Request, ip string) {
+	req, err := s.sp.MakeAuthenticationRequest(s.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		log.Println(ip, "unable to create saml authentication request: ", err)
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	redirect, err := req.Redirect("", s.sp)
+	if err != nil {
+		log.Println(ip, "unable to create saml redirect: ", err)
+		http.Error(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s.requestsLck.Lock()
+	for k, v := range s.requests {
+		if time.Now().After(v.expires) {
+			delete(s.requests, k)
+		}
+	}
+	s.requests[ip] = samlRequest{id: req.ID, expires: time.Now().Add(saml.MaxIssueDelay)}
+	s.requestsLck.Unlock()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// Request ids are single use, so that an assertion cannot be replayed
+func (s *Saml) takeRequestIDs(ip string) []string {
+	s.requestsLck.Lock()
+	defer s.requestsLck.Unlock()
+
+	request, ok := s.requests[ip]
+	delete(s.requests, ip)
+
+	if !ok || time.Now().After(request.expires) {
+		return nil
+	}
+
+	return []string{request.id}
+}
+
+// validateResponse checks the response posted by the client is signed by the identity provider and answers the last request made for that client
+func (s *Saml) validateResponse(r *http.Request, ip string) (*saml.Assertion, error) {
+	// The service provider reads the response from the parsed post form
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	assertion, err := s.sp.ParseResponse(r, s.takeRequestIDs(ip))
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+
+		return nil, err
+	}
+
+	return assertion, nil
+}
+
+// identity returns the device username and wag groups asserted by the identity provider
+func (s *Saml) identity(assertion *saml.Assertion) (deviceUsername string, groups []string, err error) {
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		deviceUsername = assertion.Subject.NameID.Value
+	}
+
+	if s.details.UsernameAttribute != "" {
+		values := samlAttributeValues(assertion, s.details.UsernameAttribute)
+		if len(values) != 1 {
+			return "", nil, errors.New("username attribute set but idP has not set exactly one value for it in the assertion")
+		}
+
+		deviceUsername = values[0]
+	}
+
+	groups = []string{}
+	for _, group := range samlAttributeValues(assertion, s.details.GroupsAttribute) {
+		groups = append(groups, "group:"+group)
+	}
+
+	return deviceUsername, groups, nil
+}
+
+func (s *Saml) renderError(w http.ResponseWriter, username, ip, msg string) {
+	w.WriteHeader(http.StatusUnauthorized)
+
+	err := resources.Render("oidc_error.html", w, &resources.Msg{
+		HelpMail:   data.GetHelpMail(),
+		NumMethods: NumberOfMethods(),
+		Message:    msg,
+		URL:        s.LogoutPath(),
+	})
+
+	if err != nil {
+		log.Println(username, ip, "error rendering oidc_error.html: ", err)
+	}
+}
+
+func samlAttributeValues(assertion *saml.Assertion, name string) (values []string) {
+	if name == "" {
+		return nil
+	}
+
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name != name && attribute.FriendlyName != name {
+				continue
+			}
+
+			for _, value := range attribute.Values {
+				values = append(values, value.Value)
+			}
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,295 @@
+package authenticators
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+	"github.com/crewjam/saml"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestMain(m *testing.M) {
+	if err := config.Load("../../config/testing_config2.json"); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	k, err := wgtypes.GenerateKey()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	err = data.Load(fmt.Sprintf("file:%s?mode=memory&cache=shared", k.String()), "", true)
+	if err != nil {
+		log.Println("cannot load database: ", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	data.TearDown()
+
+	os.Exit(code)
+}
+
+// testServiceProviders lets the test identity provider answer requests from the wag service provider
+type testServiceProviders struct {
+	metadata *saml.EntityDescriptor
+}
+
+func (p testServiceProviders) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	if serviceProviderID != p.metadata.EntityID {
+		return nil, os.ErrNotExist
+	}
+
+	return p.metadata, nil
+}
+
+// newTestIdP returns an identity provider with its own signing key, every test idP shares the same entity id so only the key differs
+func newTestIdP(t *testing.T) *saml.IdentityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.wag.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadataURL, _ := url.Parse("https://idp.wag.test/metadata")
+	ssoURL, _ := url.Parse("https://idp.wag.test/sso")
+
+	return &saml.IdentityProvider{
+		Key:             key,
+		Certificate:     certificate,
+		MetadataURL:     *metadataURL,
+		SSOURL:          *ssoURL,
+		SignatureMethod: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+	}
+}
+
+func newTestSaml(t *testing.T, idp *saml.IdentityProvider, details data.SAML) *Saml {
+	metadata, err := xml.Marshal(idp.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	details.IdPMetadataXML = string(metadata)
+
+	if err := data.SetDomain("https://vpn.wag.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := data.SetSaml(details); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Saml{}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// login starts a login for the client at ip and has idp answer it, the returned response may be changed before it is posted
+func login(t *testing.T, s *Saml, idp *saml.IdentityProvider, ip string, session *saml.Session) *saml.IdpAuthnRequest {
+	recorder := httptest.NewRecorder()
+	s.redirectToIdP(recorder, httptest.NewRequest(http.MethodGet, "/authorise/saml/", nil), ip)
+
+	if recorder.Code != http.StatusFound {
+		t.Fatal("expected a redirect to the identity provider, got: ", recorder.Code)
+	}
+
+	location := recorder.Header().Get("Location")
+	if !strings.HasPrefix(location, idp.SSOURL.String()) {
+		t.Fatal("redirect was not to the identity provider sso url: ", location)
+	}
+
+	idp.ServiceProviderProvider = testServiceProviders{metadata: s.sp.Metadata()}
+
+	request, err := saml.NewIdpAuthnRequest(idp, httptest.NewRequest(http.MethodGet, location, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := request.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(request, session); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := request.MakeResponse(); err != nil {
+		t.Fatal(err)
+	}
+
+	return request
+}
+
+// post delivers the response to the assertion consumer service as the client at ip would
+func post(t *testing.T, s *Saml, response *saml.IdpAuthnRequest, ip string) (*saml.Assertion, error) {
+	form, err := response.PostBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, s.sp.AcsURL.String(), strings.NewReader(url.Values{"SAMLResponse": {form.SAMLResponse}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return s.validateResponse(r, ip)
+}
+
+func TestSamlResponseValidation(t *testing.T) {
+	idp := newTestIdP(t)
+	s := newTestSaml(t, idp, data.SAML{})
+
+	session := &saml.Session{ID: "session", NameID: "alice", CreateTime: time.Now(), ExpireTime: time.Now().Add(time.Hour)}
+
+	response := login(t, s, idp, "10.0.0.5", session)
+
+	assertion, err := post(t, s, response, "10.0.0.5")
+	if err != nil {
+		t.Fatal("signed response should be accepted: ", err)
+	}
+
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value != "alice" {
+		t.Fatal("unexpected subject: ", assertion.Subject)
+	}
+
+	if _, err := post(t, s, response, "10.0.0.5"); err == nil {
+		t.Fatal("a response should only be accepted once")
+	}
+
+	// A response to a request made for one client cannot be used by another
+	response = login(t, s, idp, "10.0.0.5", session)
+	if _, err := post(t, s, response, "10.0.0.6"); err == nil {
+		t.Fatal("response was accepted for a client that did not make the request")
+	}
+
+	if _, err := post(t, s, response, "10.0.0.5"); err != nil {
+		t.Fatal("failed response from another client should not use up the request: ", err)
+	}
+
+	// Only the latest request for a client can be answered
+	stale := login(t, s, idp, "10.0.0.5", session)
+	latest := login(t, s, idp, "10.0.0.5", session)
+	if _, err := post(t, s, stale, "10.0.0.5"); err == nil {
+		t.Fatal("response to a superseded request was accepted")
+	}
+
+	if _, err := post(t, s, latest, "10.0.0.5"); err == nil {
+		t.Fatal("request should have been used up by the previous attempt")
+	}
+
+	response = login(t, s, idp, "10.0.0.5", session)
+	for _, signature := range response.ResponseEl.FindElements("//Signature") {
+		signature.Parent().RemoveChild(signature)
+	}
+
+	if _, err := post(t, s, response, "10.0.0.5"); err == nil {
+		t.Fatal("unsigned response was accepted")
+	}
+
+	response = login(t, s, idp, "10.0.0.5", session)
+	for _, nameID := range response.ResponseEl.FindElements("//NameID") {
+		nameID.SetText("mallory")
+	}
+
+	if _, err := post(t, s, response, "10.0.0.5"); err == nil {
+		t.Fatal("response changed after signing was accepted")
+	}
+
+	// Same entity id, different key
+	response = login(t, s, newTestIdP(t), "10.0.0.5", session)
+	if _, err := post(t, s, response, "10.0.0.5"); err == nil {
+		t.Fatal("response signed by the wrong key was accepted")
+	}
+}
+
+func TestSamlAttributeMapping(t *testing.T) {
+	idp := newTestIdP(t)
+	s := newTestSaml(t, idp, data.SAML{UsernameAttribute: "uid", GroupsAttribute: "eduPersonAffiliation"})
+
+	session := &saml.Session{
+		ID:         "session",
+		NameID:     "alice@wag.test",
+		UserName:   "alice",
+		Groups:     []string{"developers", "admins"},
+		CreateTime: time.Now(),
+		ExpireTime: time.Now().Add(time.Hour),
+	}
+
+	assertion, err := post(t, s, login(t, s, idp, "10.0.0.5", session), "10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	username, groups, err := s.identity(assertion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if username != "alice" {
+		t.Fatal("username should be taken from the attribute instead of the name id, got: ", username)
+	}
+
+	if !slices.Equal(groups, []string{"group:developers", "group:admins"}) {
+		t.Fatal("unexpected groups: ", groups)
+	}
+
+	// Attributes can also be matched by their full name
+	s.details.GroupsAttribute = "urn:oid:1.3.6.1.4.1.5923.1.1.1.1"
+	if _, groups, _ := s.identity(assertion); len(groups) != 2 {
+		t.Fatal("groups attribute was not matched by name: ", groups)
+	}
+
+	s.details.UsernameAttribute = ""
+	s.details.GroupsAttribute = ""
+	username, groups, err = s.identity(assertion)
+	if err != nil || username != "alice@wag.test" || len(groups) != 0 {
+		t.Fatal("without attributes configured the name id should be used and no groups set: ", username, groups, err)
+	}
+
+	s.details.UsernameAttribute = "uid"
+	session.CustomAttributes = []saml.Attribute{{Name: "uid", Values: []saml.AttributeValue{{Type: "xs:string", Value: "bob"}}}}
+
+	assertion, err = post(t, s, login(t, s, idp, "10.0.0.5", session), "10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.identity(assertion); err == nil {
+		t.Fatal("more than one username value should be refused")
+	}
+}
@@ -0,0 +1,114 @@
+package authenticators
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/router"
+	"github.com/NHAS/wag/internal/utils"
+	"github.com/NHAS/wag/internal/webserver/authenticators/types"
+)
+
+type stepUpContextKey struct{}
+
+// Methods that redirect to an external identity provider can only ever be the primary factor, as they would replace the users session rather than add to it
+var stepUpMethods = []types.MFA{types.Totp, types.Webauthn, types.Pam, types.Ldap}
+
+// CanStepUp returns whether the method can be used as an additional step up factor
+func CanStepUp(method string) bool {
+	return slices.Contains(stepUpMethods, types.MFA(method))
+}
+
+// AsStepUp marks a request as registering or authorising a step up factor, rather than the primary mfa method
+func AsStepUp(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), stepUpContextKey{}, true))
+}
+
+func IsStepUp(r *http.Request) bool {
+	stepUp, _ := r.Context().Value(stepUpContextKey{}).(bool)
+	return stepUp
+}
+
+// mfaUser is the part of a user that authenticators use, so the same registration and authorisation code can handle both primary and step up factors
+type mfaUser interface {
+	Authenticate(device, mfaType string, authenticator types.AuthenticatorFunc) error
+	AuthenticateStepUp(device, mfaType string, authenticator types.AuthenticatorFunc) error
+	ExtendSession(device, mfaType string, authenticator types.AuthenticatorFunc) error
+
+	IsEnforcingMFA() bool
+	IsEnforcingStepUp(mfaType string) bool
+
+	MFA() (string, error)
+	StepUpMFA(mfaType string) (string, error)
+}
+
+// isAuthed is true if there is nothing left for this request to do, for step up that is when the device has already completed this factor in its current session,
+// and for extension when the session cannot be extended (yet)
+func isAuthed(r *http.Request, ip, mfaType string) bool {
+	if IsExtension(r) {
+		return !canExtend(ip)
+	}
+
+	if !IsStepUp(r) {
+		return router.IsAuthed(ip)
+	}
+
+	device, err := data.GetDeviceByAddress(ip)
+	if err != nil {
+		return false
+	}
+
+	return slices.Contains(device.StepUpFactors, mfaType)
+}
+
+func isEnforcing(r *http.Request, user mfaUser, mfaType string) bool {
+	if IsStepUp(r) {
+		return user.IsEnforcingStepUp(mfaType)
+	}
+
+	return user.IsEnforcingMFA()
+}
+
+func authenticate(r *http.Request, user mfaUser, device, mfaType string, authenticator types.AuthenticatorFunc) error {
+	if IsExtension(r) {
+		return user.ExtendSession(device, mfaType, authenticator)
+	}
+
+	if IsStepUp(r) {
+		return user.AuthenticateStepUp(device, mfaType, authenticator)
+	}
+
+	return user.Authenticate(device, mfaType, authenticator)
+}
+
+func getUserMfa(r *http.Request, user mfaUser, mfaType string) (string, error) {
+	if IsStepUp(r) {
+		return user.StepUpMFA(mfaType)
+	}
+
+	return user.MFA()
+}
+
+func setUserMfa(r *http.Request, username, value, mfaType string) error {
+	if IsStepUp(r) {
+		return data.SetUserStepUpMfa(username, value, mfaType)
+	}
+
+	return data.SetUserMfa(username, value, mfaType)
+}
+
+// checkStepUp only allows step up requests from devices that have an active session from their primary mfa method
+func checkStepUp(method types.MFA, f func(a Authenticator) http.HandlerFunc) func(w http.ResponseWriter, r *http.Request) {
+	enabled := checkEnabled(method, f)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !router.IsAuthed(utils.GetIPFromRequest(r).String()) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		enabled(w, AsStepUp(r))
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"time"
 
 	"github.com/NHAS/wag/internal/data"
-	"github.com/NHAS/wag/internal/router"
 	"github.com/NHAS/wag/internal/users"
 	"github.com/NHAS/wag/internal/utils"
 	"github.com/NHAS/wag/internal/webserver/authenticators/types"
@@ -50,7 +49,7 @@ func (t *Totp) FriendlyName() string {
 func (t *Totp) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 	clientTunnelIp := utils.GetIPFromRequest(r)
 
-	if router.IsAuthed(clientTunnelIp.String()) {
+	if isAuthed(r, clientTunnelIp.String(), t.Type()) {
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 		resources.Render("success.html", w, nil)
 		return
@@ -63,7 +62,7 @@ func (t *Totp) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if user.IsEnforcingMFA() {
+	if isEnforcing(r, &user, t.Type()) {
 		log.Println(user.Username, clientTunnelIp, "tried to re-register mfa despite already being registered")
 
 		http.Error(w, "Bad request", 400)
@@ -90,7 +89,7 @@ func (t *Totp) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = data.SetUserMfa(user.Username, key.URL(), t.Type())
+		err = setUserMfa(r, user.Username, key.URL(), t.Type())
 		if err != nil {
 			log.Println(user.Username, clientTunnelIp, "unable to save totp key to db:", err)
 			http.Error(w, "Unknown error", 500)
@@ -125,7 +124,7 @@ func (t *Totp) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 		jsonResponse(w, &mfa, 200)
 
 	case "POST":
-		err = user.Authenticate(clientTunnelIp.String(), t.Type(), t.AuthoriseFunc(w, r))
+		err = authenticate(r, &user, clientTunnelIp.String(), t.Type(), t.AuthoriseFunc(w, r))
 		msg, status := resultMessage(err)
 		jsonResponse(w, msg, status)
 
@@ -151,7 +150,7 @@ func (t *Totp) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 
 	clientTunnelIp := utils.GetIPFromRequest(r)
 
-	if router.IsAuthed(clientTunnelIp.String()) {
+	if isAuthed(r, clientTunnelIp.String(), t.Type()) {
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 		resources.Render("success.html", w, nil)
 		return
@@ -164,12 +163,12 @@ func (t *Totp) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !user.IsEnforcingMFA() {
+	if !isEnforcing(r, &user, t.Type()) {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	err = user.Authenticate(clientTunnelIp.String(), t.Type(), t.AuthoriseFunc(w, r))
+	err = authenticate(r, &user, clientTunnelIp.String(), t.Type(), t.AuthoriseFunc(w, r))
 
 	msg, status := resultMessage(err)
 	jsonResponse(w, msg, status)
@@ -1,5 +1,7 @@
 package types
 
+import "strings"
+
 type MFA string
 
 const (
@@ -7,8 +9,23 @@ const (
 	Totp     MFA = "totp"
 	Webauthn MFA = "webauthn"
 	Oidc     MFA = "oidc"
+	Saml     MFA = "saml"
 	Pam      MFA = "pam"
+	Ldap     MFA = "ldap"
 )
 
 // This is passed to the users.Authenticate(...) function
 type AuthenticatorFunc func(mfaSecret, username string) error
+
+// OidcProvider returns the mfa method name for an additional, named, OIDC identity provider
+func OidcProvider(name string) MFA {
+	if name == "" {
+		return Oidc
+	}
+	return MFA(string(Oidc) + "-" + name)
+}
+
+// IsOidc returns true for the default OIDC method and any named OIDC provider
+func (m MFA) IsOidc() bool {
+	return m == Oidc || strings.HasPrefix(string(m), string(Oidc)+"-")
+}
@@ -6,8 +6,10 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/NHAS/session"
@@ -17,6 +19,7 @@ import (
 	"github.com/NHAS/wag/internal/utils"
 	"github.com/NHAS/wag/internal/webserver/authenticators/types"
 	"github.com/NHAS/wag/internal/webserver/resources"
+	"github.com/NHAS/wag/pkg/control"
 	"github.com/NHAS/webauthn/protocol"
 	"github.com/NHAS/webauthn/webauthn"
 )
@@ -60,7 +63,7 @@ func (wa *Webauthn) FriendlyName() string {
 func (wa *Webauthn) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 	clientTunnelIp := utils.GetIPFromRequest(r)
 
-	if router.IsAuthed(clientTunnelIp.String()) {
+	if isAuthed(r, clientTunnelIp.String(), wa.Type()) {
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 		resources.Render("success.html", w, nil)
 		return
@@ -73,7 +76,7 @@ func (wa *Webauthn) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if user.IsEnforcingMFA() {
+	if isEnforcing(r, &user, wa.Type()) {
 		log.Println(user.Username, clientTunnelIp, "tried to re-register mfa despite already being registered")
 
 		http.Error(w, "Bad request", 400)
@@ -108,7 +111,7 @@ func (wa *Webauthn) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = data.SetUserMfa(user.Username, string(webauthdata), wa.Type())
+		err = setUserMfa(r, user.Username, string(webauthdata), wa.Type())
 		if err != nil {
 			log.Println(user.Username, clientTunnelIp, "cant set user db to webauth user")
 			jsonResponse(w, "Server Error", http.StatusInternalServerError)
@@ -117,7 +120,7 @@ func (wa *Webauthn) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 
 		jsonResponse(w, options, http.StatusOK)
 	case "POST":
-		err = user.Authenticate(clientTunnelIp.String(), wa.Type(),
+		err = authenticate(r, &user, clientTunnelIp.String(), wa.Type(),
 
 			func(mfaSecret, username string) error {
 
@@ -139,14 +142,14 @@ func (wa *Webauthn) RegistrationAPI(w http.ResponseWriter, r *http.Request) {
 					return err
 				}
 
-				webauthnUser.AddCredential(*credential)
+				webauthnUser.AddNamedCredential(*credential, r.URL.Query().Get("name"))
 
 				webauthdata, err := webauthnUser.MarshalJSON()
 				if err != nil {
 					return err
 				}
 
-				err = data.SetUserMfa(username, string(webauthdata), wa.Type())
+				err = setUserMfa(r, username, string(webauthdata), wa.Type())
 				if err != nil {
 
 					return err
@@ -179,7 +182,7 @@ func (wa *Webauthn) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 
 	clientTunnelIp := utils.GetIPFromRequest(r)
 
-	if router.IsAuthed(clientTunnelIp.String()) {
+	if isAuthed(r, clientTunnelIp.String(), wa.Type()) {
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 		resources.Render("success.html", w, nil)
 		return
@@ -192,7 +195,7 @@ func (wa *Webauthn) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !user.IsEnforcingMFA() {
+	if !isEnforcing(r, &user, wa.Type()) {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
@@ -200,7 +203,7 @@ func (wa *Webauthn) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 
-		webauthUserData, err := user.MFA()
+		webauthUserData, err := getUserMfa(r, &user, wa.Type())
 		if err != nil {
 			log.Println(user.Username, clientTunnelIp, "could not get webauthn MFA details from db:", err)
 
@@ -232,7 +235,7 @@ func (wa *Webauthn) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 		log.Println(user.Username, clientTunnelIp, "begun webauthn login process (sent challenge)")
 	case "POST":
 
-		err = user.Authenticate(clientTunnelIp.String(), wa.Type(),
+		err = authenticate(r, &user, clientTunnelIp.String(), wa.Type(),
 			func(mfaSecret, username string) error {
 
 				var webauthnUser WebauthnUser
@@ -264,7 +267,7 @@ func (wa *Webauthn) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 				}
 
 				// Store the updated credentials (credential counter incremented by one)
-				err = data.SetUserMfa(username, string(webauthdata), wa.Type())
+				err = setUserMfa(r, username, string(webauthdata), wa.Type())
 				if err != nil {
 					return err
 				}
@@ -288,6 +291,174 @@ func (wa *Webauthn) AuthorisationAPI(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// CredentialsAPI lets a user that has already authorised with their security key list, add and remove keys. Added under /mfa/webauthn/
+func (wa *Webauthn) CredentialsAPI(w http.ResponseWriter, r *http.Request) {
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	if !router.IsAuthed(clientTunnelIp.String()) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	if user.GetMFAType() != wa.Type() || !user.IsEnforcingMFA() {
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	webauthUserData, err := user.MFA()
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "could not get webauthn MFA details from db:", err)
+		jsonResponse(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var webauthnUser WebauthnUser
+	err = webauthnUser.UnmarshalJSON([]byte(webauthUserData))
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "failed to unmarshal db object:", err)
+		jsonResponse(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		jsonResponse(w, webauthnUser.Credentials(), http.StatusOK)
+
+	case "POST":
+		switch r.URL.Query().Get("action") {
+		case "begin":
+			options, sessionData, err := wa.webauthnExecutor.BeginRegistration(
+				webauthnUser,
+				func(pkcco *protocol.PublicKeyCredentialCreationOptions) {
+					pkcco.AuthenticatorSelection.UserVerification = "discouraged"
+					pkcco.CredentialExcludeList = webauthnUser.CredentialExcludeList()
+				},
+			)
+			if err != nil {
+				log.Println(user.Username, clientTunnelIp, "error creating registration request for additional webauthn key:", err)
+				jsonResponse(w, "Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			wa.sessions.StartSession(w, r, sessionData, nil)
+
+			jsonResponse(w, options, http.StatusOK)
+
+		case "finish":
+			_, sessionData := wa.sessions.GetSessionFromRequest(r)
+			if sessionData == nil {
+				jsonResponse(w, "Validation failed", http.StatusBadRequest)
+				return
+			}
+
+			credential, err := wa.webauthnExecutor.FinishRegistration(webauthnUser, **sessionData, r)
+			if err != nil {
+				log.Println(user.Username, clientTunnelIp, "failed to register additional webauthn key:", err)
+				jsonResponse(w, "Validation failed", http.StatusBadRequest)
+				return
+			}
+
+			wa.sessions.DeleteSession(w, r)
+
+			webauthnUser.AddNamedCredential(*credential, r.URL.Query().Get("name"))
+
+			err = saveWebauthnUser(user.Username, &webauthnUser)
+			if err != nil {
+				log.Println(user.Username, clientTunnelIp, "unable to save additional webauthn key:", err)
+				jsonResponse(w, "Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			log.Println(user.Username, clientTunnelIp, "registered additional webauthn key")
+
+			jsonResponse(w, "Success", http.StatusOK)
+		default:
+			http.Error(w, "Bad request", 400)
+		}
+
+	case "DELETE":
+		err = webauthnUser.RemoveCredential(r.URL.Query().Get("id"))
+		if err != nil {
+			jsonResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = saveWebauthnUser(user.Username, &webauthnUser)
+		if err != nil {
+			log.Println(user.Username, clientTunnelIp, "unable to save webauthn keys after removing one:", err)
+			jsonResponse(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		log.Println(user.Username, clientTunnelIp, "removed webauthn key")
+
+		jsonResponse(w, "Success", http.StatusOK)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func saveWebauthnUser(username string, webauthnUser *WebauthnUser) error {
+	webauthdata, err := webauthnUser.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	return data.SetUserMfa(username, string(webauthdata), string(types.Webauthn))
+}
+
+func getWebauthnUser(username string) (*WebauthnUser, error) {
+	user, err := data.GetUserData(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MfaType != string(types.Webauthn) || !user.Enforcing {
+		return nil, errors.New("user does not have security keys registered")
+	}
+
+	var webauthnUser WebauthnUser
+	err = webauthnUser.UnmarshalJSON([]byte(user.Mfa))
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthnUser, nil
+}
+
+// ListWebauthnCredentials returns the security keys a user has registered as their mfa method
+func ListWebauthnCredentials(username string) ([]control.WebauthnCredential, error) {
+	webauthnUser, err := getWebauthnUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	return webauthnUser.Credentials(), nil
+}
+
+// RemoveWebauthnCredential removes one of the users security keys by id, the users last key cannot be removed
+func RemoveWebauthnCredential(username, id string) error {
+	webauthnUser, err := getWebauthnUser(username)
+	if err != nil {
+		return err
+	}
+
+	err = webauthnUser.RemoveCredential(id)
+	if err != nil {
+		return err
+	}
+
+	return saveWebauthnUser(username, webauthnUser)
+}
+
 func (wa *Webauthn) MFAPromptUI(w http.ResponseWriter, r *http.Request, username, ip string) {
 
 	if err := resources.Render("prompt_mfa_webauthn.html", w, &resources.Msg{
@@ -312,12 +483,18 @@ func (wa *Webauthn) LogoutPath() string {
 	return "/"
 }
 
+type credentialInfo struct {
+	Name  string
+	Added time.Time
+}
+
 // WebauthnUser represents the user model
 type WebauthnUser struct {
 	id          uint64
 	name        string
 	displayName string
 	credentials map[string]*webauthn.Credential
+	info        map[string]credentialInfo
 }
 
 func (u *WebauthnUser) UnmarshalJSON(b []byte) error {
@@ -326,6 +503,7 @@ func (u *WebauthnUser) UnmarshalJSON(b []byte) error {
 		Name        string
 		DisplayName string
 		Credentials map[string]webauthn.Credential
+		Info        map[string]credentialInfo
 	}{}
 
 	if err := json.Unmarshal(b, &anon); err != nil {
@@ -336,6 +514,7 @@ func (u *WebauthnUser) UnmarshalJSON(b []byte) error {
 	u.name = anon.Name
 	u.displayName = anon.DisplayName
 	u.credentials = make(map[string]*webauthn.Credential)
+	u.info = make(map[string]credentialInfo)
 
 	for id := range anon.Credentials {
 		longTerm := anon.Credentials[id]
@@ -345,6 +524,11 @@ func (u *WebauthnUser) UnmarshalJSON(b []byte) error {
 		}
 		//Encoding non-ascii characters into JSON seems to be broken in golang
 		u.credentials[string(d)] = &longTerm
+
+		// Credentials registered before keys could be named wont have any info
+		if info, ok := anon.Info[id]; ok {
+			u.info[string(d)] = info
+		}
 	}
 
 	return nil
@@ -356,11 +540,13 @@ func (u *WebauthnUser) MarshalJSON() ([]byte, error) {
 		Name        string
 		DisplayName string
 		Credentials map[string]webauthn.Credential
+		Info        map[string]credentialInfo
 	}{
 		Id:          u.id,
 		Name:        u.name,
 		DisplayName: u.displayName,
 		Credentials: make(map[string]webauthn.Credential),
+		Info:        make(map[string]credentialInfo),
 	}
 
 	for id, cred := range u.credentials {
@@ -368,6 +554,10 @@ func (u *WebauthnUser) MarshalJSON() ([]byte, error) {
 		anon.Credentials[base64.StdEncoding.EncodeToString([]byte(id))] = *cred
 	}
 
+	for id, info := range u.info {
+		anon.Info[base64.StdEncoding.EncodeToString([]byte(id))] = info
+	}
+
 	return json.Marshal(&anon)
 }
 
@@ -379,6 +569,7 @@ func NewUser(name string, displayName string) *WebauthnUser {
 	user.name = name
 	user.displayName = displayName
 	user.credentials = map[string]*webauthn.Credential{}
+	user.info = map[string]credentialInfo{}
 
 	return user
 }
@@ -418,6 +609,65 @@ func (u *WebauthnUser) AddCredential(cred webauthn.Credential) {
 
 }
 
+// AddNamedCredential associates the credential to the user, with a name so the user can tell their keys apart
+func (u *WebauthnUser) AddNamedCredential(cred webauthn.Credential, name string) {
+	u.AddCredential(cred)
+
+	if name == "" {
+		name = fmt.Sprintf("Security Key %d", len(u.credentials))
+	}
+
+	u.info[string(cred.ID)] = credentialInfo{
+		Name:  name,
+		Added: time.Now(),
+	}
+}
+
+// RemoveCredential removes a credential by its base64 encoded id, the last credential cannot be removed as the user would no longer be able to login
+func (u *WebauthnUser) RemoveCredential(id string) error {
+	rawID, err := base64.StdEncoding.DecodeString(id)
+	if err != nil {
+		return fmt.Errorf("credential id was invalid: %w", err)
+	}
+
+	if _, ok := u.credentials[string(rawID)]; !ok {
+		return errors.New("credential not found")
+	}
+
+	if len(u.credentials) == 1 {
+		return errors.New("cannot remove the only security key, reset the users mfa instead")
+	}
+
+	delete(u.credentials, string(rawID))
+	delete(u.info, string(rawID))
+
+	return nil
+}
+
+// Credentials lists the users credentials with their names, sorted by when they were added
+func (u *WebauthnUser) Credentials() (result []control.WebauthnCredential) {
+	for id := range u.credentials {
+		info := u.info[id]
+
+		name := info.Name
+		if name == "" {
+			name = "Security Key"
+		}
+
+		result = append(result, control.WebauthnCredential{
+			ID:    base64.StdEncoding.EncodeToString([]byte(id)),
+			Name:  name,
+			Added: info.Added,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Added.Before(result[j].Added)
+	})
+
+	return
+}
+
 // WebAuthnCredentials returns credentials owned by the user
 func (u WebauthnUser) WebAuthnCredential(ID []byte) (out *webauthn.Credential) {
 
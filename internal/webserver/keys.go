@@ -0,0 +1,137 @@
+package webserver
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/router"
+	"github.com/NHAS/wag/internal/users"
+	"github.com/NHAS/wag/internal/utils"
+	"github.com/NHAS/wag/internal/webserver/resources"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// deviceKeys tells a device when it must rotate its wireguard keys, and issues it new keys and a config using them.
+// The current keys keep working until the device connects with the new ones, or the grace period of its key rotation policy ends
+func deviceKeys(w http.ResponseWriter, r *http.Request) {
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		rotation, policy, ok, err := data.GetDeviceKeyRotation(clientTunnelIp.String())
+		if err != nil {
+			log.Println(user.Username, clientTunnelIp, "unable to get key rotation status:", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		status := struct {
+			Policy       bool
+			IntervalDays int       `json:",omitempty"`
+			GraceHours   int       `json:",omitempty"`
+			Due          time.Time `json:",omitempty"`
+			Overdue      bool
+			Pending      bool
+		}{
+			Policy:  ok,
+			Pending: rotation.Pending,
+		}
+
+		if ok {
+			status.IntervalDays = policy.IntervalDays
+			status.GraceHours = policy.GraceHours
+			status.Due = rotation.Due
+			status.Overdue = rotation.Overdue
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+
+	case "POST":
+		if !router.IsAuthed(clientTunnelIp.String()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// Only accepting json stops other sites requesting keys as the user, as browsers will not send it cross origin without a preflight
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			http.Error(w, "Bad request", 400)
+			return
+		}
+
+		var request struct {
+			// Optional, if unset a key pair is generated and the private key is included in the config
+			PublicKey string
+		}
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&request); err != nil {
+			http.Error(w, "Bad request", 400)
+			return
+		}
+
+		var (
+			publicKey  wgtypes.Key
+			privateKey string
+		)
+		if request.PublicKey != "" {
+			publicKey, err = wgtypes.ParseKey(request.PublicKey)
+			if err != nil {
+				http.Error(w, "Bad request", 400)
+				return
+			}
+		} else {
+			generated, err := wgtypes.GeneratePrivateKey()
+			if err != nil {
+				log.Println(user.Username, clientTunnelIp, "failed to generate wireguard keys:", err)
+				http.Error(w, "Server error", http.StatusInternalServerError)
+				return
+			}
+
+			publicKey, privateKey = generated.PublicKey(), generated.String()
+		}
+
+		device, err := data.IssueDeviceKeys(clientTunnelIp.String(), publicKey)
+		if err != nil {
+			log.Println(user.Username, clientTunnelIp, "unable to issue new keys:", err)
+			http.Error(w, "Bad request", 400)
+			return
+		}
+
+		wireguardInterface, err := deviceInterface(user.Username, device.Address, privateKey, device.PendingPresharedKey)
+		if err != nil {
+			log.Println(user.Username, clientTunnelIp, "unable to generate wireguard config: ", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Disposition", "attachment; filename="+data.GetWireguardConfigName())
+		w.Header().Set("Content-Type", "text/plain")
+
+		err = resources.RenderWithFuncs("interface.tmpl", w, &wireguardInterface, template.FuncMap{
+			"StringsJoin": strings.Join,
+			"Unescape":    func(s string) template.HTML { return template.HTML(s) },
+		})
+		if err != nil {
+			log.Println(user.Username, clientTunnelIp, "failed to execute template to generate wireguard config:", err)
+			return
+		}
+
+		log.Println(user.Username, clientTunnelIp, "issued new keys:", publicKey.String())
+		data.Audit(data.AuditKeysIssued, user.Username, clientTunnelIp.String(), device.Address, "issued "+publicKey.String())
+
+	default:
+		http.NotFound(w, r)
+	}
+}
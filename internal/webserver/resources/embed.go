@@ -27,6 +27,24 @@ type Msg struct {
 	NumMethods int
 }
 
+type ManageMFA struct {
+	HelpMail      string
+	Webauthn      bool
+	RecoveryCodes int
+}
+
+type AccessRequests struct {
+	HelpMail string
+	MaxHours int
+	Requests []AccessRequestEntry
+}
+
+type AccessRequestEntry struct {
+	ID, Routes, Duration, Reason, Status, Requested string
+	// Only pending requests can be cancelled
+	Pending bool
+}
+
 type Menu struct {
 	MFAMethods  []MenuEntry
 	LastElement int
@@ -0,0 +1,69 @@
+document.addEventListener('DOMContentLoaded', function () {
+
+    document.getElementById("requestForm").onsubmit = function () {
+        requestAccess();
+        return false;
+    };
+
+    document.querySelectorAll(".cancelRequest").forEach(function (link) {
+        link.onclick = function () {
+            cancelRequest(link.dataset.id);
+            return false;
+        };
+    });
+}, false);
+
+function showError(message) {
+    if (message) {
+        document.getElementById("errorMsg").textContent = message;
+    }
+    document.getElementById("error").hidden = false;
+}
+
+async function send(body) {
+    const response = await fetch("/access/", {
+        method: 'POST',
+        mode: 'same-origin',
+        cache: 'no-cache',
+        credentials: 'same-origin',
+        redirect: 'follow',
+        headers: {
+            'Accept': 'application/json',
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(body)
+    });
+
+    if (!response.ok) {
+        let content;
+        try {
+            content = await response.json();
+        } catch (e) {
+            content = undefined;
+        }
+        showError(content);
+        return
+    }
+
+    window.location.reload();
+}
+
+function requestAccess() {
+    const routes = document.getElementById("routes").value.split("\n")
+        .map(function (route) { return route.trim(); })
+        .filter(function (route) { return route.length > 0; });
+
+    send({
+        "routes": routes,
+        "minutes": parseInt(document.getElementById("hours").value, 10) * 60,
+        "reason": document.getElementById("reason").value
+    });
+}
+
+function cancelRequest(id) {
+    if (!confirm("Cancel this access request?")) {
+        return
+    }
+
+    send({ "cancelid": id });
+}
@@ -0,0 +1,204 @@
+document.addEventListener('DOMContentLoaded', function () {
+
+    document.getElementById("generateRecoveryCodes").onclick = generateRecoveryCodes;
+
+    const addKeyForm = document.getElementById("addKeyForm");
+    if (addKeyForm !== null) {
+        addKeyForm.onsubmit = function () {
+            addSecurityKey();
+            return false;
+        };
+
+        listSecurityKeys();
+    }
+}, false);
+
+function showError(message) {
+    if (message) {
+        document.getElementById("errorMsg").textContent = message;
+    }
+    document.getElementById("error").hidden = false;
+}
+
+async function listSecurityKeys() {
+    const response = await fetch("/mfa/webauthn/", {
+        method: 'GET',
+        mode: 'same-origin',
+        cache: 'no-cache',
+        credentials: 'same-origin',
+        redirect: 'follow'
+    });
+
+    if (!response.ok) {
+        console.log("unable to list security keys: ", response.status)
+        showError();
+        return
+    }
+
+    let keys;
+    try {
+        keys = await response.json();
+    } catch (e) {
+        showError();
+        return
+    }
+
+    const table = document.getElementById("securityKeys");
+    table.replaceChildren();
+
+    keys.forEach(function (key) {
+        const row = document.createElement("tr");
+
+        const name = document.createElement("td");
+        name.textContent = key.name;
+        row.appendChild(name);
+
+        const added = document.createElement("td");
+        const addedDate = new Date(key.added);
+        added.textContent = addedDate.getTime() > 0 ? addedDate.toLocaleDateString() : "";
+        row.appendChild(added);
+
+        const actions = document.createElement("td");
+        if (keys.length > 1) {
+            const remove = document.createElement("a");
+            remove.href = "#";
+            remove.textContent = "Remove";
+            remove.onclick = function () {
+                removeSecurityKey(key.id, key.name);
+                return false;
+            };
+            actions.appendChild(remove);
+        }
+        row.appendChild(actions);
+
+        table.appendChild(row);
+    });
+}
+
+async function removeSecurityKey(id, name) {
+    if (!confirm("Remove security key '" + name + "'?")) {
+        return
+    }
+
+    const response = await fetch("/mfa/webauthn/?" + new URLSearchParams({ "id": id }), {
+        method: 'DELETE',
+        mode: 'same-origin',
+        cache: 'no-cache',
+        credentials: 'same-origin',
+        redirect: 'follow'
+    });
+
+    if (!response.ok) {
+        let content;
+        try {
+            content = await response.json();
+        } catch (e) {
+            content = undefined;
+        }
+        showError(content);
+        return
+    }
+
+    listSecurityKeys();
+}
+
+async function addSecurityKey() {
+    try {
+        const challenge = await fetch("/mfa/webauthn/?action=begin", {
+            method: 'POST',
+            mode: 'same-origin',
+            cache: 'no-cache',
+            credentials: 'same-origin',
+            redirect: 'follow'
+        });
+
+        if (!challenge.ok) {
+            console.log("error getting challenge for registration: ", challenge.status)
+            showError();
+            return
+        }
+
+        const credentialCreationOptions = await challenge.json();
+
+        credentialCreationOptions.publicKey.challenge = bufferDecode(credentialCreationOptions.publicKey.challenge);
+        credentialCreationOptions.publicKey.user.id = bufferDecode(credentialCreationOptions.publicKey.user.id);
+        if (credentialCreationOptions.publicKey.excludeCredentials) {
+            for (var i = 0; i < credentialCreationOptions.publicKey.excludeCredentials.length; i++) {
+                credentialCreationOptions.publicKey.excludeCredentials[i].id = bufferDecode(credentialCreationOptions.publicKey.excludeCredentials[i].id);
+            }
+        }
+
+        const newCredential = await navigator.credentials.create({
+            publicKey: credentialCreationOptions.publicKey
+        });
+
+        const body = JSON.stringify({
+            id: newCredential.id,
+            rawId: bufferEncode(newCredential.rawId),
+            type: newCredential.type,
+            response: {
+                attestationObject: bufferEncode(newCredential.response.attestationObject),
+                clientDataJSON: bufferEncode(newCredential.response.clientDataJSON),
+            },
+        })
+
+        const params = new URLSearchParams({ "action": "finish", "name": document.getElementById("keyName").value });
+        const finalise = await fetch("/mfa/webauthn/?" + params, {
+            method: 'POST',
+            mode: 'same-origin',
+            cache: 'no-cache',
+            credentials: 'same-origin',
+            redirect: 'follow',
+            headers: {
+                'Accept': 'application/json',
+                'Content-Type': 'application/json'
+            },
+            body: body
+        });
+
+        if (!finalise.ok) {
+            console.log("finalising registration failed")
+            showError(await finalise.json());
+            return
+        }
+    } catch (e) {
+        console.log("adding security key failed")
+        showError(e.message);
+        return
+    }
+
+    document.getElementById("keyName").value = "";
+    listSecurityKeys();
+}
+
+async function generateRecoveryCodes() {
+    if (document.getElementById("numRecoveryCodes").textContent != "0" && !confirm("This will replace your existing recovery codes, continue?")) {
+        return
+    }
+
+    const response = await fetch("/mfa/recovery/", {
+        method: 'POST',
+        mode: 'same-origin',
+        cache: 'no-cache',
+        credentials: 'same-origin',
+        redirect: 'follow'
+    });
+
+    if (!response.ok) {
+        console.log("unable to generate recovery codes: ", response.status)
+        showError();
+        return
+    }
+
+    let codes;
+    try {
+        codes = await response.json();
+    } catch (e) {
+        showError();
+        return
+    }
+
+    document.getElementById("recoveryCodesList").textContent = codes.join("\n");
+    document.getElementById("numRecoveryCodes").textContent = codes.length;
+    document.getElementById("recoveryCodes").hidden = false;
+}
@@ -1,7 +1,10 @@
+// Step up factors and session extension use the same pages, but are served under /stepup/ and /extend/
+const pathPrefix = ["/stepup", "/extend"].find((prefix) => window.location.pathname.startsWith(prefix + "/")) ?? "";
+
 document.addEventListener('DOMContentLoaded', function () {
-    let location = '/authorise/pam/';
+    let location = pathPrefix + "/authorise/pam/";
     if (document.getElementById("registration") !== null) {
-        location = "/register_mfa/pam/";
+        location = pathPrefix + "/register_mfa/pam/";
         populatePamDetails()
     }
 
@@ -12,7 +15,7 @@ document.addEventListener('DOMContentLoaded', function () {
 }, false);
 
 async function populatePamDetails() {
-    const response = await fetch("/register_mfa/pam/", {
+    const response = await fetch(pathPrefix + "/register_mfa/pam/", {
         method: 'GET',
         mode: 'same-origin',
         cache: 'no-cache',
@@ -80,5 +83,5 @@ async function loginUser(location) {
     }
 
 
-    window.location.href = "/";
+    window.location.href = pathPrefix + "/";
 }
\ No newline at end of file
@@ -0,0 +1,54 @@
+document.addEventListener('DOMContentLoaded', function () {
+    document.getElementById('recoveryForm').onsubmit = function () {
+        useRecoveryCode();
+        return false;
+    };
+}, false);
+
+async function useRecoveryCode() {
+
+    try {
+        const send = await fetch("/recovery/", {
+            method: 'POST',
+            mode: 'same-origin',
+            cache: 'no-cache',
+            credentials: 'same-origin',
+            redirect: 'follow',
+            headers: {
+                'Accept': 'application/json',
+                'Content-Type': 'application/x-www-form-urlencoded;charset=UTF-8'
+            },
+            body: new URLSearchParams({
+                "code": document.getElementById("recoveryCode").value
+            })
+        });
+
+        document.getElementById("recoveryCode").value = "";
+
+        if (!send.ok) {
+            console.log("failed to send recovery code")
+
+            let response;
+            try {
+                response = await send.json();
+            } catch (e) {
+                console.log("using recovery code failed")
+
+                document.getElementById("error").hidden = false;
+                return
+            }
+
+            document.getElementById("errorMsg").textContent = response;
+            document.getElementById("error").hidden = false;
+            return
+        }
+    } catch (e) {
+        console.log("using recovery code failed")
+        document.getElementById("errorMsg").textContent = e.message;
+        document.getElementById("error").hidden = false;
+        return
+    }
+
+    // Send the user to manage their mfa, as they have likely lost their security key or authenticator
+    window.location.href = "/mfa/";
+}
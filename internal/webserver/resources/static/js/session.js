@@ -0,0 +1,73 @@
+// Shows when the session ends, and warns before it does so users are not surprised by their connections stopping
+const sessionPollInterval = 30 * 1000;
+
+document.addEventListener('DOMContentLoaded', function () {
+    updateSession();
+    setInterval(updateSession, sessionPollInterval);
+}, false);
+
+function formatRemaining(until) {
+    const minutes = Math.max(Math.round((new Date(until) - new Date()) / 60000), 0);
+    if (minutes < 60) {
+        return minutes + (minutes === 1 ? " minute" : " minutes");
+    }
+
+    const hours = Math.floor(minutes / 60);
+    return hours + (hours === 1 ? " hour " : " hours ") + (minutes % 60) + " minutes";
+}
+
+async function updateSession() {
+    let session;
+    try {
+        const response = await fetch("/api/v1/session", {
+            method: 'GET',
+            mode: 'same-origin',
+            cache: 'no-cache',
+            credentials: 'same-origin',
+            headers: {
+                'Accept': 'application/json'
+            }
+        });
+
+        if (!response.ok) {
+            return
+        }
+
+        session = await response.json();
+    } catch (e) {
+        console.log("unable to get session state: ", e)
+        return
+    }
+
+    const container = document.getElementById("session");
+    const status = document.getElementById("sessionStatus");
+
+    if (!session.authorised) {
+        status.textContent = "Your session has ended, reload the page to sign in again.";
+        status.className = "alert alert-error";
+        document.getElementById("extendSession").hidden = true;
+        container.hidden = false;
+        return
+    }
+
+    // Whichever of the maximum lifetime and the inactivity timeout comes first
+    let ends = session.expires;
+    let idle = false;
+    if (session.inactivity_expires && (!ends || new Date(session.inactivity_expires) < new Date(ends))) {
+        ends = session.inactivity_expires;
+        idle = true;
+    }
+
+    if (!ends) {
+        container.hidden = true;
+        return
+    }
+
+    status.textContent = idle ?
+        "Your session ends in " + formatRemaining(ends) + " unless there is traffic over the VPN." :
+        "Your session ends in " + formatRemaining(ends) + ".";
+    status.className = session.expiring ? "alert alert-warning" : "";
+
+    document.getElementById("extendSession").hidden = !session.can_extend;
+    container.hidden = false;
+}
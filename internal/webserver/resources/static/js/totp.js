@@ -1,10 +1,19 @@
+// Step up factors and session extension use the same pages, but are served under /stepup/ and /extend/
+const pathPrefix = ["/stepup", "/extend"].find((prefix) => window.location.pathname.startsWith(prefix + "/")) ?? "";
+
 document.addEventListener('DOMContentLoaded', function () {
-    let location = '/authorise/totp/';
+    let location = pathPrefix + "/authorise/totp/";
     if (document.getElementById("registration") !== null) {
-        location = "/register_mfa/totp/";
+        location = pathPrefix + "/register_mfa/totp/";
         populateTotpDetails()
     }
 
+    // Recovery codes only replace the primary mfa method
+    const recoveryLink = document.getElementById("recoveryLink");
+    if (recoveryLink !== null && pathPrefix !== "") {
+        recoveryLink.hidden = true;
+    }
+
     document.getElementById('loginForm').onsubmit = function () {
         loginUser(location);
         return false;
@@ -12,7 +21,7 @@ document.addEventListener('DOMContentLoaded', function () {
 }, false);
 
 async function populateTotpDetails() {
-    const response = await fetch("/register_mfa/totp/", {
+    const response = await fetch(pathPrefix + "/register_mfa/totp/", {
         method: 'GET',
         mode: 'same-origin',
         cache: 'no-cache',
@@ -87,5 +96,5 @@ async function loginUser(location) {
     }
 
 
-    window.location.href = "/";
+    window.location.href = pathPrefix + "/";
 }
\ No newline at end of file
@@ -1,3 +1,6 @@
+// Step up factors and session extension use the same pages, but are served under /stepup/ and /extend/
+const pathPrefix = ["/stepup", "/extend"].find((prefix) => window.location.pathname.startsWith(prefix + "/")) ?? "";
+
 document.addEventListener('DOMContentLoaded', function () {
 
     const registerButton = document.getElementById("registerButton");
@@ -10,6 +13,12 @@ document.addEventListener('DOMContentLoaded', function () {
         loginButton.onclick = loginUser;
     }
 
+    // Recovery codes only replace the primary mfa method
+    const recoveryLink = document.getElementById("recoveryLink");
+    if (recoveryLink !== null && pathPrefix !== "") {
+        recoveryLink.hidden = true;
+    }
+
     if (!window.PublicKeyCredential) {
         alert("Error: this browser does not support WebAuthn");
         return;
@@ -37,7 +46,7 @@ async function registerUser(event) {
     try {
         document.getElementById("registerButton").disable = true;
 
-        const challenge = await fetch("/register_mfa/webauthn/", {
+        const challenge = await fetch(pathPrefix + "/register_mfa/webauthn/", {
             method: 'GET',
             mode: 'same-origin',
             cache: 'no-cache',
@@ -85,7 +94,7 @@ async function registerUser(event) {
             },
         })
 
-        const finalise = await fetch('/register_mfa/webauthn/', {
+        const finalise = await fetch(pathPrefix + "/register_mfa/webauthn/", {
             method: 'POST',
             mode: 'same-origin',
             cache: 'no-cache',
@@ -112,7 +121,7 @@ async function registerUser(event) {
         document.getElementById("registerButton").disable = true;
     }
 
-    window.location.href = "/";
+    window.location.href = pathPrefix + "/";
 
 }
 
@@ -124,7 +133,7 @@ async function loginUser(event) {
     try {
         document.getElementById("loginButton").disable = true;
 
-        const challenge = await fetch("/authorise/webauthn/", {
+        const challenge = await fetch(pathPrefix + "/authorise/webauthn/", {
             method: 'GET',
             mode: 'same-origin',
             cache: 'no-cache',
@@ -189,7 +198,7 @@ async function loginUser(event) {
             },
         })
 
-        const finalise = await fetch('/authorise/webauthn/', {
+        const finalise = await fetch(pathPrefix + "/authorise/webauthn/", {
             method: 'POST',
             mode: 'same-origin',
             cache: 'no-cache',
@@ -223,5 +232,5 @@ async function loginUser(event) {
     }
 
 
-    window.location.href = "/";
+    window.location.href = pathPrefix + "/";
 }
\ No newline at end of file
@@ -15,6 +15,21 @@ func registerListeners() error {
 		return err
 	}
 
+	_, err = data.RegisterEventListener(data.OidcProvidersKey, false, oidcProvidersChanges)
+	if err != nil {
+		return err
+	}
+
+	_, err = data.RegisterEventListener(data.SamlDetailsKey, false, samlChanges)
+	if err != nil {
+		return err
+	}
+
+	_, err = data.RegisterEventListener(data.LdapDetailsKey, false, ldapChanges)
+	if err != nil {
+		return err
+	}
+
 	_, err = data.RegisterEventListener(data.DomainKey, false, domainChanged)
 	if err != nil {
 		return err
@@ -55,6 +70,82 @@ func oidcChanges(key string, current data.OIDC, previous data.OIDC, et data.Even
 	return nil
 }
 
+// OidcProvidersKey = "wag-config-authentication-oidc-providers"
+func oidcProvidersChanges(key string, current []data.OIDC, previous []data.OIDC, et data.EventType) error {
+	switch et {
+	case data.DELETED:
+		authenticators.SyncOidcProviders(nil)
+	case data.CREATED, data.MODIFIED:
+		authenticators.SyncOidcProviders(current)
+
+		methods, err := data.GetAuthenicationMethods()
+		if err != nil {
+			log.Println("Couldnt get authenication methods to enable oidc providers: ", err)
+			return err
+		}
+
+		var toInit []types.MFA
+		for _, provider := range current {
+			method := types.OidcProvider(provider.Name)
+			if provider.Name != "" && slices.Contains(methods, string(method)) {
+				toInit = append(toInit, method)
+			}
+		}
+
+		initdMethods, err := authenticators.ReinitaliseMethods(toInit...)
+		authenticators.EnableMethods(initdMethods...)
+
+		return err
+	}
+
+	return nil
+}
+
+// SamlDetailsKey = "wag-config-authentication-saml"
+func samlChanges(key string, current data.SAML, previous data.SAML, et data.EventType) error {
+	switch et {
+	case data.DELETED:
+		authenticators.DisableMethods(types.Saml)
+	case data.CREATED, data.MODIFIED:
+		methods, err := data.GetAuthenicationMethods()
+		if err != nil {
+			log.Println("Couldnt get authenication methods to enable saml: ", err)
+			return err
+		}
+
+		if slices.Contains(methods, string(types.Saml)) {
+			_, err := authenticators.ReinitaliseMethods(types.Saml)
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LdapDetailsKey = "wag-config-authentication-ldap"
+func ldapChanges(key string, current data.LDAP, previous data.LDAP, et data.EventType) error {
+	switch et {
+	case data.DELETED:
+		authenticators.DisableMethods(types.Ldap)
+	case data.CREATED, data.MODIFIED:
+		methods, err := data.GetAuthenicationMethods()
+		if err != nil {
+			log.Println("Couldnt get authenication methods to enable ldap: ", err)
+			return err
+		}
+
+		if slices.Contains(methods, string(types.Ldap)) {
+			initdMethods, err := authenticators.ReinitaliseMethods(types.Ldap)
+			authenticators.EnableMethods(initdMethods...)
+
+			return err
+		}
+	}
+
+	return nil
+}
+
 // DomainKey            = "wag-config-authentication-domain"
 func domainChanged(key string, current string, _ string, et data.EventType) error {
 	switch et {
@@ -66,11 +157,16 @@ func domainChanged(key string, current string, _ string, et data.EventType) erro
 			return err
 		}
 
-		if slices.Contains(methods, string(types.Oidc)) {
-			_, err := authenticators.ReinitaliseMethods(types.Oidc)
-
-			return err
+		// The callback url of every oidc provider and the saml assertion consumer service is derived from the domain
+		var toInit []types.MFA
+		for _, method := range append(authenticators.OidcMethods(), types.Saml) {
+			if slices.Contains(methods, string(method)) {
+				toInit = append(toInit, method)
+			}
 		}
+
+		_, err = authenticators.ReinitaliseMethods(toInit...)
+		return err
 	}
 
 	return nil
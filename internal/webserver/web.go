@@ -16,13 +16,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/NHAS/wag/internal/certificates"
 	"github.com/NHAS/wag/internal/config"
 	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/metrics"
+	"github.com/NHAS/wag/internal/proxyprotocol"
 	"github.com/NHAS/wag/internal/router"
 	"github.com/NHAS/wag/internal/routetypes"
 	"github.com/NHAS/wag/internal/users"
 	"github.com/NHAS/wag/internal/utils"
 	"github.com/NHAS/wag/internal/webserver/authenticators"
+	"github.com/NHAS/wag/internal/webserver/authenticators/types"
 	"github.com/NHAS/wag/internal/webserver/resources"
 	"github.com/boombuler/barcode"
 	"github.com/boombuler/barcode/qr"
@@ -88,6 +92,11 @@ func Start(errChan chan<- error) error {
 
 	if config.Values.Webserver.Public.SupportsTLS() {
 
+		publicTLSConfig, err := certificates.TLSConfig(tlsConfig, config.Values.Webserver.Public.ACMEDomain, config.Values.Webserver.Public.CertPath, config.Values.Webserver.Public.KeyPath)
+		if err != nil {
+			return fmt.Errorf("unable to load certificate for public listener: %w", err)
+		}
+
 		go func() {
 
 			publicTLSServ = &http.Server{
@@ -95,11 +104,18 @@ func Start(errChan chan<- error) error {
 				ReadTimeout:  5 * time.Second,
 				WriteTimeout: 10 * time.Second,
 				IdleTimeout:  120 * time.Second,
-				TLSConfig:    tlsConfig,
+				TLSConfig:    publicTLSConfig,
 				Handler:      setSecurityHeaders(public),
+				ConnContext:  proxyprotocol.ConnContext(config.Values.Webserver.Public.ProxyProtocol),
 			}
 
-			if err := publicTLSServ.ListenAndServeTLS(config.Values.Webserver.Public.CertPath, config.Values.Webserver.Public.KeyPath); err != nil && err != http.ErrServerClosed {
+			listener, err := proxyprotocol.Listen(publicTLSServ.Addr, config.Values.Webserver.Public.ProxyProtocol)
+			if err != nil {
+				errChan <- fmt.Errorf("TLS webserver public listener failed: %v", err)
+				return
+			}
+
+			if err := publicTLSServ.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
 				errChan <- fmt.Errorf("TLS webserver public listener failed: %v", err)
 			}
 		}()
@@ -125,7 +141,7 @@ func Start(errChan chan<- error) error {
 					ReadTimeout:  5 * time.Second,
 					WriteTimeout: 10 * time.Second,
 					IdleTimeout:  120 * time.Second,
-					Handler:      setSecurityHeaders(setRedirectHandler(port)),
+					Handler:      setSecurityHeaders(certificates.HTTPChallengeHandler(setRedirectHandler(port))),
 				}
 
 				log.Printf("Creating redirection from 80/tcp to TLS webserver public listener failed: %v", publicHTTPServ.ListenAndServe())
@@ -139,10 +155,17 @@ func Start(errChan chan<- error) error {
 				ReadTimeout:  5 * time.Second,
 				WriteTimeout: 10 * time.Second,
 				IdleTimeout:  120 * time.Second,
-				Handler:      setSecurityHeaders(public),
+				Handler:      setSecurityHeaders(certificates.HTTPChallengeHandler(public)),
+				ConnContext:  proxyprotocol.ConnContext(config.Values.Webserver.Public.ProxyProtocol),
+			}
+
+			listener, err := proxyprotocol.Listen(publicHTTPServ.Addr, config.Values.Webserver.Public.ProxyProtocol)
+			if err != nil {
+				errChan <- fmt.Errorf("HTTP webserver public listener failed: %v", err)
+				return
 			}
 
-			if err := publicHTTPServ.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			if err := publicHTTPServ.Serve(listener); err != nil && err != http.ErrServerClosed {
 				errChan <- fmt.Errorf("HTTP webserver public listener failed: %v", err)
 			}
 		}()
@@ -176,6 +199,15 @@ func Start(errChan chan<- error) error {
 
 	tunnel.HandleFunc("/authorise/", authorise)
 	tunnel.HandleFunc("/register_mfa/", registerMFA)
+	tunnel.HandleFunc("/stepup/", stepUp)
+	tunnel.HandleFunc("/extend/", extendSession)
+	tunnel.HandleFunc("/recovery/", recovery)
+	tunnel.HandleFunc("/mfa/", manageMFA)
+	tunnel.HandleFunc("/mfa/recovery/", recoveryCodes)
+	tunnel.HandleFunc("/access/", accessRequests)
+	tunnel.HandleFunc("/keys/", deviceKeys)
+
+	addAPIRoutes(tunnel)
 
 	tunnel.HandleFunc("/public_key/", publicKey)
 
@@ -184,6 +216,11 @@ func Start(errChan chan<- error) error {
 	tunnelListenAddress := config.Values.Wireguard.ServerAddress.String() + ":" + config.Values.Webserver.Tunnel.Port
 	if config.Values.Webserver.Tunnel.SupportsTLS() {
 
+		tunnelTLSConfig, err := certificates.TLSConfig(tlsConfig, config.Values.Webserver.Tunnel.ACMEDomain, config.Values.Webserver.Tunnel.CertPath, config.Values.Webserver.Tunnel.KeyPath)
+		if err != nil {
+			return fmt.Errorf("unable to load certificate for tunnel listener: %w", err)
+		}
+
 		go func() {
 
 			tunnelTLSServ = &http.Server{
@@ -191,10 +228,17 @@ func Start(errChan chan<- error) error {
 				ReadTimeout:  5 * time.Second,
 				WriteTimeout: 10 * time.Second,
 				IdleTimeout:  120 * time.Second,
-				TLSConfig:    tlsConfig,
+				TLSConfig:    tunnelTLSConfig,
 				Handler:      setSecurityHeaders(tunnel),
+				ConnContext:  proxyprotocol.ConnContext(config.Values.Webserver.Tunnel.ProxyProtocol),
+			}
+			listener, err := proxyprotocol.Listen(tunnelTLSServ.Addr, config.Values.Webserver.Tunnel.ProxyProtocol)
+			if err != nil {
+				errChan <- fmt.Errorf("TLS webserver tunnel listener failed: %v", err)
+				return
 			}
-			if err := tunnelTLSServ.ListenAndServeTLS(config.Values.Webserver.Tunnel.CertPath, config.Values.Webserver.Tunnel.KeyPath); err != nil && err != http.ErrServerClosed {
+
+			if err := tunnelTLSServ.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
 				errChan <- fmt.Errorf("TLS webserver tunnel listener failed: %v", err)
 			}
 
@@ -213,7 +257,7 @@ func Start(errChan chan<- error) error {
 					ReadTimeout:  5 * time.Second,
 					WriteTimeout: 10 * time.Second,
 					IdleTimeout:  120 * time.Second,
-					Handler:      setSecurityHeaders(setRedirectHandler(port)),
+					Handler:      setSecurityHeaders(certificates.HTTPChallengeHandler(setRedirectHandler(port))),
 				}
 
 				log.Printf("HTTP redirect to TLS webserver tunnel listener failed: %v", tunnelHTTPServ.ListenAndServe())
@@ -227,9 +271,16 @@ func Start(errChan chan<- error) error {
 				WriteTimeout: 10 * time.Second,
 				IdleTimeout:  120 * time.Second,
 				Handler:      setSecurityHeaders(tunnel),
+				ConnContext:  proxyprotocol.ConnContext(config.Values.Webserver.Tunnel.ProxyProtocol),
 			}
 
-			if err := tunnelHTTPServ.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			listener, err := proxyprotocol.Listen(tunnelHTTPServ.Addr, config.Values.Webserver.Tunnel.ProxyProtocol)
+			if err != nil {
+				errChan <- fmt.Errorf("webserver tunnel listener failed: %v", err)
+				return
+			}
+
+			if err := tunnelHTTPServ.Serve(listener); err != nil && err != http.ErrServerClosed {
 				errChan <- fmt.Errorf("webserver tunnel listener failed: %v", err)
 			}
 
@@ -254,7 +305,16 @@ func index(w http.ResponseWriter, r *http.Request) {
 
 	if router.IsAuthed(clientTunnelIp.String()) {
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
-		resources.Render("success.html", w, nil)
+
+		var msg *resources.Msg
+		if missing, _ := missingStepUpFactors(clientTunnelIp.String()); len(missing) > 0 {
+			msg = &resources.Msg{
+				Message: "Step up authentication",
+				URL:     "/stepup/",
+			}
+		}
+
+		resources.Render("success.html", w, msg)
 
 		return
 	}
@@ -384,6 +444,262 @@ func authorise(w http.ResponseWriter, r *http.Request) {
 	mfaMethod.MFAPromptUI(w, r, user.Username, clientTunnelIp.String())
 }
 
+// stepUp walks an already authorised device through each step up factor it has not yet completed, registering them if needed
+func stepUp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	if !router.IsAuthed(clientTunnelIp.String()) {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	missing, err := missingStepUpFactors(clientTunnelIp.String())
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "unable to get step up state:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(missing) == 0 {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		resources.Render("success.html", w, nil)
+		return
+	}
+
+	mfaMethod, ok := authenticators.GetMethod(missing[0])
+	if !ok || !authenticators.CanStepUp(missing[0]) {
+		log.Println(user.Username, clientTunnelIp, "step up method is not enabled or cannot be used for step up: ", missing[0])
+		http.Error(w, "Step up method "+missing[0]+" is unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	r = authenticators.AsStepUp(r)
+	if user.IsEnforcingStepUp(missing[0]) {
+		mfaMethod.MFAPromptUI(w, r, user.Username, clientTunnelIp.String())
+		return
+	}
+
+	mfaMethod.RegistrationUI(w, r, user.Username, clientTunnelIp.String())
+}
+
+// extendSession asks an authorised device for its mfa method again, restarting its session in place once it is close to the maximum session lifetime
+func extendSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	if !router.IsAuthed(clientTunnelIp.String()) {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	canExtend, err := data.CanExtendSession(clientTunnelIp.String())
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "unable to get session extension state:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Either the session was just extended, or it is not close enough to its end yet
+	if !canExtend {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		resources.Render("success.html", w, nil)
+		return
+	}
+
+	mfaMethod, ok := authenticators.GetMethod(user.GetMFAType())
+	if !ok || !authenticators.CanExtend(user.GetMFAType()) {
+		log.Println(user.Username, clientTunnelIp, "mfa method is not enabled or cannot extend sessions: ", user.GetMFAType())
+		http.Error(w, "Sessions cannot be extended with "+user.GetMFAType()+", sign in again once your session ends", http.StatusBadRequest)
+		return
+	}
+
+	mfaMethod.MFAPromptUI(w, authenticators.AsExtension(r), user.Username, clientTunnelIp.String())
+}
+
+func missingStepUpFactors(address string) ([]string, error) {
+	required, err := data.GetStepUpMethods()
+	if err != nil || len(required) == 0 {
+		// Step up not configured
+		return nil, nil
+	}
+
+	user, err := data.GetUserDataFromAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := data.GetDeviceByAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return data.MissingStepUpFactors(required, user.MfaType, device), nil
+}
+
+// recovery lets a device authorise with a one time recovery code when the user has lost their mfa device
+func recovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	if router.IsAuthed(clientTunnelIp.String()) {
+		http.Redirect(w, r, "/mfa/", http.StatusSeeOther)
+		return
+	}
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	if !user.IsEnforcingMFA() {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		if err := resources.Render("recovery.html", w, &resources.Msg{
+			HelpMail: data.GetHelpMail(),
+		}); err != nil {
+			log.Println(user.Username, clientTunnelIp, "unable to render recovery template: ", err)
+		}
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err = user.AuthenticateRecoveryCode(clientTunnelIp.String(), r.FormValue("code"))
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "failed to authorise with recovery code: ", err.Error())
+
+		msg := "Validation failed"
+		if strings.Contains(err.Error(), "account is locked") {
+			msg = "Account is locked contact: " + data.GetHelpMail()
+		} else if strings.Contains(err.Error(), "device is locked") {
+			msg = "Device is locked contact: " + data.GetHelpMail()
+		} else if strings.Contains(err.Error(), "too many attempts") {
+			msg = "Too many attempts, " + err.Error()[strings.Index(err.Error(), "try again"):]
+		}
+
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(msg)
+		return
+	}
+
+	log.Println(user.Username, clientTunnelIp, "authorised with recovery code")
+
+	json.NewEncoder(w).Encode("Success")
+}
+
+// manageMFA shows an authorised user their security keys and recovery codes
+func manageMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	if !router.IsAuthed(clientTunnelIp.String()) {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	numCodes, err := data.GetNumberOfRecoveryCodes(user.Username)
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "unable to get number of recovery codes:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	_, webauthnEnabled := authenticators.GetMethod(string(types.Webauthn))
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	if err := resources.Render("manage_mfa.html", w, &resources.ManageMFA{
+		HelpMail:      data.GetHelpMail(),
+		Webauthn:      webauthnEnabled && user.GetMFAType() == string(types.Webauthn),
+		RecoveryCodes: numCodes,
+	}); err != nil {
+		log.Println(user.Username, clientTunnelIp, "unable to render mfa management template: ", err)
+	}
+}
+
+// recoveryCodes generates a new set of recovery codes for an authorised user, replacing any they already have
+func recoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	clientTunnelIp := utils.GetIPFromRequest(r)
+
+	if !router.IsAuthed(clientTunnelIp.String()) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := users.GetUserFromAddress(clientTunnelIp)
+	if err != nil {
+		log.Println("unknown", clientTunnelIp, "could not get associated device:", err)
+		http.Error(w, "Bad request", 400)
+		return
+	}
+
+	codes, err := user.GenerateRecoveryCodes()
+	if err != nil {
+		log.Println(user.Username, clientTunnelIp, "unable to generate recovery codes:", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println(user.Username, clientTunnelIp, "generated new recovery codes")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(codes)
+}
+
 func reachability(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain")
 
@@ -424,9 +740,11 @@ func registerDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	username, overwrites, groups, err := data.GetRegistrationToken(key)
+	username, overwrites, groups, accountExpires, err := data.GetRegistrationToken(key)
 	if err != nil {
 		log.Println(username, remoteAddr, "failed to get registration key:", err)
+		metrics.Registrations.WithLabelValues("failure").Inc()
+		data.Audit(data.AuditRegistrationFailed, "unknown", remoteAddr.String(), "", "invalid registration token")
 		http.NotFound(w, r)
 		return
 	}
@@ -475,6 +793,15 @@ func registerDevice(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if !accountExpires.IsZero() {
+		err = data.SetUserExpiry(username, accountExpires)
+		if err != nil {
+			log.Println(username, remoteAddr, "unable to set account expiry from registration token: ", err)
+			http.Error(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	var (
 		address string
 	)
@@ -514,15 +841,6 @@ func registerDevice(w http.ResponseWriter, r *http.Request) {
 		}()
 	}
 
-	acl := data.GetEffectiveAcl(username)
-
-	wgPublicKey, wgPort, err := router.ServerDetails()
-	if err != nil {
-		log.Println(username, remoteAddr, "unable access wireguard device: ", err)
-		http.Error(w, "Server Error", http.StatusInternalServerError)
-		return
-	}
-
 	keyStr := privatekey.String()
 	//Empty value of a private key in wgtype.Key
 	if keyStr == "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=" {
@@ -536,48 +854,13 @@ func registerDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dnsWithOutSubnet, err := data.GetDNS()
-	if err != nil {
-		log.Println(username, remoteAddr, "unable get dns: ", err)
-		http.Error(w, "Server Error", http.StatusInternalServerError)
-		return
-	}
-
-	for i := 0; i < len(dnsWithOutSubnet); i++ {
-		dnsWithOutSubnet[i] = strings.TrimSuffix(dnsWithOutSubnet[i], "/32")
-	}
-
-	routes, err := routetypes.AclsToRoutes(append(acl.Allow, acl.Mfa...))
-	if err != nil {
-		log.Println(username, remoteAddr, "unable access parse acls to produce routes: ", err)
-		http.Error(w, "Server Error", http.StatusInternalServerError)
-		return
-	}
-
-	wireguardInterface := resources.Interface{
-		ClientPrivateKey:   keyStr,
-		ClientAddress:      address,
-		ServerPublicKey:    wgPublicKey.String(),
-		CapturedAddresses:  routes,
-		DNS:                dnsWithOutSubnet,
-		ClientPresharedKey: presharedKey,
-	}
-
-	externalAddress, err := data.GetExternalAddress()
+	wireguardInterface, err := deviceInterface(username, address, keyStr, presharedKey)
 	if err != nil {
-		log.Println(username, remoteAddr, "unable to get server external address from datastore: ", err)
+		log.Println(username, remoteAddr, "unable to generate wireguard config: ", err)
 		http.Error(w, "Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// If the external address defined in the config has a port, use that, otherwise defaultly add the same port as the wireguard device
-	_, _, err = net.SplitHostPort(externalAddress)
-	if err != nil {
-		externalAddress = fmt.Sprintf("%s:%d", externalAddress, wgPort)
-	}
-
-	wireguardInterface.ServerAddress = externalAddress
-
 	if r.URL.Query().Get("type") == "mobile" {
 		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 
@@ -654,6 +937,54 @@ func registerDevice(w http.ResponseWriter, r *http.Request) {
 		logMsg = "overwrote"
 	}
 	log.Println(username, remoteAddr, "successfully", logMsg, address, ":", publickey.String())
+
+	metrics.Registrations.WithLabelValues("success").Inc()
+	data.Audit(data.AuditDeviceRegistered, username, remoteAddr.String(), address, logMsg+" "+address+": "+publickey.String())
+}
+
+// deviceInterface is the wireguard config for a device, privateKey is empty if the device generated its own keys
+func deviceInterface(username, address, privateKey, presharedKey string) (resources.Interface, error) {
+	acl := data.GetEffectiveAcl(username)
+
+	wgPublicKey, wgPort, err := router.ServerDetails()
+	if err != nil {
+		return resources.Interface{}, fmt.Errorf("unable access wireguard device: %w", err)
+	}
+
+	dnsWithOutSubnet, err := data.GetDNS()
+	if err != nil {
+		return resources.Interface{}, fmt.Errorf("unable get dns: %w", err)
+	}
+
+	for i := 0; i < len(dnsWithOutSubnet); i++ {
+		dnsWithOutSubnet[i] = strings.TrimSuffix(dnsWithOutSubnet[i], "/32")
+	}
+
+	routes, err := routetypes.AclsToRoutes(append(append(acl.Allow, acl.Mfa...), acl.StepUp...))
+	if err != nil {
+		return resources.Interface{}, fmt.Errorf("unable access parse acls to produce routes: %w", err)
+	}
+
+	externalAddress, err := data.GetExternalAddress()
+	if err != nil {
+		return resources.Interface{}, fmt.Errorf("unable to get server external address from datastore: %w", err)
+	}
+
+	// If the external address defined in the config has a port, use that, otherwise defaultly add the same port as the wireguard device
+	_, _, err = net.SplitHostPort(externalAddress)
+	if err != nil {
+		externalAddress = fmt.Sprintf("%s:%d", externalAddress, wgPort)
+	}
+
+	return resources.Interface{
+		ClientPrivateKey:   privateKey,
+		ClientAddress:      address,
+		ServerPublicKey:    wgPublicKey.String(),
+		ServerAddress:      externalAddress,
+		CapturedAddresses:  routes,
+		DNS:                dnsWithOutSubnet,
+		ClientPresharedKey: presharedKey,
+	}, nil
 }
 
 func logout(w http.ResponseWriter, r *http.Request) {
@@ -683,6 +1014,8 @@ func logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	data.Audit(data.AuditLogout, user.Username, clientTunnelIp.String(), "", "")
+
 	method, ok := authenticators.GetMethod(user.GetMFAType())
 	if !ok {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -741,10 +1074,12 @@ func status(w http.ResponseWriter, r *http.Request) {
 		IsAuthorised bool
 		MFA          []string
 		Public       []string
+		StepUp       []string
 	}{
 		IsAuthorised: router.IsAuthed(remoteAddress.String()),
 		MFA:          acl.Mfa,
 		Public:       acl.Allow,
+		StepUp:       acl.StepUp,
 	}
 
 	result, err := json.Marshal(&status)
@@ -15,9 +15,19 @@ var cmds = []commands.Command{
 	commands.Cleanup(),
 
 	commands.Registration(),
+	commands.Access(),
 	commands.Devices(),
 	commands.Users(),
 	commands.Firewall(),
+	commands.Audit(),
+
+	commands.Backup(),
+	commands.Restore(),
+	commands.Apply(),
+	commands.Encryption(),
+	commands.APITokens(),
+	commands.Settings(),
+	commands.Cluster(),
 
 	commands.Webadmin(),
 
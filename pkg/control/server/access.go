@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/NHAS/wag/internal/data"
+)
+
+func listAccessRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	requests, err := data.GetAccessRequests(r.URL.Query().Get("username"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if requests == nil {
+		requests = []data.AccessRequest{}
+	}
+
+	b, err := json.Marshal(requests)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// decideAccessRequest approves, denies or revokes the access request in the id form value, as whoever is making the control request.
+// These are audited here rather than with audited, so the audit log records the user the access is for
+func decideAccessRequest(eventType data.AuditEventType, decide func(id, by, reason string) (data.AccessRequest, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.NotFound(w, r)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		by, source := requestActor(r)
+
+		request, err := decide(r.FormValue("id"), by, r.FormValue("reason"))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		log.Println("access request", request.ID, "for", request.Username, "is now", request.Status, "by", by)
+		data.Audit(eventType, by, source, request.Username, fmt.Sprintf("%s: %s for %s, reason: %s", request.ID, strings.Join(request.Routes, ", "), request.Duration, request.DecisionReason))
+
+		b, err := json.Marshal(request)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}
+}
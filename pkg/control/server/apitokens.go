@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control"
+)
+
+func listAPITokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	tokens, err := data.ListAPITokens()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	result, err := json.Marshal(tokens)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func createAPIToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	lifetime, err := time.ParseDuration(r.FormValue("lifetime"))
+	if err != nil {
+		http.Error(w, "lifetime was invalid: "+err.Error(), 400)
+		return
+	}
+
+	var scopes []string
+	for _, scope := range strings.Split(r.FormValue("scopes"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	createdBy := r.Header.Get(control.ActorHeader)
+	if createdBy == "" {
+		createdBy = "wagctl"
+	}
+
+	secret, token, err := data.CreateAPIToken(r.FormValue("name"), scopes, lifetime, createdBy)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	log.Printf("created api token %s (%s) with scopes %s", token.Name, token.ID, strings.Join(token.Scopes, ","))
+
+	result, err := json.Marshal(data.NewAPIToken{Secret: secret, Token: token})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func deleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	err = data.DeleteAPIToken(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/NHAS/wag/internal/data"
+)
+
+func planPolicies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var desired data.PolicySet
+	if err := json.NewDecoder(r.Body).Decode(&desired); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	plan, err := data.PlanPolicies(desired)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	result, err := json.Marshal(plan)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func applyPolicies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var request data.ApplyPoliciesRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	plan, err := data.ApplyPolicies(request.Desired, request.Plan)
+	if err != nil {
+		status := 500
+		if errors.Is(err, data.ErrPolicyPlanChanged) {
+			status = http.StatusConflict
+		}
+
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	log.Printf("applied %d policy and group changes", len(plan.Changes))
+
+	result, err := json.Marshal(plan)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control"
+)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// audited records successful requests to the wrapped handler in the audit log.
+// Anyone that can write to the control socket is already an administrator, so the actor set by wagctl clients (i.e the management ui) is trusted
+func audited(eventType data.AuditEventType, f http.HandlerFunc) http.HandlerFunc {
+	return auditRequest(eventType, f, true)
+}
+
+// auditedSecret records that a request was made without its body, for requests that carry secrets such as identity provider credentials
+func auditedSecret(eventType data.AuditEventType, f http.HandlerFunc) http.HandlerFunc {
+	return auditRequest(eventType, f, false)
+}
+
+func auditRequest(eventType data.AuditEventType, f http.HandlerFunc, withDetails bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var details string
+		if !withDetails {
+			details = "<redacted>"
+		} else if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+			details = string(body)
+		} else if err := r.ParseForm(); err == nil {
+			details = redactForm(r.Form).Encode()
+		}
+
+		if len(details) > 1024 {
+			details = details[:1024] + "..."
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		f(recorder, r)
+
+		if recorder.status != http.StatusOK {
+			return
+		}
+
+		actor, source := requestActor(r)
+		data.Audit(eventType, actor, source, r.URL.Path, details)
+	}
+}
+
+// requestActor returns who made a control request and where from, as set by wagctl clients acting on someone elses behalf
+func requestActor(r *http.Request) (actor, source string) {
+	actor = r.Header.Get(control.ActorHeader)
+	if actor == "" {
+		actor = "wagctl"
+	}
+
+	source = r.Header.Get(control.SourceHeader)
+	if source == "" {
+		source = "control socket"
+	}
+
+	return actor, source
+}
+
+func redactForm(form url.Values) url.Values {
+	redacted := url.Values{}
+	for key, values := range form {
+		if strings.Contains(strings.ToLower(key), "password") {
+			redacted.Set(key, "<redacted>")
+			continue
+		}
+		redacted[key] = values
+	}
+
+	return redacted
+}
+
+func searchAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	filter := data.AuditFilter{
+		Type:  data.AuditEventType(r.FormValue("type")),
+		Actor: r.FormValue("actor"),
+		Text:  r.FormValue("search"),
+	}
+
+	if since := r.FormValue("since"); since != "" {
+		filter.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "since time was invalid: "+err.Error(), 400)
+			return
+		}
+	}
+
+	if until := r.FormValue("until"); until != "" {
+		filter.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			http.Error(w, "until time was invalid: "+err.Error(), 400)
+			return
+		}
+	}
+
+	if limit := r.FormValue("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			http.Error(w, "limit was invalid: "+err.Error(), 400)
+			return
+		}
+	}
+
+	events, err := data.SearchAuditEvents(filter)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	b, err := json.Marshal(events)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
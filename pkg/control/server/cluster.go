@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/NHAS/wag/internal/data"
+)
+
+func clusterMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	members, err := data.GetClusterMembers()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	result, err := json.Marshal(members)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func addClusterMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var newNodeReq data.NewNodeRequest
+	err := json.NewDecoder(r.Body).Decode(&newNodeReq)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	token, err := data.AddMember(newNodeReq.NodeName, newNodeReq.ConnectionURL, newNodeReq.ManagerURL)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	log.Println("added new node: ", newNodeReq.NodeName, newNodeReq.ConnectionURL)
+
+	result, err := json.Marshal(data.NewNodeResponse{JoinToken: token})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func controlClusterMember(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var ncR data.NodeControlRequest
+	err := json.NewDecoder(r.Body).Decode(&ncR)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	switch ncR.Action {
+	case "promote":
+		err = data.PromoteMember(ncR.Node)
+	case "drain", "restore":
+		// Only marks the node as unhealthy so load balancers stop sending clients to it
+		err = data.SetDrained(ncR.Node, ncR.Action == "drain")
+	case "stepdown":
+		err = data.StepDown()
+	case "remove":
+		if data.GetServerID() == ncR.Node {
+			http.Error(w, "cannot remove current node", 400)
+			return
+		}
+
+		err = data.RemoveMember(ncR.Node)
+	default:
+		http.Error(w, "unknown action, expected one of promote, drain, restore, stepdown or remove", 400)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	log.Println(ncR.Action, "cluster member", ncR.Node)
+
+	w.Write([]byte("OK"))
+}
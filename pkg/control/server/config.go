@@ -42,7 +42,7 @@ func newPolicy(w http.ResponseWriter, r *http.Request) {
 
 	}
 
-	if err := data.SetAcl(acl.Effects, acls.Acl{Mfa: acl.MfaRoutes, Allow: acl.PublicRoutes, Deny: acl.DenyRoutes}, false); err != nil {
+	if err := data.SetAcl(acl.Effects, acls.Acl{Mfa: acl.MfaRoutes, Allow: acl.PublicRoutes, Deny: acl.DenyRoutes, StepUp: acl.StepUpRoutes}, false); err != nil {
 		log.Println("Unable to set acls: ", err)
 		http.Error(w, err.Error(), 500)
 		return
@@ -67,7 +67,7 @@ func editPolicy(w http.ResponseWriter, r *http.Request) {
 
 	}
 
-	if err := data.SetAcl(polciyData.Effects, acls.Acl{Mfa: polciyData.MfaRoutes, Allow: polciyData.PublicRoutes, Deny: polciyData.DenyRoutes}, true); err != nil {
+	if err := data.SetAcl(polciyData.Effects, acls.Acl{Mfa: polciyData.MfaRoutes, Allow: polciyData.PublicRoutes, Deny: polciyData.DenyRoutes, StepUp: polciyData.StepUpRoutes}, true); err != nil {
 		log.Println("Unable to set acls: ", err)
 		http.Error(w, err.Error(), 500)
 		return
@@ -6,6 +6,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/NHAS/wag/internal/data"
 	"github.com/NHAS/wag/internal/router"
@@ -192,3 +193,89 @@ func deleteDevice(w http.ResponseWriter, r *http.Request) {
 
 	w.Write([]byte("OK"))
 }
+
+// listKeyRotations shows when every device that is subject to a key rotation policy must next rotate its keys
+func listKeyRotations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	rotations, err := data.GetKeyRotations()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if rotations == nil {
+		rotations = []data.KeyRotationStatus{}
+	}
+
+	b, err := json.Marshal(rotations)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func listKeyRotationPolicies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	policies, err := data.GetKeyRotationPolicies()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	b, err := json.Marshal(policies)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func setKeyRotationPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var policy data.KeyRotationPolicy
+
+	policy.IntervalDays, err = strconv.Atoi(r.FormValue("interval_days"))
+	if err != nil {
+		http.Error(w, "interval_days must be a number", 400)
+		return
+	}
+
+	if grace := r.FormValue("grace_hours"); grace != "" {
+		policy.GraceHours, err = strconv.Atoi(grace)
+		if err != nil {
+			http.Error(w, "grace_hours must be a number", 400)
+			return
+		}
+	}
+
+	err = data.SetKeyRotationPolicy(r.FormValue("group"), policy)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	w.Write([]byte("OK"))
+}
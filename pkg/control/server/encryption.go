@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/NHAS/wag/internal/data"
+)
+
+func encryptionStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	status, err := data.GetEncryptionStatus()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	result, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func rotateEncryptionKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	status, err := data.RotateDataKey()
+	if err != nil {
+		log.Println("unable to rotate data key: ", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	log.Println("rotated data key, now using: ", status.Active)
+
+	result, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/NHAS/wag/internal/config"
+)
+
+// openAPIDocument describes the management api, it is generated from remoteRoutes so it always matches what is served and the scopes that are enforced
+func openAPIDocument() map[string]any {
+	stringProperties := func(names []string) map[string]any {
+		properties := map[string]any{}
+		for _, name := range names {
+			properties[name] = map[string]any{"type": "string"}
+		}
+		return properties
+	}
+
+	paths := map[string]any{}
+	for path, route := range remoteRoutes {
+		access := "read"
+		if route.write {
+			access = "write"
+		}
+
+		required := []string{}
+		for _, scope := range route.scopes {
+			required = append(required, scope+":"+access)
+		}
+
+		description := "Any valid api token may use this route."
+		if len(required) > 0 {
+			description = "Requires the " + strings.Join(required, " and ") + " scopes (or *)."
+		}
+
+		operation := map[string]any{
+			"summary":      route.summary,
+			"description":  description,
+			"x-wag-scopes": required,
+			"operationId":  strings.ReplaceAll(strings.Trim(path, "/"), "/", "_"),
+			"security":     []map[string][]string{{"apiToken": {}}},
+			"responses": map[string]any{
+				"200": map[string]any{"description": "Success, list routes return json"},
+				"400": map[string]any{"description": "Invalid request"},
+				"401": map[string]any{"description": "Missing, invalid or expired api token"},
+				"403": map[string]any{"description": "The api token does not have the required scopes"},
+			},
+		}
+
+		if route.method == "GET" {
+			var parameters []map[string]any
+			for _, param := range route.params {
+				parameters = append(parameters, map[string]any{
+					"name":   param,
+					"in":     "query",
+					"schema": map[string]any{"type": "string"},
+				})
+			}
+
+			if len(parameters) > 0 {
+				operation["parameters"] = parameters
+			}
+		} else if route.body != "" {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{
+						"schema": map[string]any{"description": route.body},
+					},
+				},
+			}
+		} else if len(route.params) > 0 {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/x-www-form-urlencoded": map[string]any{
+						"schema": map[string]any{"type": "object", "properties": stringProperties(route.params)},
+					},
+				},
+			}
+		}
+
+		paths[path] = map[string]any{strings.ToLower(route.method): operation}
+	}
+
+	version := config.Version
+	if version == "" {
+		version = "development"
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Wag management API",
+			"description": "The wag control api served over https. Authenticate with an api token created by `wag apitokens -add`, as `Authorization: Bearer <token>`.",
+			"version":     version,
+		},
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"apiToken": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"paths": paths,
+	}
+}
+
+func openAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	result, err := json.MarshalIndent(openAPIDocument(), "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control"
+)
+
+// adminRoute is what an administrator needs to use a control api route on behalf of the management ui
+type adminRoute struct {
+	permission data.Permission
+	// Checks a group scoped administrator may act on the target of the request, routes without a check cannot be used by scoped administrators
+	scope func(r *http.Request, admin data.AdminModel) error
+}
+
+// adminRoutes is every control api route, anything not listed is refused for administrators
+var adminRoutes = map[string]adminRoute{
+	"/device/list":     {permission: data.PermissionView, scope: unscoped},
+	"/device/sessions": {permission: data.PermissionView, scope: unscoped},
+	"/device/lock":     {permission: data.PermissionLockAccounts, scope: deviceInScope},
+	"/device/unlock":   {permission: data.PermissionLockAccounts, scope: deviceInScope},
+	"/device/delete":   {permission: data.PermissionDeleteAccounts, scope: deviceInScope},
+
+	"/device/rotation/list": {permission: data.PermissionView, scope: unscoped},
+
+	"/users/list":            {permission: data.PermissionView, scope: unscoped},
+	"/users/lock":            {permission: data.PermissionLockAccounts, scope: userInScope},
+	"/users/unlock":          {permission: data.PermissionLockAccounts, scope: userInScope},
+	"/users/delete":          {permission: data.PermissionDeleteAccounts, scope: userInScope},
+	"/users/reset":           {permission: data.PermissionResetMFA, scope: userInScope},
+	"/users/expiry":          {permission: data.PermissionLockAccounts, scope: userInScope},
+	"/users/mfa/keys/list":   {permission: data.PermissionView, scope: unscoped},
+	"/users/mfa/keys/delete": {permission: data.PermissionResetMFA, scope: userInScope},
+	"/users/mfa/recovery":    {permission: data.PermissionResetMFA, scope: userInScope},
+
+	"/webadmin/list":     {permission: data.PermissionView, scope: unscoped},
+	"/webadmin/lock":     {permission: data.PermissionAdministration},
+	"/webadmin/unlock":   {permission: data.PermissionAdministration},
+	"/webadmin/delete":   {permission: data.PermissionAdministration},
+	"/webadmin/reset":    {permission: data.PermissionAdministration},
+	"/webadmin/resetmfa": {permission: data.PermissionAdministration},
+	"/webadmin/add":      {permission: data.PermissionAdministration},
+	"/webadmin/setrole":  {permission: data.PermissionAdministration},
+
+	"/firewall/list": {permission: data.PermissionView, scope: unscoped},
+
+	"/config/policies/list":   {permission: data.PermissionView, scope: unscoped},
+	"/config/policy/edit":     {permission: data.PermissionPolicies, scope: policyInScope},
+	"/config/policy/create":   {permission: data.PermissionPolicies, scope: policyInScope},
+	"/config/policies/delete": {permission: data.PermissionPolicies, scope: policiesInScope},
+	"/config/group/list":      {permission: data.PermissionView, scope: unscoped},
+	"/config/group/edit":      {permission: data.PermissionPolicies, scope: groupInScope},
+	"/config/group/create":    {permission: data.PermissionPolicies, scope: groupInScope},
+	"/config/group/delete":    {permission: data.PermissionPolicies, scope: groupsInScope},
+	"/config/rotation/list":   {permission: data.PermissionView, scope: unscoped},
+	"/config/rotation/set":    {permission: data.PermissionPolicies, scope: rotationGroupInScope},
+	"/config/apply/plan":      {permission: data.PermissionPolicies},
+	"/config/apply":           {permission: data.PermissionPolicies},
+
+	"/encryption/status": {permission: data.PermissionView, scope: unscoped},
+	"/encryption/rotate": {permission: data.PermissionAdministration},
+
+	"/version":     {permission: data.PermissionView, scope: unscoped},
+	"/version/bpf": {permission: data.PermissionView, scope: unscoped},
+
+	"/shutdown": {permission: data.PermissionAdministration},
+
+	"/registration/list":   {permission: data.PermissionView, scope: unscoped},
+	"/registration/create": {permission: data.PermissionRegistrations, scope: registrationInScope},
+	"/registration/delete": {permission: data.PermissionRegistrations, scope: existingRegistrationInScope},
+
+	"/access/list":    {permission: data.PermissionView, scope: unscoped},
+	"/access/approve": {permission: data.PermissionAccessRequests, scope: accessRequestInScope},
+	"/access/deny":    {permission: data.PermissionAccessRequests, scope: accessRequestInScope},
+	"/access/revoke":  {permission: data.PermissionAccessRequests, scope: accessRequestInScope},
+
+	"/audit/list": {permission: data.PermissionView, scope: unscoped},
+
+	"/backup": {permission: data.PermissionAdministration},
+
+	"/apitokens/list":   {permission: data.PermissionAdministration},
+	"/apitokens/create": {permission: data.PermissionAdministration},
+	"/apitokens/delete": {permission: data.PermissionAdministration},
+
+	// Settings include identity provider secrets, so reading them needs the settings permission
+	"/settings/list":    {permission: data.PermissionSettings},
+	"/settings/general": {permission: data.PermissionSettings},
+	"/settings/login":   {permission: data.PermissionSettings},
+	"/settings/set":     {permission: data.PermissionSettings},
+
+	"/cluster/members": {permission: data.PermissionView, scope: unscoped},
+	"/cluster/add":     {permission: data.PermissionCluster},
+	"/cluster/control": {permission: data.PermissionCluster},
+
+	"/openapi.json": {permission: data.PermissionView, scope: unscoped},
+}
+
+// rbac limits requests made on behalf of a management ui administrator to what their role allows.
+// Requests without the admin header come from root on the server, or the management api which removes it, and are not limited
+func rbac(routes http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := r.Header.Get(control.AdminHeader)
+		if username == "" {
+			routes.ServeHTTP(w, r)
+			return
+		}
+
+		admin, err := data.GetAdminUser(username)
+		if err != nil {
+			http.Error(w, "unknown administrator", http.StatusForbidden)
+			return
+		}
+
+		if err := checkAdminRoute(r, admin); err != nil {
+			log.Printf("administrator %s (%s) was refused %s: %s", admin.Username, admin.EffectiveRole(), r.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		routes.ServeHTTP(w, r)
+	})
+}
+
+func checkAdminRoute(r *http.Request, admin data.AdminModel) error {
+	route, ok := adminRoutes[r.URL.Path]
+	if !ok || !admin.Can(route.permission) {
+		return fmt.Errorf("%w: the %s role cannot use %s", data.ErrPermissionDenied, admin.EffectiveRole(), r.URL.Path)
+	}
+
+	if !admin.Scoped() {
+		return nil
+	}
+
+	if route.scope == nil {
+		return fmt.Errorf("%w: administrators limited to groups cannot use %s", data.ErrPermissionDenied, r.URL.Path)
+	}
+
+	return route.scope(r, admin)
+}
+
+func unscoped(r *http.Request, admin data.AdminModel) error {
+	return nil
+}
+
+func userInScope(r *http.Request, admin data.AdminModel) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	return checkUser(admin, r.FormValue("username"))
+}
+
+func checkUser(admin data.AdminModel, username string) error {
+	ok, err := admin.CanActOnUser(username)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: %q is not in your groups", data.ErrPermissionDenied, username)
+	}
+
+	return nil
+}
+
+func deviceInScope(r *http.Request, admin data.AdminModel) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	device, err := data.GetDeviceByAddress(r.FormValue("address"))
+	if err != nil {
+		return err
+	}
+
+	return checkUser(admin, device.Username)
+}
+
+func checkGroups(admin data.AdminModel, groups []string) error {
+	if len(groups) == 0 {
+		return fmt.Errorf("%w: administrators limited to groups must specify one of their groups", data.ErrPermissionDenied)
+	}
+
+	for _, group := range groups {
+		if !admin.InScope(group) {
+			return fmt.Errorf("%w: %q is not one of your groups", data.ErrPermissionDenied, group)
+		}
+	}
+
+	return nil
+}
+
+func registrationInScope(r *http.Request, admin data.AdminModel) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var groups []string
+	if err := json.Unmarshal([]byte(r.FormValue("groups")), &groups); err != nil {
+		return fmt.Errorf("%w: administrators limited to groups must specify one of their groups", data.ErrPermissionDenied)
+	}
+
+	if r.FormValue("overwrite") != "" {
+		if err := checkUser(admin, r.FormValue("username")); err != nil {
+			return err
+		}
+	}
+
+	return checkGroups(admin, groups)
+}
+
+func existingRegistrationInScope(r *http.Request, admin data.AdminModel) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	_, _, groups, _, err := data.GetRegistrationToken(r.FormValue("id"))
+	if err != nil {
+		return err
+	}
+
+	return checkGroups(admin, groups)
+}
+
+func accessRequestInScope(r *http.Request, admin data.AdminModel) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	request, err := data.GetAccessRequest(r.FormValue("id"))
+	if err != nil {
+		return err
+	}
+
+	return checkUser(admin, request.Username)
+}
+
+// decodeBody reads a json request body without consuming it, so the handler can decode it again
+func decodeBody(r *http.Request, v any) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	return json.Unmarshal(body, v)
+}
+
+// checkEffects allows policies that apply to one of the administrators groups, or to a single user in them
+func checkEffects(admin data.AdminModel, effects string) error {
+	if strings.HasPrefix(effects, "group:") {
+		return checkGroups(admin, []string{effects})
+	}
+
+	if effects == "*" || effects == "" {
+		return fmt.Errorf("%w: administrators limited to groups cannot change policies for everyone", data.ErrPermissionDenied)
+	}
+
+	return checkUser(admin, effects)
+}
+
+func policyInScope(r *http.Request, admin data.AdminModel) error {
+	var policy control.PolicyData
+	if err := decodeBody(r, &policy); err != nil {
+		return err
+	}
+
+	return checkEffects(admin, policy.Effects)
+}
+
+func policiesInScope(r *http.Request, admin data.AdminModel) error {
+	var effects []string
+	if err := decodeBody(r, &effects); err != nil {
+		return err
+	}
+
+	for _, e := range effects {
+		if err := checkEffects(admin, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// groupInScope allows creating or editing one of the administrators groups, as long as every member is already a user they can act on
+func groupInScope(r *http.Request, admin data.AdminModel) error {
+	var group control.GroupData
+	if err := decodeBody(r, &group); err != nil {
+		return err
+	}
+
+	if err := checkGroups(admin, []string{group.Group}); err != nil {
+		return err
+	}
+
+	for _, member := range group.Members {
+		if err := checkUser(admin, member); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func rotationGroupInScope(r *http.Request, admin data.AdminModel) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	return checkGroups(admin, []string{r.FormValue("group")})
+}
+
+func groupsInScope(r *http.Request, admin data.AdminModel) error {
+	var groups []string
+	if err := decodeBody(r, &groups); err != nil {
+		return err
+	}
+
+	return checkGroups(admin, groups)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestMain(m *testing.M) {
+	if err := config.Load("../../../internal/config/testing_config2.json"); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	k, err := wgtypes.GenerateKey()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	err = data.Load(fmt.Sprintf("file:%s?mode=memory&cache=shared", k.String()), "", true)
+	if err != nil {
+		log.Println("cannot load database: ", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	data.TearDown()
+
+	os.Exit(code)
+}
+
+func TestGroupMembersInScope(t *testing.T) {
+	for _, user := range []string{"scoped_member", "outside_member"} {
+		if _, err := data.CreateUserDataAccount(user); err != nil {
+			t.Fatal(err)
+		}
+		defer data.DeleteUser(user)
+	}
+
+	if err := data.SetGroup("group:rbac_scope", []string{"scoped_member"}, false); err != nil {
+		t.Fatal(err)
+	}
+	defer data.RemoveGroup("group:rbac_scope")
+
+	if err := data.SetGroup("group:rbac_other", []string{"outside_member"}, false); err != nil {
+		t.Fatal(err)
+	}
+	defer data.RemoveGroup("group:rbac_other")
+
+	admin := data.AdminModel{Username: "rbac_admin", Role: data.RolePolicyEditor, Groups: []string{"group:rbac_scope", "group:rbac_new"}}
+
+	for _, test := range []struct {
+		path    string
+		group   control.GroupData
+		allowed bool
+	}{
+		{"/config/group/edit", control.GroupData{Group: "group:rbac_scope", Members: []string{"scoped_member"}}, true},
+		{"/config/group/create", control.GroupData{Group: "group:rbac_new", Members: []string{"scoped_member"}}, true},
+		{"/config/group/edit", control.GroupData{Group: "group:rbac_other", Members: []string{"outside_member"}}, false},
+		// Pulling users from outside the administrators groups into one of theirs would bring them into scope
+		{"/config/group/edit", control.GroupData{Group: "group:rbac_scope", Members: []string{"scoped_member", "outside_member"}}, false},
+		{"/config/group/create", control.GroupData{Group: "group:rbac_new", Members: []string{"outside_member"}}, false},
+		{"/config/group/create", control.GroupData{Group: "group:rbac_new", Members: []string{"not_a_user"}}, false},
+	} {
+		body, _ := json.Marshal(test.group)
+		r := httptest.NewRequest("POST", test.path, bytes.NewReader(body))
+
+		err := checkAdminRoute(r, admin)
+		if test.allowed && err != nil {
+			t.Fatalf("%s %v should be allowed: %s", test.path, test.group, err)
+		}
+
+		if !test.allowed && !errors.Is(err, data.ErrPermissionDenied) {
+			t.Fatalf("%s %v should be denied: %v", test.path, test.group, err)
+		}
+
+		var decoded control.GroupData
+		if err := json.NewDecoder(r.Body).Decode(&decoded); err != nil || decoded.Group != test.group.Group {
+			t.Fatal("scope check should leave the body for the handler: ", err)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/NHAS/wag/internal/data"
 	"github.com/NHAS/wag/pkg/control"
@@ -83,7 +84,16 @@ func newRegistration(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := control.RegistrationResult{Token: token, Username: username, Groups: groups, NumUses: uses}
+	var accountExpires time.Time
+	if expires := r.FormValue("account_expires"); expires != "" {
+		accountExpires, err = time.Parse(time.RFC3339, expires)
+		if err != nil {
+			http.Error(w, "invalid account expiry for registration token: "+err.Error(), 400)
+			return
+		}
+	}
+
+	resp := control.RegistrationResult{Token: token, Username: username, Groups: groups, NumUses: uses, AccountExpires: accountExpires}
 
 	tokenType := "registration"
 	if overwrite != "" {
@@ -91,7 +101,7 @@ func newRegistration(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if token != "" {
-		err := data.AddRegistrationToken(token, username, overwrite, groups, uses)
+		err := data.AddRegistrationToken(token, username, overwrite, groups, uses, accountExpires)
 		if err != nil {
 			http.Error(w, err.Error(), 500)
 			return
@@ -109,7 +119,7 @@ func newRegistration(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err = data.GenerateToken(username, overwrite, groups, uses)
+	token, err = data.GenerateToken(username, overwrite, groups, uses, accountExpires)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/certificates"
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/proxyprotocol"
+	"github.com/NHAS/wag/pkg/control"
+)
+
+// remoteRoute describes a control api route that is also served by the management api
+type remoteRoute struct {
+	method string
+	// Scopes the api token must have, e.g "users", none means any valid token may use the route
+	scopes []string
+	write  bool
+
+	summary string
+	// Query parameters for GET, or form values for POST
+	params []string
+	// Description of the json request body, if the route takes one
+	body string
+}
+
+// remoteRoutes are the only control api routes reachable over the management api, everything else (shutdown, backups, encryption, admin users and
+// api tokens themselves) is only available on the control socket
+var remoteRoutes = map[string]remoteRoute{
+	"/device/list":     {method: "GET", scopes: []string{"devices"}, summary: "List devices, optionally only those of one user", params: []string{"username"}},
+	"/device/sessions": {method: "GET", scopes: []string{"devices"}, summary: "List the addresses of devices with an active session"},
+	"/device/lock":     {method: "POST", scopes: []string{"devices"}, write: true, summary: "Lock a device", params: []string{"address"}},
+	"/device/unlock":   {method: "POST", scopes: []string{"devices"}, write: true, summary: "Unlock a device", params: []string{"address"}},
+	"/device/delete":   {method: "POST", scopes: []string{"devices"}, write: true, summary: "Delete a device", params: []string{"address"}},
+
+	"/device/rotation/list": {method: "GET", scopes: []string{"devices"}, summary: "List when devices subject to a key rotation policy must next rotate their keys"},
+
+	"/users/list":            {method: "GET", scopes: []string{"users"}, summary: "List users, optionally only one", params: []string{"username"}},
+	"/users/lock":            {method: "POST", scopes: []string{"users"}, write: true, summary: "Lock a user", params: []string{"username"}},
+	"/users/unlock":          {method: "POST", scopes: []string{"users"}, write: true, summary: "Unlock a user", params: []string{"username"}},
+	"/users/delete":          {method: "POST", scopes: []string{"users"}, write: true, summary: "Delete a user and their devices", params: []string{"username"}},
+	"/users/reset":           {method: "POST", scopes: []string{"users"}, write: true, summary: "Reset a users mfa, so it is set up again on next login", params: []string{"username"}},
+	"/users/expiry":          {method: "POST", scopes: []string{"users"}, write: true, summary: "Set when a users account is locked (RFC3339), empty removes the expiry", params: []string{"username", "expires"}},
+	"/users/mfa/keys/list":   {method: "GET", scopes: []string{"users"}, summary: "List a users webauthn credentials", params: []string{"username"}},
+	"/users/mfa/keys/delete": {method: "POST", scopes: []string{"users"}, write: true, summary: "Remove a webauthn credential from a user", params: []string{"username", "id"}},
+	"/users/mfa/recovery":    {method: "POST", scopes: []string{"users"}, write: true, summary: "Generate new recovery codes for a user", params: []string{"username"}},
+
+	"/firewall/list": {method: "GET", scopes: []string{"firewall"}, summary: "Show the firewall state of every user and device"},
+
+	"/config/policies/list":   {method: "GET", scopes: []string{"policies"}, summary: "List policies"},
+	"/config/policy/create":   {method: "POST", scopes: []string{"policies"}, write: true, summary: "Create a policy", body: "Policy, with effects and public_routes, mfa_routes, deny_routes and stepup_routes"},
+	"/config/policy/edit":     {method: "POST", scopes: []string{"policies"}, write: true, summary: "Replace a policy", body: "Policy, with effects and public_routes, mfa_routes, deny_routes and stepup_routes"},
+	"/config/policies/delete": {method: "POST", scopes: []string{"policies"}, write: true, summary: "Delete policies", body: "Array of policy effects to delete"},
+	"/config/group/list":      {method: "GET", scopes: []string{"groups"}, summary: "List groups and their members"},
+	"/config/group/create":    {method: "POST", scopes: []string{"groups"}, write: true, summary: "Create a group", body: "Group, with group and members"},
+	"/config/group/edit":      {method: "POST", scopes: []string{"groups"}, write: true, summary: "Replace the members of a group", body: "Group, with group and members"},
+	"/config/group/delete":    {method: "POST", scopes: []string{"groups"}, write: true, summary: "Delete groups", body: "Array of group names to delete"},
+	"/config/rotation/list":   {method: "GET", scopes: []string{"groups"}, summary: "List the key rotation policy of each group"},
+	"/config/rotation/set":    {method: "POST", scopes: []string{"groups"}, write: true, summary: "Set how often the devices of a group (or * for every user) rotate their keys, an interval of 0 removes the policy", params: []string{"group", "interval_days", "grace_hours"}},
+	"/config/apply/plan":      {method: "POST", scopes: []string{"policies", "groups"}, summary: "Show the changes needed to make policies and groups match the desired state", body: "Desired policy set, with Groups and Policies"},
+	"/config/apply":           {method: "POST", scopes: []string{"policies", "groups"}, write: true, summary: "Apply a previously planned change to policies and groups atomically", body: "Desired policy set and the approved plan, fails with 409 if the plan is stale"},
+
+	"/registration/list":   {method: "GET", scopes: []string{"registrations"}, summary: "List registration tokens"},
+	"/registration/create": {method: "POST", scopes: []string{"registrations"}, write: true, summary: "Create a registration token", params: []string{"username", "token", "overwrite", "groups", "uses", "account_expires"}},
+	"/registration/delete": {method: "POST", scopes: []string{"registrations"}, write: true, summary: "Delete a registration token", params: []string{"id"}},
+
+	"/settings/list":    {method: "GET", scopes: []string{"settings"}, write: true, summary: "Show general and login settings, needs write access as they include identity provider secrets"},
+	"/settings/general": {method: "POST", scopes: []string{"settings"}, write: true, summary: "Replace the general settings", body: "GeneralSettings, with HelpMail, ExternalAddress, DNS, WireguardConfigFilename and CheckUpdates"},
+	"/settings/login":   {method: "POST", scopes: []string{"settings"}, write: true, summary: "Replace the login settings", body: "LoginSettings, with session lifetimes, Lockout, mfa methods, Domain, Issuer and identity provider details"},
+	"/settings/set":     {method: "POST", scopes: []string{"settings"}, write: true, summary: "Change a single setting, e.g lockout or dns, lists are ',' delimited", params: []string{"name", "value"}},
+
+	"/cluster/members": {method: "GET", scopes: []string{"cluster"}, summary: "List cluster members and their health"},
+	"/cluster/add":     {method: "POST", scopes: []string{"cluster"}, write: true, summary: "Add a cluster member, returning the token the new node joins with", body: "NewNodeRequest, with NodeName, ConnectionURL and ManagerURL"},
+	"/cluster/control": {method: "POST", scopes: []string{"cluster"}, write: true, summary: "Promote, drain, restore, step down or remove a cluster member", body: "NodeControlRequest, with Node (hex id) and Action"},
+
+	"/access/list":    {method: "GET", scopes: []string{"access"}, summary: "List just in time access requests, optionally only those of one user", params: []string{"username"}},
+	"/access/approve": {method: "POST", scopes: []string{"access"}, write: true, summary: "Approve a pending access request, granting its routes until it expires", params: []string{"id", "reason"}},
+	"/access/deny":    {method: "POST", scopes: []string{"access"}, write: true, summary: "Deny a pending access request", params: []string{"id", "reason"}},
+	"/access/revoke":  {method: "POST", scopes: []string{"access"}, write: true, summary: "Remove an approved access request before it expires", params: []string{"id", "reason"}},
+
+	"/audit/list": {method: "GET", scopes: []string{"audit"}, summary: "Search the audit log", params: []string{"type", "actor", "search", "since", "until", "limit"}},
+
+	"/version":     {method: "GET", summary: "Wag version"},
+	"/version/bpf": {method: "GET", summary: "Hash of the loaded eBPF program"},
+}
+
+var managementAPI *http.Server
+
+// maxRemoteBody bounds request bodies from the network, the largest legitimate body is a full policy set for wag apply
+const maxRemoteBody = 8 << 20
+
+// authenticated serves routes from the control api that are allowed remotely, to requests with an api token that has the required scopes.
+// Changes are recorded in the audit log as made by the api token
+func authenticated(routes http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/openapi.json" {
+			openAPI(w, r)
+			return
+		}
+
+		route, ok := remoteRoutes[r.URL.Path]
+		if !ok || r.Method != route.method {
+			http.NotFound(w, r)
+			return
+		}
+
+		source, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			source = r.RemoteAddr
+		}
+
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wag"`)
+			http.Error(w, "api token required", http.StatusUnauthorized)
+			return
+		}
+
+		token, err := data.ValidateAPIToken(secret)
+		if err != nil {
+			if !errors.Is(err, data.ErrInvalidAPIToken) {
+				log.Println("management api unable to check api token: ", err)
+			}
+
+			log.Println("management api rejected api token from: ", source)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wag", error="invalid_token"`)
+			http.Error(w, data.ErrInvalidAPIToken.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		for _, scope := range route.scopes {
+			if !token.Allows(scope, route.write) {
+				access := "read"
+				if route.write {
+					access = "write"
+				}
+
+				http.Error(w, fmt.Sprintf("api token does not have the %s:%s scope", scope, access), http.StatusForbidden)
+				return
+			}
+		}
+
+		// Replace anything the client sent, the token is who made the change
+		r.Header.Set(control.ActorHeader, "api token "+token.Name+" ("+token.ID+")")
+		r.Header.Set(control.SourceHeader, source)
+		r.Header.Del(control.AdminHeader)
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxRemoteBody)
+
+		routes.ServeHTTP(w, r)
+	})
+}
+
+// StartManagementAPI serves the control api over https to holders of api tokens, if enabled
+func StartManagementAPI(errs chan<- error) error {
+	if !config.Values.ManagementAPI.Enabled {
+		return nil
+	}
+
+	tlsConfig, err := certificates.TLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}, config.Values.ManagementAPI.ACMEDomain, config.Values.ManagementAPI.CertPath, config.Values.ManagementAPI.KeyPath)
+	if err != nil {
+		return fmt.Errorf("unable to load certificate for management api: %w", err)
+	}
+
+	managementAPI = &http.Server{
+		Addr:         config.Values.ManagementAPI.ListenAddress,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 60 * time.Second,
+		IdleTimeout:  120 * time.Second,
+		TLSConfig:    tlsConfig,
+		Handler:      authenticated(controlRoutes()),
+		ConnContext:  proxyprotocol.ConnContext(config.Values.ManagementAPI.ProxyProtocol),
+	}
+
+	go func(srv *http.Server) {
+		listener, err := proxyprotocol.Listen(srv.Addr, config.Values.ManagementAPI.ProxyProtocol)
+		if err != nil {
+			errs <- fmt.Errorf("management api listener failed: %v", err)
+			return
+		}
+
+		if err := srv.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
+			errs <- fmt.Errorf("management api listener failed: %v", err)
+		}
+	}(managementAPI)
+
+	log.Println("Started management api:\n\t\t\tListening:", config.Values.ManagementAPI.ListenAddress)
+
+	return nil
+}
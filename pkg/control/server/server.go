@@ -1,13 +1,16 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
 	"github.com/NHAS/wag/internal/router"
 )
 
@@ -100,56 +103,101 @@ func StartControlSocket() error {
 
 	log.Println("Started control socket: \n\t\t\t", config.Values.Socket)
 
+	go func() {
+		srv := &http.Server{
+			Handler: controlRoutes(),
+		}
+
+		srv.Serve(l)
+	}()
+	return nil
+}
+
+// controlRoutes are served on the control socket, and the subset in remoteRoutes by the management api
+func controlRoutes() http.Handler {
 	controlMux := http.NewServeMux()
 
 	controlMux.HandleFunc("/device/list", listDevices)
-	controlMux.HandleFunc("/device/lock", lockDevice)
-	controlMux.HandleFunc("/device/unlock", unlockDevice)
+	controlMux.HandleFunc("/device/lock", audited(data.AuditAdminAction, lockDevice))
+	controlMux.HandleFunc("/device/unlock", audited(data.AuditAdminAction, unlockDevice))
 	controlMux.HandleFunc("/device/sessions", sessions)
-	controlMux.HandleFunc("/device/delete", deleteDevice)
+	controlMux.HandleFunc("/device/delete", audited(data.AuditAdminAction, deleteDevice))
+	controlMux.HandleFunc("/device/rotation/list", listKeyRotations)
 
 	controlMux.HandleFunc("/users/list", listUsers)
-	controlMux.HandleFunc("/users/lock", lockUser)
-	controlMux.HandleFunc("/users/unlock", unlockUser)
-	controlMux.HandleFunc("/users/delete", deleteUser)
-	controlMux.HandleFunc("/users/reset", resetMfaUser)
+	controlMux.HandleFunc("/users/lock", audited(data.AuditAdminAction, lockUser))
+	controlMux.HandleFunc("/users/unlock", audited(data.AuditAdminAction, unlockUser))
+	controlMux.HandleFunc("/users/delete", audited(data.AuditAdminAction, deleteUser))
+	controlMux.HandleFunc("/users/reset", audited(data.AuditAdminAction, resetMfaUser))
+	controlMux.HandleFunc("/users/expiry", audited(data.AuditAdminAction, setUserExpiry))
+	controlMux.HandleFunc("/users/mfa/keys/list", listUserKeys)
+	controlMux.HandleFunc("/users/mfa/keys/delete", audited(data.AuditAdminAction, deleteUserKey))
+	controlMux.HandleFunc("/users/mfa/recovery", audited(data.AuditAdminAction, generateRecoveryCodes))
 
 	controlMux.HandleFunc("/webadmin/list", listAdminUsers)
-	controlMux.HandleFunc("/webadmin/lock", lockAdminUser)
-	controlMux.HandleFunc("/webadmin/unlock", unlockAdminUser)
-	controlMux.HandleFunc("/webadmin/delete", deleteAdminUser)
-	controlMux.HandleFunc("/webadmin/reset", resetAdminUser)
-	controlMux.HandleFunc("/webadmin/add", addAdminUser)
+	controlMux.HandleFunc("/webadmin/lock", audited(data.AuditAdminAction, lockAdminUser))
+	controlMux.HandleFunc("/webadmin/unlock", audited(data.AuditAdminAction, unlockAdminUser))
+	controlMux.HandleFunc("/webadmin/delete", audited(data.AuditAdminAction, deleteAdminUser))
+	controlMux.HandleFunc("/webadmin/reset", audited(data.AuditAdminAction, resetAdminUser))
+	controlMux.HandleFunc("/webadmin/resetmfa", audited(data.AuditAdminAction, resetAdminUserMfa))
+	controlMux.HandleFunc("/webadmin/add", audited(data.AuditAdminAction, addAdminUser))
+	controlMux.HandleFunc("/webadmin/setrole", audited(data.AuditAdminAction, setAdminRole))
 
 	controlMux.HandleFunc("/firewall/list", firewallRules)
 
 	controlMux.HandleFunc("/config/policies/list", policies)
-	controlMux.HandleFunc("/config/policy/edit", editPolicy)
-	controlMux.HandleFunc("/config/policy/create", newPolicy)
-	controlMux.HandleFunc("/config/policies/delete", deletePolicies)
+	controlMux.HandleFunc("/config/policy/edit", audited(data.AuditPolicyChange, editPolicy))
+	controlMux.HandleFunc("/config/policy/create", audited(data.AuditPolicyChange, newPolicy))
+	controlMux.HandleFunc("/config/policies/delete", audited(data.AuditPolicyChange, deletePolicies))
 
 	controlMux.HandleFunc("/config/group/list", groups)
-	controlMux.HandleFunc("/config/group/edit", editGroup)
-	controlMux.HandleFunc("/config/group/create", newGroup)
-	controlMux.HandleFunc("/config/group/delete", deleteGroup)
+	controlMux.HandleFunc("/config/group/edit", audited(data.AuditPolicyChange, editGroup))
+	controlMux.HandleFunc("/config/group/create", audited(data.AuditPolicyChange, newGroup))
+	controlMux.HandleFunc("/config/group/delete", audited(data.AuditPolicyChange, deleteGroup))
+
+	controlMux.HandleFunc("/config/rotation/list", listKeyRotationPolicies)
+	controlMux.HandleFunc("/config/rotation/set", audited(data.AuditPolicyChange, setKeyRotationPolicy))
+
+	controlMux.HandleFunc("/config/apply/plan", planPolicies)
+	controlMux.HandleFunc("/config/apply", audited(data.AuditPolicyChange, applyPolicies))
+
+	controlMux.HandleFunc("/encryption/status", encryptionStatus)
+	controlMux.HandleFunc("/encryption/rotate", audited(data.AuditAdminAction, rotateEncryptionKey))
 
 	controlMux.HandleFunc("/version", version)
 	controlMux.HandleFunc("/version/bpf", bpfVersion)
 
-	controlMux.HandleFunc("/shutdown", shutdown)
+	controlMux.HandleFunc("/shutdown", audited(data.AuditAdminAction, shutdown))
 
 	controlMux.HandleFunc("/registration/list", listRegistrations)
-	controlMux.HandleFunc("/registration/create", newRegistration)
-	controlMux.HandleFunc("/registration/delete", deleteRegistration)
+	controlMux.HandleFunc("/registration/create", audited(data.AuditAdminAction, newRegistration))
+	controlMux.HandleFunc("/registration/delete", audited(data.AuditAdminAction, deleteRegistration))
 
-	go func() {
-		srv := &http.Server{
-			Handler: controlMux,
-		}
+	controlMux.HandleFunc("/access/list", listAccessRequests)
+	controlMux.HandleFunc("/access/approve", decideAccessRequest(data.AuditAccessApproved, data.ApproveAccessRequest))
+	controlMux.HandleFunc("/access/deny", decideAccessRequest(data.AuditAccessDenied, data.DenyAccessRequest))
+	controlMux.HandleFunc("/access/revoke", decideAccessRequest(data.AuditAccessRevoked, data.RevokeAccessRequest))
 
-		srv.Serve(l)
-	}()
-	return nil
+	controlMux.HandleFunc("/audit/list", searchAudit)
+
+	controlMux.HandleFunc("/backup", audited(data.AuditAdminAction, backup))
+
+	controlMux.HandleFunc("/settings/list", getSettings)
+	controlMux.HandleFunc("/settings/general", audited(data.AuditSettingsChange, setGeneralSettings))
+	controlMux.HandleFunc("/settings/login", auditedSecret(data.AuditSettingsChange, setLoginSettings))
+	controlMux.HandleFunc("/settings/set", audited(data.AuditSettingsChange, updateSetting))
+
+	controlMux.HandleFunc("/cluster/members", clusterMembers)
+	controlMux.HandleFunc("/cluster/add", audited(data.AuditAdminAction, addClusterMember))
+	controlMux.HandleFunc("/cluster/control", audited(data.AuditAdminAction, controlClusterMember))
+
+	controlMux.HandleFunc("/apitokens/list", listAPITokens)
+	controlMux.HandleFunc("/apitokens/create", audited(data.AuditAdminAction, createAPIToken))
+	controlMux.HandleFunc("/apitokens/delete", audited(data.AuditAdminAction, deleteAPIToken))
+
+	controlMux.HandleFunc("/openapi.json", openAPI)
+
+	return rbac(controlMux)
 }
 
 func TearDown() {
@@ -157,4 +205,12 @@ func TearDown() {
 	if err != nil {
 		log.Println(err)
 	}
+
+	if managementAPI != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		managementAPI.Shutdown(ctx)
+		managementAPI = nil
+	}
 }
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/NHAS/wag/internal/data"
+)
+
+func getSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	settings, err := data.GetAllSettings()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	result, err := json.Marshal(settings)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func setGeneralSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var general data.GeneralSettings
+	err := json.NewDecoder(r.Body).Decode(&general)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	err = data.SetGeneralSettings(general)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	log.Println("general settings changed")
+
+	w.Write([]byte("OK"))
+}
+
+func setLoginSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var login data.LoginSettings
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	err = data.SetLoginSettings(login)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	log.Println("login settings changed")
+
+	w.Write([]byte("OK"))
+}
+
+func updateSetting(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	err = data.UpdateSetting(r.FormValue("name"), r.FormValue("value"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	log.Printf("setting %s changed to %q", r.FormValue("name"), r.FormValue("value"))
+
+	w.Write([]byte("OK"))
+}
@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/NHAS/wag/internal/data"
 	"github.com/NHAS/wag/internal/users"
+	"github.com/NHAS/wag/internal/webserver/authenticators"
 )
 
 func listUsers(w http.ResponseWriter, r *http.Request) {
@@ -152,6 +155,41 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// setUserExpiry sets when a users account is locked, an empty expires removes the expiry
+func setUserExpiry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	username := r.FormValue("username")
+
+	var expires time.Time
+	if value := r.FormValue("expires"); value != "" {
+		expires, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "invalid expiry: "+err.Error(), 400)
+			return
+		}
+	}
+
+	err = data.SetUserExpiry(username, expires)
+	if err != nil {
+		http.Error(w, "not found: "+err.Error(), 404)
+		return
+	}
+
+	log.Println(username, "expiry set to", r.FormValue("expires"))
+
+	w.Write([]byte("OK"))
+}
+
 func listAdminUsers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.NotFound(w, r)
@@ -299,6 +337,31 @@ func resetAdminUser(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+func resetAdminUserMfa(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	username := r.FormValue("username")
+
+	err = data.ResetAdminMfa(username)
+	if err != nil {
+		http.Error(w, "unable to reset admin user mfa: "+err.Error(), 404)
+		return
+	}
+
+	log.Println(username, "admin mfa reset")
+
+	w.Write([]byte("OK"))
+}
+
 func addAdminUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.NotFound(w, r)
@@ -315,7 +378,12 @@ func addAdminUser(w http.ResponseWriter, r *http.Request) {
 	password := r.FormValue("password")
 	shouldChange := r.FormValue("change") == "true"
 
-	err = data.CreateAdminUser(username, password, shouldChange)
+	role := data.RoleSuperAdmin
+	if r.FormValue("role") != "" {
+		role = data.AdminRole(r.FormValue("role"))
+	}
+
+	err = data.CreateAdminUserWithRole(username, password, shouldChange, role, splitGroups(r.FormValue("groups")))
 	if err != nil {
 		http.Error(w, "unable to create admin user: "+err.Error(), 404)
 		return
@@ -326,6 +394,43 @@ func addAdminUser(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+func setAdminRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	username := r.FormValue("username")
+	role := data.AdminRole(r.FormValue("role"))
+
+	err = data.SetAdminRole(username, role, splitGroups(r.FormValue("groups")))
+	if err != nil {
+		http.Error(w, "unable to set admin role: "+err.Error(), 400)
+		return
+	}
+
+	log.Println(username, "admin role set to", role)
+
+	w.Write([]byte("OK"))
+}
+
+// splitGroups parses a ',' delimited list of groups
+func splitGroups(groups string) (result []string) {
+	for _, group := range strings.Split(groups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			result = append(result, group)
+		}
+	}
+
+	return result
+}
+
 func resetMfaUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.NotFound(w, r)
@@ -356,3 +461,94 @@ func resetMfaUser(w http.ResponseWriter, r *http.Request) {
 
 	w.Write([]byte("OK"))
 }
+
+func listUserKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	keys, err := authenticators.ListWebauthnCredentials(r.FormValue("username"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	b, err := json.Marshal(keys)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func deleteUserKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	username := r.FormValue("username")
+
+	err = authenticators.RemoveWebauthnCredential(username, r.FormValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	log.Println(username, "security key has been removed")
+
+	w.Write([]byte("OK"))
+}
+
+func generateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	username := r.FormValue("username")
+
+	user, err := users.GetUser(username)
+	if err != nil {
+		http.Error(w, "not found: "+err.Error(), 404)
+		return
+	}
+
+	codes, err := user.GenerateRecoveryCodes()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	log.Println(username, "recovery codes have been regenerated")
+
+	b, err := json.Marshal(codes)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -1,11 +1,16 @@
 package control
 
+import "time"
+
 type RegistrationResult struct {
 	Token      string
 	Username   string
 	Groups     []string
 	Overwrites string
 	NumUses    int
+
+	// Set as the expiry of the account that registers with this token, zero never expires
+	AccountExpires time.Time `json:",omitempty"`
 }
 
 type PolicyData struct {
@@ -13,6 +18,21 @@ type PolicyData struct {
 	PublicRoutes []string `json:"public_routes"`
 	MfaRoutes    []string `json:"mfa_routes"`
 	DenyRoutes   []string `json:"deny_routes"`
+	StepUpRoutes []string `json:"stepup_routes"`
+}
+
+const (
+	// Set by control clients acting on behalf of someone else, e.g the management ui, so the audit log shows who made a change
+	ActorHeader  = "WAG-Actor"
+	SourceHeader = "WAG-Source"
+	// Set by the management ui to the administrator making the request, the control api then only allows what their role permits
+	AdminHeader = "WAG-Admin"
+)
+
+type WebauthnCredential struct {
+	ID    string    `json:"id"`
+	Name  string    `json:"name"`
+	Added time.Time `json:"added"`
 }
 
 type GroupData struct {
@@ -3,6 +3,7 @@ package wagctl
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,7 +11,9 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/NHAS/wag/internal/data"
 	"github.com/NHAS/wag/internal/router"
@@ -34,6 +37,93 @@ func NewControlClient(socketPath string) *CtrlClient {
 	}
 }
 
+type remoteTransport struct {
+	next  http.RoundTripper
+	base  *url.URL
+	token string
+}
+
+func (t *remoteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.base.Scheme
+	r.URL.Host = t.base.Host
+	r.URL.Path = strings.TrimSuffix(t.base.Path, "/") + r.URL.Path
+	r.Host = t.base.Host
+	r.Header.Set("Authorization", "Bearer "+t.token)
+
+	return t.next.RoundTrip(r)
+}
+
+// NewRemoteClient connects to the management api of a wag server, e.g https://wag.example.com:4444, using an api token.
+// The token's scopes limit which methods will succeed. tlsConfig may be nil to verify the server with the system roots
+func NewRemoteClient(address, token string, tlsConfig *tls.Config) (*CtrlClient, error) {
+	base, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid management api address: %w", err)
+	}
+
+	if base.Scheme != "https" {
+		return nil, errors.New("management api address must be https://")
+	}
+
+	return &CtrlClient{
+		httpClient: http.Client{
+			Transport: &remoteTransport{
+				next: &http.Transport{
+					TLSClientConfig: tlsConfig,
+				},
+				base:  base,
+				token: token,
+			},
+			Timeout: 2 * time.Minute,
+		},
+	}, nil
+}
+
+type actorTransport struct {
+	next            http.RoundTripper
+	actor, sourceIP string
+	admin           bool
+}
+
+func (a *actorTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set(control.ActorHeader, a.actor)
+	r.Header.Set(control.SourceHeader, a.sourceIP)
+	if a.admin {
+		r.Header.Set(control.AdminHeader, a.actor)
+	}
+
+	return a.next.RoundTrip(r)
+}
+
+// AsAdmin is As for a management ui administrator, requests are limited to what the administrators role allows
+func (c *CtrlClient) AsAdmin(username, sourceIP string) *CtrlClient {
+	return &CtrlClient{
+		httpClient: http.Client{
+			Transport: &actorTransport{
+				next:     c.httpClient.Transport,
+				actor:    username,
+				sourceIP: sourceIP,
+				admin:    true,
+			},
+		},
+	}
+}
+
+// As returns a client whose requests are recorded in the audit log as being made by actor from sourceIP, rather than by wagctl
+func (c *CtrlClient) As(actor, sourceIP string) *CtrlClient {
+	return &CtrlClient{
+		httpClient: http.Client{
+			Transport: &actorTransport{
+				next:     c.httpClient.Transport,
+				actor:    actor,
+				sourceIP: sourceIP,
+			},
+		},
+	}
+}
+
 func (c *CtrlClient) simplepost(path string, form url.Values) error {
 
 	response, err := c.httpClient.Post("http://unix/"+path, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
@@ -77,6 +167,62 @@ func (c *CtrlClient) ListDevice(username string) (d []data.Device, err error) {
 	return
 }
 
+// KeyRotations lists when every device that is subject to a key rotation policy must next rotate its keys
+func (c *CtrlClient) KeyRotations() (rotations []data.KeyRotationStatus, err error) {
+
+	response, err := c.httpClient.Get("http://unix/device/rotation/list")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&rotations)
+
+	return
+}
+
+// KeyRotationPolicies returns the key rotation policy of each group
+func (c *CtrlClient) KeyRotationPolicies() (policies map[string]data.KeyRotationPolicy, err error) {
+
+	response, err := c.httpClient.Get("http://unix/config/rotation/list")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&policies)
+
+	return
+}
+
+// SetKeyRotationPolicy sets how often the devices of a group (or data.AllUsersKeyRotation) rotate their keys, an interval of 0 removes the policy
+func (c *CtrlClient) SetKeyRotationPolicy(group string, policy data.KeyRotationPolicy) error {
+	form := url.Values{}
+	form.Add("group", group)
+	form.Add("interval_days", strconv.Itoa(policy.IntervalDays))
+	form.Add("grace_hours", strconv.Itoa(policy.GraceHours))
+
+	return c.simplepost("config/rotation/set", form)
+}
+
 // Take device address to remove
 func (c *CtrlClient) DeleteDevice(address string) error {
 
@@ -135,6 +281,28 @@ func (c *CtrlClient) AddAdminUser(username, password string, changeOnFirstUser b
 	return c.simplepost("webadmin/add", form)
 }
 
+// AddAdminUserWithRole adds an administrator with a role, optionally limited to acting on members of groups
+func (c *CtrlClient) AddAdminUserWithRole(username, password string, changeOnFirstUser bool, role data.AdminRole, groups []string) error {
+	form := url.Values{}
+	form.Add("username", username)
+	form.Add("password", password)
+	form.Add("change", fmt.Sprintf("%t", changeOnFirstUser))
+	form.Add("role", string(role))
+	form.Add("groups", strings.Join(groups, ","))
+
+	return c.simplepost("webadmin/add", form)
+}
+
+// SetAdminRole changes the role of an administrator, and the groups they are limited to
+func (c *CtrlClient) SetAdminRole(username string, role data.AdminRole, groups []string) error {
+	form := url.Values{}
+	form.Add("username", username)
+	form.Add("role", string(role))
+	form.Add("groups", strings.Join(groups, ","))
+
+	return c.simplepost("webadmin/setrole", form)
+}
+
 // Set an existing admin users password
 func (c *CtrlClient) SetAdminUserPassword(username, password string) error {
 	form := url.Values{}
@@ -144,6 +312,14 @@ func (c *CtrlClient) SetAdminUserPassword(username, password string) error {
 	return c.simplepost("webadmin/reset", form)
 }
 
+// ResetAdminUserMFA removes an administrators management ui mfa, they enrol again on their next login
+func (c *CtrlClient) ResetAdminUserMFA(username string) error {
+	form := url.Values{}
+	form.Add("username", username)
+
+	return c.simplepost("webadmin/resetmfa", form)
+}
+
 // Take device address to remove
 func (c *CtrlClient) DeleteAdminUser(username string) error {
 	form := url.Values{}
@@ -212,6 +388,17 @@ func (c *CtrlClient) UnlockUser(username string) error {
 	return c.simplepost("users/unlock", form)
 }
 
+// SetUserExpiry locks the users account once expires has passed, a zero expires removes the expiry
+func (c *CtrlClient) SetUserExpiry(username string, expires time.Time) error {
+	form := url.Values{}
+	form.Add("username", username)
+	if !expires.IsZero() {
+		form.Add("expires", expires.Format(time.RFC3339))
+	}
+
+	return c.simplepost("users/expiry", form)
+}
+
 func (c *CtrlClient) ResetUserMFA(username string) error {
 
 	form := url.Values{}
@@ -220,6 +407,283 @@ func (c *CtrlClient) ResetUserMFA(username string) error {
 	return c.simplepost("users/reset", form)
 }
 
+// List the security keys a user has registered, only applies to users with webauthn as their mfa method
+func (c *CtrlClient) ListUserKeys(username string) (keys []control.WebauthnCredential, err error) {
+
+	response, err := c.httpClient.Get("http://unix/users/mfa/keys/list?username=" + url.QueryEscape(username))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&keys)
+
+	return
+}
+
+func (c *CtrlClient) DeleteUserKey(username, id string) error {
+
+	form := url.Values{}
+	form.Add("username", username)
+	form.Add("id", id)
+
+	return c.simplepost("users/mfa/keys/delete", form)
+}
+
+// Replace the users recovery codes, the new codes are only returned once
+func (c *CtrlClient) GenerateRecoveryCodes(username string) (codes []string, err error) {
+
+	form := url.Values{}
+	form.Add("username", username)
+
+	response, err := c.httpClient.Post("http://unix/users/mfa/recovery", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&codes)
+
+	return
+}
+
+// Search the audit log, newest events first
+func (c *CtrlClient) SearchAudit(filter data.AuditFilter) (events []data.AuditEvent, err error) {
+
+	form := url.Values{}
+	form.Add("type", string(filter.Type))
+	form.Add("actor", filter.Actor)
+	form.Add("search", filter.Text)
+	form.Add("limit", fmt.Sprintf("%d", filter.Limit))
+
+	if !filter.Since.IsZero() {
+		form.Add("since", filter.Since.Format(time.RFC3339))
+	}
+
+	if !filter.Until.IsZero() {
+		form.Add("until", filter.Until.Format(time.RFC3339))
+	}
+
+	response, err := c.httpClient.Get("http://unix/audit/list?" + form.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&events)
+
+	return
+}
+
+func (c *CtrlClient) Backup() (backup data.Backup, err error) {
+
+	response, err := c.httpClient.Get("http://unix/backup")
+	if err != nil {
+		return backup, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return backup, err
+		}
+
+		return backup, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&backup)
+
+	return
+}
+
+// PlanPolicies returns the changes needed to make the running policies and groups match desired
+func (c *CtrlClient) PlanPolicies(desired data.PolicySet) (plan data.PolicyPlan, err error) {
+
+	body, err := json.Marshal(desired)
+	if err != nil {
+		return plan, err
+	}
+
+	response, err := c.httpClient.Post("http://unix/config/apply/plan", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return plan, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return plan, err
+		}
+
+		return plan, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&plan)
+
+	return
+}
+
+// ApplyPolicies applies a plan created by PlanPolicies, it fails without changing anything if the plan is out of date
+func (c *CtrlClient) ApplyPolicies(desired data.PolicySet, plan data.PolicyPlan) (applied data.PolicyPlan, err error) {
+
+	body, err := json.Marshal(data.ApplyPoliciesRequest{Desired: desired, Plan: plan})
+	if err != nil {
+		return applied, err
+	}
+
+	response, err := c.httpClient.Post("http://unix/config/apply", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return applied, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return applied, err
+		}
+
+		return applied, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&applied)
+
+	return
+}
+
+func (c *CtrlClient) EncryptionStatus() (status data.EncryptionStatus, err error) {
+
+	response, err := c.httpClient.Get("http://unix/encryption/status")
+	if err != nil {
+		return status, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return status, err
+		}
+
+		return status, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&status)
+
+	return
+}
+
+// RotateEncryptionKey creates a new data key and re-encrypts all secrets with it
+func (c *CtrlClient) RotateEncryptionKey() (status data.EncryptionStatus, err error) {
+
+	response, err := c.httpClient.Post("http://unix/encryption/rotate", "application/json", nil)
+	if err != nil {
+		return status, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return status, err
+		}
+
+		return status, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&status)
+
+	return
+}
+
+// APITokens lists management api tokens, only available on the control socket
+func (c *CtrlClient) APITokens() (tokens []data.APIToken, err error) {
+
+	response, err := c.httpClient.Get("http://unix/apitokens/list")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&tokens)
+
+	return
+}
+
+// CreateAPIToken creates a management api token, the secret in the result is not stored and cannot be retrieved again
+func (c *CtrlClient) CreateAPIToken(name string, scopes []string, lifetime time.Duration) (created data.NewAPIToken, err error) {
+
+	form := url.Values{}
+	form.Set("name", name)
+	form.Set("scopes", strings.Join(scopes, ","))
+	form.Set("lifetime", lifetime.String())
+
+	response, err := c.httpClient.Post("http://unix/apitokens/create", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return created, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return created, err
+		}
+
+		return created, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&created)
+
+	return
+}
+
+func (c *CtrlClient) DeleteAPIToken(id string) error {
+
+	form := url.Values{}
+	form.Set("id", id)
+
+	return c.simplepost("apitokens/delete", form)
+}
+
 func (c *CtrlClient) Sessions() (out []string, err error) {
 
 	response, err := c.httpClient.Get("http://unix/device/sessions")
@@ -508,6 +972,11 @@ func (c *CtrlClient) Registrations() (result []control.RegistrationResult, err e
 }
 
 func (c *CtrlClient) NewRegistration(token, username, overwrite string, uses int, groups ...string) (r control.RegistrationResult, err error) {
+	return c.NewExpiringRegistration(token, username, overwrite, uses, time.Time{}, groups...)
+}
+
+// NewExpiringRegistration creates a registration token that sets the expiry of the users account when it is used, a zero accountExpires never expires
+func (c *CtrlClient) NewExpiringRegistration(token, username, overwrite string, uses int, accountExpires time.Time, groups ...string) (r control.RegistrationResult, err error) {
 
 	if uses <= 0 {
 		err = errors.New("unable to create token with <= 0 uses")
@@ -519,6 +988,9 @@ func (c *CtrlClient) NewRegistration(token, username, overwrite string, uses int
 	form.Add("token", token)
 	form.Add("overwrite", overwrite)
 	form.Add("uses", fmt.Sprintf("%d", uses))
+	if !accountExpires.IsZero() {
+		form.Add("account_expires", accountExpires.Format(time.RFC3339))
+	}
 
 	for _, group := range groups {
 		if !strings.HasPrefix(group, "group:") {
@@ -563,6 +1035,55 @@ func (c *CtrlClient) DeleteRegistration(id string) (err error) {
 	return c.simplepost("registration/delete", form)
 }
 
+// AccessRequests lists just in time access requests, if username is empty ("") the requests of every user are listed
+func (c *CtrlClient) AccessRequests(username string) (requests []data.AccessRequest, err error) {
+
+	response, err := c.httpClient.Get("http://unix/access/list?username=" + url.QueryEscape(username))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&requests)
+
+	return
+}
+
+// ApproveAccessRequest grants the routes of a pending access request until it expires
+func (c *CtrlClient) ApproveAccessRequest(id, reason string) error {
+	form := url.Values{}
+	form.Add("id", id)
+	form.Add("reason", reason)
+
+	return c.simplepost("access/approve", form)
+}
+
+func (c *CtrlClient) DenyAccessRequest(id, reason string) error {
+	form := url.Values{}
+	form.Add("id", id)
+	form.Add("reason", reason)
+
+	return c.simplepost("access/deny", form)
+}
+
+// RevokeAccessRequest removes an approved access request before it expires
+func (c *CtrlClient) RevokeAccessRequest(id, reason string) error {
+	form := url.Values{}
+	form.Add("id", id)
+	form.Add("reason", reason)
+
+	return c.simplepost("access/revoke", form)
+}
+
 func (c *CtrlClient) Shutdown(cleanup bool) (err error) {
 
 	form := url.Values{}
@@ -570,3 +1091,127 @@ func (c *CtrlClient) Shutdown(cleanup bool) (err error) {
 
 	return c.simplepost("shutdown", form)
 }
+
+// GetSettings returns the general and login settings, including identity provider secrets
+func (c *CtrlClient) GetSettings() (settings data.AllSettings, err error) {
+
+	response, err := c.httpClient.Get("http://unix/settings/list")
+	if err != nil {
+		return settings, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return settings, err
+		}
+
+		return settings, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&settings)
+
+	return
+}
+
+func (c *CtrlClient) postJSON(path string, body any) error {
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.httpClient.Post("http://unix/"+path, "application/json", bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+
+		return errors.New(string(result))
+	}
+
+	return nil
+}
+
+// SetGeneralSettings replaces all general settings
+func (c *CtrlClient) SetGeneralSettings(general data.GeneralSettings) error {
+	return c.postJSON("settings/general", general)
+}
+
+// SetLoginSettings replaces all login settings
+func (c *CtrlClient) SetLoginSettings(login data.LoginSettings) error {
+	return c.postJSON("settings/login", login)
+}
+
+// SetSetting changes a single setting by name, see data.SettingNames
+func (c *CtrlClient) SetSetting(name, value string) error {
+
+	form := url.Values{}
+	form.Set("name", name)
+	form.Set("value", value)
+
+	return c.simplepost("settings/set", form)
+}
+
+func (c *CtrlClient) ClusterMembers() (members []data.ClusterMember, err error) {
+
+	response, err := c.httpClient.Get("http://unix/cluster/members")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New(string(result))
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&members)
+
+	return
+}
+
+// AddClusterMember adds a node to the cluster, the returned join token is given to the new node with -join
+func (c *CtrlClient) AddClusterMember(name, connectionURL, managerURL string) (joinToken string, err error) {
+
+	body, err := json.Marshal(data.NewNodeRequest{NodeName: name, ConnectionURL: connectionURL, ManagerURL: managerURL})
+	if err != nil {
+		return "", err
+	}
+
+	response, err := c.httpClient.Post("http://unix/cluster/add", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		result, err := io.ReadAll(response.Body)
+		if err != nil {
+			return "", err
+		}
+
+		return "", errors.New(string(result))
+	}
+
+	var newNode data.NewNodeResponse
+	err = json.NewDecoder(response.Body).Decode(&newNode)
+
+	return newNode.JoinToken, err
+}
+
+// ClusterControl applies action (promote, drain, restore, stepdown or remove) to the member with the hex id node
+func (c *CtrlClient) ClusterControl(node, action string) error {
+	return c.postJSON("cluster/control", data.NodeControlRequest{Node: node, Action: action})
+}
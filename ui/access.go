@@ -0,0 +1,158 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+)
+
+func accessRequestsUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, u := sessionManager.GetSessionFromRequest(r)
+	if u == nil {
+		http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+		return
+	}
+
+	d := Page{
+
+		Description:  "Just in time access requests",
+		Title:        "Access Requests",
+		User:         u.Username,
+		WagVersion:   WagVersion,
+		ServerID:     serverID,
+		ClusterState: clusterState,
+	}
+
+	err := renderDefaults(w, r, d, "management/access_requests.html")
+	if err != nil {
+		log.Println("unable to render access_requests page: ", err)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		renderDefaults(w, r, nil, "error.html")
+		return
+	}
+}
+
+func accessRequests(w http.ResponseWriter, r *http.Request) {
+	_, u := sessionManager.GetSessionFromRequest(r)
+	if u == nil {
+		http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		requests, err := ctrl.AccessRequests("")
+		if err != nil {
+			log.Println("error getting access requests: ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		result := []AccessRequestsData{}
+		for _, request := range requests {
+			entry := AccessRequestsData{
+				ID:             request.ID,
+				Username:       request.Username,
+				Routes:         request.Routes,
+				Duration:       request.Duration.String(),
+				Reason:         request.Reason,
+				Status:         string(request.Status),
+				Requested:      request.Requested.Format(time.RFC3339),
+				DecidedBy:      request.DecidedBy,
+				DecisionReason: request.DecisionReason,
+			}
+
+			if !request.Expires.IsZero() {
+				entry.Expires = request.Expires.Format(time.RFC3339)
+			}
+
+			result = append(result, entry)
+		}
+
+		b, err := json.Marshal(result)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+
+	case "PUT":
+		var action struct {
+			Action string   `json:"action"`
+			IDs    []string `json:"ids"`
+			Reason string   `json:"reason"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&action)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		var decide func(id, reason string) error
+		switch action.Action {
+		case "approve":
+			decide = ctrlAs(r).ApproveAccessRequest
+		case "deny":
+			decide = ctrlAs(r).DenyAccessRequest
+		case "revoke":
+			decide = ctrlAs(r).RevokeAccessRequest
+		default:
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if action.Action != "approve" && strings.TrimSpace(action.Reason) == "" {
+			http.Error(w, "a reason is required", http.StatusBadRequest)
+			return
+		}
+
+		var errs []string
+		for _, id := range action.IDs {
+			if err := decide(id, action.Reason); err != nil {
+				log.Println("failed to", action.Action, "access request: ", id, "err:", err)
+				errs = append(errs, err.Error())
+			}
+		}
+
+		if len(errs) > 0 {
+			http.Error(w, fmt.Sprintf("%d/%d failed with errors:\n%s", len(errs), len(action.IDs), strings.Join(errs, "\n")), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("OK"))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// pendingAccessRequests is shown on the dashboard so approvers notice requests waiting on them
+func pendingAccessRequests() int {
+	requests, err := data.GetAccessRequests("")
+	if err != nil {
+		return 0
+	}
+
+	pending := 0
+	for _, request := range requests {
+		if request.Status == data.AccessPending {
+			pending++
+		}
+	}
+
+	return pending
+}
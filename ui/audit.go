@@ -0,0 +1,156 @@
+package ui
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/pkg/control/wagctl"
+)
+
+// ctrlAs returns a control client that records any changes in the audit log as being made by the logged in administrator, and is limited to their role
+func ctrlAs(r *http.Request) *wagctl.CtrlClient {
+	_, u := sessionManager.GetSessionFromRequest(r)
+	if u == nil {
+		return ctrl
+	}
+
+	return ctrl.AsAdmin(u.Username, r.RemoteAddr)
+}
+
+// auditAdmin records changes that the management ui makes directly, rather than through the control socket
+func auditAdmin(r *http.Request, eventType data.AuditEventType, target, details string) {
+	actor := "unknown"
+	if _, u := sessionManager.GetSessionFromRequest(r); u != nil {
+		actor = u.Username
+	}
+
+	data.Audit(eventType, actor, r.RemoteAddr, target, details)
+}
+
+func auditUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, u := sessionManager.GetSessionFromRequest(r)
+	if u == nil {
+		http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+		return
+	}
+
+	d := struct {
+		Page
+		EventTypes []data.AuditEventType
+	}{
+		Page: Page{
+			Description:  "Audit Log",
+			Title:        "Audit Log",
+			User:         u.Username,
+			WagVersion:   WagVersion,
+			ServerID:     serverID,
+			ClusterState: clusterState,
+		},
+		EventTypes: []data.AuditEventType{
+			data.AuditLogin,
+			data.AuditLoginFailed,
+			data.AuditLogout,
+			data.AuditSessionExtended,
+			data.AuditLockout,
+			data.AuditLockoutExpired,
+			data.AuditStepUp,
+			data.AuditStepUpFailed,
+			data.AuditMFARegistered,
+			data.AuditDeviceRegistered,
+			data.AuditRegistrationFailed,
+			data.AuditAdminLogin,
+			data.AuditAdminLoginFailed,
+			data.AuditAdminAction,
+			data.AuditPolicyChange,
+			data.AuditSettingsChange,
+			data.AuditAccessRequested,
+			data.AuditAccessCancelled,
+			data.AuditAccessApproved,
+			data.AuditAccessDenied,
+			data.AuditAccessRevoked,
+			data.AuditAccessExpired,
+			data.AuditAccountExpired,
+			data.AuditAccountInactive,
+			data.AuditKeysIssued,
+			data.AuditKeysRotated,
+		},
+	}
+
+	err := renderDefaults(w, r, d, "management/audit.html")
+	if err != nil {
+		log.Println("unable to render audit page: ", err)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		renderDefaults(w, r, nil, "error.html")
+		return
+	}
+}
+
+func auditData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, u := sessionManager.GetSessionFromRequest(r)
+	if u == nil {
+		http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+		return
+	}
+
+	query := r.URL.Query()
+
+	filter := data.AuditFilter{
+		Type:  data.AuditEventType(query.Get("type")),
+		Actor: query.Get("actor"),
+		Text:  query.Get("text"),
+		Limit: 1000,
+	}
+
+	if since := query.Get("since"); since != "" {
+		var err error
+		filter.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if limit := query.Get("max"); limit != "" {
+		var err error
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 0 {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := ctrl.SearchAudit(filter)
+	if err != nil {
+		log.Println("unable to search audit log: ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if events == nil {
+		events = []data.AuditEvent{}
+	}
+
+	b, err := json.Marshal(events)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
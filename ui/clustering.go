@@ -52,41 +52,17 @@ func clusterMembersUI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	members := data.GetMembers()
-	for i := range data.GetMembers() {
-		drained, err := data.IsDrained(members[i].ID.String())
+	for i := range members {
+		status, lastPing, drained, err := data.MemberStatus(members[i])
 		if err != nil {
 			log.Println("unable to render clustering page: ", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		status := "healthy" // full liveness
-		if drained {
-			status = "drained"
-		} else if !members[i].IsStarted() {
-			status = "wait for first connection..."
-		} else if members[i].IsLearner {
-			status = "learner"
-		}
-
 		ping := ""
-		if status != "learner" {
-			lastPing, err := data.GetLastPing(members[i].ID.String())
-			if err != nil {
-				log.Println("unable to fetch last ping: ", err)
-				status = "no last ping"
-			} else {
-
-				if lastPing.Before(time.Now().Add(-6 * time.Second)) {
-					status += "(lagging ping)"
-				}
-
-				if lastPing.Before(time.Now().Add(-14 * time.Second)) {
-					status = "dead"
-				}
-
-				ping = lastPing.Format(time.RFC822)
-			}
+		if !lastPing.IsZero() {
+			ping = lastPing.Format(time.RFC822)
 		}
 
 		d.Members = append(d.Members, MembershipDTO{
@@ -136,6 +112,7 @@ func newNode(w http.ResponseWriter, r *http.Request) {
 	b, _ := json.Marshal(newNodeResp)
 
 	log.Println("added new node: ", newNodeReq.NodeName, newNodeReq.ConnectionURL)
+	auditAdmin(r, data.AuditAdminAction, newNodeReq.NodeName, "added cluster member "+newNodeReq.ConnectionURL)
 
 	w.Write(b)
 }
@@ -202,6 +179,8 @@ func nodeControl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	auditAdmin(r, data.AuditAdminAction, ncR.Node, ncR.Action+" cluster member")
+
 	w.Write([]byte("OK"))
 
 }
@@ -55,7 +55,7 @@ func populateDashboard(w http.ResponseWriter, r *http.Request) {
 	lockedDevices := 0
 	activeSessions := 0
 	for _, d := range allDevices {
-		if d.Attempts >= lockout {
+		if d.LockoutAttempts() >= lockout {
 			lockedDevices++
 		}
 
@@ -107,13 +107,14 @@ func populateDashboard(w http.ResponseWriter, r *http.Request) {
 		ExternalAddress: s.ExternalAddress,
 		Subnet:          config.Values.Wireguard.Range.String(),
 
-		NumUsers:           len(allUsers),
-		ActiveSessions:     activeSessions,
-		RegistrationTokens: len(registrations),
-		Devices:            len(allDevices),
-		LockedDevices:      lockedDevices,
-		UnenforcedMFA:      unenforcedMFA,
-		LogItems:           LogQueue.ReadAll(),
+		NumUsers:              len(allUsers),
+		ActiveSessions:        activeSessions,
+		RegistrationTokens:    len(registrations),
+		PendingAccessRequests: pendingAccessRequests(),
+		Devices:               len(allDevices),
+		LockedDevices:         lockedDevices,
+		UnenforcedMFA:         unenforcedMFA,
+		LogItems:              LogQueue.ReadAll(),
 	}
 
 	err = renderDefaults(w, r, d, "management/dashboard.html")
@@ -63,16 +63,35 @@ func devicesMgmt(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		rotations, err := ctrl.KeyRotations()
+		if err != nil {
+			log.Println("error getting key rotations: ", err)
+
+			w.WriteHeader(http.StatusInternalServerError)
+			renderDefaults(w, r, nil, "error.html")
+			return
+		}
+
+		rotationsByAddress := map[string]data.KeyRotationStatus{}
+		for _, rotation := range rotations {
+			rotationsByAddress[rotation.Address] = rotation
+		}
+
 		data := []DevicesData{}
 
 		for _, dev := range allDevices {
+			rotation := rotationsByAddress[dev.Address]
+
 			data = append(data, DevicesData{
-				Owner:        dev.Username,
-				Locked:       dev.Attempts >= lockout,
-				InternalIP:   dev.Address,
-				PublicKey:    dev.Publickey,
-				LastEndpoint: dev.Endpoint.String(),
-				Active:       dev.Active,
+				Owner:              dev.Username,
+				Locked:             dev.LockoutAttempts() >= lockout,
+				InternalIP:         dev.Address,
+				PublicKey:          dev.Publickey,
+				LastEndpoint:       dev.Endpoint.String(),
+				Active:             dev.Active,
+				KeyRotationDue:     formatDate(rotation.Due),
+				KeyRotationOverdue: rotation.Overdue,
+				KeyRotationPending: rotation.Pending,
 			})
 		}
 
@@ -102,14 +121,14 @@ func devicesMgmt(w http.ResponseWriter, r *http.Request) {
 		for _, address := range action.Addresses {
 			switch action.Action {
 			case "lock":
-				err := ctrl.LockDevice(address)
+				err := ctrlAs(r).LockDevice(address)
 				if err != nil {
 					log.Println("Error locking device: ", address, " err:", err)
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 					return
 				}
 			case "unlock":
-				err := ctrl.UnlockDevice(address)
+				err := ctrlAs(r).UnlockDevice(address)
 				if err != nil {
 					log.Println("Error unlocking device: ", address, " err:", err)
 					http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -133,7 +152,7 @@ func devicesMgmt(w http.ResponseWriter, r *http.Request) {
 		}
 
 		for _, address := range addresses {
-			err := ctrl.DeleteDevice(address)
+			err := ctrlAs(r).DeleteDevice(address)
 			if err != nil {
 				log.Println("Error Deleting device: ", address, "err:", err)
 
@@ -69,7 +69,7 @@ func groups(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := ctrl.RemoveGroup(groupsToRemove); err != nil {
+		if err := ctrlAs(r).RemoveGroup(groupsToRemove); err != nil {
 			log.Println("error removing groups: ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -85,7 +85,7 @@ func groups(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := ctrl.EditGroup(group); err != nil {
+		if err := ctrlAs(r).EditGroup(group); err != nil {
 			log.Println("error editing group: ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -102,7 +102,7 @@ func groups(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := ctrl.AddGroup(group); err != nil {
+		if err := ctrlAs(r).AddGroup(group); err != nil {
 			log.Println("error adding group: ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 
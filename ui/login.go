@@ -0,0 +1,608 @@
+package ui
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/NHAS/session"
+	"github.com/NHAS/wag/internal/config"
+	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/webserver/authenticators"
+	"github.com/NHAS/wag/internal/webserver/authenticators/types"
+	"github.com/NHAS/webauthn/protocol"
+	"github.com/NHAS/webauthn/webauthn"
+	"github.com/pquerna/otp/totp"
+	"github.com/zitadel/oidc/pkg/client/rp"
+	httphelper "github.com/zitadel/oidc/pkg/http"
+	"github.com/zitadel/oidc/pkg/oidc"
+)
+
+// adminLogin is an administrator that has entered their password, but not yet completed mfa
+type adminLogin struct {
+	Username string
+
+	// Set while enrolling a totp code, until the first code is entered
+	TotpURL string
+
+	Webauthn *webauthn.SessionData
+	// Set while registering a security key
+	WebauthnUser string
+}
+
+var (
+	pendingLogins *session.SessionStore[adminLogin]
+
+	// Only available when ManagementUI.Domain is set, as security keys are bound to an origin
+	adminWebauthn *webauthn.WebAuthn
+
+	adminOIDC            rp.RelyingParty
+	adminOIDCGroupsClaim string
+)
+
+func initAdminLogin() (err error) {
+	pendingLogins, err = session.NewStore[adminLogin]("admin-login", "WAG-CSRF", 5*time.Minute, 300, false)
+	if err != nil {
+		return err
+	}
+
+	if config.Values.ManagementUI.Domain != "" {
+		u, err := url.Parse(config.Values.ManagementUI.Domain)
+		if err != nil {
+			return fmt.Errorf("management ui domain is invalid: %w", err)
+		}
+
+		adminWebauthn, err = webauthn.New(&webauthn.Config{
+			RPDisplayName: "Wag Management",
+			RPID:          u.Hostname(),
+			RPOrigin:      u.Scheme + "://" + u.Host,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Values.ManagementUI.OIDC.Enabled {
+		for group, role := range config.Values.ManagementUI.OIDC.AdminRoles {
+			if err := data.ValidateAdminRole(data.AdminRole(role), nil); err != nil {
+				return fmt.Errorf("management ui oidc admin role for %q is invalid: %w", group, err)
+			}
+		}
+
+		return initAdminOIDC()
+	}
+
+	return nil
+}
+
+func initAdminOIDC() error {
+	details, err := data.GetOidc()
+	if err != nil {
+		return err
+	}
+
+	adminOIDCGroupsClaim = details.GroupsClaimName
+	if adminOIDCGroupsClaim == "" {
+		adminOIDCGroupsClaim = "groups"
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	cookieOptions := []httphelper.CookieHandlerOpt{}
+	if !config.Values.ManagementUI.SupportsTLS() {
+		cookieOptions = append(cookieOptions, httphelper.WithUnsecure())
+	}
+
+	u, err := url.JoinPath(config.Values.ManagementUI.Domain, "/login/oidc/callback")
+	if err != nil {
+		return err
+	}
+
+	adminOIDC, err = rp.NewRelyingPartyOIDC(details.IssuerURL, details.ClientID, details.ClientSecret, u, []string{"openid"},
+		rp.WithCookieHandler(httphelper.NewCookieHandler(key, key, cookieOptions...)),
+		rp.WithVerifierOpts(rp.WithIssuedAtOffset(5*time.Second)),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to connect to oidc provider for management ui login: %w", err)
+	}
+
+	log.Println("Management ui single sign on callback: ", u)
+
+	return nil
+}
+
+func renderLogin(w http.ResponseWriter, r *http.Request, message string) {
+	err := render(w, r, Login{ErrorMessage: message, SSO: adminOIDC != nil}, "templates/login.html")
+	if err != nil {
+		log.Println("unable to render login template:", err)
+	}
+}
+
+// startMFA is called once an administrator has entered their password, they then enrol or complete mfa before their session starts
+func startMFA(w http.ResponseWriter, r *http.Request, username string) {
+	if config.Values.ManagementUI.DisableMFA {
+		if err := finishLogin(w, r, username, "password"); err != nil {
+			renderLogin(w, r, "Unable to login")
+			return
+		}
+
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	pendingLogins.StartSession(w, r, adminLogin{Username: username}, nil)
+
+	http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+}
+
+func finishLogin(w http.ResponseWriter, r *http.Request, username, method string) error {
+	if _, pending := pendingLogins.GetSessionFromRequest(r); pending != nil {
+		pendingLogins.DeleteSession(w, r)
+	}
+
+	if err := data.SetLastLoginInformation(username, r.RemoteAddr); err != nil {
+		log.Println("unable to login: ", err)
+		return err
+	}
+
+	adminDetails, err := data.GetAdminUser(username)
+	if err != nil {
+		log.Println("unable to login: ", err)
+		return err
+	}
+
+	sessionManager.StartSession(w, r, adminDetails, nil)
+
+	log.Println(username, r.RemoteAddr, "admin logged in with", method)
+	data.Audit(data.AuditAdminLogin, username, r.RemoteAddr, "", method)
+
+	return nil
+}
+
+// mfaFailed counts a failed mfa attempt towards the administrators lockout, the same as a wrong password
+func mfaFailed(r *http.Request, username string, err error) {
+	log.Println("admin mfa failed for user", username, ": ", err)
+	data.Audit(data.AuditAdminLoginFailed, username, r.RemoteAddr, "", "mfa failed")
+
+	if err := data.IncrementAdminAuthenticationAttempt(username); err != nil {
+		log.Println("unable to record failed admin mfa attempt: ", err)
+	}
+}
+
+func adminLocked(username string) bool {
+	admin, err := data.GetAdminUser(username)
+	if err != nil {
+		return true
+	}
+
+	lockout, err := data.GetLockout()
+	if err != nil {
+		return true
+	}
+
+	return admin.Attempts >= lockout
+}
+
+// pendingLogin returns the administrator that is part way through logging in, or nil if there is none or they have since been locked
+func pendingLogin(w http.ResponseWriter, r *http.Request) (string, *adminLogin) {
+	key, pending := pendingLogins.GetSessionFromRequest(r)
+	if pending == nil {
+		return "", nil
+	}
+
+	if adminLocked(pending.Username) {
+		pendingLogins.DeleteSession(w, r)
+		return "", nil
+	}
+
+	return key, pending
+}
+
+func loginMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+
+	key, pending := pendingLogin(w, r)
+	if pending == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	mfaType, _, err := data.GetAdminMfa(pending.Username)
+	if err != nil {
+		log.Println("unable to get admin mfa details: ", err)
+		renderLogin(w, r, "Unable to login")
+		return
+	}
+
+	d := LoginMFA{
+		MfaType:  mfaType,
+		Webauthn: adminWebauthn != nil,
+	}
+
+	if mfaType == "" && r.URL.Query().Get("method") == string(types.Totp) {
+		d.TotpImage, d.TotpKey, err = enrolTotp(key, pending)
+		if err != nil {
+			log.Println("unable to generate admin totp key: ", err)
+			renderLogin(w, r, "Unable to login")
+			return
+		}
+	}
+
+	if err := render(w, r, d, "templates/login_mfa.html"); err != nil {
+		log.Println("unable to render mfa login template: ", err)
+	}
+}
+
+func enrolTotp(key string, pending *adminLogin) (image, secret string, err error) {
+	issuer, err := data.GetIssuer()
+	if err != nil {
+		return "", "", err
+	}
+
+	totpKey, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer + " Management",
+		AccountName: pending.Username,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	qr, err := totpKey.Image(200, 200)
+	if err != nil {
+		return "", "", err
+	}
+
+	var buff bytes.Buffer
+	if err := png.Encode(&buff, qr); err != nil {
+		return "", "", err
+	}
+
+	pending.TotpURL = totpKey.URL()
+	pendingLogins.UpdateSession(key, *pending)
+
+	return "data:image/png;base64, " + base64.StdEncoding.EncodeToString(buff.Bytes()), totpKey.Secret(), nil
+}
+
+func loginTotp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+
+	key, pending := pendingLogin(w, r)
+	if pending == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	mfaType, secret, err := data.GetAdminMfa(pending.Username)
+	if err != nil {
+		log.Println("unable to get admin mfa details: ", err)
+		renderLogin(w, r, "Unable to login")
+		return
+	}
+
+	enrolling := mfaType == ""
+	if enrolling {
+		secret = pending.TotpURL
+	}
+
+	if (!enrolling && mfaType != string(types.Totp)) || secret == "" {
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	// Shares the totp replay protection with vpn users, so is namespaced to not collide with a user of the same name
+	err = new(authenticators.Totp).AuthoriseFunc(w, r)(secret, "admin:"+pending.Username)
+	if err != nil {
+		mfaFailed(r, pending.Username, err)
+
+		d := LoginMFA{MfaType: mfaType, Webauthn: adminWebauthn != nil, ErrorMessage: "Incorrect code"}
+		if enrolling {
+			// Show a new key, as the code may have been wrong because the last one was not scanned
+			d.TotpImage, d.TotpKey, err = enrolTotp(key, pending)
+			if err != nil {
+				log.Println("unable to generate admin totp key: ", err)
+				renderLogin(w, r, "Unable to login")
+				return
+			}
+		}
+
+		if err := render(w, r, d, "templates/login_mfa.html"); err != nil {
+			log.Println("unable to render mfa login template: ", err)
+		}
+		return
+	}
+
+	if enrolling {
+		if err := data.SetAdminMfa(pending.Username, string(types.Totp), secret); err != nil {
+			log.Println("unable to save admin totp: ", err)
+			renderLogin(w, r, "Unable to login")
+			return
+		}
+
+		data.Audit(data.AuditAdminAction, pending.Username, r.RemoteAddr, pending.Username, "enrolled totp for the management ui")
+	}
+
+	if err := finishLogin(w, r, pending.Username, string(types.Totp)); err != nil {
+		renderLogin(w, r, "Unable to login")
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func jsonResult(w http.ResponseWriter, value any, status int) {
+	b, _ := json.Marshal(value)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// loginWebauthnRegister enrols a security key for an administrator that has not yet enrolled mfa
+func loginWebauthnRegister(w http.ResponseWriter, r *http.Request) {
+	key, pending := pendingLogin(w, r)
+	if pending == nil || adminWebauthn == nil {
+		jsonResult(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	mfaType, _, err := data.GetAdminMfa(pending.Username)
+	if err != nil || mfaType != "" {
+		jsonResult(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		webauthnUser := authenticators.NewUser(pending.Username, pending.Username)
+
+		options, sessionData, err := adminWebauthn.BeginRegistration(webauthnUser, func(pkcco *protocol.PublicKeyCredentialCreationOptions) {
+			pkcco.AuthenticatorSelection.UserVerification = "discouraged"
+		})
+		if err != nil {
+			log.Println("unable to begin admin webauthn registration: ", err)
+			jsonResult(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		user, err := webauthnUser.MarshalJSON()
+		if err != nil {
+			jsonResult(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		pending.WebauthnUser = string(user)
+		pending.Webauthn = sessionData
+		pendingLogins.UpdateSession(key, *pending)
+
+		jsonResult(w, options, http.StatusOK)
+
+	case "POST":
+		if pending.Webauthn == nil {
+			jsonResult(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		var webauthnUser authenticators.WebauthnUser
+		if err := webauthnUser.UnmarshalJSON([]byte(pending.WebauthnUser)); err != nil {
+			jsonResult(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		credential, err := adminWebauthn.FinishRegistration(webauthnUser, *pending.Webauthn, r)
+		if err != nil {
+			mfaFailed(r, pending.Username, err)
+			jsonResult(w, "Unable to register security key", http.StatusUnauthorized)
+			return
+		}
+
+		webauthnUser.AddNamedCredential(*credential, "Management")
+
+		user, err := webauthnUser.MarshalJSON()
+		if err != nil {
+			jsonResult(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := data.SetAdminMfa(pending.Username, string(types.Webauthn), string(user)); err != nil {
+			log.Println("unable to save admin security key: ", err)
+			jsonResult(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data.Audit(data.AuditAdminAction, pending.Username, r.RemoteAddr, pending.Username, "enrolled a security key for the management ui")
+
+		if err := finishLogin(w, r, pending.Username, string(types.Webauthn)); err != nil {
+			jsonResult(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		jsonResult(w, "OK", http.StatusOK)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func loginWebauthnAuthorise(w http.ResponseWriter, r *http.Request) {
+	key, pending := pendingLogin(w, r)
+	if pending == nil || adminWebauthn == nil {
+		jsonResult(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	mfaType, secret, err := data.GetAdminMfa(pending.Username)
+	if err != nil || mfaType != string(types.Webauthn) {
+		jsonResult(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	var webauthnUser authenticators.WebauthnUser
+	if err := webauthnUser.UnmarshalJSON([]byte(secret)); err != nil {
+		log.Println("unable to load admin security keys: ", err)
+		jsonResult(w, "Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		options, sessionData, err := adminWebauthn.BeginLogin(webauthnUser, func(pkcro *protocol.PublicKeyCredentialRequestOptions) {
+			pkcro.UserVerification = "discouraged"
+		})
+		if err != nil {
+			log.Println("unable to begin admin webauthn login: ", err)
+			jsonResult(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		pending.Webauthn = sessionData
+		pendingLogins.UpdateSession(key, *pending)
+
+		jsonResult(w, options, http.StatusOK)
+
+	case "POST":
+		if pending.Webauthn == nil {
+			jsonResult(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		credential, err := adminWebauthn.FinishLogin(webauthnUser, *pending.Webauthn, r)
+		if err == nil && credential.Authenticator.CloneWarning {
+			err = errors.New("cloned key detected")
+		}
+
+		if err != nil {
+			mfaFailed(r, pending.Username, err)
+			jsonResult(w, "Security key was not accepted", http.StatusUnauthorized)
+			return
+		}
+
+		// Store the incremented signature counter
+		user, err := webauthnUser.MarshalJSON()
+		if err == nil {
+			err = data.SetAdminMfa(pending.Username, string(types.Webauthn), string(user))
+		}
+
+		if err != nil {
+			log.Println("unable to update admin security key: ", err)
+			jsonResult(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := finishLogin(w, r, pending.Username, string(types.Webauthn)); err != nil {
+			jsonResult(w, "Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		jsonResult(w, "OK", http.StatusOK)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func loginOIDC(w http.ResponseWriter, r *http.Request) {
+	if adminOIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	rp.AuthURLHandler(func() string {
+		b := make([]byte, 16)
+		rand.Read(b)
+		return hex.EncodeToString(b)
+	}, adminOIDC)(w, r)
+}
+
+// adminRoleFromGroups returns the most privileged role mapped from the users groups, false if they have none
+func adminRoleFromGroups(groups []string) (data.AdminRole, bool) {
+	best := -1
+	for _, group := range groups {
+		role, ok := config.Values.ManagementUI.OIDC.AdminRoles[group]
+		if !ok {
+			continue
+		}
+
+		if i := slices.Index(data.AdminRoles, data.AdminRole(role)); i != -1 && (best == -1 || i < best) {
+			best = i
+		}
+	}
+
+	if best == -1 {
+		return "", false
+	}
+
+	return data.AdminRoles[best], true
+}
+
+func loginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if adminOIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	callback := func(w http.ResponseWriter, r *http.Request, tokens *oidc.Tokens, state string, provider rp.RelyingParty, info oidc.UserInfo) {
+		username := info.GetPreferredUsername()
+		if username == "" {
+			username = info.GetEmail()
+		}
+
+		var groups []string
+		claim, _ := tokens.IDTokenClaims.GetClaim(adminOIDCGroupsClaim).([]interface{})
+		for _, group := range claim {
+			if s, ok := group.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+
+		role, ok := adminRoleFromGroups(groups)
+		if username == "" || !ok {
+			log.Println("admin single sign on refused for", username, "groups:", groups)
+			data.Audit(data.AuditAdminLoginFailed, username, r.RemoteAddr, "", "single sign on user is not in an administrator group")
+
+			renderLogin(w, r, "You are not an administrator")
+			return
+		}
+
+		if err := data.SetSSOAdmin(username, role); err != nil {
+			log.Println("admin single sign on failed for", username, ": ", err)
+			data.Audit(data.AuditAdminLoginFailed, username, r.RemoteAddr, "", err.Error())
+
+			renderLogin(w, r, "Unable to login")
+			return
+		}
+
+		if adminLocked(username) {
+			data.Audit(data.AuditAdminLoginFailed, username, r.RemoteAddr, "", "account locked")
+			renderLogin(w, r, "Unable to login")
+			return
+		}
+
+		if err := finishLogin(w, r, username, "oidc"); err != nil {
+			renderLogin(w, r, "Unable to login")
+			return
+		}
+
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	}
+
+	rp.CodeExchangeHandler(rp.UserinfoCallback(callback), adminOIDC)(w, r)
+}
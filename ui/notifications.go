@@ -2,8 +2,10 @@ package ui
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"sort"
 	"strings"
 	"sync"
@@ -271,3 +273,90 @@ func monitorClusterMembers(notifications chan<- Notification) {
 		time.Sleep(15 * time.Second)
 	}
 }
+
+// monitorDeprovisioning warns administrators about accounts that are about to be locked or deleted because they expired or have been inactive
+func monitorDeprovisioning(notifications chan<- Notification) {
+	warned := map[string]bool{}
+	for {
+		deprovisions, err := data.DeprovisionWarnings()
+		if err != nil {
+			log.Println("unable to get accounts due to be deprovisioned: ", err)
+		}
+
+		current := map[string]bool{}
+		for _, d := range deprovisions {
+			id := "deprovision_" + d.Reason + "_" + d.Username
+			current[id] = true
+
+			reason := "has not authorised a device in a while"
+			if d.Reason == data.DeprovisionExpired {
+				reason = "has an account expiry"
+			}
+
+			action := "locked"
+			if d.Action == data.DeprovisionDelete {
+				action = "deleted"
+			}
+
+			notifications <- Notification{
+				ID:         id,
+				Heading:    "Account " + d.Username + " will be " + action,
+				Message:    []string{d.Username + " " + reason + ", it will be " + action + " at " + d.Due.Format(time.DateTime)},
+				Url:        "/management/users/?username=" + url.QueryEscape(d.Username),
+				Time:       time.Now(),
+				OpenNewTab: false,
+				Color:      "#ff5f15",
+			}
+		}
+
+		// Remove warnings for accounts that have been dealt with, e.g their expiry was extended
+		notificationsMapLck.Lock()
+		for id := range warned {
+			if !current[id] {
+				delete(notificationsMap, id)
+			}
+		}
+		notificationsMapLck.Unlock()
+
+		warned = current
+
+		time.Sleep(10 * time.Minute)
+	}
+}
+
+// monitorKeyRotations warns administrators about devices that have not rotated their keys as their key rotation policy requires
+func monitorKeyRotations(notifications chan<- Notification) {
+	const id = "key_rotation_overdue"
+	for {
+		rotations, err := data.GetKeyRotations()
+		if err != nil {
+			log.Println("unable to get device key rotations: ", err)
+		}
+
+		var overdue []string
+		for _, rotation := range rotations {
+			if rotation.Overdue && !rotation.Pending {
+				overdue = append(overdue, rotation.Username+" ("+rotation.Address+") was due at "+rotation.Due.Format(time.DateTime))
+			}
+		}
+
+		if len(overdue) > 0 {
+			notifications <- Notification{
+				ID:         id,
+				Heading:    fmt.Sprintf("%d devices are overdue for key rotation", len(overdue)),
+				Message:    overdue,
+				Url:        "/management/devices/",
+				Time:       time.Now(),
+				OpenNewTab: false,
+				Color:      "#ff5f15",
+			}
+		} else {
+			// Remove the warning once every device has rotated its keys
+			notificationsMapLck.Lock()
+			delete(notificationsMap, id)
+			notificationsMapLck.Unlock()
+		}
+
+		time.Sleep(10 * time.Minute)
+	}
+}
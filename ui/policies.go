@@ -70,7 +70,7 @@ func policies(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := ctrl.RemovePolicies(policiesToRemove); err != nil {
+		if err := ctrlAs(r).RemovePolicies(policiesToRemove); err != nil {
 			log.Println("error removing policy: ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -87,7 +87,7 @@ func policies(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := ctrl.EditPolicies(group); err != nil {
+		if err := ctrlAs(r).EditPolicies(group); err != nil {
 			log.Println("error editing policy: ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 
@@ -105,7 +105,7 @@ func policies(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := ctrl.AddPolicy(policy); err != nil {
+		if err := ctrlAs(r).AddPolicy(policy); err != nil {
 			log.Println("error adding policy: ", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 
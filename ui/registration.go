@@ -68,6 +68,8 @@ func registrationTokens(w http.ResponseWriter, r *http.Request) {
 				Groups:     reg.Groups,
 				Overwrites: reg.Overwrites,
 				Uses:       reg.NumUses,
+
+				AccountExpires: formatDate(reg.AccountExpires),
 			})
 		}
 
@@ -93,7 +95,7 @@ func registrationTokens(w http.ResponseWriter, r *http.Request) {
 
 		errs := ""
 		for _, token := range tokens {
-			err := ctrl.DeleteRegistration(token)
+			err := ctrlAs(r).DeleteRegistration(token)
 			if err != nil {
 				log.Println("Error deleting registration token: ", token, "err:", err)
 				errs = errs + "\n" + err.Error()
@@ -115,6 +117,8 @@ func registrationTokens(w http.ResponseWriter, r *http.Request) {
 			Overwrites string
 			Groups     string
 			Uses       string
+
+			AccountExpires string `json:"account_expires"`
 		}
 
 		defer r.Body.Close()
@@ -144,7 +148,13 @@ func registrationTokens(w http.ResponseWriter, r *http.Request) {
 			groups = strings.Split(b.Groups, ",")
 		}
 
-		_, err = ctrl.NewRegistration(b.Token, b.Username, b.Overwrites, uses, groups...)
+		accountExpires, err := parseDate(b.AccountExpires)
+		if err != nil {
+			http.Error(w, "invalid account expiry date", http.StatusBadRequest)
+			return
+		}
+
+		_, err = ctrlAs(r).NewExpiringRegistration(b.Token, b.Username, b.Overwrites, uses, accountExpires, groups...)
 		if err != nil {
 			log.Println("unable to create new registration token: ", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -1,8 +1,11 @@
 package ui
 
 import (
+	"log"
 	"net/http"
 	"net/url"
+
+	"github.com/NHAS/wag/internal/data"
 )
 
 type security struct {
@@ -50,3 +53,49 @@ func contentType(handle http.HandlerFunc, contentType string) http.HandlerFunc {
 		handle(w, r)
 	}
 }
+
+// requires only lets administrators whose role has permission use handle
+func requires(permission data.Permission, handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, u := sessionManager.GetSessionFromRequest(r)
+		if u == nil {
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		}
+
+		if !u.Can(permission) {
+			log.Printf("administrator %s (%s) was refused %s %s", u.Username, u.EffectiveRole(), r.Method, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		handle(w, r)
+	}
+}
+
+// changes lets every administrator view with GET, but other methods need one of permissions.
+// The control api checks the exact permission, and any group scope, for the change that is made
+func changes(handle http.HandlerFunc, permissions ...data.Permission) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, u := sessionManager.GetSessionFromRequest(r)
+		if u == nil {
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		}
+
+		required := []data.Permission{data.PermissionView}
+		if r.Method != "GET" {
+			required = permissions
+		}
+
+		for _, permission := range required {
+			if u.Can(permission) {
+				handle(w, r)
+				return
+			}
+		}
+
+		log.Printf("administrator %s (%s) was refused %s %s", u.Username, u.EffectiveRole(), r.Method, r.URL.Path)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}
+}
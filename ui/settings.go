@@ -87,10 +87,22 @@ func generalSettingsUI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	oidcProviders, _ := json.MarshalIndent(datastoreSettings.OidcProviders, "", "    ")
+	if datastoreSettings.OidcProviders == nil {
+		oidcProviders = []byte("[]")
+	}
+
+	stepUpMethods := map[string]bool{}
+	for _, method := range datastoreSettings.StepUpMFAMethods {
+		stepUpMethods[method] = true
+	}
+
 	d := struct {
 		Page
-		Settings   data.AllSettings
-		MFAMethods []authenticators.Authenticator
+		Settings      data.AllSettings
+		MFAMethods    []authenticators.Authenticator
+		StepUpMethods map[string]bool
+		OidcProviders string
 	}{
 		Page: Page{
 
@@ -102,8 +114,10 @@ func generalSettingsUI(w http.ResponseWriter, r *http.Request) {
 			ClusterState: clusterState,
 		},
 
-		Settings:   datastoreSettings,
-		MFAMethods: authenticators.GetAllAvaliableMethods(),
+		Settings:      datastoreSettings,
+		MFAMethods:    authenticators.GetAllAvaliableMethods(),
+		StepUpMethods: stepUpMethods,
+		OidcProviders: string(oidcProviders),
 	}
 
 	err = renderDefaults(w, r, d, "settings/general.html")
@@ -143,6 +157,8 @@ func generalSettings(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		auditAdmin(r, data.AuditSettingsChange, "general", "")
+
 		w.Write([]byte("OK"))
 		return
 	case "login":
@@ -159,6 +175,9 @@ func generalSettings(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Login settings contain secrets, so only record that they were changed
+		auditAdmin(r, data.AuditSettingsChange, "login", "")
+
 		w.Write([]byte("OK"))
 		return
 	default:
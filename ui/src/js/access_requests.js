@@ -0,0 +1,168 @@
+
+function getIdSelections(table) {
+  return $.map(table.bootstrapTable('getSelections'), function (row) {
+    return row.id
+  })
+}
+
+function responseHandler(res) {
+  $.each(res, function (i, row) {
+    row.state = $.inArray(row.id, selections) !== -1
+  })
+  return res
+}
+
+function routesFormatter(values) {
+  if (values == null) {
+    return "";
+  }
+
+  let result = ""
+  values.forEach(function (e) {
+    let span = document.createElement('span')
+    span.className = "badge badge-primary"
+    span.innerText = e
+
+    result += span.outerHTML + "\n"
+  });
+
+  return result
+}
+
+function statusFormatter(value) {
+  let span = document.createElement('span')
+  switch (value) {
+    case "pending":
+      span.className = "badge badge-warning"
+      break
+    case "approved":
+      span.className = "badge badge-success"
+      break
+    default:
+      span.className = "badge badge-secondary"
+  }
+  span.innerText = value
+
+  return span.outerHTML
+}
+
+$(function () {
+
+  let table = createTable("#table", [
+    {
+      field: 'state',
+      checkbox: true,
+      align: 'center',
+      escape: "true"
+    }, {
+      field: 'username',
+      title: 'Username',
+      sortable: true,
+      align: 'center',
+      escape: "true"
+    }, {
+      field: 'routes',
+      title: 'Routes',
+      align: 'center',
+      formatter: routesFormatter
+    }, {
+      field: 'duration',
+      title: 'Duration',
+      align: 'center',
+      escape: "true"
+    }, {
+      field: 'reason',
+      title: 'Reason',
+      align: 'center',
+      escape: "true"
+    }, {
+      field: 'status',
+      title: 'Status',
+      sortable: true,
+      align: 'center',
+      formatter: statusFormatter
+    }, {
+      field: 'requested',
+      title: 'Requested',
+      sortable: true,
+      align: 'center',
+      escape: "true"
+    }, {
+      field: 'decided_by',
+      title: 'Decided By',
+      sortable: true,
+      align: 'center',
+      escape: "true"
+    }, {
+      field: 'decision_reason',
+      title: 'Decision Reason',
+      align: 'center',
+      visible: false,
+      escape: "true"
+    }, {
+      field: 'expires',
+      title: 'Expires',
+      sortable: true,
+      align: 'center',
+      escape: "true"
+    }
+  ])
+
+  table.on('check.bs.table uncheck.bs.table ' +
+    'check-all.bs.table uncheck-all.bs.table',
+    function () {
+      let selected = table.bootstrapTable('getSelections')
+
+      $("#approve").prop('disabled', !selected.length || selected.some(r => r.status != "pending"))
+      $("#deny").prop('disabled', !selected.length || selected.some(r => r.status != "pending"))
+      $("#revoke").prop('disabled', !selected.length || selected.some(r => r.status != "approved"))
+
+      selections = getIdSelections(table)
+    })
+
+  function decide(action) {
+    let data = {
+      "action": action,
+      "ids": getIdSelections(table),
+      "reason": $("#reason").val()
+    }
+
+    fetch("/management/access_requests/data", {
+      method: 'PUT',
+      mode: 'same-origin',
+      cache: 'no-cache',
+      credentials: 'same-origin',
+      redirect: 'follow',
+      headers: {
+        'Content-Type': 'application/json',
+        'WAG-CSRF': $("#csrf_token").val()
+      },
+      body: JSON.stringify(data)
+    }).then((response) => {
+      if (response.status == 200) {
+        $("#issue").hide()
+        $("#reason").val("")
+        table.bootstrapTable('refresh')
+        return
+      }
+
+      response.text().then(txt => {
+        $("#issue").text(txt)
+        $("#issue").show()
+      })
+    })
+  }
+
+  $("#approve").on("click", function () {
+    decide("approve")
+  })
+
+  $("#deny").on("click", function () {
+    decide("deny")
+  })
+
+  $("#revoke").on("click", function () {
+    decide("revoke")
+  })
+
+});
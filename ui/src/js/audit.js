@@ -0,0 +1,95 @@
+function queryParams(params) {
+  let query = {
+    "type": $("#type").val(),
+    "actor": $("#actor").val(),
+  }
+
+  let since = $("#since").val()
+  if (since) {
+    query.since = new Date(since).toISOString()
+  }
+
+  return query
+}
+
+function timeFormatter(value) {
+  return new Date(value).toLocaleString()
+}
+
+function typeFormatter(value) {
+  let p = document.createElement('p')
+  if (value.endsWith("_failed") || value === "lockout") {
+    p.className = "badge badge-danger"
+  } else {
+    p.className = "badge badge-primary"
+  }
+  p.innerText = value
+  return p.outerHTML
+}
+
+$(function () {
+  let table = createTable("#table", [
+    {
+      title: 'Time',
+      field: 'Time',
+      align: 'center',
+      sortable: true,
+      formatter: timeFormatter
+    }, {
+      title: 'Event',
+      field: 'Type',
+      align: 'center',
+      sortable: true,
+      formatter: typeFormatter
+    }, {
+      title: 'Actor',
+      field: 'Actor',
+      align: 'center',
+      sortable: true,
+      escape: "true"
+    }, {
+      title: 'Source',
+      field: 'SourceIP',
+      align: 'center',
+      sortable: true,
+      escape: "true"
+    }, {
+      title: 'Target',
+      field: 'Target',
+      align: 'center',
+      sortable: true,
+      escape: "true"
+    }, {
+      title: 'Details',
+      field: 'Details',
+      align: 'left',
+      escape: "true"
+    }, {
+      title: 'Node',
+      field: 'NodeID',
+      align: 'center',
+      sortable: true,
+      visible: false,
+      escape: "true"
+    }
+  ])
+
+  table.on('load-error.bs.table', function (e, status, res) {
+    $("#issue").text(res.responseText)
+    $("#issue").show()
+  })
+
+  table.on('load-success.bs.table', function () {
+    $("#issue").hide()
+  })
+
+  $("#filter").on("click", function () {
+    table.bootstrapTable('refresh')
+  })
+
+  const urlParams = new URLSearchParams(window.location.search);
+  if (urlParams.has('actor')) {
+    $("#actor").val(urlParams.get('actor'))
+    table.bootstrapTable('refresh')
+  }
+})
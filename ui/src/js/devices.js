@@ -31,6 +31,18 @@ function lockedFormatter(value) {
   return p.outerHTML
 }
 
+function keyRotationFormatter(value, row) {
+  let p = document.createElement('p')
+  p.innerText = value
+  if (row.key_rotation_pending === true) {
+    p.className = "badge badge-warning"
+    p.innerText = value + " (new keys issued)"
+  } else if (row.key_rotation_overdue === true) {
+    p.className = "badge badge-danger"
+    p.innerText = value + " (overdue)"
+  }
+  return p.outerHTML
+}
 
 $(function () {
   let table = createTable('#devicesTable', [
@@ -75,6 +87,12 @@ $(function () {
       sortable: true,
       align: 'center',
       escape: "true"
+    }, {
+      field: 'key_rotation_due',
+      title: 'Key Rotation Due',
+      sortable: true,
+      align: 'center',
+      formatter: keyRotationFormatter
     }
   ])
 
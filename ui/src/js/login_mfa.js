@@ -0,0 +1,158 @@
+document.addEventListener('DOMContentLoaded', function () {
+
+    const registerButton = document.getElementById("registerButton");
+    if (registerButton !== null) {
+        registerButton.onclick = registerKey;
+    }
+
+    const loginButton = document.getElementById("loginButton");
+    if (loginButton !== null) {
+        loginButton.onclick = useKey;
+    }
+
+    if ((registerButton !== null || loginButton !== null) && !window.PublicKeyCredential) {
+        showError("This browser does not support security keys");
+    }
+}, false);
+
+function showError(message) {
+    document.getElementById("errorMsg").textContent = message;
+    document.getElementById("error").hidden = false;
+}
+
+// Base64 to ArrayBuffer
+function bufferDecode(value) {
+    return Uint8Array.from(atob(value.replace(/_/g, '/').replace(/-/g, '+')), c => c.charCodeAt(0));
+}
+
+// ArrayBuffer to URLBase64
+function bufferEncode(value) {
+    return btoa(String.fromCharCode.apply(null, new Uint8Array(value)))
+        .replace(/\+/g, "-")
+        .replace(/\//g, "_")
+        .replace(/=/g, "");
+}
+
+async function challenge(path) {
+    const response = await fetch(path, {
+        method: 'GET',
+        mode: 'same-origin',
+        cache: 'no-cache',
+        credentials: 'same-origin',
+        redirect: 'follow'
+    });
+
+    if (!response.ok) {
+        throw new Error(await response.json());
+    }
+
+    return response.json();
+}
+
+async function finalise(path, body) {
+    const response = await fetch(path, {
+        method: 'POST',
+        mode: 'same-origin',
+        cache: 'no-cache',
+        credentials: 'same-origin',
+        redirect: 'follow',
+        headers: {
+            'Accept': 'application/json',
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(body)
+    });
+
+    if (!response.ok) {
+        throw new Error(await response.json());
+    }
+}
+
+async function registerKey(event) {
+    if (event.target.disabled) {
+        return
+    }
+
+    event.target.disabled = true;
+    try {
+        const options = await challenge("/login/mfa/webauthn/register");
+
+        options.publicKey.challenge = bufferDecode(options.publicKey.challenge);
+        options.publicKey.user.id = bufferDecode(options.publicKey.user.id);
+        if (options.publicKey.excludeCredentials) {
+            options.publicKey.excludeCredentials.forEach(function (item) {
+                item.id = bufferDecode(item.id);
+            });
+        }
+
+        const credential = await navigator.credentials.create({
+            publicKey: options.publicKey
+        });
+
+        await finalise("/login/mfa/webauthn/register", {
+            id: credential.id,
+            rawId: bufferEncode(credential.rawId),
+            type: credential.type,
+            response: {
+                attestationObject: bufferEncode(credential.response.attestationObject),
+                clientDataJSON: bufferEncode(credential.response.clientDataJSON),
+            },
+        });
+    } catch (e) {
+        console.log("registering security key failed: ", e);
+        showError(e.message);
+        return
+    } finally {
+        event.target.disabled = false;
+    }
+
+    window.location.href = "/dashboard";
+}
+
+async function useKey(event) {
+    if (event.target.disabled) {
+        return
+    }
+
+    event.target.disabled = true;
+    try {
+        const options = await challenge("/login/mfa/webauthn/authorise");
+
+        options.publicKey.challenge = bufferDecode(options.publicKey.challenge);
+        options.publicKey.allowCredentials.forEach(function (item) {
+            item.id = bufferDecode(item.id);
+        });
+
+        let assertion;
+        try {
+            assertion = await navigator.credentials.get({
+                publicKey: options.publicKey
+            });
+        } catch (e) {
+            if (e.name == "InvalidStateError") {
+                throw new Error("Incorrect security key");
+            }
+            throw e;
+        }
+
+        await finalise("/login/mfa/webauthn/authorise", {
+            id: assertion.id,
+            rawId: bufferEncode(assertion.rawId),
+            type: assertion.type,
+            response: {
+                authenticatorData: bufferEncode(assertion.response.authenticatorData),
+                clientDataJSON: bufferEncode(assertion.response.clientDataJSON),
+                signature: bufferEncode(assertion.response.signature),
+                userHandle: bufferEncode(assertion.response.userHandle),
+            },
+        });
+    } catch (e) {
+        console.log("logging in with security key failed: ", e);
+        showError(e.message);
+        return
+    } finally {
+        event.target.disabled = false;
+    }
+
+    window.location.href = "/dashboard";
+}
@@ -42,6 +42,12 @@ window.operateEvents = {
     }
     $("#public_routes").val(public_routes_content)
 
+    let stepup_routes_content = ""
+    if (row.stepup_routes != null) {
+      stepup_routes_content = row.stepup_routes.join("\n")
+    }
+    $("#stepup_routes").val(stepup_routes_content)
+
 
     $("#action").val("edit")
 
@@ -86,6 +92,13 @@ $(function () {
       align: 'center',
       formatter: rulesFormatter
 
+    }, {
+      field: 'stepup_routes',
+      title: 'Step Up Routes (Number)',
+      sortable: true,
+      align: 'center',
+      formatter: rulesFormatter
+
     }, {
       field: 'edit',
       title: 'Edit',
@@ -163,6 +176,7 @@ $(function () {
 
     $("#mfa_routes").val("")
     $("#public_routes").val("")
+    $("#stepup_routes").val("")
 
     $("#ruleModal").modal("show")
   })
@@ -173,6 +187,7 @@ $(function () {
       "deny_routes": $('#deny_routes').val().split("\n").filter(element => element),
       "mfa_routes": $('#mfa_routes').val().split("\n").filter(element => element),
       "public_routes": $('#public_routes').val().split("\n").filter(element => element),
+      "stepup_routes": $('#stepup_routes').val().split("\n").filter(element => element),
     }
 
     let method = "POST";
@@ -51,12 +51,28 @@ $(function () {
         });
 
 
+        let stepUpMethods = [];
+        document.querySelectorAll('input[type="checkbox"].stepupselection:checked').forEach(function (checkbox) {
+            stepUpMethods.push(checkbox.value);
+        });
+
+        let oidcProviders = [];
+        try {
+            oidcProviders = JSON.parse($('#oidcProviders').val() || "[]")
+        } catch (e) {
+            $("#loginSettingsIssue").text("Additional OIDC Providers is not valid JSON: " + e.message)
+            $("#loginSettingsIssue").attr('class', "alert alert-danger")
+            $("#loginSettingsIssue").show()
+            return false;
+        }
+
         let data = {
             "MaxSessionLifetimeMinutes": parseInt($('#inputSessionLife').val()),
             "SessionInactivityTimeoutMinutes": parseInt($('#inputInactivity').val()),
             "Lockout": parseInt($('#numAttempts').val()),
             "DefaultMFAMethod": $('#defaultMFA').val(),
             "EnabledMFAMethods": checkedValues,
+            "StepUpMFAMethods": stepUpMethods,
             "Domain": $('#inputVPNDomain').val(),
 
 
@@ -68,6 +84,26 @@ $(function () {
                 "GroupsClaimName": $('#oidcGroupsClaimName').val(),
                 "DeviceUsernameClaim": $("#oidcDeviceUsernameClaim").val(),
             },
+            "OidcProviders": oidcProviders,
+            "SamlDetails": {
+                "IdPMetadataURL": $('#samlIdPMetadataURL').val(),
+                "IdPMetadataXML": $('#samlIdPMetadataXML').val(),
+                "EntityID": $('#samlEntityID').val(),
+                "UsernameAttribute": $('#samlUsernameAttribute').val(),
+                "GroupsAttribute": $('#samlGroupsAttribute').val(),
+            },
+            "LdapDetails": {
+                "URL": $('#ldapURL').val(),
+                "StartTLS": $("#ldapStartTLS").is(':checked'),
+                "CACertificate": $('#ldapCACertificate').val(),
+                "UserDNTemplate": $('#ldapUserDNTemplate').val(),
+                "BindDN": $('#ldapBindDN').val(),
+                "BindPassword": $('#ldapBindPassword').val(),
+                "BaseDN": $('#ldapBaseDN').val(),
+                "UserFilter": $('#ldapUserFilter').val(),
+                "GroupAttribute": $('#ldapGroupAttribute').val(),
+                "NestedGroups": $("#ldapNestedGroups").is(':checked'),
+            },
             "PamDetails": {
                 "ServiceName": $('#pamServiceName').val(),
             }
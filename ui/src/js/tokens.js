@@ -73,6 +73,12 @@ $(function () {
       sortable: true,
       align: 'center',
       escape: "true"
+    }, {
+      field: 'account_expires',
+      title: 'Account Expires',
+      sortable: true,
+      align: 'center',
+      escape: "true"
     }
   ])
 
@@ -119,7 +125,8 @@ $(function () {
       "token": $('#token').val(),
       "overwrites": $('#overwrite').val(),
       "groups": $('#groups').val(),
-      "uses": ($("#uses").val() == "" ? "1" : $("#uses").val())
+      "uses": ($("#uses").val() == "" ? "1" : $("#uses").val()),
+      "account_expires": $('#account_expires').val()
     }
 
     fetch("/management/registration_tokens/data", {
@@ -37,9 +37,130 @@ function mfaFormatter(value) {
     p.className = "badge badge-danger"
   }
   p.innerText = value
+
+  if (value === "webauthn") {
+    let a = document.createElement('a')
+    a.href = '#'
+    a.className = "manage-keys"
+    a.innerText = "keys"
+    return p.outerHTML + a.outerHTML
+  }
+
   return p.outerHTML
 }
 
+window.mfaEvents = {
+  'click .manage-keys': function (e, value, row) {
+    e.preventDefault()
+    showKeys(row.username)
+  }
+}
+
+function showKeys(username) {
+  $("#keysIssue").hide()
+  $("#keysModal").data("username", username)
+  $("#keysModalLabel").text("Security Keys: " + username)
+
+  fetch("/management/users/mfa?username=" + encodeURIComponent(username), {
+    method: 'GET',
+    mode: 'same-origin',
+    cache: 'no-cache',
+    credentials: 'same-origin',
+    redirect: 'follow',
+    headers: {
+      'Content-Type': 'application/json',
+      'WAG-CSRF': $("#csrf_token").val()
+    }
+  }).then((response) => {
+    if (response.status != 200) {
+      response.text().then(txt => {
+        $("#keysIssue").text(txt)
+        $("#keysIssue").show()
+      })
+      return
+    }
+
+    response.json().then(keys => {
+      let list = $("#keysList")
+      list.empty()
+
+      keys.forEach(function (key) {
+        let remove = $('<button class="btn btn-danger btn-sm">Remove</button>')
+        remove.prop('disabled', keys.length == 1)
+        remove.on("click", function () {
+          removeKey(username, key.id)
+        })
+
+        let added = new Date(key.added)
+
+        let row = $("<tr>")
+        row.append($("<td>").text(key.name))
+        row.append($("<td>").text(added.getTime() > 0 ? added.toLocaleString() : ""))
+        row.append($("<td>").append(remove))
+        list.append(row)
+      })
+    })
+  })
+
+  $("#keysModal").modal("show")
+}
+
+function removeKey(username, id) {
+  fetch("/management/users/mfa", {
+    method: 'DELETE',
+    mode: 'same-origin',
+    cache: 'no-cache',
+    credentials: 'same-origin',
+    redirect: 'follow',
+    headers: {
+      'Content-Type': 'application/json',
+      'WAG-CSRF': $("#csrf_token").val()
+    },
+    body: JSON.stringify({ "username": username, "id": id })
+  }).then((response) => {
+    if (response.status == 200) {
+      showKeys(username)
+      return
+    }
+
+    response.text().then(txt => {
+      $("#keysIssue").text(txt)
+      $("#keysIssue").show()
+    })
+  })
+}
+
+function generateRecoveryCodes(username, table) {
+  fetch("/management/users/mfa", {
+    method: 'POST',
+    mode: 'same-origin',
+    cache: 'no-cache',
+    credentials: 'same-origin',
+    redirect: 'follow',
+    headers: {
+      'Content-Type': 'application/json',
+      'WAG-CSRF': $("#csrf_token").val()
+    },
+    body: JSON.stringify({ "username": username })
+  }).then((response) => {
+    if (response.status != 200) {
+      response.text().then(txt => {
+        $("#issue").text(txt)
+        $("#issue").show()
+      })
+      return
+    }
+
+    response.json().then(codes => {
+      $("#issue").hide()
+      $("#recoveryUsername").text(username)
+      $("#recoveryCodesList").text(codes.join("\n"))
+      $("#recoveryModal").modal("show")
+      table.bootstrapTable('refresh')
+    })
+  })
+}
+
 function groupsFormatter(values) {
 
   let result = ""
@@ -89,13 +210,31 @@ $(function () {
       title: 'MFA Method',
       sortable: true,
       align: 'center',
-      formatter: mfaFormatter
+      formatter: mfaFormatter,
+      events: mfaEvents
+    }, {
+      field: 'recovery_codes',
+      title: 'Recovery Codes',
+      sortable: true,
+      align: 'center'
     }, {
       field: 'locked',
       title: 'Locked',
       align: 'center',
       sortable: true,
       formatter: lockedFormatter
+    }, {
+      field: 'last_seen',
+      title: 'Last Seen',
+      align: 'center',
+      sortable: true,
+      escape: "true"
+    }, {
+      field: 'expires',
+      title: 'Expires',
+      align: 'center',
+      sortable: true,
+      escape: "true"
     }
   ])
 
@@ -104,6 +243,8 @@ $(function () {
   var $lock = $('#lock')
   var $unlock = $('#unlock')
   var $resetMFA = $('#resetMFA')
+  var $recoveryCodes = $('#recoveryCodes')
+  var $expiryStart = $('#expiryStart')
 
 
   table.on('check.bs.table uncheck.bs.table ' +
@@ -115,6 +256,9 @@ $(function () {
       $lock.prop('disabled', enableModifications)
       $unlock.prop('disabled', enableModifications)
       $resetMFA.prop('disabled', enableModifications)
+      $expiryStart.prop('disabled', enableModifications)
+      // Codes are only shown once, so only generate them for one user at a time
+      $recoveryCodes.prop('disabled', table.bootstrapTable('getSelections').length != 1)
 
       // save your data, here just save the current page
       selections = getIdSelections(table)
@@ -136,6 +280,19 @@ $(function () {
     action(ids, "resetMFA", table)
   })
 
+  $("#setExpiry").on("click", function () {
+    var ids = getIdSelections(table)
+    action(ids, "expiry", table, { "expires": $("#expires").val() })
+    $("#expiryModal").modal("hide")
+  })
+
+  $recoveryCodes.on("click", function () {
+    var ids = getIdSelections(table)
+    if (ids.length == 1) {
+      generateRecoveryCodes(ids[0], table)
+    }
+  })
+
   $remove.on("click", function () {
     var ids = getIdSelections(table)
     table.bootstrapTable('remove', {
@@ -194,10 +351,11 @@ $(function () {
 })
 
 
-function action(onUsers, action, table) {
+function action(onUsers, action, table, extra) {
   let data = {
     "action": action,
     "usernames": onUsers,
+    ...extra
   }
 
   fetch("/management/users/data", {
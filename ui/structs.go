@@ -17,6 +17,8 @@ type Dashboard struct {
 	Devices            int
 	RegistrationTokens int
 
+	PendingAccessRequests int
+
 	ActiveSessions int
 
 	Subnet string
@@ -30,6 +32,18 @@ type Dashboard struct {
 
 type Login struct {
 	ErrorMessage string
+	SSO          bool
+}
+
+type LoginMFA struct {
+	ErrorMessage string
+	MfaType      string
+	// Security keys can only be enrolled when the management ui domain is set
+	Webauthn bool
+
+	// Set while enrolling a totp code
+	TotpImage string
+	TotpKey   string
 }
 
 type ChangePassword struct {
@@ -39,12 +53,28 @@ type ChangePassword struct {
 }
 
 type UsersData struct {
-	Username  string   `json:"username"`
-	Devices   int      `json:"devices"`
-	Locked    bool     `json:"locked"`
-	DateAdded string   `json:"date_added"`
-	MFAType   string   `json:"mfa_type"`
-	Groups    []string `json:"groups"`
+	Username      string   `json:"username"`
+	Devices       int      `json:"devices"`
+	Locked        bool     `json:"locked"`
+	DateAdded     string   `json:"date_added"`
+	MFAType       string   `json:"mfa_type"`
+	Groups        []string `json:"groups"`
+	RecoveryCodes int      `json:"recovery_codes"`
+	Expires       string   `json:"expires"`
+	LastSeen      string   `json:"last_seen"`
+}
+
+type AccessRequestsData struct {
+	ID             string   `json:"id"`
+	Username       string   `json:"username"`
+	Routes         []string `json:"routes"`
+	Duration       string   `json:"duration"`
+	Reason         string   `json:"reason"`
+	Status         string   `json:"status"`
+	Requested      string   `json:"requested"`
+	DecidedBy      string   `json:"decided_by"`
+	DecisionReason string   `json:"decision_reason"`
+	Expires        string   `json:"expires"`
 }
 
 type DevicesData struct {
@@ -55,6 +85,10 @@ type DevicesData struct {
 
 	PublicKey    string `json:"public_key"`
 	LastEndpoint string `json:"last_endpoint"`
+
+	KeyRotationDue     string `json:"key_rotation_due"`
+	KeyRotationOverdue bool   `json:"key_rotation_overdue"`
+	KeyRotationPending bool   `json:"key_rotation_pending"`
 }
 
 type TokensData struct {
@@ -63,6 +97,8 @@ type TokensData struct {
 	Groups     []string `json:"groups"`
 	Overwrites string   `json:"overwrites"`
 	Uses       int      `json:"uses"`
+
+	AccountExpires string `json:"account_expires"`
 }
 
 type WgDevicesData struct {
@@ -15,8 +15,10 @@ import (
 	"time"
 
 	"github.com/NHAS/session"
+	"github.com/NHAS/wag/internal/certificates"
 	"github.com/NHAS/wag/internal/config"
 	"github.com/NHAS/wag/internal/data"
+	"github.com/NHAS/wag/internal/proxyprotocol"
 	"github.com/NHAS/wag/pkg/control/wagctl"
 	"github.com/NHAS/wag/pkg/queue"
 )
@@ -108,7 +110,7 @@ func doLogin(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 
-		err := render(w, r, nil, "templates/login.html")
+		err := render(w, r, Login{SSO: adminOIDC != nil}, "templates/login.html")
 
 		if err != nil {
 			log.Println("unable to render login template:", err)
@@ -121,46 +123,29 @@ func doLogin(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Println("bad form value: ", err)
 
-			render(w, r, Login{ErrorMessage: "Unable to login"}, "templates/login.html")
+			renderLogin(w, r, "Unable to login")
 			return
 		}
 
 		err = data.IncrementAdminAuthenticationAttempt(r.Form.Get("username"))
 		if err != nil {
 			log.Println("admin login failed for user", r.Form.Get("username"), ": ", err)
+			data.Audit(data.AuditAdminLoginFailed, r.Form.Get("username"), r.RemoteAddr, "", err.Error())
 
-			render(w, r, Login{ErrorMessage: "Unable to login"}, "templates/login.html")
+			renderLogin(w, r, "Unable to login")
 			return
 		}
 
 		err = data.CompareAdminKeys(r.Form.Get("username"), r.Form.Get("password"))
 		if err != nil {
 			log.Println("admin login failed for user", r.Form.Get("username"), ": ", err)
+			data.Audit(data.AuditAdminLoginFailed, r.Form.Get("username"), r.RemoteAddr, "", "incorrect password")
 
-			render(w, r, Login{ErrorMessage: "Unable to login"}, "templates/login.html")
+			renderLogin(w, r, "Unable to login")
 			return
 		}
 
-		if err := data.SetLastLoginInformation(r.Form.Get("username"), r.RemoteAddr); err != nil {
-			log.Println("unable to login: ", err)
-
-			render(w, r, Login{ErrorMessage: "Unable to login"}, "templates/login.html")
-			return
-		}
-
-		adminDetails, err := data.GetAdminUser(r.Form.Get("username"))
-		if err != nil {
-			log.Println("unable to login: ", err)
-
-			render(w, r, Login{ErrorMessage: "Unable to login"}, "templates/login.html")
-			return
-		}
-
-		sessionManager.StartSession(w, r, adminDetails, nil)
-
-		log.Println(r.Form.Get("username"), r.RemoteAddr, "admin logged in")
-
-		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		startMFA(w, r, r.Form.Get("username"))
 
 	default:
 		http.NotFound(w, r)
@@ -222,6 +207,10 @@ func StartWebServer(errs chan<- error) error {
 		return err
 	}
 
+	if err := initAdminLogin(); err != nil {
+		return err
+	}
+
 	clusterState = "starting"
 	if data.HasLeader() {
 		clusterState = "healthy"
@@ -256,6 +245,13 @@ func StartWebServer(errs chan<- error) error {
 		},
 	}
 
+	if config.Values.ManagementUI.SupportsTLS() {
+		tlsConfig, err = certificates.TLSConfig(tlsConfig, config.Values.ManagementUI.ACMEDomain, config.Values.ManagementUI.CertPath, config.Values.ManagementUI.KeyPath)
+		if err != nil {
+			return fmt.Errorf("unable to load certificate for management listener: %w", err)
+		}
+	}
+
 	go func() {
 
 		static := http.FileServer(http.FS(staticContent))
@@ -263,6 +259,12 @@ func StartWebServer(errs chan<- error) error {
 		protectedRoutes := http.NewServeMux()
 		allRoutes := http.NewServeMux()
 		allRoutes.HandleFunc("/login", doLogin)
+		allRoutes.HandleFunc("/login/mfa", loginMFA)
+		allRoutes.HandleFunc("/login/mfa/totp", loginTotp)
+		allRoutes.HandleFunc("/login/mfa/webauthn/register", loginWebauthnRegister)
+		allRoutes.HandleFunc("/login/mfa/webauthn/authorise", loginWebauthnAuthorise)
+		allRoutes.HandleFunc("/login/oidc", loginOIDC)
+		allRoutes.HandleFunc("/login/oidc/callback", loginOIDCCallback)
 
 		if config.Values.ManagementUI.Debug {
 			static := http.FileServer(http.Dir("./ui/src/"))
@@ -296,49 +298,63 @@ func StartWebServer(errs chan<- error) error {
 				return true
 			}))
 
-		protectedRoutes.HandleFunc("/dashboard", populateDashboard)
+		view := func(handle http.HandlerFunc) http.HandlerFunc {
+			return requires(data.PermissionView, handle)
+		}
+
+		protectedRoutes.HandleFunc("/dashboard", view(populateDashboard))
+
+		protectedRoutes.HandleFunc("/cluster/members/", view(clusterMembersUI))
+		protectedRoutes.HandleFunc("/cluster/members/new", requires(data.PermissionCluster, contentType(newNode, JSON)))
+		protectedRoutes.HandleFunc("/cluster/members/control", requires(data.PermissionCluster, contentType(nodeControl, JSON)))
 
-		protectedRoutes.HandleFunc("/cluster/members/", clusterMembersUI)
-		protectedRoutes.HandleFunc("/cluster/members/new", contentType(newNode, JSON))
-		protectedRoutes.HandleFunc("/cluster/members/control", contentType(nodeControl, JSON))
+		protectedRoutes.HandleFunc("/cluster/events/", view(clusterEventsUI))
+		protectedRoutes.HandleFunc("/cluster/events/acknowledge", requires(data.PermissionCluster, clusterEventsAcknowledge))
 
-		protectedRoutes.HandleFunc("/cluster/events/", clusterEventsUI)
-		protectedRoutes.HandleFunc("/cluster/events/acknowledge", clusterEventsAcknowledge)
+		protectedRoutes.HandleFunc("/diag/wg", view(wgDiagnositicsUI))
+		protectedRoutes.HandleFunc("/diag/wg/data", view(wgDiagnositicsData))
 
-		protectedRoutes.HandleFunc("/diag/wg", wgDiagnositicsUI)
-		protectedRoutes.HandleFunc("/diag/wg/data", wgDiagnositicsData)
+		protectedRoutes.HandleFunc("/diag/firewall", view(firewallDiagnositicsUI))
 
-		protectedRoutes.HandleFunc("/diag/firewall", firewallDiagnositicsUI)
+		protectedRoutes.HandleFunc("/diag/check", view(firewallCheckTest))
 
-		protectedRoutes.HandleFunc("/diag/check", firewallCheckTest)
+		protectedRoutes.HandleFunc("/diag/acls", view(aclsTest))
 
-		protectedRoutes.HandleFunc("/diag/acls", aclsTest)
+		protectedRoutes.HandleFunc("/audit/", view(auditUI))
+		protectedRoutes.HandleFunc("/audit/data", view(contentType(auditData, JSON)))
 
-		protectedRoutes.HandleFunc("/management/users/", usersUI)
-		protectedRoutes.HandleFunc("/management/users/data", contentType(manageUsers, JSON))
+		protectedRoutes.HandleFunc("/management/users/", view(usersUI))
+		protectedRoutes.HandleFunc("/management/users/data", changes(contentType(manageUsers, JSON), data.PermissionLockAccounts, data.PermissionResetMFA, data.PermissionDeleteAccounts))
+		protectedRoutes.HandleFunc("/management/users/mfa", changes(contentType(manageUserMFA, JSON), data.PermissionResetMFA))
 
-		protectedRoutes.HandleFunc("/management/devices/", devicesMgmtUI)
-		protectedRoutes.HandleFunc("/management/devices/data", contentType(devicesMgmt, JSON))
+		protectedRoutes.HandleFunc("/management/devices/", view(devicesMgmtUI))
+		protectedRoutes.HandleFunc("/management/devices/data", changes(contentType(devicesMgmt, JSON), data.PermissionLockAccounts, data.PermissionDeleteAccounts))
 
-		protectedRoutes.HandleFunc("/management/registration_tokens/", registrationUI)
-		protectedRoutes.HandleFunc("/management/registration_tokens/data", contentType(registrationTokens, JSON))
+		protectedRoutes.HandleFunc("/management/registration_tokens/", view(registrationUI))
+		protectedRoutes.HandleFunc("/management/registration_tokens/data", changes(contentType(registrationTokens, JSON), data.PermissionRegistrations))
 
-		protectedRoutes.HandleFunc("/policy/rules/", policiesUI)
-		protectedRoutes.HandleFunc("/policy/rules/data", contentType(policies, JSON))
+		protectedRoutes.HandleFunc("/management/access_requests/", view(accessRequestsUI))
+		protectedRoutes.HandleFunc("/management/access_requests/data", changes(contentType(accessRequests, JSON), data.PermissionAccessRequests))
 
-		protectedRoutes.HandleFunc("/policy/groups/", groupsUI)
-		protectedRoutes.HandleFunc("/policy/groups/data", contentType(groups, JSON))
+		protectedRoutes.HandleFunc("/policy/rules/", view(policiesUI))
+		protectedRoutes.HandleFunc("/policy/rules/data", changes(contentType(policies, JSON), data.PermissionPolicies))
 
-		protectedRoutes.HandleFunc("/settings/general", generalSettingsUI)
-		protectedRoutes.HandleFunc("/settings/general/data", contentType(generalSettings, JSON))
+		protectedRoutes.HandleFunc("/policy/groups/", view(groupsUI))
+		protectedRoutes.HandleFunc("/policy/groups/data", changes(contentType(groups, JSON), data.PermissionPolicies))
 
-		protectedRoutes.HandleFunc("/settings/management_users", adminUsersUI)
-		protectedRoutes.HandleFunc("/settings/management_users/data", adminUsersData)
+		// Settings include identity provider secrets, so only administrators that can change them can see them
+		protectedRoutes.HandleFunc("/settings/general", requires(data.PermissionSettings, generalSettingsUI))
+		protectedRoutes.HandleFunc("/settings/general/data", requires(data.PermissionSettings, contentType(generalSettings, JSON)))
+
+		protectedRoutes.HandleFunc("/settings/management_users", view(adminUsersUI))
+		protectedRoutes.HandleFunc("/settings/management_users/data", view(adminUsersData))
 
 		notifications := make(chan Notification, 1)
-		protectedRoutes.HandleFunc("/notifications", notificationsWS(notifications))
+		protectedRoutes.HandleFunc("/notifications", view(notificationsWS(notifications)))
 		data.RegisterEventListener(data.NodeErrors, true, receiveErrorNotifications(notifications))
 		go monitorClusterMembers(notifications)
+		go monitorDeprovisioning(notifications)
+		go monitorKeyRotations(notifications)
 
 		should, err := data.ShouldCheckUpdates()
 		if err == nil && should {
@@ -367,9 +383,16 @@ func StartWebServer(errs chan<- error) error {
 					IdleTimeout:  120 * time.Second,
 					TLSConfig:    tlsConfig,
 					Handler:      setSecurityHeaders(allRoutes),
+					ConnContext:  proxyprotocol.ConnContext(config.Values.ManagementUI.ProxyProtocol),
 				}
 
-				if err := HTTPSServer.ListenAndServeTLS(config.Values.ManagementUI.CertPath, config.Values.ManagementUI.KeyPath); err != nil && err != http.ErrServerClosed {
+				listener, err := proxyprotocol.Listen(HTTPSServer.Addr, config.Values.ManagementUI.ProxyProtocol)
+				if err != nil {
+					errs <- fmt.Errorf("TLS management listener failed: %v", err)
+					return
+				}
+
+				if err := HTTPSServer.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
 					errs <- fmt.Errorf("TLS management listener failed: %v", err)
 				}
 
@@ -382,9 +405,16 @@ func StartWebServer(errs chan<- error) error {
 					WriteTimeout: 10 * time.Second,
 					IdleTimeout:  120 * time.Second,
 					Handler:      setSecurityHeaders(allRoutes),
+					ConnContext:  proxyprotocol.ConnContext(config.Values.ManagementUI.ProxyProtocol),
 				}
-				if err := HTTPServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-					errs <- fmt.Errorf("webserver management listener failed: %v", HTTPServer.ListenAndServe())
+				listener, err := proxyprotocol.Listen(HTTPServer.Addr, config.Values.ManagementUI.ProxyProtocol)
+				if err != nil {
+					errs <- fmt.Errorf("webserver management listener failed: %v", err)
+					return
+				}
+
+				if err := HTTPServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+					errs <- fmt.Errorf("webserver management listener failed: %v", err)
 				}
 
 			}()
@@ -492,6 +522,8 @@ func changePassword(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		auditAdmin(r, data.AuditAdminAction, u.Username, "changed password")
+
 		renderDefaults(w, r, ChangePassword{Message: "Success!", Type: 0}, "change_password.html")
 	}
 
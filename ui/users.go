@@ -6,10 +6,28 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/NHAS/wag/internal/data"
 )
 
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.DateTime)
+}
+
+// parseDate reads a YYYY-MM-DD date from a date input as the start of that day, an empty date is the zero time
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.ParseInLocation(time.DateOnly, value, time.Local)
+}
+
 func usersUI(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.NotFound(w, r)
@@ -74,11 +92,14 @@ func manageUsers(w http.ResponseWriter, r *http.Request) {
 			}
 
 			usersData = append(usersData, UsersData{
-				Username: u.Username,
-				Locked:   u.Locked,
-				Devices:  len(devices),
-				Groups:   groups,
-				MFAType:  u.MfaType,
+				Username:      u.Username,
+				Locked:        u.Locked,
+				Devices:       len(devices),
+				Groups:        groups,
+				MFAType:       u.MfaType,
+				RecoveryCodes: len(u.RecoveryCodes),
+				Expires:       formatDate(u.Expires),
+				LastSeen:      formatDate(u.LastActive()),
 			})
 		}
 
@@ -94,6 +115,8 @@ func manageUsers(w http.ResponseWriter, r *http.Request) {
 		var action struct {
 			Action    string   `json:"action"`
 			Usernames []string `json:"usernames"`
+			// YYYY-MM-DD for the expiry action, empty removes the expiry
+			Expires string `json:"expires"`
 		}
 
 		err := json.NewDecoder(r.Body).Decode(&action)
@@ -102,18 +125,27 @@ func manageUsers(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		expires, err := parseDate(action.Expires)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
 		var errs []string
 		for _, username := range action.Usernames {
 			var err error
 			switch action.Action {
 			case "lock":
-				err = ctrl.LockUser(username)
+				err = ctrlAs(r).LockUser(username)
 
 			case "unlock":
-				err = ctrl.UnlockUser(username)
+				err = ctrlAs(r).UnlockUser(username)
 
 			case "resetMFA":
-				err = ctrl.ResetUserMFA(username)
+				err = ctrlAs(r).ResetUserMFA(username)
+
+			case "expiry":
+				err = ctrlAs(r).SetUserExpiry(username, expires)
 
 			default:
 				http.Error(w, "Bad Request", http.StatusBadRequest)
@@ -146,7 +178,7 @@ func manageUsers(w http.ResponseWriter, r *http.Request) {
 		errs := ""
 
 		for _, user := range usernames {
-			err := ctrl.DeleteUser(user)
+			err := ctrlAs(r).DeleteUser(user)
 			if err != nil {
 				log.Println("Error deleting user: ", user, "err: ", err)
 				errs = errs + "\n" + err.Error()
@@ -166,3 +198,81 @@ func manageUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+func manageUserMFA(w http.ResponseWriter, r *http.Request) {
+	_, u := sessionManager.GetSessionFromRequest(r)
+	if u == nil {
+		http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		keys, err := ctrl.ListUserKeys(r.URL.Query().Get("username"))
+		if err != nil {
+			log.Println("error getting users security keys: ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		b, err := json.Marshal(keys)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+
+	case "POST":
+		var req struct {
+			Username string `json:"username"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		codes, err := ctrlAs(r).GenerateRecoveryCodes(req.Username)
+		if err != nil {
+			log.Println("failed to generate recovery codes for user: ", req.Username, "err:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		b, err := json.Marshal(codes)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+
+	case "DELETE":
+		var req struct {
+			Username string `json:"username"`
+			ID       string `json:"id"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		err = ctrlAs(r).DeleteUserKey(req.Username, req.ID)
+		if err != nil {
+			log.Println("failed to remove security key from user: ", req.Username, "err:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("OK"))
+
+	default:
+		http.NotFound(w, r)
+	}
+}